@@ -0,0 +1,102 @@
+package api
+
+import "sort"
+
+// Batch commands, refer Batchop.
+const (
+	BatchSet byte = iota + 1
+	BatchSetCAS
+	BatchDelete
+)
+
+// Batchop is a single mutation in a Batch. Seqno and Err are the
+// results of applying the mutation, filled by Index.Apply.
+type Batchop struct {
+	Cmd   byte
+	Key   []byte
+	Value []byte
+	Cas   uint64 // only for BatchSetCAS.
+	Lsm   bool   // only for BatchDelete.
+
+	Seqno uint64 // seqno assigned to mutation, ZERO if it failed.
+	Err   error  // ErrorInvalidCAS, if BatchSetCAS did not match.
+}
+
+// Batch of mutations to be applied on an index under a single lock
+// acquisition, refer Index.Apply. Mutations are applied in sort order
+// of keys, mutations on the same key are applied in the order they
+// were added. Key and value slices are not copied, they must not be
+// modified until the batch is applied.
+type Batch struct {
+	ops    []Batchop
+	sorted []*Batchop
+}
+
+// NewBatch return an empty batch, with room for size mutations.
+func NewBatch(size int) *Batch {
+	return &Batch{ops: make([]Batchop, 0, size)}
+}
+
+// Set key, value pair, refer Index.Set.
+func (batch *Batch) Set(key, value []byte) *Batch {
+	op := Batchop{Cmd: BatchSet, Key: key, Value: value}
+	batch.ops = append(batch.ops, op)
+	return batch
+}
+
+// SetCAS key, value pair, refer Index.SetCAS.
+func (batch *Batch) SetCAS(key, value []byte, cas uint64) *Batch {
+	op := Batchop{Cmd: BatchSetCAS, Key: key, Value: value, Cas: cas}
+	batch.ops = append(batch.ops, op)
+	return batch
+}
+
+// Delete key, refer Index.Delete.
+func (batch *Batch) Delete(key []byte, lsm bool) *Batch {
+	op := Batchop{Cmd: BatchDelete, Key: key, Lsm: lsm}
+	batch.ops = append(batch.ops, op)
+	return batch
+}
+
+// Len return the number of mutations in batch.
+func (batch *Batch) Len() int {
+	return len(batch.ops)
+}
+
+// Op return the i-th mutation, in the order it was added to batch.
+func (batch *Batch) Op(i int) *Batchop {
+	return &batch.ops[i]
+}
+
+// Result return the seqno assigned to the i-th mutation, in the order
+// it was added to batch, and its error if any.
+func (batch *Batch) Result(i int) (uint64, error) {
+	return batch.ops[i].Seqno, batch.ops[i].Err
+}
+
+// Reset batch for reuse.
+func (batch *Batch) Reset() *Batch {
+	for i := range batch.ops {
+		batch.ops[i] = Batchop{}
+	}
+	for i := range batch.sorted {
+		batch.sorted[i] = nil
+	}
+	batch.ops, batch.sorted = batch.ops[:0], batch.sorted[:0]
+	return batch
+}
+
+// Sorted return mutations in sort order of keys, using cmp, results
+// from previous apply are cleared. Index implementations shall apply
+// mutations in the returned order.
+func (batch *Batch) Sorted(cmp Comparator) []*Batchop {
+	batch.sorted = batch.sorted[:0]
+	for i := range batch.ops {
+		batch.ops[i].Seqno, batch.ops[i].Err = 0, nil
+		batch.sorted = append(batch.sorted, &batch.ops[i])
+	}
+	sort.SliceStable(batch.sorted, func(i, j int) bool {
+		return cmp(batch.sorted[i].Key, batch.sorted[j].Key) < 0
+	})
+	return batch.sorted
+}
@@ -0,0 +1,60 @@
+package api
+
+import "testing"
+
+func TestBatch(t *testing.T) {
+	cmp, _ := Getcomparator(Binarycomparator)
+
+	batch := NewBatch(4)
+	batch.Set([]byte("c"), []byte("c1")).Delete([]byte("a"), true)
+	batch.SetCAS([]byte("b"), []byte("b1"), 10).Set([]byte("c"), []byte("c2"))
+	if batch.Len() != 4 {
+		t.Fatalf("expected %v, got %v", 4, batch.Len())
+	}
+
+	ops := batch.Sorted(cmp)
+	refs := []struct {
+		cmd   byte
+		key   string
+		value string
+	}{
+		{BatchDelete, "a", ""},
+		{BatchSetCAS, "b", "b1"},
+		{BatchSet, "c", "c1"},
+		{BatchSet, "c", "c2"},
+	}
+	for i, ref := range refs {
+		op := ops[i]
+		if op.Cmd != ref.cmd || string(op.Key) != ref.key {
+			t.Errorf("%v expected %v, got %v", i, ref, op)
+		} else if string(op.Value) != ref.value {
+			t.Errorf("%v expected %q, got %q", i, ref.value, op.Value)
+		}
+	}
+
+	// results are in the order mutations were added.
+	for i, op := range ops {
+		op.Seqno = uint64(i + 1)
+	}
+	ops[1].Seqno, ops[1].Err = 0, ErrorInvalidCAS
+	seqnos := []uint64{3, 1, 0, 4}
+	for i, seqno := range seqnos {
+		if x, _ := batch.Result(i); x != seqno {
+			t.Errorf("%v expected %v, got %v", i, seqno, x)
+		}
+	}
+	if _, err := batch.Result(2); err != ErrorInvalidCAS {
+		t.Errorf("expected %v, got %v", ErrorInvalidCAS, err)
+	} else if batch.Op(1).Lsm == false {
+		t.Errorf("expected lsm delete")
+	}
+
+	// sorting again clears the results.
+	batch.Sorted(cmp)
+	if seqno, err := batch.Result(2); seqno != 0 || err != nil {
+		t.Errorf("unexpected %v %v", seqno, err)
+	}
+	if batch.Reset().Len() != 0 {
+		t.Errorf("expected empty batch")
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import "fmt"
+import "sync"
+import "bytes"
+
+// Comparator compare key a with key b and return -1 if a sorts before
+// b, 1 if a sorts after b, and 0 if a and b are same. Comparator shall
+// define a total order on keys, and shall return 0 only for identical
+// keys.
+type Comparator func(a, b []byte) int
+
+// Partialcmp is same as Binarycmp, except that keys are compared
+// using cmp.
+func (cmp Comparator) Partialcmp(key, limit []byte, partial bool) int {
+	if ln := len(limit); partial && ln < len(key) {
+		return cmp(key[:ln], limit[:ln])
+	}
+	return cmp(key, limit)
+}
+
+// Rangecmp is same as api.Rangecmp, except that keys are compared
+// using cmp.
+func (cmp Comparator) Rangecmp(
+	key, low, high []byte, lowincl, highincl bool) int {
+
+	if low != nil {
+		x := cmp(key, low)
+		if x < 0 || (x == 0 && lowincl == false) {
+			return -1
+		}
+	}
+	if high != nil {
+		x := cmp(key, high)
+		if x > 0 || (x == 0 && highincl == false) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Binarycomparator names the default comparator, that sort keys in
+// the byte order, same as bytes.Compare.
+const Binarycomparator = "binary"
+
+var comparators = struct {
+	sync.RWMutex
+	cmps map[string]Comparator
+}{cmps: map[string]Comparator{Binarycomparator: bytes.Compare}}
+
+// Registercomparator register cmp under name. Indexes refer to
+// comparators by name, and persisted indexes remember the name, hence
+// applications shall register their comparators before creating or
+// opening indexes. A name cannot be registered more than once.
+func Registercomparator(name string, cmp Comparator) error {
+	comparators.Lock()
+	defer comparators.Unlock()
+	if name == "" || cmp == nil {
+		return fmt.Errorf("invalid comparator %q", name)
+	} else if _, ok := comparators.cmps[name]; ok {
+		return fmt.Errorf("comparator %q already registered", name)
+	}
+	comparators.cmps[name] = cmp
+	return nil
+}
+
+// Getcomparator return the comparator registered under name.
+func Getcomparator(name string) (Comparator, error) {
+	comparators.RLock()
+	defer comparators.RUnlock()
+	if cmp, ok := comparators.cmps[name]; ok {
+		return cmp, nil
+	}
+	return nil, fmt.Errorf("comparator %q not registered", name)
+}
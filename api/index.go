@@ -1,5 +1,7 @@
 package api
 
+import "time"
+
 // Getter function, given a key, returns indexed entry.
 type Getter func(key, value []byte) (val []byte, cas uint64, del, ok bool)
 
@@ -32,6 +34,11 @@ type Index interface {
 	// entry will inserted.
 	Delete(key, oldvalue []byte, lsm bool) ([]byte, uint64)
 
+	// Apply mutations in batch, in sort order of keys, with contiguous
+	// seqnos. Results are available in batch, return ErrorInvalidCAS if
+	// one or more SetCAS mutations failed, rest of the batch is applied.
+	Apply(batch *Batch) error
+
 	// Get value for key, if value argument points to valid buffer it will be
 	// used to copy the entry's value. Also return entry's cas and whether entry
 	// is marked deleted. If ok is false, then key is not found.
@@ -43,6 +50,12 @@ type Index interface {
 	// ScanEntries return a full table iterator.
 	ScanEntries() EntryIterator
 
+	// Range return an iterator over entries whose key falls between low
+	// and high. Argument incl can be "none", "low", "high" or "both", to
+	// include the bounds. A nil bound is treated as unbounded. If reverse
+	// is true, entries are iterated in descending order.
+	Range(low, high []byte, incl string, reverse bool) Iterator
+
 	// BeginTxn starts a read-write transaction. Transactions must
 	// satisfy ACID properties. Finally all transactor objects must
 	// be Aborted or Committed.
@@ -75,6 +88,10 @@ type Transactor interface {
 	// a log entry and applied on the underlying structure during Commit.
 	Set(key, value, oldvalue []byte) []byte
 
+	// SetTTL is same as Set, but the entry shall expire after ttl
+	// duration from now. Expired entries are treated as deleted.
+	SetTTL(key, value, oldvalue []byte, ttl time.Duration) []byte
+
 	// Delete key from index. The Delete operation will be remembered as a log
 	// entry and applied on the underlying structure during commit.
 	Delete(key, oldvalue []byte, lsm bool) []byte
@@ -85,6 +102,11 @@ type Transactor interface {
 	// OpenCursor open an active cursor inside the index.
 	OpenCursor(key []byte) (Cursor, error)
 
+	// OpenReverseCursor open an active cursor inside the index, positioned
+	// at the last entry less than or equal to key. If key is nil, cursor is
+	// positioned at the last entry in the index.
+	OpenReverseCursor(key []byte) (Cursor, error)
+
 	// Abort transaction, underlying index won't be touched.
 	Abort()
 
@@ -125,9 +147,18 @@ type Cursor interface {
 	// must not be used after transaction is committed or aborted.
 	GetNext() (key, value []byte, deleted bool, err error)
 
+	// GetPrev move cursor to previous entry in snapshot and return its key
+	// and value. Returned byte slices will be a reference to index entry,
+	// hence must not be used after transaction is committed or aborted.
+	GetPrev() (key, value []byte, deleted bool, err error)
+
 	// YNext implements Iterator api, to iterate over the index. Typically
 	// used for lsm-sort.
 	YNext(fin bool) (key, val []byte, seqno uint64, deleted bool, err error)
+
+	// YPrev implements Iterator api, to iterate over the index in reverse
+	// order. Typically used for reverse lsm-sort.
+	YPrev(fin bool) (key, val []byte, seqno uint64, deleted bool, err error)
 }
 
 // IndexEntry interface can be used to access individual fields in an entry.
@@ -145,6 +176,14 @@ type IndexEntry interface {
 	// Valueref returns reference to value, if value is stored in separate
 	// file, else vpos will be -1.
 	Valueref() (valuelen uint64, vpos int64)
+
+	// Expiry return entry's expiry time in unix seconds, ZERO if entry
+	// never expires.
+	Expiry() uint64
+
+	// Ismerge return whether entry's value is a merge operand, that is
+	// yet to be resolved against an older value, refer Mergeoperator.
+	Ismerge() bool
 }
 
 // Disksnapshot provides read-only API to fetch snapshot information.
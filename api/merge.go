@@ -0,0 +1,48 @@
+package api
+
+import "fmt"
+import "sync"
+
+// Mergeoperator combine operand with the existing value for key and
+// return the new value, value shall be nil if key is missing or
+// deleted. Operands that are yet to be resolved are merged together
+// by passing the older operand as value, hence operator shall be
+// associative. Operator shall not modify value or operand, and shall
+// return a newly allocated slice.
+type Mergeoperator func(key, value, operand []byte) []byte
+
+// Mergegetter is same as Getter, but return whether the value is a
+// merge operand that is yet to be resolved against an older value.
+type Mergegetter func(
+	key, value []byte) (val []byte, cas uint64, deleted, merge, ok bool)
+
+var mergeoperators = struct {
+	sync.RWMutex
+	fns map[string]Mergeoperator
+}{fns: map[string]Mergeoperator{}}
+
+// Registermergeoperator register merge operator fn under name. Like
+// comparators, indexes refer to merge operators by name, hence
+// applications shall register their merge operators before creating
+// or opening indexes. A name cannot be registered more than once.
+func Registermergeoperator(name string, fn Mergeoperator) error {
+	mergeoperators.Lock()
+	defer mergeoperators.Unlock()
+	if name == "" || fn == nil {
+		return fmt.Errorf("invalid merge operator %q", name)
+	} else if _, ok := mergeoperators.fns[name]; ok {
+		return fmt.Errorf("merge operator %q already registered", name)
+	}
+	mergeoperators.fns[name] = fn
+	return nil
+}
+
+// Getmergeoperator return the merge operator registered under name.
+func Getmergeoperator(name string) (Mergeoperator, error) {
+	mergeoperators.RLock()
+	defer mergeoperators.RUnlock()
+	if fn, ok := mergeoperators.fns[name]; ok {
+		return fn, nil
+	}
+	return nil, fmt.Errorf("merge operator %q not registered", name)
+}
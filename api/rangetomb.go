@@ -0,0 +1,90 @@
+package api
+
+import "fmt"
+import "sort"
+
+// Rangetomb is a range tombstone, it marks all entries whose key falls
+// between Low, inclusive, and High, exclusive, and whose seqno is less
+// than Seqno as deleted. A nil bound is treated as unbounded.
+type Rangetomb struct {
+	Low   []byte
+	High  []byte
+	Seqno uint64
+}
+
+// Rangetombs is a list of range tombstones.
+type Rangetombs []Rangetomb
+
+// Add return a new list of range tombstones, with a range tombstone
+// for low and high at seqno appended to tombs. Bounds are copied, and
+// an empty bound is same as nil. Receiver is not modified, hence it
+// is safe to add tombstones while readers are using the older list.
+func (tombs Rangetombs) Add(low, high []byte, seqno uint64) Rangetombs {
+	tomb := Rangetomb{Seqno: seqno}
+	if len(low) > 0 {
+		tomb.Low = append(make([]byte, 0, len(low)), low...)
+	}
+	if len(high) > 0 {
+		tomb.High = append(make([]byte, 0, len(high)), high...)
+	}
+	newtombs := make(Rangetombs, 0, len(tombs)+1)
+	newtombs = append(newtombs, tombs...)
+	return append(newtombs, tomb)
+}
+
+// Covers return whether an entry for key at seqno is deleted by one of
+// the range tombstones, along with the latest seqno of the covering
+// range tombstone.
+func (tombs Rangetombs) Covers(
+	key []byte, seqno uint64, cmp Comparator) (uint64, bool) {
+
+	tombseqno, ok := uint64(0), false
+	for _, tomb := range tombs {
+		if seqno >= tomb.Seqno || tomb.Seqno <= tombseqno {
+			continue
+		} else if cmp.Rangecmp(key, tomb.Low, tomb.High, true, false) == 0 {
+			tombseqno, ok = tomb.Seqno, true
+		}
+	}
+	return tombseqno, ok
+}
+
+// Mergerangetombs return the union of range tombstones from lists, in
+// seqno order. Range tombstones are identified by their seqno, hence
+// the same tombstone found in more than one list is included once.
+func Mergerangetombs(lists ...Rangetombs) Rangetombs {
+	var tombs Rangetombs
+	seen := map[uint64]bool{}
+	for _, list := range lists {
+		for _, tomb := range list {
+			if seen[tomb.Seqno] == false {
+				seen[tomb.Seqno] = true
+				tombs = append(tombs, tomb)
+			}
+		}
+	}
+	sort.Slice(tombs, func(i, j int) bool {
+		return tombs[i].Seqno < tombs[j].Seqno
+	})
+	return tombs
+}
+
+// Maxseqno return the latest seqno among range tombstones, ZERO if
+// tombs is empty.
+func (tombs Rangetombs) Maxseqno() (seqno uint64) {
+	for _, tomb := range tombs {
+		if tomb.Seqno > seqno {
+			seqno = tomb.Seqno
+		}
+	}
+	return seqno
+}
+
+// Validaterange check whether low and high bounds can be used for a
+// range tombstone, low shall sort before high when both are supplied.
+func Validaterange(low, high []byte, cmp Comparator) error {
+	if len(low) > 0 && len(high) > 0 && cmp(low, high) >= 0 {
+		return fmt.Errorf("invalid range %q - %q", low, high)
+	}
+	return nil
+}
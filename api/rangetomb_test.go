@@ -0,0 +1,69 @@
+package api
+
+import "testing"
+
+func TestRangetombs(t *testing.T) {
+	cmp, _ := Getcomparator(Binarycomparator)
+
+	var tombs Rangetombs
+	low := []byte("b")
+	tombs1 := tombs.Add(low, []byte("d"), 10)
+	low[0] = 'x'
+	tombs2 := tombs1.Add(nil, []byte("b"), 20)
+	tombs3 := tombs2.Add([]byte("c"), []byte{}, 30)
+	if len(tombs) != 0 || len(tombs1) != 1 || len(tombs2) != 2 {
+		t.Errorf("unexpected %v %v %v", tombs, tombs1, tombs2)
+	} else if string(tombs1[0].Low) != "b" {
+		t.Errorf("unexpected %s", tombs1[0].Low)
+	} else if tombs3[2].High != nil {
+		t.Errorf("unexpected %v", tombs3[2].High)
+	} else if x := tombs3.Maxseqno(); x != 30 {
+		t.Errorf("expected %v, got %v", 30, x)
+	}
+
+	testcases := []struct {
+		key   string
+		seqno uint64
+		tomb  uint64
+		ok    bool
+	}{
+		{"a", 1, 20, true},
+		{"a", 20, 0, false},
+		{"b", 1, 10, true},
+		{"c", 1, 30, true},
+		{"c", 15, 30, true},
+		{"c", 30, 0, false},
+		{"d", 1, 30, true},
+		{"zzz", 1, 30, true},
+	}
+	for _, tcase := range testcases {
+		tomb, ok := tombs3.Covers([]byte(tcase.key), tcase.seqno, cmp)
+		if ok != tcase.ok || tomb != tcase.tomb {
+			t.Errorf("%v expected %v,%v got %v,%v",
+				tcase.key, tcase.tomb, tcase.ok, tomb, ok)
+		}
+	}
+	if _, ok := tombs1.Covers([]byte("d"), 1, cmp); ok {
+		t.Errorf("high bound is exclusive")
+	}
+
+	merged := Mergerangetombs(tombs3, tombs1, tombs2)
+	if len(merged) != 3 {
+		t.Errorf("expected %v, got %v", 3, len(merged))
+	}
+	for i, tomb := range merged {
+		if ref := uint64((i + 1) * 10); tomb.Seqno != ref {
+			t.Errorf("expected %v, got %v", ref, tomb.Seqno)
+		}
+	}
+
+	if err := Validaterange([]byte("a"), []byte("b"), cmp); err != nil {
+		t.Error(err)
+	} else if err := Validaterange(nil, nil, cmp); err != nil {
+		t.Error(err)
+	} else if err := Validaterange([]byte("b"), []byte("b"), cmp); err == nil {
+		t.Errorf("expected error")
+	} else if err := Validaterange([]byte("c"), []byte("b"), cmp); err == nil {
+		t.Errorf("expected error")
+	}
+}
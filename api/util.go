@@ -1,6 +1,8 @@
 package api
 
+import "fmt"
 import "bytes"
+import "time"
 import "reflect"
 import "unsafe"
 
@@ -10,10 +12,48 @@ import "unsafe"
 // for limit. That is, Binarycmp([]byte("aa"), []byte("aaa")) will return -1,
 // same as bytes.Compare.
 func Binarycmp(key, limit []byte, partial bool) int {
-	if ln := len(limit); partial && ln < len(key) {
-		return bytes.Compare(key[:ln], limit[:ln])
+	return Comparator(bytes.Compare).Partialcmp(key, limit, partial)
+}
+
+// Rangeincl return whether low and high bounds of a range are to be
+// included, incl can be "none", "low", "high" or "both".
+func Rangeincl(incl string) (lowincl, highincl bool, err error) {
+	switch incl {
+	case "none":
+		return false, false, nil
+	case "low":
+		return true, false, nil
+	case "high":
+		return false, true, nil
+	case "both":
+		return true, true, nil
 	}
-	return bytes.Compare(key, limit)
+	return false, false, fmt.Errorf("invalid range inclusion %q", incl)
+}
+
+// Rangecmp compare key with low and high bounds of a range. Return -1
+// if key falls before low, 1 if key falls after high, else 0. A nil
+// bound is treated as unbounded.
+func Rangecmp(key, low, high []byte, lowincl, highincl bool) int {
+	cmp := Comparator(bytes.Compare)
+	return cmp.Rangecmp(key, low, high, lowincl, highincl)
+}
+
+// Ttlexpiry return the absolute expiry time, in unix seconds, for an
+// entry that should live for ttl duration from now. Partial seconds are
+// rounded up, return ZERO, which means never expire, if ttl <= 0.
+func Ttlexpiry(ttl time.Duration) uint64 {
+	if ttl <= 0 {
+		return 0
+	}
+	ns := time.Now().UnixNano() + int64(ttl)
+	return uint64((ns + int64(time.Second) - 1) / int64(time.Second))
+}
+
+// Isexpired return whether an entry with expiry, in unix seconds, has
+// expired. Expiry of ZERO never expires.
+func Isexpired(expiry uint64) bool {
+	return expiry > 0 && uint64(time.Now().Unix()) >= expiry
 }
 
 // Fixbuffer will expand the buffer if its capacity is less than size and
@@ -1,5 +1,7 @@
 package api
 
+import "time"
+import "bytes"
 import "testing"
 
 func TestBinarycmp(t *testing.T) {
@@ -26,6 +28,55 @@ func TestBinarycmp(t *testing.T) {
 	}
 }
 
+func TestRangecmp(t *testing.T) {
+	testcases := [][]interface{}{
+		{"none", "b", 0}, {"none", "a", -1}, {"none", "c", 1},
+		{"low", "a", 0}, {"low", "c", 1},
+		{"high", "a", -1}, {"high", "c", 0},
+		{"both", "a", 0}, {"both", "c", 0},
+		{"both", "0", -1}, {"both", "d", 1},
+	}
+	low, high := []byte("a"), []byte("c")
+	for _, tcase := range testcases {
+		incl, key, ref := tcase[0].(string), tcase[1].(string), tcase[2].(int)
+		lowincl, highincl, err := Rangeincl(incl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmp := Rangecmp([]byte(key), low, high, lowincl, highincl)
+		if cmp != ref {
+			t.Errorf("%v %q expected %v, got %v", incl, key, ref, cmp)
+		}
+		if cmp = Rangecmp([]byte(key), nil, nil, false, false); cmp != 0 {
+			t.Errorf("%v %q expected %v, got %v", incl, key, 0, cmp)
+		}
+	}
+	if _, _, err := Rangeincl("all"); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestTtlexpiry(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	if expiry := Ttlexpiry(0); expiry != 0 {
+		t.Errorf("expected %v, got %v", 0, expiry)
+	} else if expiry = Ttlexpiry(-time.Second); expiry != 0 {
+		t.Errorf("expected %v, got %v", 0, expiry)
+	} else if expiry = Ttlexpiry(time.Millisecond); expiry < now+1 {
+		t.Errorf("expected >= %v, got %v", now+1, expiry)
+	} else if expiry = Ttlexpiry(time.Hour); expiry < now+3600 {
+		t.Errorf("expected >= %v, got %v", now+3600, expiry)
+	}
+
+	if Isexpired(0) {
+		t.Errorf("unexpected expiry for ZERO")
+	} else if Isexpired(Ttlexpiry(time.Hour)) {
+		t.Errorf("unexpected expiry")
+	} else if Isexpired(now) == false {
+		t.Errorf("expected expiry")
+	}
+}
+
 func TestFixbuffer(t *testing.T) {
 	if ln := len(Fixbuffer(nil, 10)); ln != 10 {
 		t.Errorf("expected %v, got %v", 10, ln)
@@ -57,3 +108,38 @@ func BenchmarkBinarycmpP(b *testing.B) {
 		Binarycmp(x, y, true)
 	}
 }
+
+func TestComparator(t *testing.T) {
+	cmp, err := Getcomparator(Binarycomparator)
+	if err != nil {
+		t.Fatal(err)
+	} else if cmp([]byte("a"), []byte("b")) != -1 {
+		t.Errorf("unexpected return")
+	}
+
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+	if err := Registercomparator("testreverse", reverse); err != nil {
+		t.Fatal(err)
+	} else if err := Registercomparator("testreverse", reverse); err == nil {
+		t.Errorf("expected error")
+	} else if err := Registercomparator(Binarycomparator, reverse); err == nil {
+		t.Errorf("expected error")
+	} else if _, err := Getcomparator("unknown"); err == nil {
+		t.Errorf("expected error")
+	}
+	if cmp, err = Getcomparator("testreverse"); err != nil {
+		t.Fatal(err)
+	} else if cmp.Partialcmp([]byte("abcd"), []byte("ab"), true) != 0 {
+		t.Errorf("unexpected return")
+	} else if cmp.Partialcmp([]byte("abcd"), []byte("abce"), true) != 1 {
+		t.Errorf("unexpected return")
+	}
+	low, high := []byte("c"), []byte("a")
+	if cmp.Rangecmp([]byte("b"), low, high, false, false) != 0 {
+		t.Errorf("unexpected return")
+	} else if cmp.Rangecmp([]byte("d"), low, high, false, false) != -1 {
+		t.Errorf("unexpected return")
+	} else if cmp.Rangecmp([]byte("a"), low, high, true, false) != 1 {
+		t.Errorf("unexpected return")
+	}
+}
@@ -35,7 +35,11 @@ func (meta *txnmeta) puttxn(txn *Txn) {
 		txn.putcursor(cur)
 	}
 	txn.mwtxn, txn.mrview, txn.mcview = nil, nil, nil
-	txn.dviews = txn.dviews[:0]
+	txn.tombs = nil
+	txn.dviews, txn.wkeys = txn.dviews[:0], txn.wkeys[:0]
+	for key := range txn.reads {
+		delete(txn.reads, key)
+	}
 	txn.cursors, txn.gets = txn.cursors[:0], txn.gets[:0]
 	select {
 	case meta.txncache <- txn:
@@ -64,6 +68,7 @@ func (meta *txnmeta) putview(view *View) {
 		view.putcursor(cur)
 	}
 	view.mwview, view.mrview, view.mcview = nil, nil, nil
+	view.tombs = nil
 	view.dviews = view.dviews[:0]
 	view.cursors, view.gets = view.cursors[:0], view.gets[:0]
 	select {
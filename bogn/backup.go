@@ -0,0 +1,420 @@
+package bogn
+
+import "io"
+import "os"
+import "fmt"
+import "strings"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/json"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/llrb"
+import "github.com/bnclabs/gostore/bubt"
+import s "github.com/bnclabs/gosettings"
+
+// Manifest file describing a backup, refer Bogn.Backup().
+const backupmanifest = "bogn-backup.json"
+
+type manifest struct {
+	Name       string                 `json:"name"`
+	Seqno      uint64                 `json:"seqno"`
+	Comparator string                 `json:"comparator"`
+	Levels     []string               `json:"levels"` // newest level first.
+	Appdata    []byte                 `json:"appdata"`
+	Settings   map[string]interface{} `json:"settings"`
+}
+
+// Backup the index into dir, while the index continue to serve reads
+// and writes. Files of each disk level are hard-linked, or copied when
+// linking is not possible, into dir. Value logs are always copied,
+// since later versions of a disk level can append to them. Mutations
+// not yet flushed to disk are written into dir as an extra disk level.
+// Finally a manifest is written with the seqno upto which mutations
+// are backed up. With "mvcc" memstore the backup is a consistent
+// point-in-time copy, with "llrb" memstore writes are blocked only
+// while the in-memory index is cloned. Use Restore() to rebuild an
+// index from dir.
+func (bogn *Bogn) Backup(dir string) error {
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		errorf("%v backup: %v", bogn.logprefix, err)
+		return err
+	}
+	manifestfile := filepath.Join(dir, backupmanifest)
+	if _, err := os.Stat(manifestfile); err == nil {
+		return fmt.Errorf("backup already present in %q", dir)
+	}
+
+	snap := bogn.latestsnapshot()
+	if snap == nil {
+		return fmt.Errorf("closed")
+	}
+	defer snap.release()
+
+	mf := manifest{
+		Name:       bogn.name,
+		Comparator: bogn.comparator,
+		Levels:     []string{},
+		Settings:   bogn.settingstodisk(),
+	}
+	disks := snap.disklevels([]api.Index{})
+	if len(disks) > 0 {
+		mf.Appdata = bogn.getappdata(disks[0])
+	}
+
+	// mutations that are not yet flushed to disk.
+	if snap.mr != nil || snap.isdirty() {
+		ndisk, err := bogn.backupmemory(dir, snap, mf.Appdata)
+		if err != nil {
+			return err
+		}
+		mf.Levels = append(mf.Levels, ndisk.ID())
+		mf.Seqno = bogn.getdiskseqno(ndisk)
+		ndisk.Close()
+	}
+	for _, disk := range disks {
+		if err := bogn.backupdisk(dir, disk); err != nil {
+			return err
+		}
+		mf.Levels = append(mf.Levels, disk.ID())
+		if seqno := bogn.getdiskseqno(disk); seqno > mf.Seqno {
+			mf.Seqno = seqno
+		}
+	}
+
+	// manifest is written last, a backup without manifest is incomplete.
+	data, err := json.Marshal(mf)
+	if err != nil {
+		panic(err)
+	}
+	tmpfile := manifestfile + ".tmp"
+	if err := ioutil.WriteFile(tmpfile, data, 0644); err != nil {
+		errorf("%v backup: %v", bogn.logprefix, err)
+		return err
+	} else if err := os.Rename(tmpfile, manifestfile); err != nil {
+		errorf("%v backup: %v", bogn.logprefix, err)
+		return err
+	}
+
+	fmsg := "%v backup: %v levels upto seqno %v in %q"
+	infof(fmsg, bogn.logprefix, len(mf.Levels), mf.Seqno, dir)
+	return nil
+}
+
+// Restore rebuild index `name` from backup in dir, refer Bogn.Backup().
+// All levels in the backup are merged into a single disk level under
+// diskpaths configured in setts, which shall not already have an index
+// by the same name. Settings are same as that of New(), and like New()
+// the returned instance should be started.
+func Restore(dir, name string, setts s.Settings) (*Bogn, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, backupmanifest))
+	if err != nil {
+		return nil, err
+	}
+	var mf manifest
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return nil, err
+	}
+
+	bogn := (&Bogn{
+		name:      name,
+		logprefix: fmt.Sprintf("BOGN [%v]", name),
+	}).readsettings(setts)
+	if mf.Comparator != bogn.comparator {
+		fmsg := "found comparator:%q in backup, expected %q"
+		return nil, fmt.Errorf(fmsg, mf.Comparator, bogn.comparator)
+	}
+	if err := bogn.makepaths(setts); err != nil {
+		return nil, err
+	}
+	if err := bogn.isrestorable(); err != nil {
+		return nil, err
+	}
+
+	if len(mf.Levels) > 0 {
+		if err := bogn.restorelevels(dir, mf); err != nil {
+			return nil, err
+		}
+	}
+	infof("%v restore: upto seqno %v from %q", bogn.logprefix, mf.Seqno, dir)
+	return New(name, setts)
+}
+
+// open a read only view on memory index without blocking writers for
+// the duration of backup. With "mvcc" memstore view is opened on a
+// checkpoint. With "llrb" memstore, an index receiving writes is cloned
+// under its write lock and view is opened on the clone, which costs as
+// much memory as the index. Returned function shall be called after the
+// view is aborted.
+func (bogn *Bogn) backupview(
+	index api.Index, writable bool) (api.Transactor, func(), error) {
+
+	switch idx := index.(type) {
+	case *llrb.LLRB:
+		if writable == false {
+			return idx.View(0), func() {}, nil
+		}
+		clone := idx.Clone(idx.ID() + "-backup")
+		if clone == nil {
+			return nil, nil, fmt.Errorf("closed")
+		}
+		return clone.View(0), clone.Destroy, nil
+	case *llrb.MVCC:
+		name := "backup-" + bogn.newuuid()
+		seqno := idx.Checkpoint(name)
+		defer idx.Releasecheckpoint(name)
+		view, err := idx.ViewAt(seqno)
+		return view, func() {}, err
+	}
+	panic("unreachable code")
+}
+
+func (bogn *Bogn) backupmemory(
+	dir string, snap *snapshot, appdata []byte) (api.Index, error) {
+
+	var ref [2]api.EntryIterator
+	var views [2]api.Transactor
+	scans := ref[:0]
+
+	for i, index := range []api.Index{snap.mw, snap.mr} {
+		if index == nil {
+			continue
+		}
+		view, done, err := bogn.backupview(index, i == 0 /*writable*/)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		defer view.Abort()
+		views[i] = view
+	}
+	// with merge operator, values are read via views and older levels,
+	// to resolve merge operands.
+	var get getter
+	if bogn.merge != nil && views[0] != nil {
+		get = snap.mergeyget(views[0])
+	}
+	for i, index := range []api.Index{snap.mw, snap.mr} {
+		if views[i] == nil {
+			continue
+		}
+		itere, err := viewiterator(index.ID(), views[i], get)
+		if err != nil {
+			return nil, err
+		}
+		scans = append(scans, itere)
+	}
+	itere := reduceitere(scans, bogn.cmp)
+
+	switch bogn.diskstore {
+	case "bubt":
+		level, uuid := 0, bogn.newuuid()
+		ndisk, err := bogn.builddiskbubt(
+			"backup", []string{dir}, level, 0 /*version*/, uuid,
+			"" /*flushunix*/, bogn.settingstodisk(), itere,
+			bogn.indexrangetombs(snap.mw, snap.mr),
+			"" /*appendid*/, nil /*valuelogs*/, nil /*vrewrites*/, "backup",
+			appdata,
+		)
+		itere(true /*fin*/)
+		return ndisk, err
+	}
+	panic("impossible situation")
+}
+
+func (bogn *Bogn) backupdisk(dir string, disk api.Index) error {
+	switch d := disk.(type) {
+	case *bubt.Snapshot:
+		return bogn.backupbubt(dir, d)
+	}
+	panic("unreachable code")
+}
+
+func (bogn *Bogn) backupbubt(dir string, disk *bubt.Snapshot) error {
+	name := disk.ID()
+	target := filepath.Join(dir, name)
+	if err := os.MkdirAll(target, 0775); err != nil {
+		errorf("%v backup: %v", bogn.logprefix, err)
+		return err
+	}
+	for _, path := range bogn.getdiskpaths() {
+		source := filepath.Join(path, name)
+		fis, err := ioutil.ReadDir(source)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			errorf("%v backup: %v", bogn.logprefix, err)
+			return err
+		}
+		for _, fi := range fis {
+			if fi.IsDir() || fi.Name() == "bubt.lock" {
+				continue
+			}
+			src := filepath.Join(source, fi.Name())
+			dst := filepath.Join(target, fi.Name())
+			if strings.Contains(fi.Name(), "bubt-vlog") {
+				err = copyfile(dst, src)
+			} else if err = os.Link(src, dst); err != nil {
+				err = copyfile(dst, src)
+			}
+			if err != nil {
+				errorf("%v backup: %v", bogn.logprefix, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isrestorable check that there is no index by the same name.
+func (bogn *Bogn) isrestorable() error {
+	switch bogn.diskstore {
+	case "bubt":
+		disks, err := bogn.openbubtsnaps(bogn.getdiskpaths(), false)
+		n := 0
+		for _, disk := range disks {
+			if disk != nil {
+				disk.Close()
+				n++
+			}
+		}
+		if err != nil {
+			return err
+		} else if n > 0 {
+			return fmt.Errorf("index %q already exists", bogn.name)
+		}
+	}
+	if bogn.durable {
+		if fis, _ := ioutil.ReadDir(bogn.logdir("")); len(fis) > 0 {
+			return fmt.Errorf("index %q already has logs", bogn.name)
+		}
+	}
+	return nil
+}
+
+// merge all levels from backup into the oldest disk level.
+func (bogn *Bogn) restorelevels(dir string, mf manifest) error {
+	disks := []api.Index{}
+	defer func() {
+		for _, disk := range disks {
+			disk.Close()
+		}
+	}()
+	for _, level := range mf.Levels {
+		switch bogn.diskstore {
+		case "bubt":
+			disk, err := bubt.OpenSnapshot(level, []string{dir}, false)
+			if err != nil {
+				return err
+			}
+			disks = append(disks, disk)
+		}
+	}
+
+	level := len(bogn.diskversions) - 1
+	nversion := bogn.nextdiskversion(level)
+	disksetts := bogn.settingstodisk()
+	itere, uuid := compactiterator(disks, bogn.cmp), bogn.newuuid()
+	tombs := bogn.indexrangetombs(disks...)
+	ndisk, err := bogn.builddiskstore(
+		"restore", level, nversion, uuid, "" /*flushunix*/, disksetts, itere,
+		tombs, "" /*appendid*/, nil /*valuelogs*/, nil /*vrewrites*/, "restore",
+		mf.Appdata,
+	)
+	if err != nil {
+		return err
+	}
+	itere(true /*fin*/)
+	defer ndisk.Close()
+
+	if seqno := bogn.getdiskseqno(ndisk); seqno != mf.Seqno {
+		return fmt.Errorf("restored seqno %v, expected %v", seqno, mf.Seqno)
+	}
+	return nil
+}
+
+// viewiterator return a full table entry iterator on read only view,
+// if get is supplied, value of live entries are read using get, error
+// from get is returned by the iterator.
+func viewiterator(
+	id string, view api.Transactor, get getter) (api.EntryIterator, error) {
+
+	cur, err := view.OpenCursor(nil)
+	if err != nil {
+		return nil, err
+	}
+	entry := &viewentry{id: id, cur: cur.(*llrb.Cursor)}
+	entry.buf = make([]byte, 0, 16)
+	return func(fin bool) api.IndexEntry {
+		if entry.err != nil {
+			return entry
+		} else if fin {
+			entry.err = io.EOF
+			return entry
+		}
+		key, value, seqno, deleted, err := entry.cur.YNext(false /*fin*/)
+		if err == nil && deleted == false && get != nil {
+			entry.buf, _, _, _, err = get(key, entry.buf)
+			value = entry.buf
+		}
+		entry.key, entry.value, entry.seqno = key, value, seqno
+		entry.deleted, entry.err = deleted, err
+		return entry
+	}, nil
+}
+
+// viewentry implement api.IndexEntry for entries read from a view.
+type viewentry struct {
+	id      string
+	cur     *llrb.Cursor
+	key     []byte
+	value   []byte
+	buf     []byte // value read via get, refer viewiterator.
+	seqno   uint64
+	deleted bool
+	err     error
+}
+
+func (entry *viewentry) ID() string {
+	return entry.id
+}
+
+func (entry *viewentry) Key() ([]byte, uint64, bool, error) {
+	return entry.key, entry.seqno, entry.deleted, entry.err
+}
+
+func (entry *viewentry) Value() []byte {
+	return entry.value
+}
+
+func (entry *viewentry) Valueref() (valuelen uint64, vpos int64) {
+	return uint64(len(entry.value)), -1
+}
+
+func (entry *viewentry) Expiry() uint64 {
+	if entry.err != nil {
+		return 0
+	}
+	return entry.cur.Expiry()
+}
+
+func (entry *viewentry) Ismerge() bool {
+	return false
+}
+
+func copyfile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -34,31 +34,49 @@ type Bogn struct {
 	nroutines int64
 	dgmstate  int64
 	snapspin  int64
+	evicting  int64
 	// statistics
 	wramplification int64
+	n_cachehits     int64
+	n_cachemisses   int64
+	n_evictions     int64
 
 	name         string
 	epoch        time.Time
 	snapshot     unsafe.Pointer // *snapshot
+	health       unsafe.Pointer // *DegradedError, nil if healthy.
 	memversions  [3]int
 	diskversions [16]int
 	finch        chan struct{}
 	snaprw       sync.RWMutex
 	compactorch  chan []interface{}
+	wal          *wal
+	changelog    *lib.Changelog // shared by all `mw` levels.
+	secondaries  unsafe.Pointer // *[]*secondary, copy on write.
+	secmu        sync.Mutex
 	txnmeta
 
 	// bogn settings
 	logpath       string
 	memstore      string
 	diskstore     string
+	comparator    string
+	cmp           api.Comparator
+	mergeoperator string
+	merge         api.Mergeoperator
+	serialize     bool // "llrb.txnisolation" is "serializable", or "llrb"
 	durable       bool
 	dgm           bool
 	workingset    bool
+	cachecapacity int64
 	flushratio    float64
 	compactratio  float64
 	autocommit    time.Duration
 	compactperiod time.Duration
 	memcapacity   int64
+	blockcache    *bubt.Blockcache // nil if not configured.
+	pinlevels     int64
+	vloggcratio   float64
 	setts         s.Settings
 	logprefix     string
 }
@@ -81,6 +99,29 @@ func CompactIndex(name, diskstore string, diskpaths []string, merge bool) {
 	return
 }
 
+// Disklevels return information about disk level snapshots, including
+// older versions, for index `name` found under `diskpaths`. Index need
+// not be opened, entries are sorted by level and version, each with:
+//   id              : name of the disk snapshot.
+//   level, version  : level and its version.
+//   uuid            : unique id generated while building the snapshot.
+//   seqno           : maximum seqno persisted in the snapshot.
+//   flushunix       : time of flush, in seconds since epoch.
+//   n_count         : number of entries in the snapshot.
+//   footprint       : disk footprint for the snapshot.
+//   n_written       : bytes written to disk while building snapshot.
+func Disklevels(
+	name, diskstore string, diskpaths []string) ([]s.Settings, error) {
+
+	bogn := &Bogn{name: name, diskstore: diskstore}
+	bogn.logprefix = fmt.Sprintf("BOGN [%v]", name)
+	switch diskstore {
+	case "bubt":
+		return bogn.bubtlevels(diskpaths)
+	}
+	return nil, fmt.Errorf("invalid diskstore %q", diskstore)
+}
+
 // New create a new bogn instance.
 func New(name string, setts s.Settings) (*Bogn, error) {
 	bogn := (&Bogn{
@@ -117,6 +158,13 @@ func New(name string, setts s.Settings) (*Bogn, error) {
 		bogn.Close()
 		return nil, err
 	}
+	// replay mutations that were logged but not yet flushed to disk.
+	if err := bogn.openwal(head.mw, lastseqno); err != nil {
+		bogn.Close()
+		return nil, err
+	}
+	// ingested levels can carry seqnos beyond the log, refer Ingest().
+	head.beginseqno = head.moveseqno(bogn.maxdiskseqno(disks[:]))
 	head.refer()
 	bogn.setheadsnapshot(head)
 
@@ -133,13 +181,22 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 	bogn.durable = setts.Bool("durable")
 	bogn.dgm = setts.Bool("dgm")
 	bogn.workingset = setts.Bool("workingset")
+	bogn.cachecapacity = setts.Int64("cachecapacity")
 	bogn.flushratio = setts.Float64("flushratio")
 	bogn.compactratio = setts.Float64("compactratio")
 	bogn.autocommit = time.Duration(setts.Int64("autocommit"))
 	bogn.autocommit *= time.Second
 	bogn.compactperiod = time.Duration(setts.Int64("compactperiod"))
 	bogn.compactperiod *= time.Second
-	bogn.setts = setts
+	bogn.readcomparator(setts).readmergeoperator(setts)
+	// memory levels shall sort keys using the same comparator, and
+	// resolve operands using the same merge operator.
+	bogn.setts = (s.Settings{}).Mixin(
+		setts, s.Settings{
+			"llrb.comparator":    bogn.comparator,
+			"llrb.mergeoperator": bogn.mergeoperator,
+		},
+	)
 
 	atomic.StoreInt64(&bogn.dgmstate, 0)
 	if bogn.dgm {
@@ -147,6 +204,9 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 	}
 
 	// validate
+	if bogn.cachecapacity < 0 {
+		panic(fmt.Errorf("invalid cachecapacity %v", bogn.cachecapacity))
+	}
 	switch bogn.memstore {
 	case "llrb", "mvcc":
 	default:
@@ -154,6 +214,32 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 	}
 	switch bogn.diskstore {
 	case "bubt":
+		for _, key := range []string{"bubt.zcodec", "bubt.vcodec"} {
+			switch codec := setts.String(key); codec {
+			case bubt.CodecNone, bubt.CodecSnappy:
+			default:
+				panic(fmt.Errorf("invalid %v %q", key, codec))
+			}
+		}
+		if bits := setts.Int64("bubt.bloombits"); bits < 0 {
+			panic(fmt.Errorf("invalid bubt.bloombits %v", bits))
+		}
+		if n := setts.Int64("bubt.restartinterval"); n < 0 {
+			panic(fmt.Errorf("invalid bubt.restartinterval %v", n))
+		}
+		if capacity := setts.Int64("bubt.blockcache"); capacity < 0 {
+			panic(fmt.Errorf("invalid bubt.blockcache %v", capacity))
+		} else if capacity > 0 {
+			bogn.blockcache = bubt.NewBlockcache(capacity)
+		}
+		if bogn.pinlevels = setts.Int64("bubt.pinlevels"); bogn.pinlevels < 0 {
+			panic(fmt.Errorf("invalid bubt.pinlevels %v", bogn.pinlevels))
+		}
+		bogn.vloggcratio = setts.Float64("bubt.vloggcratio")
+		if bogn.vloggcratio < 0 || bogn.vloggcratio > 1 {
+			fmsg := "invalid bubt.vloggcratio %v"
+			panic(fmt.Errorf(fmsg, bogn.vloggcratio))
+		}
 	default:
 		panic(fmt.Errorf("invalid diskstore %q", bogn.diskstore))
 	}
@@ -167,7 +253,7 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 				if len(diskpaths) == 0 {
 					panic(fmt.Errorf("missing bubt `diskpaths` settings"))
 				}
-				bogn.logpath = diskpaths[rand.Intn(10000)%len(diskpaths)]
+				bogn.logpath = bogn.picklogpath(diskpaths)
 
 			default:
 				panic(fmt.Errorf("invalid diskstore %q", bogn.diskstore))
@@ -182,11 +268,57 @@ func (bogn *Bogn) readsettings(setts s.Settings) *Bogn {
 	return bogn.readmemsettings(setts)
 }
 
+// pick a diskpath for logdir, if logdir is already present under one
+// of the diskpaths, from previous boot, pick the same.
+func (bogn *Bogn) picklogpath(diskpaths []string) string {
+	for _, path := range diskpaths {
+		if fi, err := os.Stat(bogn.logdir(path)); err == nil && fi.IsDir() {
+			return path
+		}
+	}
+	return diskpaths[rand.Intn(10000)%len(diskpaths)]
+}
+
+// comparator named in settings, older indexes did not remember their
+// comparator and are sorted in binary order.
+func (bogn *Bogn) readcomparator(setts s.Settings) *Bogn {
+	bogn.comparator = api.Binarycomparator
+	if _, ok := setts["comparator"]; ok {
+		bogn.comparator = setts.String("comparator")
+	}
+	cmp, err := api.Getcomparator(bogn.comparator)
+	if err != nil {
+		panic(err)
+	}
+	bogn.cmp = cmp
+	return bogn
+}
+
+func (bogn *Bogn) readmergeoperator(setts s.Settings) *Bogn {
+	bogn.mergeoperator, bogn.merge = "", nil
+	if _, ok := setts["mergeoperator"]; ok {
+		bogn.mergeoperator = setts.String("mergeoperator")
+	}
+	if bogn.mergeoperator != "" {
+		merge, err := api.Getmergeoperator(bogn.mergeoperator)
+		if err != nil {
+			panic(err)
+		}
+		bogn.merge = merge
+	}
+	return bogn
+}
+
 func (bogn *Bogn) readmemsettings(setts s.Settings) *Bogn {
 	switch bogn.memstore {
 	case "llrb", "mvcc":
 		llrbsetts := bogn.setts.Section("llrb.").Trim("llrb.")
 		bogn.memcapacity = llrbsetts.Int64("memcapacity")
+		nchangelog := llrbsetts.Int64("changelog")
+		bogn.changelog = lib.NewChangelog(int(nchangelog))
+		isolation := llrbsetts.String("txnisolation")
+		bogn.serialize = isolation == "serializable"
+		bogn.serialize = bogn.serialize || bogn.memstore == "llrb"
 	}
 	return bogn
 }
@@ -198,6 +330,7 @@ func (bogn *Bogn) settingstodisk() s.Settings {
 		"logpath":       bogn.logpath,
 		"memstore":      bogn.memstore,
 		"diskstore":     bogn.diskstore,
+		"comparator":    bogn.comparator,
 		"workingset":    bogn.workingset,
 		"flushratio":    bogn.flushratio,
 		"compactratio":  bogn.compactratio,
@@ -250,6 +383,14 @@ func (bogn *Bogn) validatesettings(disksetts s.Settings) {
 		fmsg := "found diskstore:%q on disk, expected %q"
 		panic(fmt.Errorf(fmsg, diskstore, bogn.diskstore))
 	}
+	comparator := api.Binarycomparator
+	if _, ok := disksetts["comparator"]; ok {
+		comparator = disksetts.String("comparator")
+	}
+	if comparator != bogn.comparator {
+		fmsg := "found comparator:%q on disk, expected %q"
+		panic(fmt.Errorf(fmsg, comparator, bogn.comparator))
+	}
 	if bogn.durable {
 		if logpath := disksetts.String("logpath"); logpath != bogn.logpath {
 			fmsg := "found logpath:%q on disk, expected %q"
@@ -356,12 +497,12 @@ func (bogn *Bogn) llrbfromdisk(
 	now := time.Now()
 
 	bogn.memversions[0]++
-	iter, seqno := ndisk.Scan(), bogn.getdiskseqno(ndisk)
+	seqno := bogn.getdiskseqno(ndisk)
 	name := bogn.memlevelname("mw", bogn.memversions[0])
 	llrbsetts := bogn.setts.Section("llrb.").Trim("llrb.")
-	mw := llrb.LoadLLRB(name, llrbsetts, iter)
+	mw := llrb.NewLLRB(name, llrbsetts)
+	bogn.loadentries(mw, ndisk.ScanEntries())
 	mw.Setseqno(seqno)
-	iter(true /*fin*/)
 
 	fmsg := "%v warmup: LLRB %v (%v) %v entries -> %v in %v"
 	arg1 := humanize.Bytes(uint64(payload))
@@ -377,12 +518,12 @@ func (bogn *Bogn) mvccfromdisk(
 	now := time.Now()
 
 	bogn.memversions[0]++
-	iter, seqno := ndisk.Scan(), bogn.getdiskseqno(ndisk)
+	seqno := bogn.getdiskseqno(ndisk)
 	name := bogn.memlevelname("mw", bogn.memversions[0])
 	llrbsetts := bogn.setts.Section("llrb.").Trim("llrb.")
-	mw := llrb.LoadMVCC(name, llrbsetts, iter)
+	mw := llrb.NewMVCC(name, llrbsetts)
+	bogn.loadentries(mw, ndisk.ScanEntries())
 	mw.Setseqno(seqno)
-	iter(true /*fin*/)
 
 	fmsg := "%v warmup: MVCC %v (%v) %v entries -> %v in %v"
 	arg1 := humanize.Bytes(uint64(payload))
@@ -392,6 +533,37 @@ func (bogn *Bogn) mvccfromdisk(
 	return mw
 }
 
+// loadentries from a full table scan on disk level into memory index,
+// unlike api.Iterator, index entries carry the expiry of each entry.
+func (bogn *Bogn) loadentries(index api.Index, itere api.EntryIterator) {
+	if itere == nil {
+		return
+	}
+	for entry := itere(false /*fin*/); ; entry = itere(false /*fin*/) {
+		key, seqno, deleted, err := entry.Key()
+		if err != nil {
+			break
+		}
+		switch idx := index.(type) {
+		case *llrb.LLRB:
+			idx.Setseqno(seqno - 1)
+			if deleted {
+				idx.Delete(key, nil, true /*lsm*/)
+			} else {
+				idx.SetExpiry(key, entry.Value(), nil, entry.Expiry())
+			}
+		case *llrb.MVCC:
+			idx.Setseqno(seqno - 1)
+			if deleted {
+				idx.Delete(key, nil, true /*lsm*/)
+			} else {
+				idx.SetExpiry(key, entry.Value(), nil, entry.Expiry())
+			}
+		}
+	}
+	itere(true /*fin*/)
+}
+
 // Start bogn service. Typically bogn instances are created and
 // started as:
 //   inst := NewBogn("storage", setts).Start()
@@ -404,6 +576,9 @@ func (bogn *Bogn) Start() *Bogn {
 	for atomic.LoadInt64(&bogn.nroutines) < 2 {
 		runtime.Gosched()
 	}
+	for _, sec := range bogn.getsecondaries() {
+		sec.index.Start()
+	}
 	return bogn
 }
 
@@ -436,6 +611,110 @@ func (bogn *Bogn) makepaths(setts s.Settings) error {
 	return nil
 }
 
+// open write-ahead-log and replay all mutations after `seqno` on `mw`.
+func (bogn *Bogn) openwal(mw api.Index, seqno uint64) (err error) {
+	if bogn.durable == false {
+		return nil
+	}
+
+	walsetts := bogn.setts.Section("wal.").Trim("wal.")
+	segsize, syncmode := walsetts.Int64("segmentsize"), walsetts.String("sync")
+	logdir := bogn.logdir("")
+	bogn.wal, err = newwal(bogn.logprefix, logdir, segsize, syncmode)
+	if err != nil {
+		return err
+	}
+
+	setseqno := func(seqno uint64) {
+		switch index := mw.(type) {
+		case *llrb.LLRB:
+			index.Setseqno(seqno)
+		case *llrb.MVCC:
+			index.Setseqno(seqno)
+		}
+	}
+	setexpiry := func(key, value []byte, expiry uint64) {
+		switch index := mw.(type) {
+		case *llrb.LLRB:
+			index.SetExpiry(key, value, nil, expiry)
+		case *llrb.MVCC:
+			index.SetExpiry(key, value, nil, expiry)
+		}
+	}
+	deleterange := func(low, high []byte) {
+		switch index := mw.(type) {
+		case *llrb.LLRB:
+			index.DeleteRange(low, high)
+		case *llrb.MVCC:
+			index.DeleteRange(low, high)
+		}
+	}
+	merge := func(key, operand []byte) {
+		switch index := mw.(type) {
+		case *llrb.LLRB:
+			index.Merge(key, operand)
+		case *llrb.MVCC:
+			index.Merge(key, operand)
+		}
+	}
+	n := 0
+	apply := func(batchseqno uint64, ops []walop) {
+		for _, op := range ops {
+			if op.seqno > 0 {
+				setseqno(op.seqno - 1)
+			}
+			switch op.cmd {
+			case walcmdSet:
+				mw.Set(op.key, op.value, nil)
+			case walcmdSetTTL:
+				setexpiry(op.key, op.value, op.expiry)
+			case walcmdDelete:
+				mw.Delete(op.key, nil, true /*lsm*/)
+			case walcmdRemove:
+				mw.Delete(op.key, nil, false /*lsm*/)
+			case walcmdDeleteRange:
+				deleterange(op.key, op.value)
+			case walcmdMerge:
+				merge(op.key, op.value)
+			default:
+				panic(fmt.Errorf("invalid wal command %v", op.cmd))
+			}
+			n++
+		}
+		setseqno(batchseqno)
+	}
+	if err = bogn.wal.replay(seqno, apply); err != nil {
+		return err
+	}
+	fmsg := "%v wal: replayed %v mutations after seqno %v, upto %v"
+	infof(fmsg, bogn.logprefix, n, seqno, bogn.indexseqno(mw))
+	return nil
+}
+
+// log mutations added to the current batch, must be called with
+// the log locked. Return position of the record in the log.
+func (bogn *Bogn) logmutations(seqno uint64) (int64, error) {
+	return bogn.wal.flushops(seqno)
+}
+
+// synclog wait for the record at pos, and for updates on secondary
+// indexes, to be durable, must be called after releasing the locks,
+// err is from logmutations. If the log has failed, index is degraded
+// and *DegradedError is returned.
+func (bogn *Bogn) synclog(pos int64, err error) error {
+	if err == nil {
+		err = bogn.wal.waitsync(pos)
+	}
+	if err != nil {
+		bogn.setdegraded("wal", err)
+		return bogn.Health()
+	} else if err = bogn.syncsecondaries(); err != nil {
+		bogn.setdegraded("secondary", err)
+		return bogn.Health()
+	}
+	return nil
+}
+
 func (bogn *Bogn) currsnapshot() *snapshot {
 	return (*snapshot)(atomic.LoadPointer(&bogn.snapshot))
 }
@@ -460,6 +739,28 @@ func (bogn *Bogn) latestsnapshot() *snapshot {
 	panic("unreachable code")
 }
 
+// checkvalue return error if value cannot be held by write store.
+func (bogn *Bogn) checkvalue(value []byte) error {
+	if int64(len(value)) > llrb.Maxvaluesize {
+		fmsg := "value size %v exceeds %v"
+		return fmt.Errorf(fmsg, len(value), int64(llrb.Maxvaluesize))
+	}
+	return nil
+}
+
+// mutationlock serialize mutations on the write store, along with
+// write-ahead-log, so that records are appended and secondary indexes
+// are updated in the same order as mutations.
+func (bogn *Bogn) mutationlock() {
+	bogn.wal.lock()
+	bogn.secmu.Lock()
+}
+
+func (bogn *Bogn) mutationunlock() {
+	bogn.secmu.Unlock()
+	bogn.wal.unlock()
+}
+
 var writelatch int64 = 0x10000
 var writelock int64 = 0x4000000000000000
 
@@ -810,9 +1111,10 @@ func (bogn *Bogn) memlevelname(level string, version int) string {
 }
 
 func (bogn *Bogn) logdir(logpath string) string {
-	if len(logpath) == 0 && len(bogn.logpath) > 0 {
+	if len(logpath) == 0 {
 		logpath = bogn.logpath
-	} else if len(bogn.logpath) == 0 {
+	}
+	if len(logpath) == 0 {
 		return ""
 	}
 	dirname := fmt.Sprintf("bogn-%v-logs", bogn.name)
@@ -884,7 +1186,14 @@ func (bogn *Bogn) Disksnapshots() func(fin bool) api.Disksnapshot {
 // BeginTxn starts a read-write transaction. All transactions should either
 // be committed or aborted. If transactions are not released for long time
 // it might increase the memory pressure on the system. Concurrent
-// transactions are allowed, and serialized internally.
+// transactions are allowed, conflicting transactions are rolled back
+// on Commit, refer to "llrb.txnisolation" settings. With "mvcc"
+// memstore, transactions read from the latest snapshot of the write
+// store, and can be rolled back until that snapshot catches up with
+// the latest mutations. Applications shall retry the transaction when
+// Commit returns api.ErrorRollback. With "llrb" memstore, write store
+// is locked only while committing, but cursors opened on the
+// transaction hold its read lock until Commit or Abort.
 func (bogn *Bogn) BeginTxn(id uint64) api.Transactor {
 	bogn.snaprlock()
 	if snap := bogn.latestsnapshot(); snap != nil {
@@ -941,6 +1250,9 @@ func (bogn *Bogn) TombstonePurge() {
 // lastest snapshot.
 func (bogn *Bogn) Commit(appdata []byte) {
 	postcommit(bogn, appdata)
+	for _, sec := range bogn.getsecondaries() {
+		sec.index.Commit(nil)
+	}
 }
 
 // Log vital statistics for all active bogn levels.
@@ -962,6 +1274,64 @@ func (bogn *Bogn) Log() {
 		bogn.logstore(disk)
 	}
 	snap.release()
+
+	if bogn.blockcache != nil {
+		info := bogn.blockcache.Info()
+		fmsg := "%v block cache %v/%v bytes, %v blocks, hits:%v misses:%v"
+		size, capacity := info.Int64("size"), info.Int64("capacity")
+		hits, misses := info.Int64("hits"), info.Int64("misses")
+		nblocks := info.Int64("n_blocks")
+		infof(fmsg, bogn.logprefix, size, capacity, nblocks, hits, misses)
+	}
+
+	if bogn.workingset {
+		stats := bogn.Cachestats()
+		fmsg := "%v cache %v bytes, hits:%v misses:%v evictions:%v"
+		heap, hits := stats["heap"], stats["n_hits"]
+		misses, evictions := stats["n_misses"], stats["n_evictions"]
+		infof(fmsg, bogn.logprefix, heap, hits, misses, evictions)
+	}
+
+	bogn.wal.log()
+}
+
+// Walstats return write-ahead-log statistics, including the configured
+// sync mode and latency histograms, in microseconds, for fsync calls
+// and for writers waiting on their mutation to be logged. Return nil
+// if index is not durable.
+func (bogn *Bogn) Walstats() map[string]interface{} {
+	return bogn.wal.stats()
+}
+
+// Cachestats return statistics on the working set cache, number of
+// lookups that hit and missed the cache, its hit ratio, number of
+// entries evicted from cache and the cache's memory footprint. Return
+// nil if "workingset" is not configured.
+func (bogn *Bogn) Cachestats() map[string]interface{} {
+	if !bogn.workingset {
+		return nil
+	}
+	hits := atomic.LoadInt64(&bogn.n_cachehits)
+	misses := atomic.LoadInt64(&bogn.n_cachemisses)
+	hitratio := float64(0)
+	if hits+misses > 0 {
+		hitratio = float64(hits) / float64(hits+misses)
+	}
+
+	bogn.snaprlock()
+	snap := bogn.latestsnapshot()
+	heap := bogn.indexfootprint(snap.mc)
+	snap.release()
+	bogn.snaprunlock()
+
+	return map[string]interface{}{
+		"n_hits":      hits,
+		"n_misses":    misses,
+		"hitratio":    hitratio,
+		"n_evictions": atomic.LoadInt64(&bogn.n_evictions),
+		"capacity":    bogn.cachecapacity,
+		"heap":        heap,
+	}
 }
 
 // Validate active bogn levels.
@@ -1010,11 +1380,12 @@ func (bogn *Bogn) validatedisklevel(
 
 // Close this instance, no calls allowed after Close.
 func (bogn *Bogn) Close() {
-	if bogn.autocommit == 0 {
+	if bogn.autocommit == 0 && bogn.Health() == nil {
 		if snap := bogn.currsnapshot(); snap.isdirty() {
 			panic("commit before close")
 		}
 	}
+	bogn.changelog.Close()
 
 	compactorclose(bogn)
 	close(bogn.finch)
@@ -1025,10 +1396,17 @@ func (bogn *Bogn) Close() {
 
 	bogn.logstatistics("close")
 
+	// a degraded index, say with a failed log, is replayed on restart.
+	if err := bogn.wal.close(); err != nil && bogn.Health() == nil {
+		panic(err)
+	}
+
 	// check whether all mutations are flushed to disk.
+	// unless index is degraded, in which case mutations not flushed to
+	// disk are in write-ahead-log.
 	snap := bogn.currsnapshot()
 	mwseqno, disks := bogn.indexseqno(snap.mw), snap.disklevels([]api.Index{})
-	if len(disks) > 0 && mwseqno > 0 {
+	if len(disks) > 0 && mwseqno > 0 && bogn.Health() == nil {
 		disk := disks[0]
 		if diskseqno := bogn.getdiskseqno(disk); diskseqno != mwseqno {
 			fmsg := "diskseqno(%v) != mwseqno(%v)"
@@ -1052,6 +1430,10 @@ func (bogn *Bogn) Close() {
 	}
 	bogn.setheadsnapshot(nil)
 
+	for _, sec := range bogn.getsecondaries() {
+		sec.index.Close()
+	}
+
 	infof("%v closed ...", bogn.logprefix)
 }
 
@@ -1059,6 +1441,9 @@ func (bogn *Bogn) Close() {
 func (bogn *Bogn) Destroy() {
 	diskpaths := bogn.getdiskpaths()
 	bogn.destroydisksnaps("destory", bogn.logpath, bogn.diskstore, diskpaths)
+	for _, sec := range bogn.getsecondaries() {
+		sec.index.Destroy()
+	}
 	infof("%v destroyed ...", bogn.logprefix)
 	return
 }
@@ -1077,19 +1462,118 @@ func (bogn *Bogn) Get(key, value []byte) (v []byte, cas uint64, del, ok bool) {
 	return
 }
 
+// GetE is same as Get, except that disk errors and corrupt blocks,
+// that Get shall log and treat as missing key, are returned as error.
+func (bogn *Bogn) GetE(
+	key, value []byte) (v []byte, cas uint64, del, ok bool, err error) {
+
+	snap := bogn.latestsnapshot()
+	if snap.ygete != nil {
+		v, cas, del, ok, err = snap.ygete(key, value)
+	}
+	snap.release()
+	if err != nil {
+		return nil, 0, false, false, err
+	}
+	return v, cas, del, ok, nil
+}
+
 // Scan return a full table iterator, if iteration is stopped before
 // reaching end of table (io.EOF), application should call iterator
-// with fin as true. EG: iter(true)
+// with fin as true. EG: iter(true). If a disk level could not be
+// scanned, iterator shall return the error on its first call, use
+// ScanE to get the error up front.
 func (bogn *Bogn) Scan() api.Iterator {
+	iter, err := bogn.ScanE()
+	if err != nil {
+		return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+			return nil, nil, 0, false, err
+		}
+	}
+	return iter
+}
+
+// ScanE is same as Scan, except that it return the error if any of the
+// disk levels could not be scanned. Error hit while scanning is
+// returned by the iterator, after which the iteration is closed.
+func (bogn *Bogn) ScanE() (api.Iterator, error) {
+	var key, value []byte
+	var seqno uint64
+	var del bool
+	var err error
+
+	snap := bogn.latestsnapshot()
+	iter, err := snap.iterator()
+	if err != nil {
+		snap.release()
+		return nil, err
+	}
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if err != nil {
+			return nil, nil, 0, false, err
+
+		} else if iter == nil {
+			err = io.EOF
+			snap.release()
+			return nil, nil, 0, false, err
+
+		} else if fin {
+			iter(fin) // close all underlying iterations.
+			err = io.EOF
+			snap.release()
+			return nil, nil, 0, false, err
+		}
+		if key, value, seqno, del, err = iter(fin); err != nil {
+			iter(fin)
+			snap.release()
+		}
+		return key, value, seqno, del, err
+	}, nil
+}
+
+// Range return an iterator over entries whose key falls between low
+// and high, incl can be "none", "low", "high" or "both". A nil bound is
+// treated as unbounded and if reverse is true, entries are iterated in
+// descending order. If iteration is stopped before reaching end of range
+// (io.EOF), application should call iterator with fin as true.
+// EG: iter(true). If a disk level could not be scanned, iterator shall
+// return the error on its first call, use RangeE to get the error up
+// front.
+func (bogn *Bogn) Range(
+	low, high []byte, incl string, reverse bool) api.Iterator {
+
+	iter, err := bogn.RangeE(low, high, incl, reverse)
+	if err != nil {
+		return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+			return nil, nil, 0, false, err
+		}
+	}
+	return iter
+}
+
+// RangeE is same as Range, except that it return the error if any of
+// the disk levels could not be scanned. Error hit while scanning is
+// returned by the iterator, after which the iteration is closed.
+func (bogn *Bogn) RangeE(
+	low, high []byte, incl string, reverse bool) (api.Iterator, error) {
+
 	var key, value []byte
 	var seqno uint64
 	var del bool
 	var err error
 
+	if _, _, err := api.Rangeincl(incl); err != nil {
+		panic(err)
+	}
+
 	snap := bogn.latestsnapshot()
-	iter := snap.iterator()
+	iter, err := snap.rangeiterator(low, high, incl, reverse)
+	if err != nil {
+		snap.release()
+		return nil, err
+	}
 	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
-		if err == io.EOF {
+		if err != nil {
 			return nil, nil, 0, false, err
 
 		} else if iter == nil {
@@ -1103,12 +1587,30 @@ func (bogn *Bogn) Scan() api.Iterator {
 			snap.release()
 			return nil, nil, 0, false, err
 		}
-		if key, value, seqno, del, err = iter(fin); err == io.EOF {
+		if key, value, seqno, del, err = iter(fin); err != nil {
 			iter(fin)
 			snap.release()
 		}
 		return key, value, seqno, del, err
+	}, nil
+}
+
+// Feed return an iterator over mutations with seqno greater than
+// fromseqno, in seqno order. Latest version of entries, from memory
+// and disk levels, are returned first, and then the iterator blocks
+// for live mutations, including deletes. Call iterator with fin as
+// true to close the feed, it is okay to do so from a different
+// go-routine. Closing the index will close all its feeds.
+func (bogn *Bogn) Feed(fromseqno uint64) api.Iterator {
+	scan := func() func(bool) ([]byte, []byte, uint64, bool, error) {
+		// in-memory levels shall include all mutations applied so far.
+		snap := bogn.latestsnapshot()
+		bogn.waitindexseqno(snap.mw)
+		bogn.waitindexseqno(snap.mr)
+		snap.release()
+		return bogn.Scan()
 	}
+	return bogn.changelog.Feed(fromseqno, scan)
 }
 
 // ScanEntries is not supported by Bogn.
@@ -1120,14 +1622,76 @@ func (bogn *Bogn) ScanEntries() api.EntryIterator {
 
 // Set a key, value pair in the index, if key is already present, its value
 // will be over-written. Make sure key is not nil. Return old value if
-// oldvalue points to valid buffer.
+// oldvalue points to valid buffer. If index is degraded, or turns
+// degraded while logging this mutation, error is logged, use SetE to
+// get the error.
 func (bogn *Bogn) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	ov, cas, err := bogn.SetE(key, value, oldvalue)
+	if err != nil {
+		errorf("%v Set(%q): %v", bogn.logprefix, key, err)
+	}
+	return ov, cas
+}
+
+// SetE is same as Set, except that it return *DegradedError, without
+// applying the mutation, if index is read-only. If the write-ahead-log
+// fails, mutation is applied in memory but not durable, and index is
+// turned read-only. Values larger than llrb.Maxvaluesize are rejected
+// with an error.
+func (bogn *Bogn) SetE(
+	key, value, oldvalue []byte) (ov []byte, cas uint64, err error) {
+
+	if err = bogn.Health(); err != nil {
+		return oldvalue, 0, err
+	} else if err = bogn.checkvalue(value); err != nil {
+		return oldvalue, 0, err
+	}
 	bogn.snaprlock()
+	bogn.mutationlock()
 	ov, cas = bogn.currsnapshot().set(key, value, oldvalue)
+	bogn.wal.addop(walcmdSet, cas, key, value)
+	pos, err := bogn.logmutations(cas)
+	bogn.secondaryset(key, value)
+	bogn.mutationunlock()
 	bogn.snaprunlock()
+	return ov, cas, bogn.synclog(pos, err)
+}
+
+// SetTTL is same as Set, but the entry shall expire after ttl duration
+// from now. Once expired, the entry is treated as deleted by readers
+// and shall be purged, like tombstones, while compacting disk levels.
+func (bogn *Bogn) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) (ov []byte, cas uint64) {
+
+	ov, cas, err := bogn.SetTTLE(key, value, oldvalue, ttl)
+	if err != nil {
+		errorf("%v SetTTL(%q): %v", bogn.logprefix, key, err)
+	}
 	return ov, cas
 }
 
+// SetTTLE is same as SetTTL, error is returned like SetE.
+func (bogn *Bogn) SetTTLE(
+	key, value, oldvalue []byte,
+	ttl time.Duration) (ov []byte, cas uint64, err error) {
+
+	if err = bogn.Health(); err != nil {
+		return oldvalue, 0, err
+	} else if err = bogn.checkvalue(value); err != nil {
+		return oldvalue, 0, err
+	}
+	expiry := api.Ttlexpiry(ttl)
+	bogn.snaprlock()
+	bogn.mutationlock()
+	ov, cas = bogn.currsnapshot().setexpiry(key, value, oldvalue, expiry)
+	bogn.wal.addttlop(cas, key, value, expiry)
+	pos, err := bogn.logmutations(cas)
+	bogn.secondaryset(key, value)
+	bogn.mutationunlock()
+	bogn.snaprunlock()
+	return ov, cas, bogn.synclog(pos, err)
+}
+
 // SetCAS a key, value pair in the index, if CAS is ZERO then key should
 // not be present in the index, otherwise existing CAS should match the
 // supplied CAS. Value will be over-written. Make sure key is not nil.
@@ -1135,6 +1699,11 @@ func (bogn *Bogn) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
 func (bogn *Bogn) SetCAS(
 	key, value, oldvalue []byte, cas uint64) ([]byte, uint64, error) {
 
+	if err := bogn.Health(); err != nil {
+		return oldvalue, 0, err
+	} else if err := bogn.checkvalue(value); err != nil {
+		return oldvalue, 0, err
+	}
 	ov, rccas, err, ok := bogn.setcasMem(key, value, oldvalue, cas)
 	if ok {
 		return ov, rccas, err
@@ -1160,31 +1729,209 @@ func (bogn *Bogn) setcasMem(
 
 	var ov []byte
 	var rccas uint64
-	var err error
+	var err, logerr error
+	var pos int64
 
 	ok := false
 
 	bogn.snaprlock()
 	if atomic.LoadInt64(&bogn.dgmstate) == 0 {
+		bogn.mutationlock()
 		ov, rccas, err = bogn.currsnapshot().setCAS(key, value, oldvalue, cas)
+		if err == nil {
+			bogn.wal.addop(walcmdSet, rccas, key, value)
+			pos, logerr = bogn.logmutations(rccas)
+			bogn.secondaryset(key, value)
+		}
+		bogn.mutationunlock()
 		ok = true
 	}
 	bogn.snaprunlock()
+	if logerr = bogn.synclog(pos, logerr); logerr != nil {
+		return ov, rccas, logerr, ok
+	}
 	return ov, rccas, err, ok
 }
 
 // Delete key from index. Key should not be nil, if key found return its
 // value. If lsm is true, then don't delete the node instead mark the node
 // as deleted. Again, if lsm is true but key is not found in index, a new
-// entry will inserted.
+// entry will inserted. Errors are logged like Set, use DeleteE to get
+// the error.
 func (bogn *Bogn) Delete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
+	ov, cas, err := bogn.DeleteE(key, oldvalue, lsm)
+	if err != nil {
+		errorf("%v Delete(%q): %v", bogn.logprefix, key, err)
+	}
+	return ov, cas
+}
+
+// DeleteE is same as Delete, error is returned like SetE.
+func (bogn *Bogn) DeleteE(
+	key, oldvalue []byte, lsm bool) ([]byte, uint64, error) {
+
+	if err := bogn.Health(); err != nil {
+		return oldvalue, 0, err
+	}
 	bogn.snaprlock()
 	if atomic.LoadInt64(&bogn.dgmstate) == 1 { // auto-enable lsm in dgm
 		lsm = true
 	}
+	bogn.mutationlock()
 	ov, cas := bogn.currsnapshot().delete(key, oldvalue, lsm)
+	if lsm {
+		bogn.wal.addop(walcmdDelete, cas, key, nil)
+	} else {
+		bogn.wal.addop(walcmdRemove, cas, key, nil)
+	}
+	pos, err := bogn.logmutations(cas)
+	bogn.secondarydelete(key)
+	bogn.mutationunlock()
 	bogn.snaprunlock()
-	return ov, cas
+	return ov, cas, bogn.synclog(pos, err)
+}
+
+// Apply mutations in batch on the write store under a single lock
+// acquisition, refer api.Batch. Successful mutations are assigned
+// contiguous seqnos and logged as a single write-ahead-log record.
+// Return api.ErrorInvalidCAS if one or more SetCAS failed, rest of
+// the batch is applied. Return *DegradedError, without applying the
+// batch, if index is read-only.
+func (bogn *Bogn) Apply(batch *api.Batch) error {
+	pos, logerr, err := bogn.apply(batch)
+	if logerr = bogn.synclog(pos, logerr); logerr != nil {
+		return logerr
+	}
+	return err
+}
+
+// apply is same as Apply, except that the caller shall wait for the
+// log record at pos, or handle logerr, refer synclog.
+func (bogn *Bogn) apply(batch *api.Batch) (pos int64, logerr, err error) {
+	if err := bogn.Health(); err != nil {
+		return 0, nil, err
+	}
+	ops := batch.Sorted(bogn.cmp)
+
+	bogn.snaprlock()
+	bogn.mutationlock()
+	snap := bogn.currsnapshot()
+	dgm := atomic.LoadInt64(&bogn.dgmstate) == 1
+	mwbatch := api.NewBatch(len(ops))
+	for _, op := range ops {
+		switch op.Cmd {
+		case api.BatchSet:
+			mwbatch.Set(op.Key, op.Value)
+		case api.BatchSetCAS:
+			cas := op.Cas
+			if dgm {
+				cas = snap.mwcas(op.Key, cas)
+			}
+			mwbatch.SetCAS(op.Key, op.Value, cas)
+		case api.BatchDelete: // auto-enable lsm in dgm
+			mwbatch.Delete(op.Key, op.Lsm || dgm)
+		}
+	}
+	// mwbatch is in sort order, hence its ops map one to one with ops.
+	err = snap.mw.Apply(mwbatch)
+	seqno := uint64(0)
+	for i, op := range ops {
+		mwop := mwbatch.Op(i)
+		op.Seqno, op.Err = mwop.Seqno, mwop.Err
+		if op.Seqno == 0 {
+			continue
+		}
+		switch mwop.Cmd {
+		case api.BatchSet, api.BatchSetCAS:
+			bogn.wal.addop(walcmdSet, op.Seqno, op.Key, op.Value)
+		case api.BatchDelete:
+			if mwop.Lsm {
+				bogn.wal.addop(walcmdDelete, op.Seqno, op.Key, nil)
+			} else {
+				bogn.wal.addop(walcmdRemove, op.Seqno, op.Key, nil)
+			}
+		}
+		seqno = op.Seqno
+	}
+	pos, logerr = bogn.logmutations(seqno)
+	bogn.secondaryapply(ops)
+	bogn.mutationunlock()
+	bogn.snaprunlock()
+	return pos, logerr, err
+}
+
+// Merge operand into the value for key, using the merge operator
+// configured via "mergeoperator" settings, without reading the value.
+// Operand is resolved right away if key is found in write store,
+// otherwise it is stored as is and resolved against older levels by
+// readers, and while flushing to disk. Return the seqno of mutation.
+// Errors are logged like Set, use MergeE to get the error.
+func (bogn *Bogn) Merge(key, operand []byte) uint64 {
+	cas, err := bogn.MergeE(key, operand)
+	if err != nil {
+		errorf("%v Merge(%q): %v", bogn.logprefix, key, err)
+	}
+	return cas
+}
+
+// MergeE is same as Merge, error is returned like SetE.
+func (bogn *Bogn) MergeE(key, operand []byte) (uint64, error) {
+	if bogn.merge == nil {
+		panic(fmt.Errorf("%v mergeoperator not configured", bogn.logprefix))
+	}
+	if err := bogn.Health(); err != nil {
+		return 0, err
+	} else if err := bogn.checkvalue(operand); err != nil {
+		return 0, err
+	}
+	bogn.snaprlock()
+	bogn.mutationlock()
+	snap := bogn.currsnapshot()
+	cas := snap.mergeoperand(key, operand)
+	bogn.wal.addop(walcmdMerge, cas, key, operand)
+	pos, err := bogn.logmutations(cas)
+	bogn.secondarymerge(snap, key)
+	bogn.mutationunlock()
+	bogn.snaprunlock()
+	return cas, bogn.synclog(pos, err)
+}
+
+// DeleteRange mark all keys between low, inclusive, and high,
+// exclusive, as deleted across all levels, by recording a single range
+// tombstone in the write store. Keys set after this call are not
+// affected. A nil bound is treated as unbounded. Range tombstones are
+// persisted along with disk levels, and are dropped along with the
+// entries they cover once compacted into the oldest level. Return the
+// seqno of the range tombstone. Invalid range shall panic, other
+// errors are logged like Set, use DeleteRangeE to get the error.
+func (bogn *Bogn) DeleteRange(low, high []byte) uint64 {
+	if err := api.Validaterange(low, high, bogn.cmp); err != nil {
+		panic(err)
+	}
+	seqno, err := bogn.DeleteRangeE(low, high)
+	if err != nil {
+		errorf("%v DeleteRange(%q, %q): %v", bogn.logprefix, low, high, err)
+	}
+	return seqno
+}
+
+// DeleteRangeE is same as DeleteRange, error is returned like SetE,
+// including invalid range.
+func (bogn *Bogn) DeleteRangeE(low, high []byte) (uint64, error) {
+	if err := api.Validaterange(low, high, bogn.cmp); err != nil {
+		return 0, err
+	} else if err := bogn.Health(); err != nil {
+		return 0, err
+	}
+	bogn.snaprlock()
+	bogn.mutationlock()
+	seqno := bogn.currsnapshot().deleterange(low, high)
+	bogn.wal.addop(walcmdDeleteRange, seqno, low, high)
+	pos, err := bogn.logmutations(seqno)
+	bogn.secondarydeleterange(low, high)
+	bogn.mutationunlock()
+	bogn.snaprunlock()
+	return seqno, bogn.synclog(pos, err)
 }
 
 //---- local methods
@@ -1203,16 +1950,19 @@ func (bogn *Bogn) newmemstore(
 		name = bogn.memlevelname(level, bogn.memversions[2])
 	}
 
+	llrbsetts := bogn.setts.Section("llrb.").Trim("llrb.")
+	if level == "mc" { // to evict cold entries from working set.
+		llrbsetts["accesstime"] = true
+	}
+
 	switch bogn.memstore {
 	case "llrb":
-		llrbsetts := bogn.setts.Section("llrb.").Trim("llrb.")
 		index := llrb.NewLLRB(name, llrbsetts)
 		index.Setseqno(seqno)
 		infof("%v %v: new llrb store %q", bogn.logprefix, logprefix, name)
 		return index, nil
 
 	case "mvcc":
-		llrbsetts := bogn.setts.Section("llrb.").Trim("llrb.")
 		index := llrb.NewMVCC(name, llrbsetts)
 		index.Setseqno(seqno)
 		infof("%v %v: new mvcc store %q", bogn.logprefix, logprefix, name)
@@ -1224,14 +1974,16 @@ func (bogn *Bogn) newmemstore(
 func (bogn *Bogn) builddiskstore(
 	logprefix string,
 	level, version int, sha, flushunix string, settstodisk s.Settings,
-	itere api.EntryIterator, appendid string, valuelogs []string,
+	itere api.EntryIterator, tombs api.Rangetombs,
+	appendid string, valuelogs, vrewrites []string,
 	what string, appdata []byte) (index api.Index, err error) {
 
 	switch bogn.diskstore {
 	case "bubt":
 		index, err = bogn.builddiskbubt(
-			logprefix, level, version, sha, flushunix, settstodisk, itere,
-			appendid, valuelogs, what, appdata,
+			logprefix, bogn.getdiskpaths(), level, version, sha, flushunix,
+			settstodisk, itere, tombs, appendid, valuelogs, vrewrites, what,
+			appdata,
 		)
 		fmsg := "%v %v: new bubt snapshot %q"
 		infof(fmsg, bogn.logprefix, logprefix, index.ID())
@@ -1241,13 +1993,16 @@ func (bogn *Bogn) builddiskstore(
 }
 
 func (bogn *Bogn) builddiskbubt(
-	logprefix string,
+	logprefix string, paths []string,
 	level, version int, sha, flushunix string, settstodisk s.Settings,
-	itere api.EntryIterator, appendid string, valuelogs []string,
+	itere api.EntryIterator, tombs api.Rangetombs,
+	appendid string, valuelogs, vrewrites []string,
 	what string, appdata []byte) (index api.Index, err error) {
 
-	// book-keep largest seqno for this snapshot.
-	var diskseqno, count uint64
+	// book-keep largest seqno for this snapshot, including the seqno
+	// of range tombstones.
+	var count uint64
+	diskseqno := tombs.Maxseqno()
 	eof := &eofentry{}
 
 	wrap := func(fin bool) (entry api.IndexEntry) {
@@ -1269,7 +2024,6 @@ func (bogn *Bogn) builddiskbubt(
 	dirname := bogn.levelname(level, version, sha)
 
 	bubtsetts := bogn.setts.Section("bubt.").Trim("bubt.")
-	paths := bubtsetts.Strings("diskpaths")
 	msize := bubtsetts.Int64("mblocksize")
 	zsize := bubtsetts.Int64("zblocksize")
 	vsize := bubtsetts.Int64("vblocksize")
@@ -1280,11 +2034,26 @@ func (bogn *Bogn) builddiskbubt(
 	}
 
 	// futher configure bubt builder.
+	zcodec, vcodec := bubtsetts.String("zcodec"), bubtsetts.String("vcodec")
+	bt.Compression(zcodec, vcodec)
+	bt.Bloom(int(bubtsetts.Int64("bloombits")))
+	bt.Restartinterval(int(bubtsetts.Int64("restartinterval")))
+	bt.Comparator(bogn.comparator)
+	// range tombstones cover nothing beyond the oldest level, hence
+	// drop them there, along with the entries they cover. Backup of
+	// memory index is restored on top of disk levels, keep them.
+	oldest := what != "backup" && bogn.isoldestlevel(level)
+	bt.Rangetombs(tombs, oldest)
 	if what == "compact.tombstonepurge" {
 		bt.TombstonePurge(true)
 
 	} else if bogn.isappendvlogs(vsize, what, valuelogs, paths) {
 		bt.AppendValuelogs(vsize, appendid, valuelogs)
+		if len(vrewrites) > 0 {
+			fmsg := "%v %v: rewriting value logs %v"
+			infof(fmsg, bogn.logprefix, logprefix, vrewrites)
+			bt.Rewritevaluelogs(vrewrites)
+		}
 	}
 
 	// build
@@ -1313,6 +2082,9 @@ func (bogn *Bogn) builddiskbubt(
 	if err != nil {
 		errorf("%v OpenSnapshot(): %v", bogn.logprefix, err)
 		return nil, err
+	} else if err = bogn.setblockcache(ndisk); err != nil {
+		ndisk.Close()
+		return nil, err
 	}
 
 	fp := humanize.Bytes(uint64(ndisk.Footprint()))
@@ -1324,6 +2096,21 @@ func (bogn *Bogn) builddiskbubt(
 	return ndisk, nil
 }
 
+// return whether a disk snapshot built for level shall be the oldest
+// level, that is, there is no disk snapshot beyond level.
+func (bogn *Bogn) isoldestlevel(level int) bool {
+	snap := bogn.currsnapshot()
+	if snap == nil {
+		return true
+	}
+	for _, disk := range snap.disks[level+1:] {
+		if disk != nil {
+			return false
+		}
+	}
+	return true
+}
+
 // open latest versions for each disk level
 func (bogn *Bogn) opendisksnaps(
 	setts s.Settings) (disks [16]api.Index, err error) {
@@ -1386,6 +2173,9 @@ func (bogn *Bogn) openbubtsnaps(
 			disk, err := bubt.OpenSnapshot(dirname, paths, mmap)
 			if err != nil {
 				return disks, err
+			} else if err = bogn.setblockcache(disk); err != nil {
+				disk.Close()
+				return disks, err
 			}
 			if disks[level] != nil {
 				panic("impossible situation")
@@ -1397,6 +2187,65 @@ func (bogn *Bogn) openbubtsnaps(
 	return disks, nil
 }
 
+// setblockcache share the block cache, if configured, with disk
+// snapshot opened for this instance.
+func (bogn *Bogn) setblockcache(disk *bubt.Snapshot) error {
+	if bogn.blockcache == nil {
+		return nil
+	}
+	if err := disk.Setblockcache(bogn.blockcache, bogn.pinlevels); err != nil {
+		errorf("%v Setblockcache(): %v", bogn.logprefix, err)
+		return err
+	}
+	return nil
+}
+
+func (bogn *Bogn) bubtlevels(paths []string) ([]s.Settings, error) {
+	levels, dircache := []s.Settings{}, map[string]bool{}
+	for _, path := range paths {
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			errorf("%v bubtlevels.ReadDir(): %v", bogn.logprefix, err)
+			return nil, err
+		}
+		for _, fi := range fis {
+			dirname := fi.Name()
+			if !fi.IsDir() || dircache[dirname] {
+				continue
+			}
+			level, version, uuid := bogn.path2level(dirname)
+			if level < 0 {
+				continue // not a bogn disk level
+			}
+			disk, err := bubt.OpenSnapshot(dirname, paths, false /*mmap*/)
+			if err != nil {
+				return nil, err
+			}
+			levels = append(levels, s.Settings{
+				"id":              dirname,
+				"level":           level,
+				"version":         version,
+				"uuid":            uuid,
+				"seqno":           bogn.getdiskseqno(disk),
+				"flushunix":       strings.Trim(bogn.getflushunix(disk), `"`),
+				"n_count":         disk.Count(),
+				"footprint":       disk.Footprint(),
+				"n_written":       bogn.diskwritebytes(disk),
+			})
+			disk.Close()
+			dircache[dirname] = true
+		}
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		li, lj := levels[i].Int64("level"), levels[j].Int64("level")
+		if li == lj {
+			return levels[i].Int64("version") < levels[j].Int64("version")
+		}
+		return li < lj
+	})
+	return levels, nil
+}
+
 // compact away older versions in disk levels.
 func (bogn *Bogn) compactdisksnaps(
 	logprefix, diskstore string, diskpaths []string, merge bool) error {
@@ -1492,17 +2341,20 @@ func (bogn *Bogn) mergedisksnapshots(
 	flushunix := bogn.getflushunix(disks[0])
 	appdata := bogn.getappdata(disks[0])
 	bogn.setts = disksetts
+	bogn.readcomparator(disksetts)
 
 	fmsg := "%v %v: merging [%v]"
 	infof(fmsg, bogn.logprefix, logprefix, strings.Join(sourceids, ","))
 
-	itere := reduceitere(scans)
+	itere := reduceitere(scans, bogn.cmp)
+	tombs := bogn.indexrangetombs(disks...)
 	level, uuid := 15, bogn.newuuid()
 	diskversions := bogn.getdiskversions(disks[0])
 	version := diskversions[level] + 1
 	ndisk, err := bogn.builddiskstore(
-		logprefix, level, version, uuid, flushunix, disksetts, itere,
-		"" /*appendid*/, nil /*valuelogs*/, "offlinemerge", appdata,
+		logprefix, level, version, uuid, flushunix, disksetts, itere, tombs,
+		"" /*appendid*/, nil /*valuelogs*/, nil /*vrewrites*/, "offlinemerge",
+		appdata,
 	)
 	if err != nil {
 		return err
@@ -1694,6 +2546,23 @@ func (bogn *Bogn) indexfootprint(index api.Index) int64 {
 	panic("unreachable code")
 }
 
+// use bogn's changelog to remember mutations on index.
+func (bogn *Bogn) setchangelog(index api.Index) {
+	switch idx := index.(type) {
+	case *llrb.LLRB:
+		idx.Setchangelog(bogn.changelog)
+	case *llrb.MVCC:
+		idx.Setchangelog(bogn.changelog)
+	}
+}
+
+// wait for read snapshots on index to catch up with its latest mutation.
+func (bogn *Bogn) waitindexseqno(index api.Index) {
+	if idx, ok := index.(*llrb.MVCC); ok && idx != nil {
+		idx.Waitseqno(idx.Getseqno())
+	}
+}
+
 func (bogn *Bogn) indexseqno(index api.Index) uint64 {
 	if index == nil {
 		return 0
@@ -1744,6 +2613,28 @@ func (bogn *Bogn) indexcount(index api.Index) int64 {
 	panic("unreachable code")
 }
 
+// return the union of range tombstones from indexes.
+func (bogn *Bogn) indexrangetombs(indexes ...api.Index) api.Rangetombs {
+	lists := make([]api.Rangetombs, 0, len(indexes))
+	for _, index := range indexes {
+		switch idx := index.(type) {
+		case *llrb.LLRB:
+			if idx != nil {
+				lists = append(lists, idx.Rangetombs())
+			}
+		case *llrb.MVCC:
+			if idx != nil {
+				lists = append(lists, idx.Rangetombs())
+			}
+		case *bubt.Snapshot:
+			if idx != nil {
+				lists = append(lists, idx.Rangetombs())
+			}
+		}
+	}
+	return api.Mergerangetombs(lists...)
+}
+
 // returns approximate payload in index.
 func (bogn *Bogn) indexpayload(index api.Index) int64 {
 	if index == nil {
@@ -1763,20 +2654,31 @@ func (bogn *Bogn) indexpayload(index api.Index) int64 {
 }
 
 // return the oldest snapshots value-logs.
-func (bogn *Bogn) indexvaluelogs(disks []api.Index) (string, []string) {
+// indexvaluelogs return the value logs from the latest of disks, to
+// be appended by the next disk snapshot, along with value logs whose
+// live ratio has fallen below vloggcratio, to be rewritten.
+func (bogn *Bogn) indexvaluelogs(
+	disks []api.Index) (string, []string, []string) {
+
 	if len(disks) == 0 {
-		return "", nil
+		return "", nil, nil
 	}
 	disk := disks[len(disks)-1]
 	if disk == nil {
-		return "", nil
+		return "", nil, nil
 	}
 	switch index := disk.(type) {
 	case *bubt.Snapshot:
 		if index == nil {
-			return "", nil
+			return "", nil, nil
+		}
+		valuelogs, vrewrites := index.Valuelogs(), []string{}
+		for i, ratio := range index.Valuelogratios() {
+			if ratio < bogn.vloggcratio {
+				vrewrites = append(vrewrites, valuelogs[i])
+			}
 		}
-		return index.ID(), index.Valuelogs()
+		return index.ID(), valuelogs, vrewrites
 	}
 	panic("unreachable code")
 }
@@ -1788,10 +2690,9 @@ func (bogn *Bogn) diskwritebytes(disk api.Index) int64 {
 	switch index := disk.(type) {
 	case *bubt.Snapshot:
 		info := index.Info()
-		zsize := info.Int64("zblocksize")
 		msize := info.Int64("mblocksize")
 		vsize := info.Int64("vblocksize")
-		wramplification := info.Int64("n_zblocks") * zsize
+		wramplification := info.Int64("zmem")
 		wramplification += info.Int64("n_mblocks") * msize
 		n_vblocks := info.Int64("n_vblocks")
 		n_ablocks := info.Int64("n_ablocks")
@@ -1867,6 +2768,7 @@ func (bogn *Bogn) newuuid() string {
 func (bogn *Bogn) logstatistics(logprefix string) {
 	n := humanize.Bytes(uint64(atomic.LoadInt64(&bogn.wramplification)))
 	infof("%v %v: write amplifications %v", bogn.logprefix, logprefix, n)
+	bogn.wal.log()
 }
 
 func (bogn *Bogn) isappendvlogs(
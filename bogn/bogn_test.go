@@ -1,14 +1,20 @@
 package bogn
 
 import "io"
+import "os"
 import "fmt"
 import "testing"
 import "time"
 import "sync"
+import "strconv"
+import "strings"
 import "sync/atomic"
 import "math/rand"
+import "path/filepath"
 
+import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/llrb"
+import "github.com/bnclabs/gostore/bubt"
 
 func TestReload(t *testing.T) {
 	destoryindex("index", makepaths())
@@ -236,69 +242,1867 @@ func TestReloadDurable(t *testing.T) {
 	t.Logf("re-reload and iteration successful")
 }
 
-func TestSnaplock(t *testing.T) {
-	bogn := &Bogn{}
-	buffer := make([]byte, 1000)
-	finch := make(chan struct{})
-	var wg sync.WaitGroup
-	var rcounts [16]int64
-	var wcounts [16]int64
+func TestReloadWal(t *testing.T) {
+	destoryindex("index", makepaths())
 
-	reader := func(i int) {
-		defer wg.Done()
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["wal.segmentsize"] = 64 * 1024
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		for {
-			bogn.snaprlock()
-			x := buffer[len(buffer)-1]
-			for _, ch := range buffer {
-				if ch != x {
-					t.Errorf("expected %v, got %v", x, ch)
+	n := 10000
+	k, v := []byte("key000000000000"), []byte("val00000000000000")
+	for i := 0; i < n; i++ {
+		x := fmt.Sprintf("%d", i)
+		key, val := append(k[:3], x...), append(v[:3], x...)
+		mindex.Set(key, val, nil)
+		index.Set(key, val, nil)
+		if i%10 == 0 {
+			mindex.Delete(key, nil, true /*lsm*/)
+			index.Delete(key, nil, true /*lsm*/)
+		}
+		if i%100 == 0 {
+			dokey := append(k[:3], fmt.Sprintf("%d", rand.Intn(i+1))...)
+			keys, val := [][]byte{}, append(v[:3], fmt.Sprintf("txn%d", i)...)
+			for j := 0; j < 4; j++ {
+				x = fmt.Sprintf("%d", rand.Intn(i+1))
+				keys = append(keys, append(k[:3:3], x...))
+			}
+			_, err := committxn(index, func(txn api.Transactor) {
+				for _, key := range keys {
+					txn.Set(key, val, nil)
 				}
+				txn.Delete(dokey, nil, true /*lsm*/)
+			})
+			if err != nil {
+				t.Fatal(err)
 			}
-			bogn.snaprunlock()
-			atomic.AddInt64(&rcounts[i], 1)
+			mtxn := mindex.BeginTxn(0x1234)
+			for _, key := range keys {
+				mtxn.Set(key, val, nil)
+			}
+			mtxn.Delete(dokey, nil, true /*lsm*/)
+			if err := mtxn.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	t.Logf("Loaded %v items", n)
 
-			select {
-			case <-finch:
-				return
-			default:
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	w *= 100
+	time.Sleep(w)
+
+	// order of mutations within a transaction is not deterministic,
+	// remember seqnos from index.
+	seqnos, iter := map[string]uint64{}, index.Scan()
+	key2, _, seqno2, _, err2 := iter(false /*fin*/)
+	for ; err2 == nil; key2, _, seqno2, _, err2 = iter(false /*fin*/) {
+		seqnos[string(key2)] = seqno2
+	}
+	iter(true /*fin*/)
+
+	// simulate a crash, none of the mutations are flushed to disk.
+	index.wal.close()
+
+	//// Reload
+	index, err = New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	time.Sleep(w)
+
+	if x, y := mindex.Getseqno(), index.Getseqno(); x != y {
+		t.Errorf("expected %v, got %v", x, y)
+	}
+	miter := mindex.Scan()
+	iter = index.Scan()
+	key1, val1, _, del1, err1 := miter(false /*fin*/)
+	key2, val2, seqno2, del2, err2 := iter(false /*fin*/)
+	for err1 == nil && err2 == nil {
+		if string(key1) != string(key2) {
+			t.Errorf("expected %q, got %q", key1, key2)
+		} else if seqno1 := seqnos[string(key1)]; seqno1 != seqno2 {
+			t.Errorf("%q expected %v, got %v", key1, seqno1, seqno2)
+		} else if del1 != del2 {
+			t.Errorf("%q expected %v, got %v", key1, del1, del2)
+		} else if del1 == false && string(val1) != string(val2) {
+			t.Errorf("%q expected %q, got %q", key1, val1, val2)
+		}
+		key1, val1, _, del1, err1 = miter(false /*fin*/)
+		key2, val2, seqno2, del2, err2 = iter(false /*fin*/)
+	}
+	if err1 != io.EOF || err2 != io.EOF {
+		t.Errorf("unexpected %v %v", err1, err2)
+	}
+	miter(true /*fin*/)
+	iter(true /*fin*/)
+
+	index.Close()
+	index.Destroy()
+
+	t.Logf("reload from write-ahead-log successful")
+}
+
+func TestRange(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["bubt.zcodec"] = "snappy"
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	load := func(n int, modn int) {
+		k, v := []byte("key000000000000"), []byte("val00000000000000")
+		for i := 0; i < n; i += modn {
+			x := fmt.Sprintf("%d", i)
+			key, val := append(k[:3], x...), append(v[:3], x...)
+			mindex.Set(key, val, nil)
+			index.Set(key, val, nil)
+			if i%10 == 0 {
+				mindex.Delete(key, nil, true /*lsm*/)
+				index.Delete(key, nil, true /*lsm*/)
 			}
 		}
 	}
-	writer := func(i int) {
-		defer wg.Done()
 
-		for {
-			bogn.snaplock()
-			x := byte(rand.Intn(256))
-			for i := range buffer {
-				buffer[i] = x
+	// entries on disk.
+	load(10000, 1)
+	index.Close()
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	// entries in memory.
+	load(10000, 3)
+
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 100)
+
+	for i := 0; i < 100; i++ {
+		low := []byte(fmt.Sprintf("key%d", rand.Intn(10000)))
+		high := []byte(fmt.Sprintf("key%d", rand.Intn(10000)))
+		if i%10 == 0 {
+			low = nil
+		} else if i%10 == 1 {
+			high = nil
+		}
+		incl := []string{"none", "low", "high", "both"}[rand.Intn(4)]
+		reverse := rand.Intn(2) == 1
+
+		miter := mindex.Range(low, high, incl, reverse)
+		iter := index.Range(low, high, incl, reverse)
+		key1, val1, seqno1, del1, err1 := miter(false /*fin*/)
+		key2, val2, seqno2, del2, err2 := iter(false /*fin*/)
+		for err1 == nil && err2 == nil {
+			if string(key1) != string(key2) {
+				t.Fatalf("expected %q, got %q", key1, key2)
+			} else if seqno1 != seqno2 {
+				t.Errorf("%q expected %v, got %v", key1, seqno1, seqno2)
+			} else if del1 != del2 {
+				t.Errorf("%q expected %v, got %v", key1, del1, del2)
+			} else if del1 == false && string(val1) != string(val2) {
+				t.Errorf("%q expected %q, got %q", key1, val1, val2)
 			}
-			bogn.snapunlock()
-			atomic.AddInt64(&wcounts[i], 1)
+			key1, val1, seqno1, del1, err1 = miter(false /*fin*/)
+			key2, val2, seqno2, del2, err2 = iter(false /*fin*/)
+		}
+		if err1 != io.EOF || err2 != io.EOF {
+			t.Errorf("%q %q %v %v: %v %v", low, high, incl, reverse, err1, err2)
+		}
+		miter(true /*fin*/)
+		iter(true /*fin*/)
+	}
 
-			select {
-			case <-finch:
+	index.Close()
+	index.Destroy()
+}
+
+func TestTTL(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index.Set([]byte("key1"), []byte("val1"), nil)
+	index.SetTTL([]byte("key2"), []byte("val2"), nil, time.Hour)
+	index.SetTTL([]byte("key3"), []byte("val3"), nil, time.Second)
+	_, err = committxn(index, func(txn api.Transactor) {
+		txn.SetTTL([]byte("key4"), []byte("val4"), nil, time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash, expiry should be replayed from write-ahead-log.
+	index.wal.close()
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	time.Sleep(2 * time.Second) // wait for key3 to expire.
+
+	refs := []struct {
+		key, value string
+		deleted    bool
+	}{
+		{"key1", "val1", false},
+		{"key2", "val2", false},
+		{"key3", "", true},
+		{"key4", "val4", false},
+	}
+	for _, ref := range refs {
+		value, _, deleted, ok := index.Get([]byte(ref.key), []byte{})
+		if ok == false {
+			t.Errorf("%v expected key", ref.key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.deleted, deleted)
+		} else if string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestTxnConflict(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = false
+	setts["llrb.txnisolation"] = "serializable"
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	// concurrent read-modify-write on the same key shall not lose updates.
+	key, nroutines, nincrs := []byte("counter"), 4, 50
+	index.Set(key, []byte("0"), nil)
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	var wg sync.WaitGroup
+	var rollbacks int64
+	increment := func() {
+		defer wg.Done()
+		for i := 0; i < nincrs; i++ {
+			n, err := committxn(index, func(txn api.Transactor) {
+				value, _, _, _ := txn.Get(key, []byte{})
+				count, _ := strconv.Atoi(string(value))
+				txn.Set(key, []byte(strconv.Itoa(count+1)), nil)
+			})
+			if atomic.AddInt64(&rollbacks, int64(n)); err != nil {
+				t.Error(err)
 				return
-			default:
 			}
 		}
 	}
-	for i := 0; i < 8; i++ {
+	for i := 0; i < nroutines; i++ {
 		wg.Add(1)
-		go reader(i)
-		if (i % 8) == 0 {
-			wg.Add(1)
-			go writer(i)
-		}
+		go increment()
 	}
-	time.Sleep(10 * time.Second)
-	close(finch)
 	wg.Wait()
+	value, _, _, _ := index.Get(key, []byte{})
+	if x := strconv.Itoa(nroutines * nincrs); string(value) != x {
+		t.Errorf("expected %v, got %s", x, value)
+	}
+	t.Logf("%v increments, %v rollbacks", nroutines*nincrs, rollbacks)
 
-	t.Logf("rcounts: %v", rcounts)
-	t.Logf("wcounts: %v", wcounts)
+	// keys only read by a transaction are validated when serializable.
+	index.Set([]byte("key1"), []byte("on"), nil)
+	index.Set([]byte("key2"), []byte("on"), nil)
+	time.Sleep(w * 10)
+
+	txn1 := index.BeginTxn(1)
+	txn1.Get([]byte("key1"), nil)
+	txn1.Get([]byte("key2"), nil)
+	txn2 := index.BeginTxn(2)
+	txn2.Get([]byte("key1"), nil)
+	txn2.Get([]byte("key2"), nil)
+	txn1.Set([]byte("key1"), []byte("off"), nil)
+	txn2.Set([]byte("key2"), []byte("off"), nil)
+	if err := txn1.Commit(); err != nil {
+		t.Errorf("unexpected %v", err)
+	} else if err = txn2.Commit(); err != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err)
+	}
+
+	index.Close()
+	index.Destroy()
 }
 
-// TODO: unit test case
-// Open a bogn instance with one level of disk snapshots,
+func TestTxnWriter(t *testing.T) {
+	for _, memstore := range []string{"llrb", "mvcc"} {
+		testtxnwriter(t, memstore)
+	}
+}
+
+// writers shall not be blocked by transactions left open.
+func testtxnwriter(t *testing.T, memstore string) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["memstore"] = memstore
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	index.Set([]byte("key1"), []byte("val1"), nil)
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 10)
+
+	write := func(key string) chan bool {
+		donech := make(chan bool)
+		go func() {
+			index.Set([]byte(key), []byte("set"), nil)
+			close(donech)
+		}()
+		return donech
+	}
+
+	txn := index.BeginTxn(1)
+	txn.Get([]byte("key1"), []byte{})
+	txn.Set([]byte("key1"), []byte("txn"), nil)
+	select {
+	case <-write("key1"):
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%v writer blocked by open transaction", memstore)
+	}
+	// key written by the transaction was updated by the writer.
+	if err := txn.Commit(); err != api.ErrorRollback {
+		t.Errorf("%v expected %v, got %v", memstore, api.ErrorRollback, err)
+	}
+
+	// writer waiting on open cursor shall not block commit.
+	time.Sleep(w * 10)
+	txn = index.BeginTxn(2)
+	cur, err := txn.OpenCursor(nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if key, _ := cur.Key(); string(key) != "key1" {
+		t.Errorf("%v expected %q, got %q", memstore, "key1", key)
+	}
+	donech := write("key3")
+	time.Sleep(100 * time.Millisecond)
+	txn.Set([]byte("key2"), []byte("txn"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Errorf("%v unexpected %v", memstore, err)
+	}
+	<-donech
+
+	for key, ref := range map[string]string{
+		"key1": "set", "key2": "txn", "key3": "set",
+	} {
+		value, _, _, _ := index.Get([]byte(key), []byte{})
+		if string(value) != ref {
+			t.Errorf("%v %v expected %q, got %q", memstore, key, ref, value)
+		}
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestTxnConflictDisk(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["dgm"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	n := 10000
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close()
+
+	// reload, without enough memory to warmup from disk, keys are read
+	// from disk level.
+	setts["llrb.memcapacity"] = 64 * 1024
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	if atomic.LoadInt64(&index.dgmstate) != 1 {
+		t.Fatalf("expected dgm")
+	}
+
+	key := []byte("key00100")
+	txn := index.BeginTxn(1)
+	if _, cas, _, ok := txn.Get(key, []byte{}); !ok || cas == 0 {
+		t.Fatalf("unexpected %v %v", ok, cas)
+	}
+	txn.Set(key, []byte("txn"), nil)
+	index.Set(key, []byte("set"), nil)
+	if err := txn.Commit(); err != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err)
+	}
+
+	// writes on keys that did not change since they were read commit.
+	key = []byte("key00200")
+	txn = index.BeginTxn(2)
+	txn.Get(key, []byte{})
+	txn.Set(key, []byte("txn"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	if value, _, _, _ := index.Get(key, []byte{}); string(value) != "txn" {
+		t.Errorf("expected %q, got %q", "txn", value)
+	}
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestFeed(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%v", i), fmt.Sprintf("val%v", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close()
+
+	// reload, so that entries are fed from disk.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	feed := index.Feed(50)
+	next := func(seqno uint64, key string, deleted bool) {
+		k, v, s, d, err := feed(false /*fin*/)
+		if err != nil {
+			t.Fatal(err)
+		} else if s != seqno {
+			t.Fatalf("expected %v, got %v", seqno, s)
+		} else if string(k) != key {
+			t.Fatalf("expected %q, got %q", key, k)
+		} else if d != deleted {
+			t.Fatalf("%q expected %v, got %v", key, deleted, d)
+		} else if deleted == false && string(v) != "val"+key[3:] {
+			t.Fatalf("%q unexpected value %q", key, v)
+		}
+	}
+	for seqno := uint64(51); seqno <= 100; seqno++ {
+		next(seqno, fmt.Sprintf("key%v", seqno-1), false)
+	}
+	go func() {
+		for i := 100; i < 200; i++ {
+			key, val := fmt.Sprintf("key%v", i), fmt.Sprintf("val%v", i)
+			index.Set([]byte(key), []byte(val), nil)
+		}
+		index.Delete([]byte("key0"), nil, true /*lsm*/)
+	}()
+	for seqno := uint64(101); seqno <= 200; seqno++ {
+		next(seqno, fmt.Sprintf("key%v", seqno-1), false)
+	}
+	next(201, "key0", true)
+	feed(true /*fin*/)
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestComparator(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["comparator"] = "testreverse"
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close()
+
+	// reload, and merge entries from disk and memory.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 100; i < 200; i += 2 {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 10)
+	check := func(iter api.Iterator, keys []string) {
+		defer iter(true /*fin*/)
+		for _, ref := range keys {
+			key, _, _, _, err := iter(false /*fin*/)
+			if err != nil {
+				t.Fatal(err)
+			} else if string(key) != ref {
+				t.Fatalf("expected %q, got %q", ref, key)
+			}
+		}
+		if _, _, _, _, err := iter(false /*fin*/); err != io.EOF {
+			t.Errorf("expected %v, got %v", io.EOF, err)
+		}
+	}
+	keys := []string{}
+	for i := 198; i >= 100; i -= 2 {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	for i := 99; i >= 0; i-- {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	check(index.Scan(), keys)
+	low, high := []byte("key110"), []byte("key090")
+	check(index.Range(low, high, "both", false /*reverse*/), keys[44:60])
+	if value, _, _, ok := index.Get([]byte("key050"), []byte{}); !ok {
+		t.Errorf("expected key050")
+	} else if string(value) != "val050" {
+		t.Errorf("expected %q, got %q", "val050", value)
+	}
+	index.Close()
+
+	// index cannot be re-opened with a different comparator.
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		setts["comparator"] = api.Binarycomparator
+		New("index", setts)
+	}()
+
+	setts["comparator"] = "testreverse"
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	index.Close()
+	index.Destroy()
+}
+
+func TestBackup(t *testing.T) {
+	for _, memstore := range []string{"llrb", "mvcc"} {
+		testbackup(t, memstore)
+	}
+}
+
+func testbackup(t *testing.T, memstore string) {
+	dir := filepath.Join(os.TempDir(), "bogn-backup")
+	os.RemoveAll(dir)
+	rpaths := []string{}
+	for _, base := range []string{"r1", "r2"} {
+		rpaths = append(rpaths, filepath.Join(os.TempDir(), base))
+	}
+	destoryindex("index", makepaths())
+	destoryindex("restored", strings.Join(rpaths, ","))
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["memstore"] = memstore
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close()
+
+	// reload, and backup entries from disk and memory.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 50; i < 150; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("new%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Delete([]byte("key000"), nil, true /*lsm*/)
+	if err := index.Backup(dir); err != nil {
+		t.Fatal(err)
+	} else if err := index.Backup(dir); err == nil {
+		t.Errorf("expected error")
+	}
+	index.Set([]byte("key999"), []byte("val999"), nil)
+	index.Close()
+	index.Destroy()
+
+	rsetts := makesettings()
+	rsetts["bubt.diskpaths"] = strings.Join(rpaths, ",")
+	rsetts["durable"] = true
+	rsetts["memstore"] = memstore
+	restored, err := Restore(dir, "restored", rsetts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.Start()
+	if seqno := restored.Getseqno(); seqno != 201 {
+		t.Errorf("expected %v, got %v", 201, seqno)
+	}
+	for i := 0; i < 150; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		if i >= 50 {
+			val = fmt.Sprintf("new%03d", i)
+		}
+		value, _, deleted, ok := restored.Get([]byte(key), []byte{})
+		if i == 0 && (ok && deleted == false) {
+			t.Errorf("unexpected %q", key)
+		} else if i > 0 && ok == false {
+			t.Errorf("%v missing %q", memstore, key)
+		} else if i > 0 && string(value) != val {
+			t.Errorf("%q expected %q, got %q", key, val, value)
+		}
+	}
+	if _, _, _, ok := restored.Get([]byte("key999"), []byte{}); ok {
+		t.Errorf("unexpected key999")
+	}
+	if _, err := Restore(dir, "restored", rsetts); err == nil {
+		t.Errorf("expected error")
+	}
+	restored.Close()
+	restored.Destroy()
+	os.RemoveAll(dir)
+}
+
+func TestIngest(t *testing.T) {
+	for _, memstore := range []string{"llrb", "mvcc"} {
+		testingest(t, memstore, false /*dgm*/)
+		testingest(t, memstore, true /*dgm*/)
+	}
+}
+
+func testingest(t *testing.T, memstore string, dgm bool) {
+	destoryindex("index", makepaths())
+
+	// build snapshots to ingest, with seqnos ahead of the index.
+	ipath, ipaths := filepath.Join(os.TempDir(), "bogn-ingest"), []string{}
+	for _, base := range []string{"1", "2", "3"} {
+		ipaths = append(ipaths, filepath.Join(ipath, base))
+	}
+	os.RemoveAll(ipath)
+	buildingest := func(name string, msize int64, from, till int) {
+		mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+		mindex.Setseqno(1000)
+		for i := from; i < till; i++ {
+			key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("ing%03d", i)
+			mindex.Set([]byte(key), []byte(val), nil)
+		}
+		bt, err := bubt.NewBubt(name, ipaths, msize, msize, 0)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := bt.Build(mindex.ScanEntries(), nil); err != nil {
+			t.Fatal(err)
+		}
+		bt.Close()
+		mindex.Destroy()
+	}
+	buildingest("ingest", 4096, 100, 150)
+	buildingest("badsize", 8192, 100, 150)
+	buildingest("overlap", 4096, 90, 150)
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["memstore"] = memstore
+	setts["dgm"] = dgm
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close()
+
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	// mutations in memory, logged but not yet flushed.
+	for i := 40; i < 60; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("mem%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	if err := index.Ingest("badsize", ipaths); err == nil {
+		t.Errorf("expected error")
+	} else if err := index.Ingest("overlap", ipaths); err == nil {
+		t.Errorf("expected error")
+	} else if err := index.Ingest("ingest", ipaths); err != nil {
+		t.Fatal(err)
+	}
+	// mutations after ingest supersede ingested entries.
+	if seqno := index.Getseqno(); seqno != 1050 {
+		t.Errorf("expected %v, got %v", 1050, seqno)
+	}
+	if x := atomic.LoadInt64(&index.dgmstate); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	}
+	index.Set([]byte("key100"), []byte("new100"), nil)
+	if err := index.Ingest("ingest", ipaths); err == nil {
+		t.Errorf("expected error")
+	}
+
+	verify := func(index *Bogn) {
+		for i := 0; i < 150; i++ {
+			key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+			if i == 100 {
+				val = "new100"
+			} else if i > 100 {
+				val = fmt.Sprintf("ing%03d", i)
+			} else if i >= 40 && i < 60 {
+				val = fmt.Sprintf("mem%03d", i)
+			}
+			value, _, _, ok := index.Get([]byte(key), []byte{})
+			if ok == false {
+				t.Errorf("%v missing %q", memstore, key)
+			} else if string(value) != val {
+				t.Errorf("%q expected %q, got %q", key, val, value)
+			}
+		}
+	}
+	verify(index)
+	index.Close()
+
+	// ingested entries shall persist across reload.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	if seqno := index.Getseqno(); seqno != 1051 {
+		t.Errorf("expected %v, got %v", 1051, seqno)
+	}
+	verify(index)
+	index.Close()
+	index.Destroy()
+	os.RemoveAll(ipath)
+}
+
+func TestReverseCursor(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	mindex := llrb.NewLLRB("mindex", llrb.Defaultsettings())
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	load := func(n int, modn int) {
+		k, v := []byte("key000000000000"), []byte("val00000000000000")
+		for i := 0; i < n; i += modn {
+			x := fmt.Sprintf("%d", i)
+			key, val := append(k[:3], x...), append(v[:3], x...)
+			mindex.Set(key, val, nil)
+			index.Set(key, val, nil)
+			if i%10 == 0 {
+				mindex.Delete(key, nil, true /*lsm*/)
+				index.Delete(key, nil, true /*lsm*/)
+			}
+		}
+	}
+
+	// entries on disk.
+	load(10000, 1)
+	index.Close()
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	// entries in memory, newer versions shadow the ones on disk.
+	load(10000, 3)
+
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 100)
+
+	mview, view := mindex.View(0), index.View(0)
+	for i := 0; i < 100; i++ {
+		seek := []byte(fmt.Sprintf("key%d", rand.Intn(10000)))
+		if i%10 == 0 {
+			seek = nil
+		}
+		mcur, err := mview.OpenReverseCursor(seek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cur, err := view.OpenReverseCursor(seek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key1, _ := mcur.Key()
+		if key2, _ := cur.Key(); string(key1) != string(key2) {
+			t.Fatalf("%q expected %q, got %q", seek, key1, key2)
+		}
+		for j := 0; j < 1000; j++ {
+			// walk backwards, with an occasional step forward.
+			mmove, move := mcur.GetPrev, cur.GetPrev
+			if rand.Intn(10) == 0 {
+				mmove, move = mcur.GetNext, cur.GetNext
+			}
+			key1, val1, del1, err1 := mmove()
+			key2, val2, del2, err2 := move()
+			if err1 != nil || err2 != nil {
+				if err1 != io.EOF || err2 != io.EOF {
+					t.Fatalf("%q expected %v, got %v", seek, err1, err2)
+				}
+				break
+			} else if string(key1) != string(key2) {
+				t.Fatalf("%q expected %q, got %q", seek, key1, key2)
+			} else if del1 != del2 {
+				t.Errorf("%q expected %v, got %v", key1, del1, del2)
+			} else if del1 == false && string(val1) != string(val2) {
+				t.Errorf("%q expected %q, got %q", key1, val1, val2)
+			}
+		}
+	}
+	mview.Abort()
+	view.Abort()
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestSnaplock(t *testing.T) {
+	bogn := &Bogn{}
+	buffer := make([]byte, 1000)
+	finch := make(chan struct{})
+	var wg sync.WaitGroup
+	var rcounts [16]int64
+	var wcounts [16]int64
+
+	reader := func(i int) {
+		defer wg.Done()
+
+		for {
+			bogn.snaprlock()
+			x := buffer[len(buffer)-1]
+			for _, ch := range buffer {
+				if ch != x {
+					t.Errorf("expected %v, got %v", x, ch)
+				}
+			}
+			bogn.snaprunlock()
+			atomic.AddInt64(&rcounts[i], 1)
+
+			select {
+			case <-finch:
+				return
+			default:
+			}
+		}
+	}
+	writer := func(i int) {
+		defer wg.Done()
+
+		for {
+			bogn.snaplock()
+			x := byte(rand.Intn(256))
+			for i := range buffer {
+				buffer[i] = x
+			}
+			bogn.snapunlock()
+			atomic.AddInt64(&wcounts[i], 1)
+
+			select {
+			case <-finch:
+				return
+			default:
+			}
+		}
+	}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go reader(i)
+		if (i % 8) == 0 {
+			wg.Add(1)
+			go writer(i)
+		}
+	}
+	time.Sleep(10 * time.Second)
+	close(finch)
+	wg.Wait()
+
+	t.Logf("rcounts: %v", rcounts)
+	t.Logf("wcounts: %v", wcounts)
+}
+
+// TODO: unit test case
+// Open a bogn instance with one level of disk snapshots,
+
+func TestDeleteRange(t *testing.T) {
+	for _, memstore := range []string{"llrb", "mvcc"} {
+		testdeleterange(t, memstore)
+	}
+}
+
+func testdeleterange(t *testing.T, memstore string) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["memstore"] = memstore
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.DeleteRange([]byte("key010"), []byte("key020"))
+	index.Set([]byte("key015"), []byte("new015"), nil)
+	index.DeleteRange([]byte("key090"), nil)
+
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 100)
+
+	ranges := [][2]string{{"key010", "key020"}, {"key090", "~"}}
+	isdeleted := func(key string) bool {
+		for _, r := range ranges {
+			if key != "key015" && key >= r[0] && key < r[1] {
+				return true
+			}
+		}
+		return false
+	}
+	verify := func(index *Bogn, what string) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key%03d", i)
+			value, _, deleted, ok := index.Get([]byte(key), []byte{})
+			if isdeleted(key) {
+				if ok && deleted == false {
+					t.Errorf("%v %v unexpected %q", memstore, what, key)
+				}
+			} else if ok == false || deleted {
+				t.Errorf("%v %v missing %q", memstore, what, key)
+			} else if key == "key015" && string(value) != "new015" {
+				t.Errorf("%v %v unexpected %q", memstore, what, value)
+			}
+		}
+
+		iter := index.Scan()
+		key, _, _, deleted, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, deleted, err = iter(false /*fin*/) {
+			if isdeleted(string(key)) != deleted {
+				t.Errorf("%v %v unexpected %q", memstore, what, key)
+			}
+		}
+		iter(true /*fin*/)
+
+		view := index.View(0)
+		cur, err := view.OpenCursor(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, _, deleted, err = cur.GetNext()
+		for ; err == nil; key, _, deleted, err = cur.GetNext() {
+			if isdeleted(string(key)) != deleted {
+				t.Errorf("%v %v unexpected %q", memstore, what, key)
+			}
+		}
+		view.Abort()
+	}
+	verify(index, "memory")
+
+	// simulate a crash, range tombstones are replayed from wal.
+	index.wal.close()
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	time.Sleep(w * 100)
+	verify(index, "wal")
+	index.Close()
+
+	// range tombstones are flushed to the oldest level, hence dropped
+	// along with the entries they cover.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	verify(index, "disk")
+	iter, count := index.Scan(), 0
+	_, _, _, _, err = iter(false /*fin*/)
+	for ; err == nil; _, _, _, _, err = iter(false /*fin*/) {
+		count++
+	}
+	iter(true /*fin*/)
+	if count != 81 {
+		t.Errorf("%v expected %v, got %v", memstore, 81, count)
+	}
+
+	// range tombstones in memory cover entries on disk.
+	index.DeleteRange([]byte("key050"), []byte("key060"))
+	ranges = append(ranges, [2]string{"key050", "key060"})
+	time.Sleep(w * 100)
+	verify(index, "memory-disk")
+
+	index.Close()
+	index.Destroy()
+}
+
+func TestMerge(t *testing.T) {
+	for _, memstore := range []string{"llrb", "mvcc"} {
+		for _, dgm := range []bool{false, true} {
+			testmerge(t, memstore, dgm)
+		}
+	}
+}
+
+func testmerge(t *testing.T, memstore string, dgm bool) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["dgm"] = dgm
+	setts["memstore"] = memstore
+	setts["mergeoperator"] = "testappend"
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		index.Set([]byte(key), []byte("a"), nil)
+	}
+	index.Merge([]byte("key050"), []byte("x"))
+
+	// simulate a crash, operands are replayed from wal.
+	index.wal.close()
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	if value, _, _, _ := index.Get([]byte("key050"), []byte{}); string(value) != "x" {
+		t.Errorf("%v %v expected %q, got %q", memstore, dgm, "x", value)
+	}
+	index.Close()
+
+	// base values are on disk, operands in memory.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	index.Merge([]byte("key000"), []byte("b"))
+	index.Merge([]byte("key000"), []byte("c"))
+	index.Delete([]byte("key001"), nil, true /*lsm*/)
+	index.Merge([]byte("key001"), []byte("z"))
+
+	w := time.Duration(setts.Int64("llrb.snapshottick")) * time.Millisecond
+	time.Sleep(w * 100)
+
+	refs := map[string]string{"key000": "abc", "key001": "z", "key050": "x"}
+	for i := 2; i < 10; i++ {
+		refs[fmt.Sprintf("key%03d", i)] = "a"
+	}
+	verify := func(index *Bogn, what string) {
+		for key, ref := range refs {
+			value, _, deleted, ok := index.Get([]byte(key), []byte{})
+			if ok == false || deleted {
+				t.Errorf("%v %v %v missing %q", memstore, dgm, what, key)
+			} else if string(value) != ref {
+				fmsg := "%v %v %v %q expected %q, got %q"
+				t.Errorf(fmsg, memstore, dgm, what, key, ref, value)
+			}
+		}
+
+		n, iter := 0, index.Scan()
+		key, value, _, _, err := iter(false /*fin*/)
+		for ; err == nil; key, value, _, _, err = iter(false /*fin*/) {
+			if ref := refs[string(key)]; string(value) != ref {
+				fmsg := "%v %v %v %q expected %q, got %q"
+				t.Errorf(fmsg, memstore, dgm, what, key, ref, value)
+			}
+			n++
+		}
+		iter(true /*fin*/)
+		if n != len(refs) {
+			fmsg := "%v %v %v expected %v, got %v"
+			t.Errorf(fmsg, memstore, dgm, what, len(refs), n)
+		}
+
+		view := index.View(0)
+		cur, err := view.OpenCursor(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, value, _, err = cur.GetNext()
+		for ; err == nil; key, value, _, err = cur.GetNext() {
+			if ref := refs[string(key)]; string(value) != ref {
+				fmsg := "%v %v %v %q expected %q, got %q"
+				t.Errorf(fmsg, memstore, dgm, what, key, ref, value)
+			}
+		}
+		view.Abort()
+	}
+	verify(index, "memory")
+	index.Close()
+
+	// operands are resolved while flushing to disk.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	verify(index, "disk")
+	index.Close()
+	index.Destroy()
+
+	// merge operator is mandatory for Merge.
+	delete(setts, "mergeoperator")
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		index.Merge([]byte("key000"), []byte("b"))
+	}()
+	index.Close()
+	index.Destroy()
+}
+
+func TestBlockcache(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["dgm"] = true
+	setts["bubt.mmap"] = false
+	setts["bubt.blockcache"] = 1024 * 1024
+	setts["bubt.pinlevels"] = 1
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	n := 10000
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close()
+
+	// reload, without enough memory to warmup from disk, lookups are
+	// served from disk snapshot via block cache.
+	setts["llrb.memcapacity"] = 64 * 1024
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for round := 0; round < 2; round++ {
+		for i := 0; i < n; i++ {
+			key, ref := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+			value, _, _, ok := index.Get([]byte(key), []byte{})
+			if !ok {
+				t.Fatalf("expected %q", key)
+			} else if string(value) != ref {
+				t.Fatalf("%q expected %q, got %q", key, ref, value)
+			}
+		}
+	}
+	info := index.blockcache.Info()
+	hits, misses := info.Int64("hits"), info.Int64("misses")
+	t.Logf("hits: %v, misses: %v", hits, misses)
+	if misses == 0 || hits <= misses {
+		t.Errorf("unexpected hits %v, misses %v", hits, misses)
+	}
+	index.Log()
+	index.Close()
+	index.Destroy()
+}
+
+func TestWorkingset(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["dgm"] = true
+	setts["workingset"] = true
+	setts["cachecapacity"] = 64 * 1024
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	n := 10000
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close()
+
+	// reload, without enough memory to warmup from disk, lookups are
+	// cached in working set, cold entries are evicted from cache.
+	setts["llrb.memcapacity"] = 64 * 1024
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	get := func(i int) {
+		key, ref := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+		value, _, _, ok := index.Get([]byte(key), []byte{})
+		if !ok {
+			t.Fatalf("expected %q", key)
+		} else if string(value) != ref {
+			t.Fatalf("%q expected %q, got %q", key, ref, value)
+		}
+	}
+	for i := 0; i < n; i++ {
+		get(i)
+		if i%100 == 0 { // let cacher catch up.
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for round := 0; round < 10; round++ { // skewed reads.
+		for i := 0; i < n; i += 100 {
+			get(i)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := index.Cachestats()
+	t.Logf("%v", stats)
+	hits, evictions := stats["n_hits"].(int64), stats["n_evictions"].(int64)
+	if hits == 0 || evictions == 0 {
+		t.Errorf("unexpected hits %v, evictions %v", hits, evictions)
+	} else if hitratio := stats["hitratio"].(float64); hitratio <= 0 {
+		t.Errorf("unexpected hitratio %v", hitratio)
+	}
+	index.Log()
+	index.Close()
+	index.Destroy()
+}
+
+func TestValuelogGC(t *testing.T) {
+	paths := strings.Split(makepaths(), ",")
+	msize, zsize, vsize := int64(4096), int64(4096), int64(4096)
+
+	setts := llrb.Defaultsettings()
+	mi := llrb.NewLLRB("refllrb", setts)
+	defer mi.Destroy()
+	for i := 0; i < 10000; i++ {
+		key, val := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+		mi.Set([]byte(key), []byte(val), nil)
+	}
+
+	build := func(name string, itere api.EntryIterator, disk *bubt.Snapshot) {
+		bt, err := bubt.NewBubt(name, paths, msize, zsize, vsize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if disk != nil {
+			bt.AppendValuelogs(vsize, disk.ID(), disk.Valuelogs())
+		}
+		if err := bt.Build(itere, nil); err != nil {
+			t.Fatal(err)
+		}
+		bt.Close()
+	}
+
+	itere := mi.ScanEntries()
+	build("testvloggc1", itere, nil)
+	itere(true /*fin*/)
+	disk1, err := bubt.OpenSnapshot("testvloggc1", paths, true /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk1.Destroy()
+	defer disk1.Close()
+
+	// drop 3 out of 4 entries, their values are garbage in value log.
+	count, diter := 0, disk1.ScanEntries()
+	build("testvloggc2", func(fin bool) api.IndexEntry {
+		entry := diter(fin)
+		for count++; count%4 != 0; count++ {
+			entry = diter(fin)
+		}
+		return entry
+	}, disk1)
+	diter(true /*fin*/)
+	disk2, err := bubt.OpenSnapshot("testvloggc2", paths, true /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk2.Destroy()
+	defer disk2.Close()
+
+	index := &Bogn{vloggcratio: 0.5}
+	appendid, valuelogs, vrewrites := index.indexvaluelogs(
+		[]api.Index{disk1, disk2},
+	)
+	if appendid != disk2.ID() {
+		t.Errorf("expected %v, got %v", disk2.ID(), appendid)
+	} else if len(valuelogs) != len(paths) {
+		t.Errorf("expected %v, got %v", len(paths), len(valuelogs))
+	} else if len(vrewrites) != len(valuelogs) {
+		t.Errorf("expected %v, got %v", valuelogs, vrewrites)
+	}
+	// value logs of disk1 are fully referred.
+	_, _, vrewrites = index.indexvaluelogs([]api.Index{disk1})
+	if len(vrewrites) > 0 {
+		t.Errorf("unexpected %v", vrewrites)
+	}
+	index.vloggcratio = 0 // disabled
+	_, _, vrewrites = index.indexvaluelogs([]api.Index{disk2})
+	if len(vrewrites) > 0 {
+		t.Errorf("unexpected %v", vrewrites)
+	}
+}
+
+func TestDegraded(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	n := 1000
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	if err := index.Health(); err != nil {
+		t.Fatalf("unexpected %v", err)
+	}
+
+	// failed compaction turns the index read-only.
+	postfindisk(index, nil, fmt.Errorf("disk full"))
+	for index.Health() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	health, ok := index.Health().(*DegradedError)
+	if !ok || health.Op != "compact" || health.Err.Error() != "disk full" {
+		t.Fatalf("unexpected %v", index.Health())
+	}
+	index.Set([]byte("key"), []byte("value"), nil)
+	if _, _, err := index.SetE([]byte("key"), nil, nil); err != health {
+		t.Errorf("expected %v, got %v", health, err)
+	} else if _, _, _, ok := index.Get([]byte("key"), nil); ok {
+		t.Errorf("unexpected key")
+	}
+	key := []byte("key00001")
+	if _, _, err := index.SetCAS(key, []byte("value"), nil, 0); err != health {
+		t.Errorf("expected %v, got %v", health, err)
+	}
+	txn := index.BeginTxn(0x1234)
+	txn.Set(key, []byte("value"), nil)
+	if err := txn.Commit(); err != health {
+		t.Errorf("expected %v, got %v", health, err)
+	}
+
+	// reads are served.
+	if value, _, _, ok, err := index.GetE(key, []byte{}); err != nil {
+		t.Errorf("unexpected %v", err)
+	} else if !ok || string(value) != "val00001" {
+		t.Errorf("unexpected %q, %v", value, ok)
+	}
+	iter, err := index.ScanE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	_, _, _, _, err = iter(false /*fin*/)
+	for err == nil {
+		count++
+		_, _, _, _, err = iter(false /*fin*/)
+	}
+	if count != n {
+		t.Errorf("expected %v, got %v", n, count)
+	}
+	index.Close()
+
+	// mutations are replayed from write-ahead-log on restart.
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	if err := index.Health(); err != nil {
+		t.Errorf("unexpected %v", err)
+	} else if _, _, _, ok := index.Get(key, nil); !ok {
+		t.Errorf("expected %q", key)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestDegradedWal(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	key := []byte("key00001")
+	index.Set(key, []byte("val00001"), nil)
+
+	// failed write-ahead-log turns the index read-only, without
+	// holding on to the locks or crashing the writer.
+	index.wal.lock()
+	index.wal.fd.Close()
+	index.wal.unlock()
+	_, _, err = index.SetE([]byte("key"), []byte("value"), nil)
+	if health, ok := err.(*DegradedError); !ok || health.Op != "wal" {
+		t.Errorf("unexpected %v", err)
+	} else if err != index.Health() {
+		t.Errorf("expected %v, got %v", index.Health(), err)
+	}
+	index.Set([]byte("key"), []byte("value"), nil)
+	index.SetTTL([]byte("key"), []byte("value"), nil, time.Hour)
+	index.Delete(key, nil, true /*lsm*/)
+	index.DeleteRange([]byte("key"), []byte("key1"))
+	if _, err := index.DeleteRangeE(nil, nil); err != index.Health() {
+		t.Errorf("expected %v, got %v", index.Health(), err)
+	}
+	batch := api.NewBatch(1)
+	batch.Set([]byte("key"), []byte("value"))
+	if err := index.Apply(batch); err != index.Health() {
+		t.Errorf("expected %v, got %v", index.Health(), err)
+	}
+	if value, _, _, ok, err := index.GetE(key, []byte{}); err != nil {
+		t.Errorf("unexpected %v", err)
+	} else if !ok || string(value) != "val00001" {
+		t.Errorf("unexpected %q, %v", value, ok)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestApply(t *testing.T) {
+	destoryindex("index", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n, cas := 1000, uint64(0)
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%05d", i), fmt.Sprintf("val%05d", i)
+		_, cas = index.Set([]byte(key), []byte(val), nil)
+	}
+	batch := api.NewBatch(n)
+	for i := n - 1; i >= 0; i-- {
+		key, val := fmt.Sprintf("key%05d", i), fmt.Sprintf("bat%05d", i)
+		if i%3 == 0 {
+			batch.Delete([]byte(key), true /*lsm*/)
+		} else if i%3 == 1 {
+			batch.SetCAS([]byte(key), []byte(val), uint64(i+1))
+		} else {
+			batch.SetCAS([]byte(key), []byte(val), 0) // mismatch
+		}
+	}
+	if err := index.Apply(batch); err != api.ErrorInvalidCAS {
+		t.Fatalf("expected %v, got %v", api.ErrorInvalidCAS, err)
+	}
+	// batch is applied in sort order, with contiguous seqnos.
+	seqno := cas
+	for i := 0; i < n; i++ {
+		x, err := batch.Result(n - 1 - i)
+		if i%3 == 2 {
+			if x != 0 || err != api.ErrorInvalidCAS {
+				t.Errorf("%v unexpected %v %v", i, x, err)
+			}
+		} else if seqno++; x != seqno || err != nil {
+			t.Errorf("%v expected %v, got %v %v", i, seqno, x, err)
+		}
+	}
+	if x := index.Getseqno(); x != seqno {
+		t.Errorf("expected %v, got %v", seqno, x)
+	}
+
+	// simulate a crash, batch shall be replayed from write-ahead-log.
+	index.wal.close()
+
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	if x := index.Getseqno(); x != seqno {
+		t.Errorf("expected %v, got %v", seqno, x)
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		value, _, deleted, ok := index.Get([]byte(key), []byte{})
+		if !ok {
+			t.Errorf("missing %q", key)
+		} else if i%3 == 0 && !deleted {
+			t.Errorf("expected %q deleted", key)
+		} else if i%3 == 1 && string(value) != fmt.Sprintf("bat%05d", i) {
+			t.Errorf("%q unexpected %q", key, value)
+		} else if i%3 == 2 && string(value) != fmt.Sprintf("val%05d", i) {
+			t.Errorf("%q unexpected %q", key, value)
+		}
+	}
+	index.Close()
+
+	// in dgm, cas of keys not in write store are looked up on disk.
+	setts["dgm"] = true
+	if index, err = New("index", setts); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	key1, key2 := []byte("key00001"), []byte("key00002")
+	_, cas1, _, _ := index.Get(key1, nil)
+	_, cas2, _, _ := index.Get(key2, nil)
+	batch.Reset()
+	batch.SetCAS(key1, []byte("dgm1"), cas1)
+	batch.SetCAS(key2, []byte("dgm2"), cas2+1)          // mismatch
+	batch.SetCAS([]byte("key00003"), []byte("dgm3"), 0) // deleted
+	if err := index.Apply(batch); err != api.ErrorInvalidCAS {
+		t.Fatalf("expected %v, got %v", api.ErrorInvalidCAS, err)
+	}
+	if x, err := batch.Result(0); x != seqno+1 || err != nil {
+		t.Errorf("expected %v, got %v %v", seqno+1, x, err)
+	} else if x, err := batch.Result(1); x != 0 || err == nil {
+		t.Errorf("unexpected %v %v", x, err)
+	} else if x, err := batch.Result(2); x != seqno+2 || err != nil {
+		t.Errorf("expected %v, got %v %v", seqno+2, x, err)
+	}
+	if value, _, _, _ := index.Get(key1, []byte{}); string(value) != "dgm1" {
+		t.Errorf("expected %q, got %q", "dgm1", value)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestSecondary(t *testing.T) {
+	destoryindex("index", makepaths())
+	destoryindex("index.tags", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+
+	// value is a comma separated list of tags.
+	extract := func(key, value []byte) [][]byte {
+		skeys := [][]byte{}
+		for _, tag := range strings.Split(string(value), ",") {
+			if tag != "" {
+				skeys = append(skeys, []byte(tag))
+			}
+		}
+		return skeys
+	}
+	lookup := func(index *Bogn, tag string) string {
+		pkeys, err := index.LookupSecondary("tags", []byte(tag))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss := []string{}
+		for _, pkey := range pkeys {
+			ss = append(ss, string(pkey))
+		}
+		return strings.Join(ss, " ")
+	}
+	scan := func(index *Bogn, low, high, incl string, reverse bool) string {
+		var lowkey, highkey []byte
+		if low != "" {
+			lowkey = []byte(low)
+		}
+		if high != "" {
+			highkey = []byte(high)
+		}
+		iter, err := index.RangeSecondary(
+			"tags", lowkey, highkey, incl, reverse,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss := []string{}
+		skey, pkey, _, _, err := iter(false /*fin*/)
+		for ; err == nil; skey, pkey, _, _, err = iter(false /*fin*/) {
+			ss = append(ss, string(skey)+":"+string(pkey))
+		}
+		iter(true /*fin*/)
+		return strings.Join(ss, " ")
+	}
+	open := func(secondary bool) *Bogn {
+		index, err := New("index", setts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secondary {
+			if err := index.Addsecondary("tags", extract); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return index.Start()
+	}
+
+	// existing entries are indexed when secondary is added.
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Set([]byte("key1"), []byte("red,blue"), nil)
+	index.Set([]byte("key2"), []byte("blue"), nil)
+	if err := index.Addsecondary("tags", extract); err != nil {
+		t.Fatal(err)
+	} else if err := index.Addsecondary("tags", extract); err == nil {
+		t.Errorf("expected error")
+	}
+	index.Start()
+	if x := lookup(index, "blue"); x != "key1 key2" {
+		t.Errorf("expected %q, got %q", "key1 key2", x)
+	}
+
+	index.Set([]byte("key3"), []byte("red,green,red"), nil)
+	index.Set([]byte("key1"), []byte("green"), nil)
+	index.Delete([]byte("key2"), nil, true /*lsm*/)
+	batch := api.NewBatch(4).Set([]byte("key4"), []byte("blue,red"))
+	batch.Delete([]byte("key3"), false /*lsm*/)
+	batch.Set([]byte("key6"), []byte("a,zz"))
+	if err := index.Apply(batch); err != nil {
+		t.Fatal(err)
+	}
+	if x := lookup(index, "red"); x != "key4" {
+		t.Errorf("expected %q, got %q", "key4", x)
+	}
+	_, err = committxn(index, func(txn api.Transactor) {
+		txn.Set([]byte("key5"), []byte("green"), nil)
+		txn.Delete([]byte("key4"), nil, true /*lsm*/)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := map[string]string{
+		"green": "key1 key5", "red": "", "blue": "", "a": "key6",
+	}
+	for tag, ref := range refs {
+		if x := lookup(index, tag); x != ref {
+			t.Errorf("%v expected %q, got %q", tag, ref, x)
+		}
+	}
+
+	index.DeleteRange([]byte("key1"), []byte("key2"))
+	if x := lookup(index, "green"); x != "key5" {
+		t.Errorf("expected %q, got %q", "key5", x)
+	}
+	ref := "zz:key6 green:key5 a:key6"
+	if x := scan(index, "", "", "both", true); x != ref {
+		t.Errorf("expected %q, got %q", ref, x)
+	}
+	if x := scan(index, "a", "zz", "none", false); x != "green:key5" {
+		t.Errorf("expected %q, got %q", "green:key5", x)
+	}
+	if x := scan(index, "b", "zz", "high", false); x != "green:key5 zz:key6" {
+		t.Errorf("expected %q, got %q", "green:key5 zz:key6", x)
+	}
+	if _, err := index.LookupSecondary("colors", []byte("x")); err == nil {
+		t.Errorf("expected error")
+	}
+	index.Close()
+
+	// secondary index is upto date on reopen.
+	index = open(true)
+	if x := lookup(index, "green"); x != "key5" {
+		t.Errorf("expected %q, got %q", "key5", x)
+	}
+	index.Close()
+
+	// mutations while secondary was not added are indexed on reopen.
+	index = open(false)
+	index.Set([]byte("key7"), []byte("a"), nil)
+	index.Delete([]byte("key6"), nil, true /*lsm*/)
+	index.Close()
+
+	index = open(true)
+	if x := scan(index, "", "", "both", false); x != "a:key7 green:key5" {
+		t.Errorf("expected %q, got %q", "a:key7 green:key5", x)
+	}
+	index.Close()
+	index.Destroy()
+}
+
+func TestSecondarySync(t *testing.T) {
+	destoryindex("index", makepaths())
+	destoryindex("index.tags", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	setts["durable"] = true
+	setts["wal.sync"] = "group"
+	extract := func(key, value []byte) [][]byte {
+		return [][]byte{value}
+	}
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := index.Addsecondary("tags", extract); err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	sec := index.getsecondary("tags")
+
+	n, nwriters := 100, 8
+	var wg sync.WaitGroup
+	for i := 0; i < nwriters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				key := []byte(fmt.Sprintf("key%v-%v", i, j))
+				index.Set(key, []byte(fmt.Sprintf("tag%v", j%10)), nil)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := index.Health(); err != nil {
+		t.Errorf("unexpected %v", err)
+	}
+	// secondary updates are durable once the mutations return.
+	pos, w := atomic.LoadInt64(&sec.pos), sec.index.wal
+	w.syncmu.Lock()
+	nsynced := w.nsynced
+	w.syncmu.Unlock()
+	if pos == 0 || nsynced < pos {
+		t.Errorf("expected %v, got %v", pos, nsynced)
+	}
+	pkeys, err := index.LookupSecondary("tags", []byte("tag1"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pkeys) != n*nwriters/10 {
+		t.Errorf("expected %v, got %v", n*nwriters/10, len(pkeys))
+	}
+	stats := sec.index.wal.stats()
+	nrecords, nsyncs := stats["n_records"], stats["n_syncs"]
+	t.Logf("secondary: %v records %v syncs", nrecords, nsyncs)
+	index.Close()
+	index.Destroy()
+}
+
+func TestSecondaryRebuild(t *testing.T) {
+	destoryindex("index", makepaths())
+	destoryindex("index.tags", makepaths())
+
+	setts, paths := makesettings(), makepaths()
+	setts["bubt.diskpaths"] = paths
+	extract := func(key, value []byte) [][]byte {
+		return [][]byte{value}
+	}
+	index, err := New("index", setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+
+	n := 20 * secbatchsize
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		index.Set(key, []byte(fmt.Sprintf("tag%v", i%10)), nil)
+	}
+
+	// writer shall proceed while secondary is rebuilt.
+	var nwrites, done int64
+	started := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n && atomic.LoadInt64(&done) == 0; i++ {
+			index.Set([]byte(fmt.Sprintf("new%06d", i)), []byte("new"), nil)
+			index.Delete([]byte(fmt.Sprintf("key%06d", i)), nil, true)
+			if atomic.AddInt64(&nwrites, 1) == 1 {
+				close(started)
+			}
+		}
+	}()
+	<-started
+	before := atomic.LoadInt64(&nwrites)
+	if err := index.Addsecondary("tags", extract); err != nil {
+		t.Fatal(err)
+	}
+	during := atomic.LoadInt64(&nwrites) - before
+	atomic.StoreInt64(&done, 1)
+	wg.Wait()
+	if during == 0 {
+		t.Errorf("writer blocked while rebuilding secondary")
+	}
+
+	count := func(tag string) int {
+		pkeys, err := index.LookupSecondary("tags", []byte(tag))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(pkeys)
+	}
+	total := 0
+	for i := 0; i < 10; i++ {
+		total += count(fmt.Sprintf("tag%v", i))
+	}
+	nw := int(atomic.LoadInt64(&nwrites))
+	if x := count("new"); x != nw {
+		t.Errorf("expected %v, got %v", nw, x)
+	} else if total != n-nw {
+		t.Errorf("expected %v, got %v", n-nw, total)
+	}
+	sec := index.getsecondary("tags")
+	if x, y := sec.watermark(), index.Getseqno(); x != y {
+		t.Errorf("expected %v, got %v", y, x)
+	}
+	index.Close()
+	index.Destroy()
+}
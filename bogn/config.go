@@ -1,6 +1,7 @@
 package bogn
 
 import s "github.com/bnclabs/gosettings"
+import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/llrb"
 
 // Defaultsettings for bogn instances. Applications can get the default
@@ -17,8 +18,20 @@ import "github.com/bnclabs/gostore/llrb"
 // "diskstore" (string, default: "bubt")
 //		Type of index for in disk storage, can be "bubt".
 //
+// "comparator" (string, default: "binary")
+//		Name of the comparator, registered via api.Registercomparator,
+//		to sort keys in memory and disk levels. Overrides
+//		"llrb.comparator". Comparator is remembered on disk, and an
+//		index cannot be re-opened with a different comparator.
+//
+// "mergeoperator" (string, default: "")
+//		Name of the merge operator, registered via
+//		api.Registermergeoperator, to resolve entries added by Merge().
+//		Overrides "llrb.mergeoperator". If empty, Merge() is not allowed.
+//
 // "durable" (bool, default:false)
-//		Persist index on disk.
+//		Persist index on disk. Every mutation is also appended to a
+//		write-ahead-log under logpath, and replayed on restart.
 //
 // "dgm" (bool, default:false)
 //		Disk-Greater-than-Memory, configure bogn-index whose size won't
@@ -29,6 +42,13 @@ import "github.com/bnclabs/gostore/llrb"
 //      Set this as true only when a subset of keys in bogn-index will
 //      be actived accessed, either for read or write.
 //
+// "cachecapacity" (int64, default: 0)
+//      This configuration is valid only when `workingset` is set to true.
+//      Memory, in bytes, for entries cached from disk levels. Once
+//      exceeded, least recently accessed entries are evicted from the
+//      cache. If set to ZERO, cache is not limited, other than by
+//      flushing it along with memory levels.
+//
 // "autocommit" (int64, default: 100)
 //		Time is seconds to periodically persist transient writes onto disk.
 //		If set to ZERO, then it is upto the application to issue a Commit()
@@ -50,6 +70,32 @@ import "github.com/bnclabs/gostore/llrb"
 //      If the lifetime, measured in seconds, of a disk snapshot exceeds
 //		compactperiod, then it will be merged with next disk level snapshot.
 //
+// "llrb.txnisolation" (string, default: "snapshot")
+//		This configuration is valid only when `memstore` is "mvcc".
+//		With "snapshot" isolation, a transaction is rolled back if any
+//		of the keys it wrote was updated after the transaction read it.
+//		With "serializable" isolation, the transaction is also rolled
+//		back if any of the keys it read via Get was updated. With
+//		"llrb" memstore transactions are always "serializable".
+//
+// "llrb.changelog" (int64, default: 10000)
+//		Number of latest mutations remembered for active feeds, refer
+//		to Bogn.Feed(). Feeds falling behind by more than this many
+//		mutations will catch up by scanning the index.
+//
+// "wal.segmentsize" (int64, default: 67108864)
+//		This configuration is valid only when `durable` is set to true.
+//		Maximum size of a write-ahead-log segment file, once exceeded
+//		a new segment file will be created under logpath.
+//
+// "wal.sync" (string, default: "interval:100")
+//		This configuration is valid only when `durable` is set to true.
+//		Policy to fsync write-ahead-log, can be "always", to fsync
+//		before every write call returns, "group", to have a committer
+//		routine fsync on behalf of all concurrent writers and wake them
+//		together, or "interval:<ms>", to fsync every <ms> milliseconds
+//		without blocking the writers.
+//
 // "bubt.mblocksize" (int64, default: 4096)
 //		BottomsUpBTree, size of intermediate node, m-nodes, on disk.
 //
@@ -59,10 +105,45 @@ import "github.com/bnclabs/gostore/llrb"
 // "bubt.vblocksize" (int64, default: same as mblocksize)
 //		BottomsUpBTree, size of value log blocsk, on disk.
 //
+// "bubt.zcodec" (string, default: "none")
+//		BottomsUpBTree, codec to compress leaf nodes, z-nodes, on disk.
+//		Can be "none" or "snappy".
+//
+// "bubt.vcodec" (string, default: "none")
+//		BottomsUpBTree, codec to compress values in value log, valid
+//		only when vblocksize is > 0. Can be "none" or "snappy".
+//
+// "bubt.bloombits" (int64, default: 10)
+//		BottomsUpBTree, bits per key for bloom filter built for every
+//		disk snapshot, point lookups for missing keys are answered
+//		without reading the disk. Set to 0 to disable bloom filter.
+//
+// "bubt.restartinterval" (int64, default: 0)
+//		BottomsUpBTree, prefix compress keys in leaf nodes and
+//		intermediate nodes, every restartinterval-th key is stored in
+//		full. Set to 0 to store keys in full.
+//
 // "bubt.mmap" (bool, default: true)
 //		BottomsUpBTree, whether to memory-map leaf node, intermediate
 //		nodes are always memory-mapped.
 //
+// "bubt.blockcache" (int64, default: 0)
+//		BottomsUpBTree, capacity in bytes of block cache shared by all
+//		disk snapshots of this instance, to cache leaf nodes and
+//		intermediate nodes when mmap is false. Set to 0 to disable.
+//
+// "bubt.pinlevels" (int64, default: 0)
+//		BottomsUpBTree, number of levels, from the root, of intermediate
+//		nodes to pin in memory for each disk snapshot, valid only when
+//		blockcache is enabled.
+//
+// "bubt.vloggcratio" (float64, default: 0.5)
+//		BottomsUpBTree, value logs are appended across disk snapshots,
+//		when the fraction of a value log still referred by the latest
+//		disk snapshot falls below vloggcratio, its live values are
+//		copied into a new value log by the next compaction. Set to 0
+//		to always append. Valid only when vblocksize is > 0.
+//
 // "bubt.diskpaths" (string, default: "/opt/bogn/")
 //		BottomsUpBTree, comma separated list of path to persist intermediate
 //		nodes and leaf nodes.
@@ -72,14 +153,22 @@ func Defaultsettings() s.Settings {
 		"logpath":       "",
 		"memstore":      "mvcc",
 		"diskstore":     "bubt",
+		"comparator":    api.Binarycomparator,
+		"mergeoperator": "",
 		"durable":       true,
 		"dgm":           false,
 		"workingset":    false,
+		"cachecapacity": 0,
 		"flushratio":    0.25,
 		"autocommit":    100,
 		"compactratio":  0.50,
 		"compactperiod": 300,
 	}
+	walsetts := s.Settings{
+		"wal.segmentsize": 64 * 1024 * 1024,
+		"wal.sync":        "interval:100",
+	}
+	setts = (s.Settings{}).Mixin(setts, walsetts)
 	switch setts.String("memstore") {
 	case "mvcc", "llrb":
 		llrbsetts := llrb.Defaultsettings().AddPrefix("llrb.")
@@ -88,11 +177,18 @@ func Defaultsettings() s.Settings {
 	switch setts.String("diskstore") {
 	case "bubt":
 		bubtsetts := s.Settings{
-			"bubt.diskpaths":  "/opt/bogn/",
-			"bubt.mblocksize": 4096,
-			"bubt.zblocksize": 4096,
-			"bubt.vblocksize": 0,
-			"bubt.mmap":       true,
+			"bubt.diskpaths":       "/opt/bogn/",
+			"bubt.mblocksize":      4096,
+			"bubt.zblocksize":      4096,
+			"bubt.vblocksize":      0,
+			"bubt.zcodec":          "none",
+			"bubt.vcodec":          "none",
+			"bubt.bloombits":       10,
+			"bubt.restartinterval": 0,
+			"bubt.mmap":            true,
+			"bubt.blockcache":      0,
+			"bubt.pinlevels":       0,
+			"bubt.vloggcratio":     0.5,
 		}
 		setts = (s.Settings{}).Mixin(setts, bubtsetts)
 	}
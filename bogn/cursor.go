@@ -1,5 +1,6 @@
 package bogn
 
+import "io"
 import "fmt"
 
 import "github.com/bnclabs/gostore/api"
@@ -17,66 +18,131 @@ type Cursor struct {
 	cas     uint64
 	deleted bool
 
-	iter  api.Iterator
-	iters []api.Iterator
+	iter    api.Iterator
+	iters   []api.Iterator
+	reverse bool
+	eof     bool
 }
 
 func (cur *Cursor) opencursor(t *Txn, v *View, key []byte) (*Cursor, error) {
+	cur.txn, cur.view = t, v
+	if err := cur.open(key, false /*reverse*/); err != nil {
+		return cur, err
+	}
+	_, _, _, _, err := cur.YNext(false /*fin*/)
+	if err != nil && err != io.EOF {
+		return cur, err
+	}
+	return cur, nil
+}
+
+// openreverse position the cursor at the last entry less than or equal
+// to key, if key is nil position the cursor at the last entry.
+func (cur *Cursor) openreverse(
+	t *Txn, v *View, key []byte) (*Cursor, error) {
+
+	cur.txn, cur.view = t, v
+	if err := cur.open(key, true /*reverse*/); err != nil {
+		return cur, err
+	}
+	_, _, _, _, err := cur.YPrev(false /*fin*/)
+	if err != nil && err != io.EOF {
+		return cur, err
+	}
+	return cur, nil
+}
+
+// open a cursor on every snapshot under the transaction, and merge them
+// in ascending or descending sort order. On equal keys, entry with the
+// latest seqno shall win.
+func (cur *Cursor) open(key []byte, reverse bool) error {
 	var mrview, mcview api.Transactor
 	var dviews [32]api.Transactor
 	var dviews1 []api.Transactor
 
-	cur.txn, cur.view = t, v
+	opencur := func(tr api.Transactor) error {
+		if reverse {
+			c, err := tr.OpenReverseCursor(key)
+			if err != nil {
+				return err
+			}
+			cur.iters = append(cur.iters, c.YPrev)
+			return nil
+		}
+		c, err := tr.OpenCursor(key)
+		if err != nil {
+			return err
+		}
+		cur.iters = append(cur.iters, c.YNext)
+		return nil
+	}
+
+	var cmp api.Comparator
+	var tombs api.Rangetombs
+	var get api.Getter
+	var merge api.Mergeoperator
+	var ismerge func([]byte, uint64) bool
 
+	cur.iter, cur.iters, cur.reverse = nil, cur.iters[:0], reverse
 	if cur.txn != nil {
-		mwcur, err := cur.txn.mwtxn.OpenCursor(key)
-		if err != nil {
-			return cur, err
+		if err := opencur(cur.txn.mwtxn); err != nil {
+			return err
 		}
-		cur.iters = append(cur.iters, mwcur.YNext)
 		mrview, mcview = cur.txn.mrview, cur.txn.mcview
 		dviews1 = dviews[:copy(dviews[:], cur.txn.dviews)]
+		cmp, tombs = cur.txn.bogn.cmp, cur.txn.tombs
+		get, merge = cur.txn.yget, cur.txn.bogn.merge
+		ismerge = memmerge(cur.txn.mwtxn, cur.txn.mrview)
 
 	} else if cur.view != nil {
-		mwcur, err := cur.view.mwview.OpenCursor(key)
-		if err != nil {
-			return cur, err
+		if err := opencur(cur.view.mwview); err != nil {
+			return err
 		}
-		cur.iters = append(cur.iters, mwcur.YNext)
 		mrview, mcview = cur.view.mrview, cur.view.mcview
+		cmp, tombs = cur.view.bogn.cmp, cur.view.tombs
+		get, merge = cur.view.yget, cur.view.bogn.merge
+		ismerge = memmerge(cur.view.mwview, cur.view.mrview)
 		dviews1 = dviews[:copy(dviews[:], cur.view.dviews)]
 	}
 
 	if mrview != nil {
-		mcur, err := mrview.OpenCursor(key)
-		if err != nil {
-			return cur, err
+		if err := opencur(mrview); err != nil {
+			return err
 		}
-		cur.iters = append(cur.iters, mcur.YNext)
 	}
 	if mcview != nil {
-		mcur, err := mcview.OpenCursor(key)
-		if err != nil {
-			return cur, err
+		if err := opencur(mcview); err != nil {
+			return err
 		}
-		cur.iters = append(cur.iters, mcur.YNext)
 	}
 	for _, dview := range dviews1 {
-		dcur, err := dview.OpenCursor(key)
-		if err != nil {
-			return cur, err
+		if err := opencur(dview); err != nil {
+			return err
 		}
-		cur.iters = append(cur.iters, dcur.YNext)
 	}
 	if len(cur.iters) > 0 {
-		cur.iter = cur.iters[len(cur.iters)-1]
-		for i := len(cur.iters) - 2; i >= 0; i-- {
-			cur.iter = lsm.YSort(cur.iters[i], cur.iter)
-		}
+		cur.iter = reduceiter(cur.iters, reverse, cmp)
+		cur.iter = lsm.YRangetombs(cur.iter, tombs, cmp)
+		cur.iter = mergeiter(cur.iter, ismerge, get, merge)
 	}
+	return nil
+}
 
-	cur.YNext(false /*fin*/)
-	return cur, nil
+// turn the cursor around, if it is not already iterating in the
+// direction of reverse. Cursor shall point to the same entry.
+func (cur *Cursor) turn(reverse bool) error {
+	if cur.reverse == reverse || cur.eof {
+		return nil
+	}
+	key := lib.Fixbuffer(nil, int64(len(cur.key)))
+	copy(key, cur.key)
+	if err := cur.open(key, reverse); err != nil {
+		return err
+	}
+	if _, _, _, _, err := cur.iter(false /*fin*/); err != nil {
+		return err
+	}
+	return nil
 }
 
 // Key return current key under the cursor. Returned byte slice will
@@ -101,6 +167,14 @@ func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	return cur.key, cur.value, cur.deleted, err
 }
 
+// GetPrev move cursor to previous entry in snapshot and return its key
+// and value. Returned byte slices will be a reference to index entry,
+// hence must not be used after transaction is committed or aborted.
+func (cur *Cursor) GetPrev() (key, value []byte, deleted bool, err error) {
+	_, _, _, _, err = cur.YPrev(false /*fin*/)
+	return cur.key, cur.value, cur.deleted, err
+}
+
 // Set is an alias to txn.Set call. The current position of the cursor
 // does not affect the set operation.
 func (cur *Cursor) Set(key, value, oldvalue []byte) []byte {
@@ -132,7 +206,31 @@ func (cur *Cursor) Delcursor(lsm bool) {
 func (cur *Cursor) YNext(
 	fin bool) (key, value []byte, cas uint64, deleted bool, err error) {
 
+	if err = cur.turn(false /*reverse*/); err != nil {
+		return nil, nil, 0, false, err
+	}
+	return cur.move()
+}
+
+// YPrev can be used for reverse lsm-sort.
+func (cur *Cursor) YPrev(
+	fin bool) (key, value []byte, cas uint64, deleted bool, err error) {
+
+	if err = cur.turn(true /*reverse*/); err != nil {
+		return nil, nil, 0, false, err
+	}
+	return cur.move()
+}
+
+func (cur *Cursor) move() (
+	key, value []byte, cas uint64, deleted bool, err error) {
+
+	if cur.iter == nil {
+		cur.eof = true
+		return nil, nil, 0, false, io.EOF
+	}
 	key, value, cur.cas, cur.deleted, err = cur.iter(false /*fin*/)
+	cur.eof = err != nil
 
 	cur.key = lib.Fixbuffer(cur.key, int64(len(key)))
 	copy(cur.key, key)
@@ -31,3 +31,11 @@ func (entry *eofentry) ID() string {
 func (entry *eofentry) Valueref() (valuelen uint64, vlogpos int64) {
 	return 0, -1
 }
+
+func (entry *eofentry) Expiry() uint64 {
+	return 0
+}
+
+func (entry *eofentry) Ismerge() bool {
+	return false
+}
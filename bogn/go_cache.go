@@ -12,14 +12,20 @@ type setcache struct {
 	key     []byte
 	value   []byte
 	seqno   uint64
+	expiry  uint64
 	deleted bool
 }
 
+// evictperiod number of entries cached between successive checks on
+// cache's memory against "cachecapacity".
+const evictperiod = 256
+
+// mc is shared by successive snapshots, and shall be destroyed by the
+// purger along with the last snapshot referring to it.
 func cacher(bogn *Bogn, mc api.Index, setch, cachech chan *setcache) {
 	infof("%v rcacher: starting for %s ...", bogn.logprefix, mc.ID())
 
 	defer func() {
-		mc.Destroy()
 		if r := recover(); r != nil {
 			errorf("%v cacher crashed %v", bogn.logprefix, r)
 			errorf("\n%s", lib.GetStacktrace(2, debug.Stack()))
@@ -38,17 +44,71 @@ func cacher(bogn *Bogn, mc api.Index, setch, cachech chan *setcache) {
 		}
 	}
 
+	setexpiry := func(key, value []byte, expiry uint64) (cas uint64) {
+		switch index := mc.(type) {
+		case *llrb.LLRB:
+			_, cas = index.SetExpiry(key, value, nil, expiry)
+		case *llrb.MVCC:
+			_, cas = index.SetExpiry(key, value, nil, expiry)
+		}
+		return cas
+	}
+
+	// evict cold entries to bring cache's memory within its capacity,
+	// only one cacher shall evict at any given time.
+	evict := func() {
+		if !atomic.CompareAndSwapInt64(&bogn.evicting, 0, 1) {
+			return
+		}
+		defer atomic.StoreInt64(&bogn.evicting, 0)
+
+		var n int64
+		switch index := mc.(type) {
+		case *llrb.LLRB:
+			n = index.Evict(bogn.cachecapacity)
+		case *llrb.MVCC:
+			n = index.Evict(bogn.cachecapacity)
+		}
+		if n > 0 {
+			atomic.AddInt64(&bogn.n_evictions, n)
+			fmsg := "%v rcacher: evicted %v entries from %s"
+			debugf(fmsg, bogn.logprefix, n, mc.ID())
+		}
+	}
+
 	atomic.AddInt64(&bogn.nroutines, 1)
-	for cmd := range setch {
+	ncached := 0
+loop:
+	for {
+		var cmd *setcache
+		select {
+		case cmd = <-setch:
+			if cmd == nil { // snapshot is purged.
+				break loop
+			}
+		case <-bogn.finch:
+			break loop
+		}
+
 		setseqno(cmd.seqno - 1)
 		if cmd.deleted { // delete in lsm mode.
 			if _, cas := mc.Delete(cmd.key, nil, true); cas != cmd.seqno {
 				panic("impossible situation")
 			}
 
-		} else if _, cas := mc.Set(cmd.key, cmd.value, nil); cas != cmd.seqno {
-			panic("impossible situation")
+		} else {
+			cas := setexpiry(cmd.key, cmd.value, cmd.expiry)
+			if cas != cmd.seqno {
+				panic("impossible situation")
+			}
+		}
+		select {
+		case cachech <- cmd:
+		default:
+		}
+
+		if ncached++; bogn.cachecapacity > 0 && ncached%evictperiod == 0 {
+			evict()
 		}
-		cachech <- cmd
 	}
 }
@@ -21,6 +21,7 @@ var Compacttick = time.Duration(1 * time.Second)
 // list worker functions
 // dopersist(bogn *Bogn) (err error)
 // doflush(bogn *Bogn, disks []api.Index) (err error)
+// doingest(bogn *Bogn, disks []api.Index, ingest api.Index) error
 //   startdisk( bogn *Bogn, disks []api.Index, nlevel int)
 //   findisk(bogn *Bogn, disks []api.Index, ndisk api.Index) error
 // dowindup(bogn *Bogn) error
@@ -103,16 +104,19 @@ func compactor(bogn *Bogn, compactorch chan []interface{}) {
 		}
 	}
 
+	// failures in flush or compaction shall turn the index read-only,
+	// refer Bogn.Health(), and no more disk snapshots are built.
 	tryfindisk := func(ndisk api.Index, err error) {
-		if err != nil {
-			panic(err)
-
-		} else if ndisk == nil {
-			panic("impossible case")
+		if err == nil && ndisk == nil {
+			err = fmt.Errorf("impossible case")
 		}
-
-		if err := findisk(bogn, disks, ndisk); err != nil {
-			panic(err)
+		if err == nil {
+			err = bogn.trydo(func() error {
+				return findisk(bogn, disks, ndisk)
+			})
+		}
+		if err != nil {
+			bogn.setdegraded("compact", err)
 		}
 		if tombstonepurge && tspch != nil {
 			tspch <- []interface{}{nil}
@@ -121,6 +125,22 @@ func compactor(bogn *Bogn, compactorch chan []interface{}) {
 		activecompaction, tombstonepurge = false, false
 	}
 
+	tryflushing := func(appdata []byte) {
+		if bogn.Health() != nil {
+			return
+		}
+		if activecompaction == false {
+			trystartdisk()
+		}
+		// only blocking call !!
+		err := bogn.trydo(func() error {
+			return doflushing(disks, appdata)
+		})
+		if err != nil {
+			bogn.setdegraded("flush", err)
+		}
+	}
+
 	docmd := func(cmd []interface{}) {
 		switch cmdname := cmd[0].(string); cmdname {
 		case "compact.tombstonepurge":
@@ -129,29 +149,21 @@ func compactor(bogn *Bogn, compactorch chan []interface{}) {
 		case "compact.autocommit":
 			appdata, respch := []byte(nil), cmd[1].(chan []interface{})
 			if bogn.durable { // disk is not involved.
-				if activecompaction == false {
-					trystartdisk()
-				}
-				// only blocking call !!
-				if err := doflushing(disks, appdata); err != nil {
-					panic(err)
-				}
+				tryflushing(appdata)
 			}
 			respch <- []interface{}{nil}
 
 		case "compact.commit":
 			appdata, respch := cmd[1].([]byte), cmd[2].(chan []interface{})
 			if bogn.durable { // disk is not involved.
-				if activecompaction == false {
-					trystartdisk()
-				}
-				// only blocking call !!
-				if err := doflushing(disks, appdata); err != nil {
-					panic(err)
-				}
+				tryflushing(appdata)
 			}
 			respch <- []interface{}{nil}
 
+		case "compact.ingest":
+			ingest, respch := cmd[1].(api.Index), cmd[2].(chan []interface{})
+			respch <- []interface{}{doingest(bogn, disks, ingest)}
+
 		case "compact.findisk":
 			a, b, ndisk, err := cmd[1], cmd[2], api.Index(nil), error(nil)
 			if a != nil {
@@ -165,8 +177,11 @@ func compactor(bogn *Bogn, compactorch chan []interface{}) {
 		case "compact.close":
 			closed = true
 			respch := cmd[1].(chan []interface{})
-			if err := dowindup(bogn); err != nil {
-				panic(err)
+			if bogn.Health() == nil {
+				err := bogn.trydo(func() error { return dowindup(bogn) })
+				if err != nil {
+					bogn.setdegraded("windup", err)
+				}
 			}
 			respch <- []interface{}{nil}
 		}
@@ -252,9 +267,11 @@ func dopersist(bogn *Bogn, appdata []byte) (err error) {
 
 	// iterate on snap.mw
 	itere, uuid := snap.persistiterator(), bogn.newuuid()
+	tombs := bogn.indexrangetombs(snap.mw)
 	ndisk, err := bogn.builddiskstore(
 		"dopersist", level, nversion, uuid, "" /*flushunix*/, disksetts, itere,
-		"" /*appendid*/, nil /*valuelogs*/, "persist", appdata,
+		tombs, "" /*appendid*/, nil /*valuelogs*/, nil /*vrewrites*/, "persist",
+		appdata,
 	)
 	if err != nil {
 		return err
@@ -276,6 +293,7 @@ func dopersist(bogn *Bogn, appdata []byte) (err error) {
 		defer bogn.snapunlock()
 
 		head := newsnapshot(bogn, snap.mw, nil, nil, disks, uuid, lastseqno)
+		head.ygete = noerror(head.mw.Get)
 		head.yget = head.mw.Get
 		atomic.StorePointer(&head.next, unsafe.Pointer(snap))
 		head.refer()
@@ -288,7 +306,8 @@ func dopersist(bogn *Bogn, appdata []byte) (err error) {
 		infof(fmsg, snap.bogn.logprefix, head.attributes(), ndisk.ID())
 	}()
 
-	return nil
+	// mutations upto lastseqno are durable on disk.
+	return bogn.wal.truncate(lastseqno)
 }
 
 func doflush(
@@ -361,10 +380,11 @@ func doflush(
 	// iterate on snap.mr [+ snap.mc] [+ fdisks]
 	uuid = bogn.newuuid()
 	itere := snap.flushiterator(fdisks)
-	appendid, valuelogs := bogn.indexvaluelogs(fdisks)
+	tombs := bogn.indexrangetombs(append([]api.Index{snap.mr}, fdisks...)...)
+	appendid, valuelogs, vrewrites := bogn.indexvaluelogs(fdisks)
 	ndisk, err := bogn.builddiskstore(
 		"doflush", nlevel, nversion, uuid, "" /*flushunix*/, disksetts, itere,
-		appendid, valuelogs, what, appdata,
+		tombs, appendid, valuelogs, vrewrites, what, appdata,
 	)
 	if err != nil {
 		return err
@@ -373,8 +393,9 @@ func doflush(
 
 	bogn.addamplification(ndisk)
 
-	if lastseqno := bogn.getdiskseqno(ndisk); lastseqno != mwseqno {
-		panic(fmt.Errorf("lastseqno(%v) != mwseqno(%v)", lastseqno, mwseqno))
+	// seqnos skipped by Ingest() leave no entry behind.
+	if lastseqno := bogn.getdiskseqno(ndisk); lastseqno > mwseqno {
+		panic(fmt.Errorf("lastseqno(%v) > mwseqno(%v)", lastseqno, mwseqno))
 	}
 
 	var ndisks [16]api.Index
@@ -413,14 +434,15 @@ func doflush(
 		infof(fmsg, snap.bogn.logprefix, head.attributes(), ndisk.ID())
 	}()
 
-	return nil
+	// mutations upto mwseqno are durable on disk.
+	return bogn.wal.truncate(mwseqno)
 }
 
 func startdisk(bogn *Bogn, disks []api.Index, nlevel int, what string) {
 	infof("%v startdisk ...", bogn.logprefix)
 
 	disk0 := disks[0]
-	itere, uuid := compactiterator(disks), bogn.newuuid()
+	itere, uuid := compactiterator(disks, bogn.cmp), bogn.newuuid()
 	nversion := bogn.nextdiskversion(nlevel)
 	disksetts := (s.Settings{}).Mixin(bogn.settingsfromdisk(disk0))
 	flushunix := bogn.getflushunix(disk0)
@@ -430,16 +452,21 @@ func startdisk(bogn *Bogn, disks []api.Index, nlevel int, what string) {
 	for _, disk := range disks {
 		ids = append(ids, disk.ID())
 	}
-	appendid, valuelogs := bogn.indexvaluelogs(disks)
+	tombs := bogn.indexrangetombs(disks...)
+	appendid, valuelogs, vrewrites := bogn.indexvaluelogs(disks)
 
 	go func() {
 		fmsg := "%v startdisk: compaction (%v) %v ..."
 		infof(fmsg, bogn.logprefix, what, strings.Join(ids, " + "))
 
-		ndisk, err := bogn.builddiskstore(
-			"startdisk", nlevel, nversion, uuid, flushunix, disksetts, itere,
-			appendid, valuelogs, what, appdata,
-		)
+		var ndisk api.Index
+		err := bogn.trydo(func() (err error) {
+			ndisk, err = bogn.builddiskstore(
+				"startdisk", nlevel, nversion, uuid, flushunix, disksetts,
+				itere, tombs, appendid, valuelogs, vrewrites, what, appdata,
+			)
+			return err
+		})
 		itere(true /*fin*/)
 		if err != nil {
 			postfindisk(bogn, nil, err)
@@ -527,10 +554,13 @@ func dowindup(bogn *Bogn) error {
 	snap.finalizeindex(snap.mw)
 
 	itere, uuid := snap.windupiterator(purgedisk), bogn.newuuid()
-	appendid, valuelogs := bogn.indexvaluelogs([]api.Index{purgedisk})
+	tombs := bogn.indexrangetombs(snap.mw, purgedisk)
+	appendid, valuelogs, vrewrites := bogn.indexvaluelogs(
+		[]api.Index{purgedisk},
+	)
 	ndisk, err := bogn.builddiskstore(
 		"dowindup", nlevel, nversion, uuid, "" /*flushunix*/, disksetts, itere,
-		appendid, valuelogs, "windup", nil, /*appdata*/
+		tombs, appendid, valuelogs, vrewrites, "windup", nil, /*appdata*/
 	)
 	if err != nil {
 		return err
@@ -559,7 +589,9 @@ func dowindup(bogn *Bogn) error {
 		fmsg := "%v dowindup: new snapshot %s windup on disk %v"
 		infof(fmsg, snap.bogn.logprefix, head.attributes(), ndisk.ID())
 	}()
-	return nil
+
+	// all mutations are durable on disk.
+	return bogn.wal.truncate(bogn.getdiskseqno(ndisk))
 }
 
 func compactticker(bogn *Bogn, compactorch chan []interface{}) {
@@ -0,0 +1,59 @@
+package bogn
+
+import "fmt"
+import "unsafe"
+import "sync/atomic"
+import "runtime/debug"
+
+import "github.com/bnclabs/gostore/lib"
+
+// DegradedError is reported by Health(), and returned by write APIs,
+// once a background flush or compaction has failed. After that index
+// is read-only, for durable index mutations already logged in
+// write-ahead-log shall be replayed when index is re-opened.
+type DegradedError struct {
+	Op  string // background operation that failed.
+	Err error  // cause of failure.
+}
+
+func (err *DegradedError) Error() string {
+	return fmt.Sprintf("bogn.degraded %v: %v", err.Op, err.Err)
+}
+
+// Health return nil if index is healthy, else return *DegradedError
+// describing the first background failure, index is read-only after
+// that.
+func (bogn *Bogn) Health() error {
+	if ptr := atomic.LoadPointer(&bogn.health); ptr != nil {
+		return (*DegradedError)(ptr)
+	}
+	return nil
+}
+
+// setdegraded turn index read-only, only the first failure is
+// remembered.
+func (bogn *Bogn) setdegraded(op string, err error) {
+	degraded := &DegradedError{Op: op, Err: err}
+	ptr := unsafe.Pointer(degraded)
+	if atomic.CompareAndSwapPointer(&bogn.health, nil, ptr) {
+		errorf("%v %v, index is read-only", bogn.logprefix, degraded)
+	}
+}
+
+// trydo call fn, converting a panic, like disk errors while building
+// a disk snapshot, into error. Background routines can then degrade
+// the index instead of crashing.
+func (bogn *Bogn) trydo(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+			errorf("%v %v", bogn.logprefix, err)
+			errorf("\n%s", lib.GetStacktrace(2, debug.Stack()))
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,262 @@
+package bogn
+
+import "io"
+import "fmt"
+import "unsafe"
+import "sync/atomic"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+import "github.com/bnclabs/gostore/bubt"
+
+// Ingest an externally built bubt snapshot, found under paths by
+// name, into this index, by linking its files in as a new disk level
+// without rewriting the entries. Snapshot shall be built with the same
+// block sizes, comparator and number of disk paths as configured for
+// this index. Keys in the snapshot, along with its range tombstones,
+// shall not overlap with entries or range tombstones already in the
+// index, else an error is returned. If the snapshot carries seqnos
+// beyond the last mutation, index seqno is moved ahead, so mutations
+// applied after Ingest() supersede ingested entries. New level is
+// newer than all disk levels and index falls back to dgm mode, if not
+// already. Ingest is serialized with memory flush and disk compaction,
+// and is durable once it returns. Only supported for durable index.
+// Files of the snapshot are hard-linked, or copied, and the snapshot
+// itself is left untouched.
+func (bogn *Bogn) Ingest(name string, paths []string) error {
+	if bogn.durable == false {
+		return fmt.Errorf("ingest not supported for non-durable index")
+	} else if bogn.diskstore != "bubt" {
+		return fmt.Errorf("ingest not supported for %q", bogn.diskstore)
+	} else if err := bogn.Health(); err != nil {
+		return err
+	}
+
+	disk, err := bubt.OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		errorf("%v ingest: %v", bogn.logprefix, err)
+		return err
+	}
+	defer disk.Close()
+
+	if err := bogn.isingestable(disk); err != nil {
+		return err
+	} else if disk.Count() == 0 && len(disk.Rangetombs()) == 0 {
+		infof("%v ingest: nothing to ingest from %q", bogn.logprefix, name)
+		return nil
+	}
+
+	respch := make(chan []interface{}, 1)
+	cmd := []interface{}{"compact.ingest", api.Index(disk), respch}
+	resp, err := lib.FailsafeRequest(
+		bogn.compactorch, respch, cmd, bogn.finch,
+	)
+	if err != nil {
+		return err
+	} else if resp[0] != nil {
+		return resp[0].(error)
+	}
+	bogn.secondaryingest(disk)
+	return bogn.synclog(0, nil) // wait for secondary indexes.
+}
+
+// isingestable check that disk is built with the same configuration
+// as that of the index.
+func (bogn *Bogn) isingestable(disk *bubt.Snapshot) error {
+	info := disk.Info()
+	bubtsetts := bogn.setts.Section("bubt.").Trim("bubt.")
+	for _, key := range []string{"mblocksize", "zblocksize", "vblocksize"} {
+		size1, size2 := info.Int64(key), bubtsetts.Int64(key)
+		if size1 != size2 {
+			fmsg := "found %v:%v in %q, expected %v"
+			return fmt.Errorf(fmsg, key, size1, disk.ID(), size2)
+		}
+	}
+	comparator := info.String("comparator")
+	if comparator != bogn.comparator {
+		fmsg := "found comparator:%q in %q, expected %q"
+		return fmt.Errorf(fmsg, comparator, disk.ID(), bogn.comparator)
+	}
+	numpaths, diskpaths := info.Int64("numpaths"), bogn.getdiskpaths()
+	if numpaths != int64(len(diskpaths)) {
+		fmsg := "found numpaths:%v in %q, expected %v"
+		return fmt.Errorf(fmsg, numpaths, disk.ID(), len(diskpaths))
+	}
+	return nil
+}
+
+// called by compactor, link ingest as a disk level newer than all
+// the disk levels. Failures leave the index as it is.
+func doingest(bogn *Bogn, disks []api.Index, ingest api.Index) error {
+	low, high, err := bogn.ingestspan(ingest.(*bubt.Snapshot))
+	if err != nil {
+		return err
+	}
+	snap := bogn.currsnapshot()
+	if err := bogn.isoverlapping(snap, low, high); err != nil {
+		return err
+	}
+
+	// mutations upto seqno, of the latest disk level, are durable on
+	// disk, rest shall be replayed from log on restart.
+	var seqno uint64
+	var flushunix string
+	var appdata []byte
+	nlevel := len(snap.disks) - 1
+	if latestlevel, disk := snap.latestlevel(); latestlevel == 0 {
+		return fmt.Errorf("no free level to ingest %q", ingest.ID())
+	} else if disk != nil {
+		nlevel = latestlevel - 1
+		seqno, flushunix = bogn.getdiskseqno(disk), bogn.getflushunix(disk)
+		appdata = bogn.getappdata(disk)
+	}
+	nversion, uuid := bogn.nextdiskversion(nlevel), bogn.newuuid()
+	disksetts := bogn.settingstodisk()
+	metadata := bogn.mwmetadata(seqno, flushunix, appdata, disksetts)
+
+	name := bogn.levelname(nlevel, nversion, uuid)
+	paths := bogn.getdiskpaths()
+	fmsg := "%v doingest: linking %v as %v"
+	infof(fmsg, bogn.logprefix, ingest.ID(), name)
+	err = ingest.(*bubt.Snapshot).Link(name, paths, metadata)
+	if err != nil {
+		return err
+	}
+	// like a fresh flush, newest level is mmaped.
+	ndisk, err := bubt.OpenSnapshot(name, paths, true /*mmap*/)
+	if err != nil {
+		bubt.PurgeSnapshot(name, paths)
+		return err
+	} else if err = bogn.setblockcache(ndisk); err != nil {
+		ndisk.Close()
+		ndisk.Destroy()
+		return err
+	}
+
+	func() {
+		bogn.snaplock()
+		defer bogn.snapunlock()
+
+		// writers are blocked, check again for mutations applied
+		// after the first check.
+		snap := bogn.currsnapshot()
+		if err = bogn.isoverlapping(snap, low, high); err != nil {
+			return
+		}
+		beginseqno := snap.moveseqno(ndisk.Getseqno())
+		atomic.StoreInt64(&bogn.dgmstate, 1)
+
+		var ndisks [16]api.Index
+		copy(ndisks[:], snap.disks[:])
+		ndisks[nlevel] = ndisk
+
+		mw, mr, mc := snap.mw, snap.mr, snap.mc
+		head := newsnapshot(bogn, mw, mr, mc, ndisks, uuid, beginseqno)
+		atomic.StorePointer(&head.next, unsafe.Pointer(snap))
+		head.refer()
+		bogn.setheadsnapshot(head)
+		snap.release()
+
+		fmsg := "%v doingest: new snapshot %v after ingesting %v"
+		infof(fmsg, bogn.logprefix, head.attributes(), ndisk.ID())
+	}()
+	if err != nil {
+		ndisk.Close()
+		ndisk.Destroy()
+		return err
+	}
+	return nil
+}
+
+// ingestspan return the smallest and the largest key, both inclusive,
+// touched by entries and range tombstones in ingest. Nil bound is
+// treated as unbounded.
+func (bogn *Bogn) ingestspan(
+	ingest *bubt.Snapshot) (low, high []byte, err error) {
+
+	lows, highs := [][]byte{}, [][]byte{}
+	if ingest.Count() > 0 {
+		for _, reverse := range []bool{false, true} {
+			iter, err := ingest.RangeE(nil, nil, "both", reverse)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, _, _, _, err := iter(false /*fin*/)
+			key = copybytes(key)
+			iter(true /*fin*/)
+			if err != nil {
+				return nil, nil, err
+			} else if reverse {
+				highs = append(highs, key)
+			} else {
+				lows = append(lows, key)
+			}
+		}
+	}
+	for _, tomb := range ingest.Rangetombs() {
+		lows, highs = append(lows, tomb.Low), append(highs, tomb.High)
+	}
+
+	low, high = lows[0], highs[0]
+	for _, key := range lows[1:] {
+		if low != nil && (key == nil || bogn.cmp(key, low) < 0) {
+			low = key
+		}
+	}
+	for _, key := range highs[1:] {
+		if high != nil && (key == nil || bogn.cmp(key, high) > 0) {
+			high = key
+		}
+	}
+	return low, high, nil
+}
+
+// isoverlapping return error if any of the levels in snap has entries,
+// or range tombstones, between low and high, both inclusive.
+func (bogn *Bogn) isoverlapping(snap *snapshot, low, high []byte) error {
+	indexes := []api.Index{}
+	for _, index := range []api.Index{snap.mw, snap.mr, snap.mc} {
+		if index != nil {
+			indexes = append(indexes, index)
+		}
+	}
+	indexes = snap.disklevels(indexes)
+
+	for _, index := range indexes {
+		iter, err := diskrange(index, low, high, "both", false)
+		if err != nil {
+			return err
+		} else if iter == nil {
+			continue
+		}
+		_, _, _, _, err = iter(false /*fin*/)
+		iter(true /*fin*/)
+		if err == nil {
+			fmsg := "ingest overlaps with %q, between %q and %q"
+			return fmt.Errorf(fmsg, index.ID(), low, high)
+		} else if err != io.EOF {
+			return err
+		}
+	}
+	for _, tomb := range bogn.indexrangetombs(indexes...) {
+		ok1 := tomb.High == nil || low == nil || bogn.cmp(tomb.High, low) > 0
+		ok2 := tomb.Low == nil || high == nil || bogn.cmp(tomb.Low, high) <= 0
+		if ok1 && ok2 {
+			fmsg := "ingest overlaps with range tombstone %q-%q"
+			return fmt.Errorf(fmsg, tomb.Low, tomb.High)
+		}
+	}
+	return nil
+}
+
+// maxdiskseqno return the largest seqno of entries across disks,
+// which can be ahead of the seqno logged in metadata of the latest
+// level, refer Ingest().
+func (bogn *Bogn) maxdiskseqno(disks []api.Index) (seqno uint64) {
+	for _, disk := range disks {
+		if d, ok := disk.(*bubt.Snapshot); ok && d.Getseqno() > seqno {
+			seqno = d.Getseqno()
+		}
+	}
+	return seqno
+}
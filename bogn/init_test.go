@@ -2,11 +2,14 @@ package bogn
 
 import "os"
 import "fmt"
+import "time"
+import "bytes"
 import "strings"
 import "net/http"
 import "path/filepath"
 
 import "github.com/bnclabs/golog"
+import "github.com/bnclabs/gostore/api"
 import s "github.com/bnclabs/gosettings"
 import _ "net/http/pprof"
 
@@ -25,13 +28,26 @@ func init() {
 	log.SetLogger(nil, setts)
 	LogComponents("all")
 
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+	if err := api.Registercomparator("testreverse", reverse); err != nil {
+		panic(err)
+	}
+	appendop := func(key, value, operand []byte) []byte {
+		return append(append([]byte{}, value...), operand...)
+	}
+	if err := api.Registermergeoperator("testappend", appendop); err != nil {
+		panic(err)
+	}
+
 	go func() {
 		log.Infof("%v", http.ListenAndServe("localhost:6060", nil))
 	}()
 }
 
+// disk paths are not shared with tests in other packages, which may
+// clean them up while running in parallel.
 func makepaths() string {
-	path, paths := os.TempDir(), []string{}
+	path, paths := filepath.Join(os.TempDir(), "bogn"), []string{}
 	for _, base := range []string{"1", "2", "3"} {
 		paths = append(paths, filepath.Join(path, base))
 	}
@@ -48,3 +64,16 @@ func destoryindex(name, paths string) {
 	logpath, diskstore := setts.String("logpath"), setts.String("diskstore")
 	PurgeIndex(name, logpath, diskstore, strings.Split(paths, ","))
 }
+
+// committxn run fn on a new transaction and commit it, retrying when
+// it is rolled back, refer BeginTxn. Return the number of rollbacks.
+func committxn(index *Bogn, fn func(txn api.Transactor)) (int, error) {
+	for rollbacks := 0; ; rollbacks++ {
+		txn := index.BeginTxn(0x1234)
+		fn(txn)
+		if err := txn.Commit(); err != api.ErrorRollback {
+			return rollbacks, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
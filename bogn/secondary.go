@@ -0,0 +1,612 @@
+package bogn
+
+import "io"
+import "fmt"
+import "sort"
+import "bytes"
+import "unsafe"
+import "strings"
+import "sync/atomic"
+import "encoding/binary"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+import s "github.com/bnclabs/gosettings"
+
+// Extractor return the list of secondary keys to index for primary
+// key, value, refer Bogn.Addsecondary. Returned slices are copied.
+type Extractor func(key, value []byte) [][]byte
+
+// secondary index is maintained as a companion bogn instance, named
+// "<index>.<secondary>", with following entries:
+//
+//   'r' | escaped secondary key | primary key -> nil
+//   'f' | primary key -> list of secondary keys indexed for it
+//   'w' -> seqno of the primary index this instance is upto date with.
+//
+// secondary keys are escaped, 0x00 as 0x00 0xFF, and terminated by
+// 0x00 0x01, so that entries sort by secondary key and then by
+// primary key.
+type secondary struct {
+	pos      int64 // log position of the latest update, refer synclog.
+	building int32 // non-zero while rebuilding, refer rebuildsecondary.
+	name     string
+	extract  Extractor
+	index    *Bogn
+}
+
+// secupdate is the outcome of a mutation on primary key.
+type secupdate struct {
+	key     []byte
+	value   []byte
+	deleted bool
+}
+
+var secwatermark = []byte("w")
+
+// number of mutations applied in a single batch while rebuilding.
+const secbatchsize = 1024
+
+// Addsecondary register a secondary index, extract shall be called on
+// every mutation to get the secondary keys for the primary key, value.
+// Secondary index is updated along with the mutation, under the same
+// lock, and is persisted along with the index. Write-ahead-log of
+// secondary index is synced along with the mutation, but after
+// releasing the lock, so that concurrent writers can commit as group.
+// If secondary index is missing, or not upto date with the index, like
+// when it is added to existing data or after a crash, it is rebuilt by
+// scanning the index. Rebuild does not block writers, mutations are
+// indexed as they happen while the scan catches up in batches under
+// a short lock, but it costs a full scan of the index on every such
+// restart, and lookups on the secondary fail until it completes.
+// Secondary keys are sorted in binary order.
+func (bogn *Bogn) Addsecondary(name string, extract Extractor) error {
+	if name == "" || strings.ContainsAny(name, ".-") {
+		return fmt.Errorf("invalid secondary name %q", name)
+	}
+
+	sec, err := bogn.registersecondary(name, extract)
+	if err != nil || atomic.LoadInt32(&sec.building) == 0 {
+		return err
+	}
+
+	if err = bogn.rebuildsecondary(sec); err != nil {
+		bogn.mutationlock()
+		bogn.removesecondary(sec)
+		bogn.mutationunlock()
+		sec.index.Close()
+		return err
+	}
+
+	bogn.mutationlock()
+	atomic.StoreInt32(&sec.building, 0)
+	err = sec.update(nil, bogn.Getseqno())
+	bogn.mutationunlock()
+	if err != nil {
+		return err
+	}
+	return sec.index.synclog(atomic.LoadInt64(&sec.pos), nil)
+}
+
+// open the companion index and register it under mutation lock, so
+// that mutations from here on are indexed. If companion index is not
+// upto date, it is marked as building, refer rebuildsecondary.
+func (bogn *Bogn) registersecondary(
+	name string, extract Extractor) (*secondary, error) {
+
+	bogn.mutationlock()
+	defer bogn.mutationunlock()
+
+	secs := bogn.getsecondaries()
+	for _, sec := range secs {
+		if sec.name == name {
+			return nil, fmt.Errorf("secondary %q already added", name)
+		}
+	}
+
+	indexname := fmt.Sprintf("%v.%v", bogn.name, name)
+	index, err := New(indexname, bogn.secondarysettings())
+	if err != nil {
+		return nil, err
+	}
+	sec := &secondary{name: name, extract: extract, index: index}
+	seqno := bogn.Getseqno()
+	if watermark := sec.watermark(); watermark != seqno {
+		fmsg := "%v secondary %q at seqno %v, expected %v, rebuilding ..."
+		infof(fmsg, bogn.logprefix, name, watermark, seqno)
+		sec.building = 1
+	}
+	if bogn.compactorch != nil { // already started
+		index.Start()
+	}
+
+	newsecs := make([]*secondary, 0, len(secs)+1)
+	newsecs = append(newsecs, secs...)
+	newsecs = append(newsecs, sec)
+	atomic.StorePointer(&bogn.secondaries, unsafe.Pointer(&newsecs))
+	return sec, nil
+}
+
+// called with mutation lock held.
+func (bogn *Bogn) removesecondary(sec *secondary) {
+	secs := bogn.getsecondaries()
+	newsecs := make([]*secondary, 0, len(secs))
+	for _, s := range secs {
+		if s != sec {
+			newsecs = append(newsecs, s)
+		}
+	}
+	atomic.StorePointer(&bogn.secondaries, unsafe.Pointer(&newsecs))
+}
+
+// LookupSecondary return primary keys indexed under secondary key
+// skey, in sort order of primary keys.
+func (bogn *Bogn) LookupSecondary(name string, skey []byte) ([][]byte, error) {
+	iter, err := bogn.RangeSecondary(name, skey, skey, "both", false)
+	if err != nil {
+		return nil, err
+	}
+	defer iter(true /*fin*/)
+
+	pkeys := [][]byte{}
+	_, pkey, _, _, err := iter(false /*fin*/)
+	for ; err == nil; _, pkey, _, _, err = iter(false /*fin*/) {
+		pkeys = append(pkeys, copybytes(pkey))
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	return pkeys, nil
+}
+
+// RangeSecondary return an iterator over entries in secondary index
+// whose secondary key falls between low and high, refer Index.Range.
+// Iterator return secondary key as key, primary key as value, along
+// with the seqno of the primary entry. Entries are verified against
+// the index, hence primary entries that are deleted or expired are
+// skipped.
+func (bogn *Bogn) RangeSecondary(
+	name string, low, high []byte,
+	incl string, reverse bool) (api.Iterator, error) {
+
+	if _, _, err := api.Rangeincl(incl); err != nil {
+		return nil, err
+	}
+	sec := bogn.getsecondary(name)
+	if sec == nil {
+		return nil, fmt.Errorf("secondary %q not found", name)
+	} else if atomic.LoadInt32(&sec.building) != 0 {
+		return nil, fmt.Errorf("secondary %q is rebuilding", name)
+	}
+
+	var value []byte
+	lowkey, highkey := secondarybounds(low, high, incl)
+	iter := sec.index.Range(lowkey, highkey, "low", reverse)
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if fin {
+			return iter(fin)
+		}
+		key, _, _, deleted, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, deleted, err = iter(false /*fin*/) {
+			if deleted {
+				continue
+			}
+			skey, pkey := splitskey(key[1:])
+			v, cas, del, ok := bogn.Get(pkey, lib.Fixbuffer(value, 0))
+			if value = v; ok && !del && hasskey(sec.extract(pkey, v), skey) {
+				return skey, pkey, cas, false, nil
+			}
+		}
+		return nil, nil, 0, false, err
+	}, nil
+}
+
+func (bogn *Bogn) getsecondaries() []*secondary {
+	if ptr := atomic.LoadPointer(&bogn.secondaries); ptr != nil {
+		return *((*[]*secondary)(ptr))
+	}
+	return nil
+}
+
+func (bogn *Bogn) getsecondary(name string) *secondary {
+	for _, sec := range bogn.getsecondaries() {
+		if sec.name == name {
+			return sec
+		}
+	}
+	return nil
+}
+
+// companion index is sorted in binary order, and held in "llrb" so
+// that updates are visible to the next update without delay.
+func (bogn *Bogn) secondarysettings() s.Settings {
+	return (s.Settings{}).Mixin(
+		bogn.setts, s.Settings{
+			"logpath":       bogn.logpath,
+			"memstore":      "llrb",
+			"comparator":    api.Binarycomparator,
+			"mergeoperator": "",
+			"workingset":    false,
+			"cachecapacity": 0,
+		},
+	)
+}
+
+// called with mutation lock held, update secondary indexes for a set
+// operation on primary key.
+func (bogn *Bogn) secondaryset(key, value []byte) {
+	if secs := bogn.getsecondaries(); len(secs) > 0 {
+		updates := []secupdate{{key: key, value: value}}
+		bogn.updatesecondaries(secs, updates)
+	}
+}
+
+// called with mutation lock held, update secondary indexes for a
+// delete operation on primary key.
+func (bogn *Bogn) secondarydelete(key []byte) {
+	if secs := bogn.getsecondaries(); len(secs) > 0 {
+		updates := []secupdate{{key: key, deleted: true}}
+		bogn.updatesecondaries(secs, updates)
+	}
+}
+
+// called with mutation lock held, update secondary indexes for a
+// merge operation, with the resolved value of primary key.
+func (bogn *Bogn) secondarymerge(snap *snapshot, key []byte) {
+	if secs := bogn.getsecondaries(); len(secs) > 0 {
+		value, _, deleted, ok := snap.yget(key, []byte{})
+		upd := secupdate{key: key, value: value, deleted: !ok || deleted}
+		bogn.updatesecondaries(secs, []secupdate{upd})
+	}
+}
+
+// called with mutation lock held, update secondary indexes for the
+// successful mutations in a batch, ops are in sort order of keys.
+func (bogn *Bogn) secondaryapply(ops []*api.Batchop) {
+	secs := bogn.getsecondaries()
+	if len(secs) == 0 {
+		return
+	}
+	updates := make([]secupdate, 0, len(ops))
+	for _, op := range ops {
+		if op.Seqno == 0 {
+			continue
+		}
+		upd := secupdate{key: op.Key, value: op.Value}
+		upd.deleted = op.Cmd == api.BatchDelete
+		if n := len(updates); n > 0 && bogn.cmp(updates[n-1].key, op.Key) == 0 {
+			updates[n-1] = upd // last mutation on a key wins.
+			continue
+		}
+		updates = append(updates, upd)
+	}
+	bogn.updatesecondaries(secs, updates)
+}
+
+// called with mutation lock held, remove entries for primary keys
+// between low, inclusive, and high, exclusive, from secondary indexes.
+func (bogn *Bogn) secondarydeleterange(low, high []byte) {
+	for _, sec := range bogn.getsecondaries() {
+		lowkey, highkey := []byte("f"), []byte("g")
+		if bogn.comparator == api.Binarycomparator {
+			if low != nil {
+				lowkey = append(lowkey, low...)
+			}
+			if high != nil {
+				highkey = append([]byte("f"), high...)
+			}
+		}
+		updates := []secupdate{}
+		iter := sec.index.Range(lowkey, highkey, "low", false)
+		key, _, _, deleted, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, deleted, err = iter(false /*fin*/) {
+			pkey := key[1:]
+			if deleted || bogn.cmp.Rangecmp(pkey, low, high, true, false) != 0 {
+				continue
+			}
+			upd := secupdate{key: copybytes(pkey), deleted: true}
+			updates = append(updates, upd)
+		}
+		iter(true /*fin*/)
+		bogn.updatesecondaries([]*secondary{sec}, updates)
+	}
+}
+
+// update secondary indexes for entries ingested from disk, with the
+// latest value of those entries in the index.
+func (bogn *Bogn) secondaryingest(disk api.Index) {
+	secs := bogn.getsecondaries()
+	if len(secs) == 0 {
+		return
+	}
+
+	bogn.snaprlock()
+	bogn.mutationlock()
+	defer bogn.snaprunlock()
+	defer bogn.mutationunlock()
+
+	snap := bogn.currsnapshot()
+	bogn.waitindexseqno(snap.mw)
+
+	updates := make([]secupdate, 0, secbatchsize)
+	iter := disk.Scan()
+	key, _, _, _, err := iter(false /*fin*/)
+	for ; err == nil; key, _, _, _, err = iter(false /*fin*/) {
+		key = copybytes(key)
+		value, _, deleted, ok := snap.yget(key, []byte{})
+		upd := secupdate{key: key, value: value, deleted: !ok || deleted}
+		if updates = append(updates, upd); len(updates) == secbatchsize {
+			bogn.updatesecondaries(secs, updates)
+			updates = updates[:0]
+		}
+	}
+	iter(true /*fin*/)
+	bogn.updatesecondaries(secs, updates)
+}
+
+// called with mutation lock held. A failure to update secondary index
+// shall turn the index read-only, secondary indexes are rebuilt when
+// index is re-opened. Updates are logged but not synced, refer
+// syncsecondaries.
+func (bogn *Bogn) updatesecondaries(secs []*secondary, updates []secupdate) {
+	seqno := bogn.Getseqno()
+	for _, sec := range secs {
+		watermark := seqno
+		if atomic.LoadInt32(&sec.building) != 0 {
+			watermark = 0 // rebuild again if we crash before catching up.
+		}
+		update := func() error { return sec.update(updates, watermark) }
+		if err := bogn.trydo(update); err != nil {
+			bogn.setdegraded("secondary", err)
+		}
+	}
+}
+
+// rebuild secondary index, while it is registered and kept upto date
+// with mutations, by scanning a snapshot of the index without holding
+// the mutation lock. Scanned keys are re-indexed in batches with their
+// latest value, under a short lock, refer catchupsecondary. Then
+// entries for primary keys no more in the index are removed the same
+// way.
+func (bogn *Bogn) rebuildsecondary(sec *secondary) error {
+	scan := func(iter api.Iterator, prefix int) error {
+		keys := make([][]byte, 0, secbatchsize)
+		key, _, _, deleted, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, deleted, err = iter(false /*fin*/) {
+			if deleted {
+				continue
+			}
+			keys = append(keys, copybytes(key[prefix:]))
+			if len(keys) < secbatchsize {
+				continue
+			} else if err := bogn.catchupsecondary(sec, keys); err != nil {
+				iter(true /*fin*/)
+				return err
+			}
+			keys = keys[:0]
+		}
+		iter(true /*fin*/)
+		if err != io.EOF {
+			return err
+		}
+		return bogn.catchupsecondary(sec, keys)
+	}
+
+	bogn.waitindexseqno(bogn.currsnapshot().mw)
+	iter, err := bogn.ScanE()
+	if err != nil {
+		return err
+	} else if err := scan(iter, 0); err != nil {
+		return err
+	}
+	iter = sec.index.Range([]byte("f"), []byte("g"), "low", false)
+	return scan(iter, 1)
+}
+
+// re-index primary keys with their latest value in the index, under
+// mutation lock, keys that are missing or deleted are removed from
+// secondary index.
+func (bogn *Bogn) catchupsecondary(sec *secondary, keys [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	bogn.snaprlock()
+	bogn.mutationlock()
+	defer bogn.snaprunlock()
+	defer bogn.mutationunlock()
+
+	snap := bogn.currsnapshot()
+	bogn.waitindexseqno(snap.mw)
+
+	updates := make([]secupdate, 0, len(keys))
+	for _, key := range keys {
+		value, _, deleted, ok := snap.yget(key, []byte{})
+		upd := secupdate{key: key, value: value, deleted: !ok || deleted}
+		updates = append(updates, upd)
+	}
+	return sec.update(updates, 0 /*seqno*/)
+}
+
+// wait for the latest update on secondary indexes to be durable, must
+// be called after releasing the locks, refer synclog.
+func (bogn *Bogn) syncsecondaries() error {
+	for _, sec := range bogn.getsecondaries() {
+		pos := atomic.LoadInt64(&sec.pos)
+		if err := sec.index.synclog(pos, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update entries for the list of mutations on primary keys, along with
+// the watermark, as a single batch. There shall be atmost one update
+// for a primary key. Update is logged, but not synced, refer
+// syncsecondaries.
+func (sec *secondary) update(updates []secupdate, seqno uint64) error {
+	batch := api.NewBatch(len(updates)*2 + 1)
+	for _, upd := range updates {
+		fkey := append([]byte("f"), upd.key...)
+		var oldkeys, newkeys [][]byte
+		value, _, deleted, ok := sec.index.Get(fkey, []byte{})
+		if ok && !deleted {
+			oldkeys = decodeskeys(value)
+		}
+		if !upd.deleted {
+			newkeys = sortskeys(sec.extract(upd.key, upd.value))
+		}
+
+		// both lists are sorted, add and remove the difference.
+		changed, i, j := false, 0, 0
+		for i < len(oldkeys) || j < len(newkeys) {
+			cmp := 0
+			if i == len(oldkeys) {
+				cmp = 1
+			} else if j == len(newkeys) {
+				cmp = -1
+			} else {
+				cmp = bytes.Compare(oldkeys[i], newkeys[j])
+			}
+			if cmp < 0 {
+				batch.Delete(joinskey(oldkeys[i], upd.key), false /*lsm*/)
+				i, changed = i+1, true
+			} else if cmp > 0 {
+				batch.Set(joinskey(newkeys[j], upd.key), nil)
+				j, changed = j+1, true
+			} else {
+				i, j = i+1, j+1
+			}
+		}
+		if changed && len(newkeys) > 0 {
+			batch.Set(fkey, encodeskeys(newkeys))
+		} else if changed {
+			batch.Delete(fkey, false /*lsm*/)
+		}
+	}
+
+	var scratch [8]byte
+	binary.BigEndian.PutUint64(scratch[:], seqno)
+	batch.Set(secwatermark, scratch[:])
+	pos, logerr, err := sec.index.apply(batch)
+	if logerr != nil {
+		sec.index.setdegraded("wal", logerr)
+		return logerr
+	} else if pos > 0 {
+		atomic.StoreInt64(&sec.pos, pos)
+	}
+	return err
+}
+
+// watermark return the seqno of primary index this secondary index is
+// upto date with.
+func (sec *secondary) watermark() uint64 {
+	value, _, deleted, ok := sec.index.Get(secwatermark, []byte{})
+	if ok && !deleted && len(value) == 8 {
+		return binary.BigEndian.Uint64(value)
+	}
+	return 0
+}
+
+// secondarybounds return the range of entries in companion index for
+// secondary keys between low and high, low is inclusive and high is
+// exclusive.
+func secondarybounds(low, high []byte, incl string) ([]byte, []byte) {
+	lowkey, highkey := []byte("r"), []byte("s")
+	if low != nil {
+		lowkey = appendskey([]byte("r"), low)
+		if incl == "none" || incl == "high" { // skip past low
+			lowkey[len(lowkey)-1] = 0x02
+		}
+	}
+	if high != nil {
+		highkey = appendskey([]byte("r"), high)
+		if incl == "both" || incl == "high" { // include high
+			highkey[len(highkey)-1] = 0x02
+		}
+	}
+	return lowkey, highkey
+}
+
+func joinskey(skey, pkey []byte) []byte {
+	key := make([]byte, 0, 1+len(skey)+2+len(pkey))
+	key = appendskey(append(key, 'r'), skey)
+	return append(key, pkey...)
+}
+
+func appendskey(buf, skey []byte) []byte {
+	for _, b := range skey {
+		if buf = append(buf, b); b == 0x00 {
+			buf = append(buf, 0xFF)
+		}
+	}
+	return append(buf, 0x00, 0x01)
+}
+
+// splitskey is the reverse of joinskey, without the leading 'r'.
+func splitskey(key []byte) (skey, pkey []byte) {
+	skey = make([]byte, 0, len(key))
+	for i := 0; i+1 < len(key); i++ {
+		if key[i] != 0x00 {
+			skey = append(skey, key[i])
+		} else if key[i+1] == 0x01 {
+			return skey, key[i+2:]
+		} else {
+			skey, i = append(skey, 0x00), i+1
+		}
+	}
+	panic(fmt.Errorf("invalid secondary entry %q", key))
+}
+
+// sortskeys return a sorted copy of secondary keys, without
+// duplicates.
+func sortskeys(skeys [][]byte) [][]byte {
+	sorted := make([][]byte, 0, len(skeys))
+	for _, skey := range skeys {
+		sorted = append(sorted, copybytes(skey))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	n := 0
+	for i, skey := range sorted {
+		if i == 0 || bytes.Compare(sorted[n-1], skey) != 0 {
+			sorted[n], n = skey, n+1
+		}
+	}
+	return sorted[:n]
+}
+
+func hasskey(skeys [][]byte, skey []byte) bool {
+	for _, key := range skeys {
+		if bytes.Compare(key, skey) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// list of secondary keys are encoded as | len uint32 | skey | ...
+func encodeskeys(skeys [][]byte) []byte {
+	var scratch [4]byte
+	buf := []byte{}
+	for _, skey := range skeys {
+		binary.BigEndian.PutUint32(scratch[:], uint32(len(skey)))
+		buf = append(append(buf, scratch[:]...), skey...)
+	}
+	return buf
+}
+
+func decodeskeys(buf []byte) [][]byte {
+	skeys := [][]byte{}
+	for len(buf) >= 4 {
+		n := binary.BigEndian.Uint32(buf[:4])
+		skeys = append(skeys, copybytes(buf[4:4+n]))
+		buf = buf[4+n:]
+	}
+	return skeys
+}
+
+func copybytes(src []byte) []byte {
+	return append(make([]byte, 0, len(src)), src...)
+}
@@ -1,6 +1,7 @@
 package bogn
 
 import "fmt"
+import "math"
 import "unsafe"
 import "strconv"
 import "strings"
@@ -11,6 +12,7 @@ import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/lib"
 import "github.com/bnclabs/gostore/lsm"
 import "github.com/bnclabs/gostore/llrb"
+import "github.com/bnclabs/gostore/bubt"
 
 type snapshot struct {
 	// must be 8-byte aligned.
@@ -24,6 +26,7 @@ type snapshot struct {
 	mw, mr, mc   api.Index
 	disks        [16]api.Index
 	yget         api.Getter
+	ygete        getter
 	purgeindexes []api.Index
 
 	// working memory
@@ -54,6 +57,7 @@ func opensnapshot(
 			return nil, err
 		}
 	}
+	bogn.setchangelog(head.mw)
 	if bogn.workingset {
 		numcpu := runtime.GOMAXPROCS(-1) * 100
 		head.setch = make(chan *setcache, numcpu)
@@ -64,7 +68,8 @@ func opensnapshot(
 		}
 		go cacher(bogn, head.mc, head.setch, head.cachech)
 	}
-	head.yget = head.latestyget()
+	head.ygete = head.latestygete()
+	head.yget = yget(head.ygete)
 	return head, nil
 }
 
@@ -82,13 +87,17 @@ func newsnapshot(
 		mw: mw, mr: mr, mc: mc,
 	}
 	copy(head.disks[:], disks[:])
+	if head.mw != nil {
+		bogn.setchangelog(head.mw)
+	}
 	if head.mc != nil {
 		numcpu := runtime.GOMAXPROCS(-1) * 100
 		head.setch = make(chan *setcache, numcpu)
 		head.cachech = make(chan *setcache, numcpu)
 		go cacher(bogn, head.mc, head.setch, head.cachech)
 	}
-	head.yget = head.latestyget()
+	head.ygete = head.latestygete()
+	head.yget = yget(head.ygete)
 	return head
 }
 
@@ -102,6 +111,25 @@ func (snap *snapshot) mwseqno() uint64 {
 	panic("unreachable code")
 }
 
+// moveseqno move the seqno of write store ahead to seqno, if behind,
+// and return beginseqno, which is moved along if there are no
+// mutations to flush.
+func (snap *snapshot) moveseqno(seqno uint64) (beginseqno uint64) {
+	mwseqno, beginseqno := snap.mwseqno(), snap.beginseqno
+	if seqno <= mwseqno {
+		return beginseqno
+	} else if mwseqno == beginseqno {
+		beginseqno = seqno
+	}
+	switch index := snap.mw.(type) {
+	case *llrb.LLRB:
+		index.Setseqno(seqno)
+	case *llrb.MVCC:
+		index.Setseqno(seqno)
+	}
+	return beginseqno
+}
+
 func (snap *snapshot) addtopurge(indexes ...api.Index) {
 	if snap.purgeindexes == nil {
 		snap.purgeindexes = []api.Index{}
@@ -179,59 +207,101 @@ func (snap *snapshot) memheap() int64 {
 	return heap
 }
 
-func (snap *snapshot) latestyget() (get api.Getter) {
-	gets := []api.Getter{}
+// getter is same as api.Getter, additionally return error from disk
+// levels, refer bubt.Snapshot.GetE. Lookup stops at the first error.
+type getter func(key, value []byte) ([]byte, uint64, bool, bool, error)
+
+// mergegetter is same as api.Mergegetter, additionally return error
+// from disk levels.
+type mergegetter func(
+	key, value []byte) ([]byte, uint64, bool, bool, bool, error)
+
+func (snap *snapshot) latestyget() api.Getter {
+	return yget(snap.latestygete())
+}
+
+func (snap *snapshot) latestygete() getter {
+	if snap.bogn.merge != nil {
+		return snap.mergeyget(snap.mw)
+	}
+
+	gets := []getter{}
 	if snap.mw != nil {
-		gets = append(gets, snap.mw.Get)
+		gets = append(gets, noerror(snap.mw.Get))
 	}
 	if snap.mr != nil {
-		gets = append(gets, snap.mr.Get)
+		gets = append(gets, noerror(snap.mr.Get))
 	}
 	if snap.mc != nil {
-		gets = append(gets, snap.mc.Get)
+		gets = append(gets, noerror(snap.cacheget()))
 	}
 
 	if atomic.LoadInt64(&snap.bogn.dgmstate) == 1 {
 		for _, disk := range snap.disklevels([]api.Index{}) {
 			if snap.mc != nil {
-				gets = append(gets, snap.cachedget(disk.Get))
+				gets = append(gets, snap.cachedget(disk))
 			} else {
-				gets = append(gets, disk.Get)
+				gets = append(gets, diskget(disk))
 			}
 		}
 	}
+	return ygetchain(gets)
+}
 
-	if len(gets) == 0 {
-		return nil
+func (snap *snapshot) txnyget(tv api.Transactor, gets []getter) api.Getter {
+	var disks [256]api.Index
+
+	if snap.bogn.merge != nil {
+		return yget(snap.mergeyget(tv))
 	}
-	get = gets[len(gets)-1]
-	for i := len(gets) - 2; i >= 0; i-- {
-		get = lsm.YGet(gets[i], get)
+
+	if tv != nil {
+		gets = append(gets, noerror(tv.Get))
+	}
+	if snap.mr != nil {
+		gets = append(gets, noerror(snap.mr.Get))
+	}
+	if snap.mc != nil {
+		gets = append(gets, noerror(snap.cacheget()))
 	}
-	return
-}
 
-func (snap *snapshot) txnyget(
-	tv api.Transactor, gets []api.Getter) api.Getter {
+	if atomic.LoadInt64(&snap.bogn.dgmstate) == 1 {
+		for _, disk := range snap.disklevels(disks[:0]) {
+			if snap.mc != nil {
+				gets = append(gets, snap.cachedget(disk))
+			} else {
+				gets = append(gets, diskget(disk))
+			}
+		}
+	}
+	return yget(ygetchain(gets))
+}
 
+// mergeyget is same as latestyget and txnyget, except that merge
+// operands from mem, the latest level, and from read store are resolved
+// against older levels. Operands are left in memory levels only when
+// key is missing in them, hence they are resolved against nil value if
+// key is missing in all levels.
+func (snap *snapshot) mergeyget(mem interface{}) getter {
 	var disks [256]api.Index
 
-	if tv != nil {
-		gets = append(gets, tv.Get)
+	gets := []mergegetter{}
+	if mem != nil {
+		gets = append(gets, mergeerror(getmerge(mem)))
 	}
 	if snap.mr != nil {
-		gets = append(gets, snap.mr.Get)
+		gets = append(gets, mergeerror(getmerge(snap.mr)))
 	}
 	if snap.mc != nil {
-		gets = append(gets, snap.mc.Get)
+		gets = append(gets, nomerge(noerror(snap.cacheget())))
 	}
 
 	if atomic.LoadInt64(&snap.bogn.dgmstate) == 1 {
 		for _, disk := range snap.disklevels(disks[:0]) {
 			if snap.mc != nil {
-				gets = append(gets, snap.cachedget(disk.Get))
+				gets = append(gets, nomerge(snap.cachedget(disk)))
 			} else {
-				gets = append(gets, disk.Get)
+				gets = append(gets, nomerge(diskget(disk)))
 			}
 		}
 	}
@@ -239,39 +309,190 @@ func (snap *snapshot) txnyget(
 	if len(gets) == 0 {
 		return nil
 	}
+	merge := snap.bogn.merge
 	get := gets[len(gets)-1]
 	for i := len(gets) - 2; i >= 0; i-- {
-		get = lsm.YGet(gets[i], get)
+		get = ygetmerge(get, gets[i], merge) // gets[i] is the latest.
+	}
+	return func(key, value []byte) ([]byte, uint64, bool, bool, error) {
+		val, cas, deleted, ismerge, ok, err := get(key, value)
+		if err != nil {
+			return val, 0, false, false, err
+		} else if ismerge && val != nil {
+			newval := merge(key, nil, val)
+			val = lib.Fixbuffer(val, int64(len(newval)))
+			copy(val, newval)
+		}
+		return val, cas, deleted, ok, nil
+	}
+}
+
+// ygetchain combine gets, ordered from latest to oldest level, into a
+// single getter that handles LSM, refer lsm.YGet.
+func ygetchain(gets []getter) getter {
+	if len(gets) == 0 {
+		return nil
+	}
+	get := gets[len(gets)-1]
+	for i := len(gets) - 2; i >= 0; i-- {
+		get = yget2(get, gets[i]) // gets[i] is the latest version.
 	}
 	return get
 }
 
-// try caching the entry from this get operation.
-func (snap *snapshot) cachedget(get api.Getter) api.Getter {
+// yget2 is same as lsm.YGet, except that lookup stops with error from
+// b, the latest version.
+func yget2(a, b getter) getter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool, error) {
+		val, cas, deleted, ok, err := b(key, value)
+		if ok || err != nil {
+			return val, cas, deleted, ok, err
+		}
+		return a(key, value)
+	}
+}
+
+// ygetmerge is same as lsm.YGetmerge, except that lookup stops with
+// error from either levels.
+func ygetmerge(a, b mergegetter, merge api.Mergeoperator) mergegetter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool, bool, error) {
+		val, cas, deleted, ismerge, ok, err := b(key, value)
+		if err != nil {
+			return val, 0, false, false, false, err
+		} else if ok == false {
+			return a(key, value)
+		} else if ismerge == false {
+			return val, cas, deleted, ismerge, ok, nil
+		}
+
+		if value == nil { // operand is required to resolve.
+			val, cas, deleted, ismerge, ok, err = b(key, make([]byte, 0, 16))
+			if err != nil {
+				return val, 0, false, false, false, err
+			}
+		}
+		older, _, odeleted, omerge, ook, err := a(key, make([]byte, 0, 16))
+		if err != nil {
+			return val, 0, false, false, false, err
+		} else if ook == false {
+			return val, cas, false, true, true, nil
+		} else if odeleted {
+			older, omerge = nil, false
+		}
+		newval := merge(key, older, val)
+		val = lib.Fixbuffer(val, int64(len(newval)))
+		copy(val, newval)
+		return val, cas, false, omerge, true, nil
+	}
+}
+
+// yget return get as api.Getter, error from disk levels is logged and
+// key is treated as missing, like bubt.Snapshot.Get.
+func yget(get getter) api.Getter {
+	if get == nil {
+		return nil
+	}
 	return func(key, value []byte) ([]byte, uint64, bool, bool) {
-		value, cas, deleted, ok := get(key, value)
-		if ok == false {
-			return value, cas, deleted, ok
+		val, cas, deleted, ok, err := get(key, value)
+		if err != nil {
+			errorf("bogn.Get(%q): %v", key, err)
+			return val, 0, false, false
+		}
+		return val, cas, deleted, ok
+	}
+}
+
+// noerror return get, for levels that cannot fail, as getter.
+func noerror(get api.Getter) getter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool, error) {
+		val, cas, deleted, ok := get(key, value)
+		return val, cas, deleted, ok, nil
+	}
+}
+
+// mergeerror return get, for levels that cannot fail, as mergegetter.
+func mergeerror(get api.Mergegetter) mergegetter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool, bool, error) {
+		val, cas, deleted, merge, ok := get(key, value)
+		return val, cas, deleted, merge, ok, nil
+	}
+}
+
+// diskget return getter for disk level, that return disk errors and
+// corrupt blocks as error.
+func diskget(disk api.Index) getter {
+	if d, ok := disk.(*bubt.Snapshot); ok {
+		return d.GetE
+	}
+	return noerror(disk.Get)
+}
+
+// yget over disk levels, used to resolve merge operands from memory
+// levels while flushing them to disk.
+func (snap *snapshot) diskyget() (get api.Getter) {
+	disks := snap.disklevels([]api.Index{})
+	for i := len(disks) - 1; i >= 0; i-- {
+		if get == nil {
+			get = disks[i].Get
+		} else {
+			get = lsm.YGet(get, disks[i].Get) // disks[i] is the latest.
+		}
+	}
+	return get
+}
+
+// lookup working set cache, counting the hits and misses.
+func (snap *snapshot) cacheget() api.Getter {
+	bogn, mc := snap.bogn, snap.mc
+	return func(key, value []byte) ([]byte, uint64, bool, bool) {
+		value, cas, deleted, ok := mc.Get(key, value)
+		if ok {
+			atomic.AddInt64(&bogn.n_cachehits, 1)
+		} else {
+			atomic.AddInt64(&bogn.n_cachemisses, 1)
+		}
+		return value, cas, deleted, ok
+	}
+}
+
+// try caching the entry, along with its expiry, from this get operation.
+func (snap *snapshot) cachedget(disk api.Index) getter {
+	get := func(
+		key, value []byte) ([]byte, uint64, uint64, bool, bool, error) {
+
+		switch d := disk.(type) {
+		case *bubt.Snapshot:
+			return d.Getexpiry(key, value)
+		}
+		value, cas, deleted, ok := disk.Get(key, value)
+		return value, cas, 0, deleted, ok, nil
+	}
+
+	return func(key, value []byte) ([]byte, uint64, bool, bool, error) {
+		value, cas, expiry, deleted, ok, err := get(key, value)
+		if err != nil || ok == false {
+			return value, cas, deleted, ok, err
 		}
 
 		// TODO: if `mc` is skip list with concurrent writes, could
 		// perform better.
+		var cmd *setcache
 		select {
-		case cmd := <-snap.cachech:
-			cmd.key = lib.Fixbuffer(cmd.key, int64(len(key)))
-			copy(cmd.key, key)
-			cmd.value = lib.Fixbuffer(cmd.value, int64(len(value)))
-			copy(cmd.value, value)
-			cmd.seqno = cas
-			cmd.deleted = deleted
-			select {
-			case snap.setch <- cmd:
-			default:
-			}
-
+		case cmd = <-snap.cachech: // reuse commands returned by cacher.
 		default:
+			cmd = &setcache{}
 		}
-		return value, cas, deleted, ok
+		cmd.key = lib.Fixbuffer(cmd.key, int64(len(key)))
+		copy(cmd.key, key)
+		cmd.value = lib.Fixbuffer(cmd.value, int64(len(value)))
+		copy(cmd.value, value)
+		cmd.seqno, cmd.expiry = cas, expiry
+		cmd.deleted = deleted
+		select {
+		case snap.setch <- cmd:
+		default:
+		}
+		return value, cas, deleted, ok, nil
 	}
 }
 
@@ -285,9 +506,10 @@ func (snap *snapshot) finalizeindex(index api.Index) {
 }
 
 // full table scan.
-func (snap *snapshot) iterator() api.Iterator {
+func (snap *snapshot) iterator() (api.Iterator, error) {
 	var ref [20]api.Iterator
 	scans := ref[:0]
+	tombs := snap.rangetombs()
 
 	if iter := snap.mw.Scan(); iter != nil {
 		scans = append(scans, iter)
@@ -298,18 +520,128 @@ func (snap *snapshot) iterator() api.Iterator {
 		}
 	}
 	for _, disk := range snap.disklevels([]api.Index{}) {
-		if iter := disk.Scan(); iter != nil {
+		iter, err := diskscan(disk)
+		if err != nil {
+			for _, scan := range scans {
+				scan(true /*fin*/)
+			}
+			return nil, err
+		} else if iter != nil {
+			scans = append(scans, iter)
+		}
+	}
+
+	iter := reduceiter(scans, false /*reverse*/, snap.bogn.cmp)
+	iter = lsm.YRangetombs(iter, tombs, snap.bogn.cmp)
+	ismerge := memmerge(snap.mw, snap.mr)
+	return mergeiter(iter, ismerge, snap.yget, snap.bogn.merge), nil
+}
+
+// full table scan on disk level, return error if it could not be
+// scanned.
+func diskscan(disk api.Index) (api.Iterator, error) {
+	switch d := disk.(type) {
+	case *bubt.Snapshot:
+		return d.ScanE()
+	}
+	return disk.Scan(), nil
+}
+
+// range scan, bounds are pushed down to every level. Return error if
+// a disk level could not be scanned.
+func (snap *snapshot) rangeiterator(
+	low, high []byte, incl string, reverse bool) (api.Iterator, error) {
+
+	var ref [20]api.Iterator
+	scans := ref[:0]
+	tombs := snap.rangetombs()
+
+	if iter := snap.mw.Range(low, high, incl, reverse); iter != nil {
+		scans = append(scans, iter)
+	}
+	if snap.mr != nil {
+		if iter := snap.mr.Range(low, high, incl, reverse); iter != nil {
+			scans = append(scans, iter)
+		}
+	}
+	for _, disk := range snap.disklevels([]api.Index{}) {
+		iter, err := diskrange(disk, low, high, incl, reverse)
+		if err != nil {
+			for _, scan := range scans {
+				scan(true /*fin*/)
+			}
+			return nil, err
+		} else if iter != nil {
 			scans = append(scans, iter)
 		}
 	}
 
-	return reduceiter(scans)
+	iter := reduceiter(scans, reverse, snap.bogn.cmp)
+	iter = lsm.YRangetombs(iter, tombs, snap.bogn.cmp)
+	ismerge := memmerge(snap.mw, snap.mr)
+	return mergeiter(iter, ismerge, snap.yget, snap.bogn.merge), nil
+}
+
+// range scan on disk level, return error if it could not be scanned.
+func diskrange(
+	disk api.Index, low, high []byte,
+	incl string, reverse bool) (api.Iterator, error) {
+
+	switch d := disk.(type) {
+	case *bubt.Snapshot:
+		return d.RangeE(low, high, incl, reverse)
+	}
+	return disk.Range(low, high, incl, reverse), nil
+}
+
+// mergeiter re-read the value of merge operands from iter using get,
+// if merge operator is configured. Memory levels resolve merge
+// operands against nil value, while iterating, which is not the case
+// when older versions are on disk levels. Disk levels never hold merge
+// operands, hence an entry is a merge operand only if ismerge says so
+// for memory levels, refer memmerge.
+func mergeiter(
+	iter api.Iterator, ismerge func([]byte, uint64) bool, get api.Getter,
+	merge api.Mergeoperator) api.Iterator {
+
+	if merge == nil || iter == nil || get == nil {
+		return iter
+	}
+	value := make([]byte, 0, 16)
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		key, val, seqno, deleted, err := iter(fin)
+		if err != nil || deleted || ismerge(key, seqno) == false {
+			return key, val, seqno, deleted, err
+		}
+		value, _, _, _ = get(key, value)
+		return key, value, seqno, deleted, err
+	}
+}
+
+// memmerge return a function to check whether the version of key at
+// seqno, from one of the memory levels mems, is a merge operand.
+func memmerge(mems ...interface{}) func([]byte, uint64) bool {
+	gets := make([]api.Mergegetter, 0, len(mems))
+	for _, mem := range mems {
+		if mem != nil {
+			gets = append(gets, getmerge(mem))
+		}
+	}
+	return func(key []byte, seqno uint64) bool {
+		for _, get := range gets {
+			if _, cas, _, merge, ok := get(key, nil); ok && cas == seqno {
+				return merge
+			}
+		}
+		return false
+	}
 }
 
 // iterate on write store.
 func (snap *snapshot) persistiterator() api.EntryIterator {
 	if snap.mw != nil {
-		return snap.mw.ScanEntries()
+		// full data set is in memory, resolve operands against nil.
+		return snap.mergeentries(snap.mw.ScanEntries(), nil)
 	}
 	return nil
 }
@@ -319,7 +651,8 @@ func (snap *snapshot) flushiterator(disks []api.Index) api.EntryIterator {
 	var ref [20]api.EntryIterator
 	scans := ref[:0]
 
-	if itere := snap.mr.ScanEntries(); itere != nil {
+	itere := snap.mergeentries(snap.mr.ScanEntries(), snap.diskyget())
+	if itere != nil {
 		scans = append(scans, itere)
 	}
 	if snap.mc != nil {
@@ -332,14 +665,18 @@ func (snap *snapshot) flushiterator(disks []api.Index) api.EntryIterator {
 			scans = append(scans, itere)
 		}
 	}
-	return reduceitere(scans)
+	return reduceitere(scans, snap.bogn.cmp)
 }
 
 func (snap *snapshot) windupiterator(disk api.Index) api.EntryIterator {
 	var ref [20]api.EntryIterator
 	scans := ref[:0]
 
-	if itere := snap.mw.ScanEntries(); itere != nil {
+	var get api.Getter
+	if atomic.LoadInt64(&snap.bogn.dgmstate) == 1 {
+		get = snap.diskyget()
+	}
+	if itere := snap.mergeentries(snap.mw.ScanEntries(), get); itere != nil {
 		scans = append(scans, itere)
 	}
 	if disk != nil {
@@ -348,13 +685,25 @@ func (snap *snapshot) windupiterator(disk api.Index) api.EntryIterator {
 		}
 	}
 
-	return reduceitere(scans)
+	return reduceitere(scans, snap.bogn.cmp)
 }
 
 func (snap *snapshot) set(key, value, oldvalue []byte) ([]byte, uint64) {
 	return snap.mw.Set(key, value, oldvalue)
 }
 
+func (snap *snapshot) setexpiry(
+	key, value, oldvalue []byte, expiry uint64) ([]byte, uint64) {
+
+	switch index := snap.mw.(type) {
+	case *llrb.LLRB:
+		return index.SetExpiry(key, value, oldvalue, expiry)
+	case *llrb.MVCC:
+		return index.SetExpiry(key, value, oldvalue, expiry)
+	}
+	panic("unreachable code")
+}
+
 func (snap *snapshot) setCAS(
 	key, value, oldvalue []byte, cas uint64) ([]byte, uint64, error) {
 	return snap.mw.SetCAS(key, value, oldvalue, cas)
@@ -364,8 +713,59 @@ func (snap *snapshot) delete(key, value []byte, lsm bool) ([]byte, uint64) {
 	return snap.mw.Delete(key, value, lsm)
 }
 
+// mwcas translate the expected cas of a SetCAS mutation, when older
+// levels are on disk, to the cas expected in write store. If key is
+// not in write store, its cas is looked up in older levels, and when
+// matched key should still be missing in write store.
+func (snap *snapshot) mwcas(key []byte, cas uint64) uint64 {
+	if _, _, _, ok := snap.mw.Get(key, nil); ok {
+		return cas
+	}
+	var gcas uint64
+	var deleted, ok bool
+	if snap.yget != nil {
+		_, gcas, deleted, ok = snap.yget(key, nil)
+	}
+	if ok == false || deleted {
+		gcas = 0
+	}
+	if gcas == cas {
+		return 0
+	}
+	return math.MaxUint64 // shall not match in write store.
+}
+
+func (snap *snapshot) mergeoperand(key, operand []byte) uint64 {
+	switch index := snap.mw.(type) {
+	case *llrb.LLRB:
+		return index.Merge(key, operand)
+	case *llrb.MVCC:
+		return index.Merge(key, operand)
+	}
+	panic("unreachable code")
+}
+
+func (snap *snapshot) deleterange(low, high []byte) uint64 {
+	switch index := snap.mw.(type) {
+	case *llrb.LLRB:
+		return index.DeleteRange(low, high)
+	case *llrb.MVCC:
+		return index.DeleteRange(low, high)
+	}
+	panic("unreachable code")
+}
+
+// union of range tombstones from write store, read store and disk
+// levels, cache store is populated from disk levels and shall not have
+// range tombstones.
+func (snap *snapshot) rangetombs() api.Rangetombs {
+	indexes := []api.Index{snap.mw, snap.mr}
+	indexes = append(indexes, snap.disklevels([]api.Index{})...)
+	return snap.bogn.indexrangetombs(indexes...)
+}
+
 func (snap *snapshot) close() {
-	if snap.bogn.workingset {
+	if snap.setch != nil {
 		close(snap.setch)
 	}
 	snap.bogn = nil
@@ -373,7 +773,7 @@ func (snap *snapshot) close() {
 	for i := range snap.disks {
 		snap.disks[i] = nil
 	}
-	snap.yget, snap.purgeindexes = nil, nil
+	snap.yget, snap.ygete, snap.purgeindexes = nil, nil, nil
 	atomic.StorePointer(&snap.next, nil)
 }
 
@@ -429,7 +829,20 @@ func (snap *snapshot) attributes() string {
 	return "<" + memlevels + " " + disklevels + ">"
 }
 
-func compactiterator(disks []api.Index) api.EntryIterator {
+// mergeentries resolve merge operands from itere, a memory level, into
+// full values, using older versions returned by get.
+func (snap *snapshot) mergeentries(
+	itere api.EntryIterator, get api.Getter) api.EntryIterator {
+
+	if snap.bogn.merge == nil {
+		return itere
+	}
+	return lsm.YMergeEntries(itere, get, snap.bogn.merge)
+}
+
+func compactiterator(
+	disks []api.Index, cmp api.Comparator) api.EntryIterator {
+
 	var ref [20]api.EntryIterator
 	scans := ref[:0]
 
@@ -438,27 +851,53 @@ func compactiterator(disks []api.Index) api.EntryIterator {
 			scans = append(scans, itere)
 		}
 	}
-	return reduceitere(scans)
+	return reduceitere(scans, cmp)
 }
 
-func reduceiter(scans []api.Iterator) api.Iterator {
+func reduceiter(
+	scans []api.Iterator, reverse bool, cmp api.Comparator) api.Iterator {
+
 	if len(scans) == 0 {
 		return nil
+	} else if reverse {
+		iter, _ := lsm.MergeIteratorsReversecmp(cmp, scans...)
+		return iter
 	}
-	scan := scans[len(scans)-1]
-	for i := len(scans) - 2; i >= 0; i-- {
-		scan = lsm.YSort(scans[i], scan)
-	}
-	return scan
+	iter, _ := lsm.MergeIteratorscmp(cmp, scans...)
+	return iter
 }
 
-func reduceitere(scans []api.EntryIterator) api.EntryIterator {
+func reduceitere(
+	scans []api.EntryIterator, cmp api.Comparator) api.EntryIterator {
+
 	if len(scans) == 0 {
 		return nil
 	}
-	scan := scans[len(scans)-1]
-	for i := len(scans) - 2; i >= 0; i-- {
-		scan = lsm.YSortEntries(scans[i], scan)
+	itere, _ := lsm.MergeEntryIteratorscmp(cmp, scans...)
+	return itere
+}
+
+// getmerge return a get function for memory level, that return merge
+// operands as is.
+func getmerge(index interface{}) api.Mergegetter {
+	switch idx := index.(type) {
+	case *llrb.LLRB:
+		return idx.Getmerge
+	case *llrb.MVCC:
+		return idx.Getmerge
+	case *llrb.Txn:
+		return idx.Getmerge
+	case *llrb.View:
+		return idx.Getmerge
+	}
+	panic("unreachable code")
+}
+
+// nomerge return get as mergegetter, for levels that cannot have
+// merge operands.
+func nomerge(get getter) mergegetter {
+	return func(key, value []byte) ([]byte, uint64, bool, bool, bool, error) {
+		value, cas, deleted, ok, err := get(key, value)
+		return value, cas, deleted, false, ok, err
 	}
-	return scan
 }
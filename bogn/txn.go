@@ -1,9 +1,13 @@
 package bogn
 
+import "sort"
+import "time"
+import "bytes"
 import "sync/atomic"
 
 import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/lib"
+import "github.com/bnclabs/gostore/llrb"
 
 // Txn transaction definition. Transaction gives a gaurantee of isolation and
 // atomicity on the latest snapshot.
@@ -16,11 +20,23 @@ type Txn struct {
 	mcview api.Transactor
 	dviews []api.Transactor
 	yget   api.Getter
+	tombs  api.Rangetombs
+
+	// write-ahead-log and secondary indexes
+	wkeys []walop // keys written and their expiry, in write order.
+	// cas observed through yget on first access of a key, validated
+	// during commit.
+	reads map[string]txnread
 
 	// working memory.
 	cursors []*Cursor
 	curchan chan *Cursor
-	gets    []api.Getter
+	gets    []getter
+}
+
+type txnread struct {
+	cas     uint64 // ZERO if key was missing.
+	written bool
 }
 
 func newtxn(id uint64, bogn *Bogn, snap *snapshot, cch chan *Cursor) *Txn {
@@ -29,7 +45,9 @@ func newtxn(id uint64, bogn *Bogn, snap *snapshot, cch chan *Cursor) *Txn {
 		dviews:  make([]api.Transactor, 0, 32),
 		cursors: make([]*Cursor, 0, 8),
 		curchan: cch,
-		gets:    make([]api.Getter, 0, 32),
+		gets:    make([]getter, 0, 32),
+		wkeys:   make([]walop, 0, 8),
+		reads:   make(map[string]txnread),
 	}
 	return txn
 }
@@ -38,7 +56,14 @@ func (txn *Txn) inittxn() *Txn {
 	var disks [256]api.Index
 
 	id, snap := txn.id, txn.snap
-	txn.mwtxn = snap.mw.BeginTxn(id)
+	txn.tombs = snap.rangetombs()
+	// llrb memstore is locked only during commit, mutations can proceed
+	// while this transaction is open, refer Commit.
+	if mw, ok := snap.mw.(*llrb.LLRB); ok {
+		txn.mwtxn = mw.BeginOptimistic(id)
+	} else {
+		txn.mwtxn = snap.mw.BeginTxn(id)
+	}
 	if snap.mr != nil {
 		txn.mrview = snap.mr.View(id)
 	}
@@ -71,11 +96,28 @@ func (txn *Txn) OpenCursor(key []byte) (api.Cursor, error) {
 	return cur, nil
 }
 
+// OpenReverseCursor open an active cursor inside the index, positioned
+// at the last entry less than or equal to key.
+func (txn *Txn) OpenReverseCursor(key []byte) (api.Cursor, error) {
+	cur, err := txn.getcursor().openreverse(txn, nil, key)
+	if err != nil {
+		txn.putcursor(cur)
+		return nil, err
+	}
+	return cur, nil
+}
+
 // Commit transaction, commit will block until all write operations
 // under the transaction are successfully applied. Return
 // ErrorRollback if ACID properties are not met while applying the
-// write operations. Transactions are never partially committed.
+// write operations. Transactions are never partially committed. Return
+// *DegradedError, without applying the writes, if index is read-only,
+// refer Bogn.Health().
 func (txn *Txn) Commit() error {
+	if err := txn.bogn.Health(); err != nil {
+		txn.Abort()
+		return err
+	}
 	if txn.mrview != nil {
 		txn.mrview.Abort()
 	}
@@ -85,11 +127,34 @@ func (txn *Txn) Commit() error {
 	for _, dview := range txn.dviews {
 		dview.Abort()
 	}
+	// cursors on llrb memstore shall not block writers holding the
+	// mutation lock.
+	txn.mwtxn.(*llrb.Txn).Release()
+
+	bogn := txn.bogn
+	bogn.mutationlock()
+	// Keys written by this transaction, and with serializable isolation
+	// keys read by it, are validated against the latest version across
+	// `mw`, `mr` and disk levels, first committer wins.
+	pos, mwseqno := int64(0), txn.snap.mwseqno()
+	var logerr error
+	err1 := txn.validatereads()
+	if err1 != nil {
+		txn.mwtxn.Abort()
+	} else {
+		err1 = txn.mwtxn.Commit()
+	}
+	if err1 == nil {
+		pos, logerr = txn.logwrites(mwseqno)
+		txn.indexwrites(mwseqno)
+	}
+	bogn.mutationunlock()
 
-	err1 := txn.mwtxn.Commit()
-	err2 := txn.bogn.commit(txn)
+	err2 := bogn.commit(txn)
 	if err1 != nil {
 		return err1
+	} else if err := bogn.synclog(pos, logerr); err != nil {
+		return err
 	} else if err2 != nil {
 		return err2
 	}
@@ -114,11 +179,14 @@ func (txn *Txn) Abort() {
 
 //---- Exported Read methods
 
-// Get value for key from snapshot.
+// Get value for key from snapshot. Keys read via Get are validated
+// during Commit, if isolation is "serializable", refer BeginTxn.
 func (txn *Txn) Get(
 	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
 
-	return txn.yget(key, value)
+	v, cas, deleted, ok = txn.yget(key, value)
+	txn.addread(key, cas, false /*written*/)
+	return v, cas, deleted, ok
 }
 
 //---- Exported Write methods
@@ -126,17 +194,164 @@ func (txn *Txn) Get(
 // Set an entry of key, value pair. The set operation will be remembered
 // as a log entry and applied on the underlying structure during Commit.
 func (txn *Txn) Set(key, value, oldvalue []byte) []byte {
+	txn.addwrite(key)
+	txn.addwkey(key, 0)
 	return txn.mwtxn.Set(key, value, oldvalue)
 }
 
+// SetTTL is same as Set, but the entry shall expire after ttl duration
+// from now. Once expired, the entry is treated as deleted by readers.
+func (txn *Txn) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) []byte {
+
+	expiry := api.Ttlexpiry(ttl)
+	txn.addwrite(key)
+	txn.addwkey(key, expiry)
+	return txn.mwtxn.(*llrb.Txn).SetExpiry(key, value, oldvalue, expiry)
+}
+
 // Delete key from index. The Delete operation will be remembered as a log
 // entry and applied on the underlying structure during commit.
 func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
+	txn.addwrite(key)
+	txn.addwkey(key, 0)
 	return txn.mwtxn.Delete(key, oldvalue, lsm)
 }
 
 //---- local methods
 
+// addread remember the cas observed for key on its first access.
+func (txn *Txn) addread(key []byte, cas uint64, written bool) {
+	read, ok := txn.reads[string(key)]
+	if ok == false {
+		read.cas = cas
+	}
+	read.written = read.written || written
+	txn.reads[string(key)] = read
+}
+
+// addwrite remember the cas of key, as seen by this transaction before
+// its first write on the key.
+func (txn *Txn) addwrite(key []byte) {
+	if _, ok := txn.reads[string(key)]; ok {
+		txn.addread(key, 0, true /*written*/)
+		return
+	}
+	_, cas, _, _ := txn.yget(key, nil)
+	txn.addread(key, cas, true /*written*/)
+}
+
+// validatereads check the cas observed for keys accessed by this
+// transaction against the latest version, called with mutation lock
+// held. Disk levels cannot change while the transaction holds on to
+// its snapshot, still they are looked up so that a key missing in
+// memory is validated against its version on disk.
+func (txn *Txn) validatereads() error {
+	if len(txn.reads) == 0 {
+		return nil
+	}
+	get := txn.snap.latestyget()
+	for key, read := range txn.reads {
+		if read.written == false && txn.bogn.serialize == false {
+			continue
+		}
+		if _, cas, _, _ := get([]byte(key), nil); cas != read.cas {
+			return api.ErrorRollback
+		}
+	}
+	return nil
+}
+
+func (txn *Txn) addwkey(key []byte, expiry uint64) {
+	if txn.bogn.wal != nil || len(txn.bogn.getsecondaries()) > 0 {
+		wkey := make([]byte, len(key))
+		copy(wkey, key)
+		txn.wkeys = append(txn.wkeys, walop{key: wkey, expiry: expiry})
+	}
+}
+
+// log the outcome of committed writes as a single batch, called with
+// write-ahead-log locked. Since no other mutation can happen on `mw`
+// while the log is locked, entries with seqno greater than `mwseqno`
+// are those applied by this transaction.
+func (txn *Txn) logwrites(mwseqno uint64) (int64, error) {
+	wal := txn.bogn.wal
+	if wal == nil || len(txn.wkeys) == 0 {
+		return 0, nil
+	}
+
+	// stable sort, so that the last write on a key comes last.
+	sort.SliceStable(txn.wkeys, func(i, j int) bool {
+		return bytes.Compare(txn.wkeys[i].key, txn.wkeys[j].key) < 0
+	})
+	ops := make([]walop, 0, len(txn.wkeys))
+	for i, wkey := range txn.wkeys {
+		if i+1 < len(txn.wkeys) {
+			if bytes.Compare(wkey.key, txn.wkeys[i+1].key) == 0 {
+				continue
+			}
+		}
+		key, expiry := wkey.key, wkey.expiry
+		value, cas, deleted, ok := txn.snap.mw.Get(key, []byte{})
+		if ok == false {
+			ops = append(ops, walop{cmd: walcmdRemove, key: key})
+		} else if cas <= mwseqno { // not touched by this transaction.
+			continue
+		} else if deleted {
+			ops = append(ops, walop{cmd: walcmdDelete, seqno: cas, key: key})
+		} else if expiry > 0 {
+			op := walop{
+				cmd: walcmdSetTTL, seqno: cas, key: key, value: value,
+				expiry: expiry,
+			}
+			ops = append(ops, op)
+		} else {
+			op := walop{cmd: walcmdSet, seqno: cas, key: key, value: value}
+			ops = append(ops, op)
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return ops[i].seqno < ops[j].seqno
+	})
+	for _, op := range ops {
+		if op.cmd == walcmdSetTTL {
+			wal.addttlop(op.seqno, op.key, op.value, op.expiry)
+			continue
+		}
+		wal.addop(op.cmd, op.seqno, op.key, op.value)
+	}
+	return txn.bogn.logmutations(txn.snap.mwseqno())
+}
+
+// update secondary indexes with the outcome of committed writes,
+// called with mutation lock held, refer logwrites.
+func (txn *Txn) indexwrites(mwseqno uint64) {
+	secs := txn.bogn.getsecondaries()
+	if len(secs) == 0 || len(txn.wkeys) == 0 {
+		return
+	}
+
+	// stable sort, so that the last write on a key comes last.
+	sort.SliceStable(txn.wkeys, func(i, j int) bool {
+		return bytes.Compare(txn.wkeys[i].key, txn.wkeys[j].key) < 0
+	})
+	updates := make([]secupdate, 0, len(txn.wkeys))
+	for i, wkey := range txn.wkeys {
+		if i+1 < len(txn.wkeys) {
+			if bytes.Compare(wkey.key, txn.wkeys[i+1].key) == 0 {
+				continue
+			}
+		}
+		value, cas, deleted, ok := txn.snap.mw.Get(wkey.key, []byte{})
+		if ok && cas <= mwseqno { // not touched by this transaction.
+			continue
+		}
+		upd := secupdate{key: wkey.key, value: value, deleted: !ok || deleted}
+		updates = append(updates, upd)
+	}
+	txn.bogn.updatesecondaries(secs, updates)
+}
+
 func (txn *Txn) getcursor() (cur *Cursor) {
 	select {
 	case cur = <-txn.curchan:
@@ -153,6 +368,7 @@ func (txn *Txn) putcursor(cur *Cursor) {
 	cur.value = lib.Fixbuffer(cur.value, 0)
 	cur.cas, cur.deleted = 0, false
 	cur.iter, cur.iters = nil, cur.iters[:0]
+	cur.reverse, cur.eof = false, false
 
 	select {
 	case txn.curchan <- cur:
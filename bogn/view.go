@@ -1,5 +1,6 @@
 package bogn
 
+import "time"
 import "sync/atomic"
 
 import "github.com/bnclabs/gostore/api"
@@ -15,11 +16,12 @@ type View struct {
 	mcview api.Transactor
 	dviews []api.Transactor
 	yget   api.Getter
+	tombs  api.Rangetombs
 
 	// working memory.
 	cursors []*Cursor
 	curchan chan *Cursor
-	gets    []api.Getter
+	gets    []getter
 }
 
 func newview(id uint64, bogn *Bogn, snap *snapshot, cch chan *Cursor) *View {
@@ -28,7 +30,7 @@ func newview(id uint64, bogn *Bogn, snap *snapshot, cch chan *Cursor) *View {
 		dviews:  make([]api.Transactor, 0, 32),
 		cursors: make([]*Cursor, 0, 8),
 		curchan: cch,
-		gets:    make([]api.Getter, 0, 32),
+		gets:    make([]getter, 0, 32),
 	}
 	return view
 }
@@ -37,6 +39,7 @@ func (view *View) initview() *View {
 	var disks [256]api.Index
 
 	id, snap := view.id, view.snap
+	view.tombs = snap.rangetombs()
 	view.mwview = snap.mw.View(id)
 	if snap.mr != nil {
 		view.mrview = snap.mr.View(id)
@@ -70,6 +73,17 @@ func (view *View) OpenCursor(key []byte) (api.Cursor, error) {
 	return cur, nil
 }
 
+// OpenReverseCursor open an active cursor inside the index, positioned
+// at the last entry less than or equal to key.
+func (view *View) OpenReverseCursor(key []byte) (api.Cursor, error) {
+	cur, err := view.getcursor().openreverse(nil, view, key)
+	if err != nil {
+		view.putcursor(cur)
+		return nil, err
+	}
+	return cur, nil
+}
+
 // Commit not allowed.
 func (view *View) Commit() error {
 	panic("Commit not allowed on view")
@@ -107,6 +121,13 @@ func (view *View) Set(key, value, oldvalue []byte) []byte {
 	panic("Set not allowed on view")
 }
 
+// SetTTL is not allowed.
+func (view *View) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) []byte {
+
+	panic("SetTTL not allowed on view")
+}
+
 // Delete is not allowed.
 func (view *View) Delete(key, oldvalue []byte, lsm bool) []byte {
 	panic("Delete not allowed on view")
@@ -130,6 +151,7 @@ func (view *View) putcursor(cur *Cursor) {
 	cur.value = lib.Fixbuffer(cur.value, 0)
 	cur.cas, cur.deleted = 0, false
 	cur.iter, cur.iters = nil, cur.iters[:0]
+	cur.reverse, cur.eof = false, false
 
 	select {
 	case view.curchan <- cur:
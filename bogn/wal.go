@@ -0,0 +1,632 @@
+package bogn
+
+import "io"
+import "os"
+import "fmt"
+import "sort"
+import "sync"
+import "runtime"
+import "time"
+import "strconv"
+import "strings"
+import "hash/crc32"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/binary"
+
+import "github.com/bnclabs/gostore/lib"
+
+// Write ahead log, every mutation applied on the write store `mw` is
+// appended to the log before the mutation is acknowledged. Log is
+// organised as a sequence of segment files under logdir, each segment
+// is a sequence of records:
+//
+//   | length uint32 | crc32c uint32 | payload |
+//
+// payload is a batch of one or more operations, mutations from a single
+// api call or from a single transaction commit:
+//
+//   | seqno uint64 | numops uint32 | op1 | op2 | ... |
+//
+// where seqno is the seqno of write store after applying the batch,
+// and each operation is encoded as:
+//
+//   | cmd byte | seqno uint64 | keylen uint32 | vallen uint32 | key | val |
+//
+// walcmdSetTTL operations are followed by 8-byte expiry, in unix seconds.
+// walcmdDeleteRange operations carry the low bound of range as key and
+// the high bound as value, an empty bound is treated as unbounded.
+// walcmdMerge operations carry the merge operand as value.
+//
+// Records are made durable on disk based on the sync mode:
+//
+// "always", fsync the segment file after every record is appended,
+// while the log is locked.
+//
+// "group", a committer routine fsyncs the segment file on behalf of
+// all the writers waiting for their record to be synced, and wakes
+// them together.
+//
+// "interval:<ms>", a committer routine fsyncs the segment file every
+// <ms> milliseconds, writers don't wait for their record to be synced.
+
+const (
+	walcmdSet byte = iota + 1
+	walcmdDelete
+	walcmdRemove // non-lsm delete, removes the key from memory.
+	walcmdSetTTL // set with expiry.
+	walcmdDeleteRange
+	walcmdMerge // merge operand, refer Bogn.Merge.
+)
+
+const walheadersize = 8
+
+type walop struct {
+	cmd    byte
+	seqno  uint64
+	key    []byte
+	value  []byte
+	expiry uint64 // only for walcmdSetTTL.
+}
+
+type walsegment struct {
+	id       int
+	path     string
+	maxseqno uint64
+}
+
+type wal struct {
+	mu        sync.Mutex
+	logprefix string
+	dir       string
+	segsize   int64
+	segments  []*walsegment // closed segments, oldest first.
+	active    *walsegment
+	fd        *os.File
+	fpos      int64
+	nwritten  int64 // number of records written, protected by mu.
+	tblcrc32  *crc32.Table
+
+	// sync
+	syncmode string
+	interval time.Duration
+	syncmu   sync.Mutex
+	synccond *sync.Cond
+	nsynced  int64 // number of records synced, protected by syncmu.
+	syncerr  error
+	syncch   chan struct{}
+	finch    chan struct{}
+	wg       sync.WaitGroup
+
+	// stats, protected by syncmu.
+	n_syncs       int64
+	h_fsync       *lib.HistogramInt64
+	h_synclatency *lib.HistogramInt64
+
+	// working memory.
+	ops    []walop
+	buffer []byte
+}
+
+func newwal(
+	logprefix, dir string, segsize int64, syncmode string) (*wal, error) {
+
+	w := &wal{
+		logprefix: logprefix,
+		dir:       dir,
+		segsize:   segsize,
+		segments:  []*walsegment{},
+		tblcrc32:  crc32.MakeTable(crc32.Castagnoli),
+		syncch:    make(chan struct{}, 1),
+		finch:     make(chan struct{}),
+		ops:       make([]walop, 0, 16),
+		buffer:    make([]byte, 0, 1024),
+	}
+	w.synccond = sync.NewCond(&w.syncmu)
+	// latencies are measured in microseconds.
+	w.h_fsync = lib.NewhistorgramInt64(0, 100000, 1000)
+	w.h_synclatency = lib.NewhistorgramInt64(0, 100000, 1000)
+	if err := w.parsesync(syncmode); err != nil {
+		errorf("%v wal: %v", logprefix, err)
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		errorf("%v wal: %v", logprefix, err)
+		return nil, err
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		errorf("%v wal.ReadDir(): %v", logprefix, err)
+		return nil, err
+	}
+	for _, fi := range fis {
+		if id, ok := walsegmentid(fi.Name()); ok && !fi.IsDir() {
+			path := filepath.Join(dir, fi.Name())
+			w.segments = append(w.segments, &walsegment{id: id, path: path})
+		}
+	}
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].id < w.segments[j].id
+	})
+
+	if w.syncmode != "always" {
+		w.wg.Add(1)
+		go w.committer()
+	}
+	return w, nil
+}
+
+func (w *wal) parsesync(syncmode string) error {
+	switch {
+	case syncmode == "always", syncmode == "group":
+		w.syncmode = syncmode
+		return nil
+
+	case strings.HasPrefix(syncmode, "interval:"):
+		arg := strings.TrimPrefix(syncmode, "interval:")
+		ms, err := strconv.Atoi(arg)
+		if err == nil && ms > 0 {
+			w.syncmode = "interval"
+			w.interval = time.Duration(ms) * time.Millisecond
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid wal.sync %q", syncmode)
+}
+
+func walsegmentname(id int) string {
+	return fmt.Sprintf("segment-%010d.log", id)
+}
+
+func walsegmentid(name string) (int, bool) {
+	if !strings.HasPrefix(name, "segment-") {
+		return -1, false
+	} else if !strings.HasSuffix(name, ".log") {
+		return -1, false
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "segment-"), ".log")
+	id, err := strconv.Atoi(name)
+	if err != nil {
+		return -1, false
+	}
+	return id, true
+}
+
+// replay all records from all segments, in the order they were
+// appended, and call apply for each batch whose seqno is greater than
+// `seqno`. A partially written record at the tail of the last segment
+// is treated as an incomplete write and truncated. After replay the log
+// is ready for appends.
+func (w *wal) replay(seqno uint64, apply func(uint64, []walop)) error {
+	for i, seg := range w.segments {
+		last := i == len(w.segments)-1
+		if err := w.replaysegment(seg, last, seqno, apply); err != nil {
+			return err
+		}
+	}
+	return w.rotate()
+}
+
+func (w *wal) replaysegment(
+	seg *walsegment, last bool, seqno uint64,
+	apply func(uint64, []walop)) error {
+
+	data, err := ioutil.ReadFile(seg.path)
+	if err != nil {
+		errorf("%v wal.ReadFile(%q): %v", w.logprefix, seg.path, err)
+		return err
+	}
+
+	fpos, n := 0, 0
+	for fpos < len(data) {
+		batchseqno, ops, size, err := w.decoderecord(data[fpos:])
+		if err != nil && last {
+			fmsg := "%v wal: truncating %q at %v, %v"
+			warnf(fmsg, w.logprefix, seg.path, fpos, err)
+			if err := os.Truncate(seg.path, int64(fpos)); err != nil {
+				errorf("%v wal.Truncate(%q): %v", w.logprefix, seg.path, err)
+				return err
+			}
+			break
+
+		} else if err != nil {
+			fmsg := "%v wal: segment %q at %v: %v"
+			errorf(fmsg, w.logprefix, seg.path, fpos, err)
+			return err
+		}
+		if batchseqno > seg.maxseqno {
+			seg.maxseqno = batchseqno
+		}
+		if batchseqno > seqno {
+			apply(batchseqno, ops)
+			n++
+		}
+		fpos += size
+	}
+	fmsg := "%v wal: replayed %v batches from %q"
+	infof(fmsg, w.logprefix, n, filepath.Base(seg.path))
+	return nil
+}
+
+// lock the log, all mutations on the write store shall be applied
+// with the log locked, so that records are appended in seqno order.
+func (w *wal) lock() {
+	if w != nil {
+		w.mu.Lock()
+	}
+}
+
+func (w *wal) unlock() {
+	if w != nil {
+		w.mu.Unlock()
+	}
+}
+
+// called with log locked, add an operation to the current batch.
+func (w *wal) addop(cmd byte, seqno uint64, key, value []byte) {
+	if w != nil {
+		op := walop{cmd: cmd, seqno: seqno, key: key, value: value}
+		w.ops = append(w.ops, op)
+	}
+}
+
+// called with log locked, add a set operation, with expiry, to the
+// current batch.
+func (w *wal) addttlop(seqno uint64, key, value []byte, expiry uint64) {
+	if w != nil {
+		op := walop{
+			cmd: walcmdSetTTL, seqno: seqno, key: key, value: value,
+			expiry: expiry,
+		}
+		w.ops = append(w.ops, op)
+	}
+}
+
+// called with log locked, append the current batch as a single record.
+// Return the position of the record in the log, to be used with
+// waitsync once the log is unlocked.
+func (w *wal) flushops(seqno uint64) (int64, error) {
+	if w == nil {
+		return 0, nil
+	} else if len(w.ops) == 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+
+	w.buffer = w.encoderecord(w.buffer[:0], seqno, w.ops)
+	for i := range w.ops {
+		w.ops[i].key, w.ops[i].value = nil, nil
+	}
+	w.ops = w.ops[:0]
+
+	n, err := w.fd.Write(w.buffer)
+	if err != nil {
+		errorf("%v wal.Write(%q): %v", w.logprefix, w.active.path, err)
+		return 0, err
+	} else if n != len(w.buffer) {
+		err := fmt.Errorf("bogn.wal.partialwrite")
+		errorf("%v wal.Write(%q): %v", w.logprefix, w.active.path, err)
+		return 0, err
+	}
+	w.fpos += int64(n)
+	w.nwritten++
+	if seqno > w.active.maxseqno {
+		w.active.maxseqno = seqno
+	}
+
+	switch w.syncmode {
+	case "always":
+		if err := w.syncfd(w.fd, w.nwritten); err != nil {
+			return 0, err
+		}
+		fallthrough
+	case "interval":
+		w.syncmu.Lock()
+		w.h_synclatency.Add(int64(time.Since(start) / time.Microsecond))
+		err = w.syncerr
+		w.syncmu.Unlock()
+		if err != nil { // report error from previous sync.
+			return 0, err
+		}
+	}
+
+	if w.fpos >= w.segsize {
+		return w.nwritten, w.rotate()
+	}
+	return w.nwritten, nil
+}
+
+// waitsync shall be called after the log is unlocked, block until the
+// record at `pos` is synced to disk. Only in "group" mode the caller
+// has to wait for the committer, in other modes this call returns
+// immediately. Return error if log could not be synced.
+func (w *wal) waitsync(pos int64) error {
+	if w == nil || pos == 0 || w.syncmode != "group" {
+		return nil
+	}
+
+	start := time.Now()
+	w.syncmu.Lock()
+	if w.nsynced < pos && w.syncerr == nil {
+		select {
+		case w.syncch <- struct{}{}:
+		default: // committer is already notified.
+		}
+	}
+	for w.nsynced < pos && w.syncerr == nil {
+		w.synccond.Wait()
+	}
+	err := w.syncerr
+	if err == nil {
+		w.h_synclatency.Add(int64(time.Since(start) / time.Microsecond))
+	}
+	w.syncmu.Unlock()
+	return err
+}
+
+// committer routine, for "group" mode sync on behalf of all waiting
+// writers, for "interval" mode sync periodically.
+func (w *wal) committer() {
+	defer w.wg.Done()
+
+	var tickch <-chan time.Time
+	if w.syncmode == "interval" {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tickch = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.syncch:
+		case <-tickch:
+		case <-w.finch:
+			return
+		}
+		// give a chance for concurrent writers to append their record.
+		runtime.Gosched()
+		w.mu.Lock()
+		fd, nwritten := w.fd, w.nwritten
+		w.mu.Unlock()
+		// error is remembered and reported to writers.
+		w.syncfd(fd, nwritten)
+	}
+}
+
+// syncfd fsync the segment file, all records upto `nwritten` shall be
+// durable after this call.
+func (w *wal) syncfd(fd *os.File, nwritten int64) error {
+	w.syncmu.Lock()
+	defer w.syncmu.Unlock()
+
+	// if active segment is rotated after fd was picked, then it is
+	// already synced.
+	if w.syncerr != nil {
+		return w.syncerr
+	} else if fd == nil || w.nsynced >= nwritten {
+		return nil
+	}
+
+	start := time.Now()
+	if err := fd.Sync(); err != nil {
+		errorf("%v wal.Sync(): %v", w.logprefix, err)
+		w.syncerr = err
+		w.synccond.Broadcast()
+		return err
+	}
+	w.h_fsync.Add(int64(time.Since(start) / time.Microsecond))
+	w.nsynced, w.n_syncs = nwritten, w.n_syncs+1
+	w.synccond.Broadcast()
+	return nil
+}
+
+// stats return sync mode and sync statistics for this log.
+func (w *wal) stats() map[string]interface{} {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	nwritten := w.nwritten
+	w.mu.Unlock()
+
+	w.syncmu.Lock()
+	defer w.syncmu.Unlock()
+
+	m := map[string]interface{}{
+		"wal.sync":      w.syncmode,
+		"n_records":     nwritten,
+		"n_syncs":       w.n_syncs,
+		"h_fsync":       w.h_fsync.Fullstats(),
+		"h_synclatency": w.h_synclatency.Fullstats(),
+	}
+	if w.syncmode == "interval" {
+		ms := int64(w.interval / time.Millisecond)
+		m["wal.sync"] = fmt.Sprintf("interval:%v", ms)
+	}
+	return m
+}
+
+// remove segments whose mutations are all flushed to disk, that is,
+// all mutations are less than or equal to `seqno`.
+func (w *wal) truncate(seqno uint64) error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.fd != nil && w.fpos > 0 && w.active.maxseqno <= seqno {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	segments := w.segments[:0]
+	for _, seg := range w.segments {
+		if seg.maxseqno > seqno {
+			segments = append(segments, seg)
+			continue
+		}
+		if err := os.Remove(seg.path); err != nil {
+			errorf("%v wal.Remove(%q): %v", w.logprefix, seg.path, err)
+			return err
+		}
+		fmsg := "%v wal: removed segment %q upto seqno %v"
+		debugf(fmsg, w.logprefix, filepath.Base(seg.path), seg.maxseqno)
+	}
+	w.segments = segments
+	return nil
+}
+
+// log sync statistics for this log.
+func (w *wal) log() {
+	if w == nil {
+		return
+	}
+
+	w.syncmu.Lock()
+	defer w.syncmu.Unlock()
+
+	fmsg := "%v wal: sync %q, %v syncs"
+	infof(fmsg, w.logprefix, w.syncmode, w.n_syncs)
+	infof("%v wal: h_fsync %v", w.logprefix, w.h_fsync.Logstring())
+	fmsg = "%v wal: h_synclatency %v"
+	infof(fmsg, w.logprefix, w.h_synclatency.Logstring())
+}
+
+// close the active segment and open a new one.
+func (w *wal) rotate() error {
+	id := 0
+	if w.active != nil {
+		id = w.active.id + 1
+		// closed segments are always durable.
+		if err := w.syncfd(w.fd, w.nwritten); err != nil {
+			return err
+		}
+		if err := w.fd.Close(); err != nil {
+			errorf("%v wal.Close(%q): %v", w.logprefix, w.active.path, err)
+			return err
+		}
+		w.segments = append(w.segments, w.active)
+		w.active, w.fd, w.fpos = nil, nil, 0
+
+	} else if len(w.segments) > 0 {
+		id = w.segments[len(w.segments)-1].id + 1
+	}
+
+	path := filepath.Join(w.dir, walsegmentname(id))
+	flags := os.O_CREATE | os.O_EXCL | os.O_WRONLY | os.O_APPEND
+	fd, err := os.OpenFile(path, flags, 0660)
+	if err != nil {
+		errorf("%v wal.OpenFile(%q): %v", w.logprefix, path, err)
+		return err
+	}
+	w.active, w.fd, w.fpos = &walsegment{id: id, path: path}, fd, 0
+	return nil
+}
+
+func (w *wal) close() error {
+	if w == nil {
+		return nil
+	}
+
+	close(w.finch)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	if w.fd != nil {
+		err = w.syncfd(w.fd, w.nwritten)
+		if e := w.fd.Close(); e != nil {
+			errorf("%v wal.Close(%q): %v", w.logprefix, w.active.path, e)
+			if err == nil {
+				err = e
+			}
+		}
+		w.fd = nil
+	}
+	return err
+}
+
+func (w *wal) encoderecord(
+	buf []byte, seqno uint64, ops []walop) []byte {
+
+	var scratch [8]byte
+
+	buf = append(buf, scratch[:walheadersize]...) // place holder
+	binary.BigEndian.PutUint64(scratch[:8], seqno)
+	buf = append(buf, scratch[:8]...)
+	binary.BigEndian.PutUint32(scratch[:4], uint32(len(ops)))
+	buf = append(buf, scratch[:4]...)
+	for _, op := range ops {
+		buf = append(buf, op.cmd)
+		binary.BigEndian.PutUint64(scratch[:8], op.seqno)
+		buf = append(buf, scratch[:8]...)
+		binary.BigEndian.PutUint32(scratch[:4], uint32(len(op.key)))
+		buf = append(buf, scratch[:4]...)
+		binary.BigEndian.PutUint32(scratch[:4], uint32(len(op.value)))
+		buf = append(buf, scratch[:4]...)
+		buf = append(buf, op.key...)
+		buf = append(buf, op.value...)
+		if op.cmd == walcmdSetTTL {
+			binary.BigEndian.PutUint64(scratch[:8], op.expiry)
+			buf = append(buf, scratch[:8]...)
+		}
+	}
+
+	payload := buf[walheadersize:]
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(payload, w.tblcrc32))
+	return buf
+}
+
+// decoderecord return the batch seqno, list of operations and number of
+// bytes consumed from data. Returned key and value slices refer to data.
+func (w *wal) decoderecord(data []byte) (uint64, []walop, int, error) {
+	if len(data) < walheadersize {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+	ln := int(binary.BigEndian.Uint32(data[:4]))
+	crc := binary.BigEndian.Uint32(data[4:8])
+	if len(data[walheadersize:]) < ln {
+		return 0, nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := data[walheadersize : walheadersize+ln]
+	if crc32.Checksum(payload, w.tblcrc32) != crc {
+		return 0, nil, 0, fmt.Errorf("bogn.wal.checksum")
+	} else if len(payload) < 12 {
+		return 0, nil, 0, fmt.Errorf("bogn.wal.invalidrecord")
+	}
+
+	seqno := binary.BigEndian.Uint64(payload[:8])
+	numops := int(binary.BigEndian.Uint32(payload[8:12]))
+	ops, payload := make([]walop, 0, numops), payload[12:]
+	for i := 0; i < numops; i++ {
+		if len(payload) < 17 {
+			return 0, nil, 0, fmt.Errorf("bogn.wal.invalidrecord")
+		}
+		op := walop{cmd: payload[0]}
+		op.seqno = binary.BigEndian.Uint64(payload[1:9])
+		klen := int(binary.BigEndian.Uint32(payload[9:13]))
+		vlen := int(binary.BigEndian.Uint32(payload[13:17]))
+		if payload = payload[17:]; len(payload) < klen+vlen {
+			return 0, nil, 0, fmt.Errorf("bogn.wal.invalidrecord")
+		}
+		op.key, op.value = payload[:klen], payload[klen:klen+vlen]
+		payload = payload[klen+vlen:]
+		if op.cmd == walcmdSetTTL {
+			if len(payload) < 8 {
+				return 0, nil, 0, fmt.Errorf("bogn.wal.invalidrecord")
+			}
+			op.expiry = binary.BigEndian.Uint64(payload[:8])
+			payload = payload[8:]
+		}
+		ops = append(ops, op)
+	}
+	return seqno, ops, walheadersize + ln, nil
+}
@@ -0,0 +1,214 @@
+package bogn
+
+import "os"
+import "fmt"
+import "sync"
+import "time"
+import "testing"
+import "hash/crc32"
+import "io/ioutil"
+import "path/filepath"
+
+func TestWalRecord(t *testing.T) {
+	w := &wal{tblcrc32: crc32.MakeTable(crc32.Castagnoli)}
+
+	ops := []walop{
+		{cmd: walcmdSet, seqno: 10, key: []byte("key1"), value: []byte("val1")},
+		{cmd: walcmdDelete, seqno: 11, key: []byte("key2")},
+		{cmd: walcmdRemove, seqno: 12, key: []byte("key3")},
+		{
+			cmd: walcmdSetTTL, seqno: 13, key: []byte("key4"),
+			value: []byte("val4"), expiry: 1234567890,
+		},
+	}
+	buf := w.encoderecord(make([]byte, 0), 13, ops)
+	seqno, rops, n, err := w.decoderecord(buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if seqno != 13 {
+		t.Errorf("expected %v, got %v", 13, seqno)
+	} else if n != len(buf) {
+		t.Errorf("expected %v, got %v", len(buf), n)
+	} else if len(rops) != len(ops) {
+		t.Fatalf("expected %v, got %v", len(ops), len(rops))
+	}
+	for i, op := range ops {
+		rop := rops[i]
+		if rop.cmd != op.cmd || rop.seqno != op.seqno {
+			t.Errorf("expected %v, got %v", op, rop)
+		} else if rop.expiry != op.expiry {
+			t.Errorf("expected %v, got %v", op, rop)
+		} else if string(rop.key) != string(op.key) {
+			t.Errorf("expected %q, got %q", op.key, rop.key)
+		} else if string(rop.value) != string(op.value) {
+			t.Errorf("expected %q, got %q", op.value, rop.value)
+		}
+	}
+
+	// torn record
+	if _, _, _, err := w.decoderecord(buf[:len(buf)-1]); err == nil {
+		t.Errorf("expected error")
+	}
+	// corrupted record
+	buf[len(buf)-1] ^= 0xff
+	if _, _, _, err := w.decoderecord(buf); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestWalReplay(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "bogn-waltest-logs")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	w, err := newwal("waltest", dir, 1024, "always")
+	if err != nil {
+		t.Fatal(err)
+	} else if err := w.replay(0, nil); err != nil {
+		t.Fatal(err)
+	}
+	n := 1000
+	for i := 1; i <= n; i++ {
+		key, val := fmt.Sprintf("key%d", i), fmt.Sprintf("val%d", i)
+		w.lock()
+		w.addop(walcmdSet, uint64(i), []byte(key), []byte(val))
+		if _, err := w.flushops(uint64(i)); err != nil {
+			t.Fatal(err)
+		}
+		w.unlock()
+	}
+	if len(w.segments) == 0 {
+		t.Errorf("expected segments to rotate")
+	}
+	// simulate a torn write at the tail.
+	w.fd.Write([]byte{0, 0, 1, 0, 1, 2})
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay := func(w *wal, seqno uint64) (uint64, int) {
+		nextseqno, count := seqno+1, 0
+		err := w.replay(seqno, func(batchseqno uint64, ops []walop) {
+			if batchseqno != nextseqno {
+				t.Errorf("expected %v, got %v", nextseqno, batchseqno)
+			}
+			key := fmt.Sprintf("key%d", batchseqno)
+			if len(ops) != 1 {
+				t.Errorf("expected %v, got %v", 1, len(ops))
+			} else if string(ops[0].key) != key {
+				t.Errorf("expected %q, got %q", key, ops[0].key)
+			}
+			nextseqno, count = nextseqno+1, count+1
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nextseqno - 1, count
+	}
+
+	w, err = newwal("waltest", dir, 1024, "always")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqno, count := replay(w, 500); seqno != uint64(n) {
+		t.Errorf("expected %v, got %v", n, seqno)
+	} else if count != n-500 {
+		t.Errorf("expected %v, got %v", n-500, count)
+	}
+
+	// truncate and replay.
+	if err := w.truncate(500); err != nil {
+		t.Fatal(err)
+	} else if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+	w, err = newwal("waltest", dir, 1024, "always")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seqno, count := replay(w, 500); seqno != uint64(n) {
+		t.Errorf("expected %v, got %v", n, seqno)
+	} else if count != n-500 {
+		t.Errorf("expected %v, got %v", n-500, count)
+	}
+	if err := w.truncate(uint64(n)); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(fis) != 1 { // active segment.
+		t.Errorf("expected %v, got %v", 1, len(fis))
+	}
+	w.close()
+}
+
+func TestWalSync(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "bogn-waltest-logs")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	if _, err := newwal("waltest", dir, 1024, "interval:"); err == nil {
+		t.Errorf("expected error")
+	} else if _, err := newwal("waltest", dir, 1024, "never"); err == nil {
+		t.Errorf("expected error")
+	}
+
+	n, nwriters := 1000, 8
+	for _, syncmode := range []string{"always", "group", "interval:10"} {
+		w, err := newwal("waltest", dir, 1024*1024, syncmode)
+		if err != nil {
+			t.Fatal(err)
+		} else if err := w.replay(0, nil); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		seqno := uint64(0)
+		for i := 0; i < nwriters; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < n; j++ {
+					w.lock()
+					seqno++
+					w.addop(walcmdSet, seqno, []byte("key"), []byte("val"))
+					pos, err := w.flushops(seqno)
+					if err != nil {
+						t.Error(err)
+					}
+					w.unlock()
+					if err := w.waitsync(pos); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if syncmode == "interval:10" {
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		stats := w.stats()
+		nsyncs := stats["n_syncs"].(int64)
+		t.Logf("%v: %v records %v syncs", syncmode, stats["n_records"], nsyncs)
+		if x := stats["wal.sync"].(string); x != syncmode {
+			t.Errorf("expected %v, got %v", syncmode, x)
+		} else if x := stats["n_records"].(int64); x != int64(n*nwriters) {
+			t.Errorf("expected %v, got %v", n*nwriters, x)
+		}
+		switch syncmode {
+		case "always":
+			if nsyncs != int64(n*nwriters) {
+				t.Errorf("expected %v, got %v", n*nwriters, nsyncs)
+			}
+		default:
+			if nsyncs == 0 || nsyncs > int64(n*nwriters) {
+				t.Errorf("unexpected %v syncs", nsyncs)
+			}
+		}
+		if err := w.close(); err != nil {
+			t.Fatal(err)
+		}
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,188 @@
+package bubt
+
+import "sync"
+import "sync/atomic"
+
+import s "github.com/bnclabs/gosettings"
+
+// number of shards in block cache, must be a power of 2.
+const blockcacheshards = 16
+
+// Blockcache caches m-blocks and z-blocks for snapshots opened with
+// mmap as false, refer Snapshot.Setblockcache. Blocks are cached after
+// verifying their checksum and after decompressing them, hence a hit
+// saves the cpu cycles along with the read from the file system. Same
+// cache can be shared by any number of snapshots, and cache is split
+// into shards, each with its share of capacity, evicting blocks in
+// least recently used order.
+type Blockcache struct {
+	fileids  uint64 // atomic access, 8-byte aligned
+	capacity int64
+	shards   [blockcacheshards]cacheshard
+}
+
+type blockkey struct {
+	fileid uint64
+	fpos   int64
+}
+
+type cacheblock struct {
+	key   blockkey
+	block []byte
+	next  int64 // file position of the next block.
+	prev  *cacheblock
+	nxt   *cacheblock
+}
+
+type cacheshard struct {
+	mu        sync.Mutex
+	capacity  int64
+	size      int64
+	blocks    map[blockkey]*cacheblock
+	lru       cacheblock // sentinel, lru.nxt is the most recently used.
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// NewBlockcache create a new block cache that can hold upto capacity
+// bytes of blocks.
+func NewBlockcache(capacity int64) *Blockcache {
+	cache := &Blockcache{capacity: capacity}
+	for i := range cache.shards {
+		shard := &cache.shards[i]
+		shard.capacity = capacity / blockcacheshards
+		shard.blocks = make(map[blockkey]*cacheblock)
+		shard.lru.prev, shard.lru.nxt = &shard.lru, &shard.lru
+	}
+	return cache
+}
+
+// Info return statistics for the cache.
+//
+//   capacity  : maximum bytes of blocks that can be cached.
+//   size      : bytes of blocks cached.
+//   n_blocks  : number of blocks cached.
+//   hits      : number of reads served from cache.
+//   misses    : number of reads that missed the cache.
+//   evictions : number of blocks evicted to make room for new blocks.
+func (cache *Blockcache) Info() s.Settings {
+	var size, nblocks, hits, misses, evictions int64
+	for i := range cache.shards {
+		shard := &cache.shards[i]
+		shard.mu.Lock()
+		size, nblocks = size+shard.size, nblocks+int64(len(shard.blocks))
+		hits, misses = hits+shard.hits, misses+shard.misses
+		evictions += shard.evictions
+		shard.mu.Unlock()
+	}
+	return s.Settings{
+		"capacity":  cache.capacity,
+		"size":      size,
+		"n_blocks":  nblocks,
+		"hits":      hits,
+		"misses":    misses,
+		"evictions": evictions,
+	}
+}
+
+// newfileid return a unique id for a file read via this cache, blocks
+// are keyed by file-id, so that files re-created in the same path are
+// not served with stale blocks.
+func (cache *Blockcache) newfileid() uint64 {
+	return atomic.AddUint64(&cache.fileids, 1)
+}
+
+// get block at fpos for file, copying it into block. Return the file
+// position of the block that follows.
+func (cache *Blockcache) get(
+	fileid uint64, fpos int64, block []byte) (next int64, ok bool) {
+
+	key := blockkey{fileid: fileid, fpos: fpos}
+	shard := cache.shardfor(key)
+	shard.mu.Lock()
+	cb, ok := shard.blocks[key]
+	if ok {
+		shard.unlink(cb)
+		shard.pushfront(cb)
+		copy(block, cb.block)
+		next = cb.next
+		shard.hits++
+	} else {
+		shard.misses++
+	}
+	shard.mu.Unlock()
+	return next, ok
+}
+
+// put a copy of block at fpos for file into cache, evicting least
+// recently used blocks if shard is full.
+func (cache *Blockcache) put(
+	fileid uint64, fpos int64, block []byte, next int64) {
+
+	key := blockkey{fileid: fileid, fpos: fpos}
+	shard := cache.shardfor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	size := int64(len(block))
+	if _, ok := shard.blocks[key]; ok { // concurrently cached.
+		return
+	} else if size > shard.capacity { // never fits, don't evict.
+		return
+	}
+	var buf []byte
+	for shard.size+size > shard.capacity && shard.lru.prev != &shard.lru {
+		cb := shard.lru.prev
+		shard.unlink(cb)
+		delete(shard.blocks, cb.key)
+		shard.size -= int64(len(cb.block))
+		shard.evictions++
+		if cap(cb.block) >= len(block) {
+			buf = cb.block[:len(block)]
+		}
+	}
+	if buf == nil {
+		buf = make([]byte, len(block))
+	}
+	copy(buf, block)
+	cb := &cacheblock{key: key, block: buf, next: next}
+	shard.blocks[key] = cb
+	shard.pushfront(cb)
+	shard.size += size
+}
+
+// purge all blocks cached for file.
+func (cache *Blockcache) purge(fileid uint64) {
+	for i := range cache.shards {
+		shard := &cache.shards[i]
+		shard.mu.Lock()
+		for key, cb := range shard.blocks {
+			if key.fileid == fileid {
+				shard.unlink(cb)
+				delete(shard.blocks, key)
+				shard.size -= int64(len(cb.block))
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+func (cache *Blockcache) shardfor(key blockkey) *cacheshard {
+	h := (key.fileid * 0x9E3779B97F4A7C15) ^ uint64(key.fpos)
+	h ^= h >> 31
+	h *= 0xBF58476D1CE4E5B9
+	h ^= h >> 29
+	return &cache.shards[h&(blockcacheshards-1)]
+}
+
+func (shard *cacheshard) unlink(cb *cacheblock) {
+	cb.prev.nxt, cb.nxt.prev = cb.nxt, cb.prev
+	cb.prev, cb.nxt = nil, nil
+}
+
+func (shard *cacheshard) pushfront(cb *cacheblock) {
+	cb.prev, cb.nxt = &shard.lru, shard.lru.nxt
+	shard.lru.nxt.prev = cb
+	shard.lru.nxt = cb
+}
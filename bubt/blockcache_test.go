@@ -0,0 +1,171 @@
+package bubt
+
+import "io"
+import "fmt"
+import "time"
+import "bytes"
+import "testing"
+import "math/rand"
+
+import "github.com/bnclabs/gostore/llrb"
+import s "github.com/bnclabs/gosettings"
+
+func TestBlockcache(t *testing.T) {
+	bsize := int64(64)
+	cache := NewBlockcache(bsize * 4 * blockcacheshards)
+	fileid := cache.newfileid()
+
+	block, out := make([]byte, bsize), make([]byte, bsize)
+	for i := int64(0); i < 1000; i++ {
+		block[0] = byte(i)
+		cache.put(fileid, i*bsize, block, (i+1)*bsize)
+	}
+	info := cache.Info()
+	if x := info.Int64("size"); x > info.Int64("capacity") {
+		t.Errorf("size %v exceeds capacity %v", x, info.Int64("capacity"))
+	} else if x := info.Int64("evictions"); x == 0 {
+		t.Errorf("expected evictions")
+	}
+
+	// most recently used blocks are retained.
+	for i := int64(999); i > 990; i-- {
+		if _, ok := cache.get(fileid, i*bsize, out); ok {
+			if out[0] != byte(i) {
+				t.Errorf("%v expected %v, got %v", i, byte(i), out[0])
+			}
+		}
+	}
+	block[0] = 0xAB
+	cache.put(fileid, 1000*bsize, block, 1001*bsize)
+	if next, ok := cache.get(fileid, 1000*bsize, out); !ok {
+		t.Errorf("expected block")
+	} else if next != 1001*bsize {
+		t.Errorf("expected %v, got %v", 1001*bsize, next)
+	} else if out[0] != 0xAB {
+		t.Errorf("expected %v, got %v", 0xAB, out[0])
+	}
+	// blocks are keyed by file-id.
+	if _, ok := cache.get(cache.newfileid(), 1000*bsize, out); ok {
+		t.Errorf("unexpected block")
+	}
+
+	// block larger than a shard is not cached, and evicts nothing.
+	nblocks := cache.Info().Int64("n_blocks")
+	large := make([]byte, bsize*5)
+	cache.put(fileid, 2000*bsize, large, 2005*bsize)
+	if _, ok := cache.get(fileid, 2000*bsize, large); ok {
+		t.Errorf("unexpected block")
+	} else if x := cache.Info().Int64("n_blocks"); x != nblocks {
+		t.Errorf("expected %v, got %v", nblocks, x)
+	}
+
+	cache.purge(fileid)
+	info = cache.Info()
+	if x := info.Int64("size"); x != 0 {
+		t.Errorf("unexpected %v", x)
+	} else if x := info.Int64("n_blocks"); x != 0 {
+		t.Errorf("unexpected %v", x)
+	}
+}
+
+func TestSnapshotBlockcache(t *testing.T) {
+	n := 20000
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	mi := llrb.NewLLRB("buildllrb", setts)
+	defer mi.Destroy()
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i*2))
+		mi.Set(key, []byte(fmt.Sprintf("val%v", i)), nil)
+		if i%10 == 0 {
+			mi.Delete(key, nil, true /*lsm*/)
+		}
+	}
+
+	paths := makepaths123(-1)
+	rand.Seed(time.Now().UnixNano())
+	name, msize, zsize := "testbuild", int64(4096), int64(4096)
+	vsize := []int64{0, zsize}[rand.Intn(100000)%2]
+	zcodec := []string{CodecNone, CodecSnappy}[rand.Intn(100000)%2]
+	t.Logf("paths: %v, vsize: %v, zcodec: %v", len(paths), vsize, zcodec)
+	bubt, err := NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bubt.Compression(zcodec, CodecNone)
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	cache := NewBlockcache(1024 * 1024)
+	if err := snap.Setblockcache(cache, 1 /*pinlevels*/); err != nil {
+		t.Fatal(err)
+	} else if x := snap.Info().Int64("n_pinned"); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	}
+
+	// lookup every key twice, second round shall hit the cache.
+	for round := 0; round < 2; round++ {
+		for i := 0; i < n*2; i++ {
+			key := []byte(fmt.Sprintf("key%015d", i))
+			refval, refseqno, refdel, refok := mi.Get(key, []byte{})
+			val, seqno, del, ok := snap.Get(key, []byte{})
+			if ok != refok {
+				t.Fatalf("%q expected %v, got %v", key, refok, ok)
+			} else if ok == false {
+				continue
+			} else if seqno != refseqno {
+				t.Errorf("%q expected %v, got %v", key, refseqno, seqno)
+			} else if del != refdel {
+				t.Errorf("%q expected %v, got %v", key, refdel, del)
+			} else if del == false && bytes.Compare(val, refval) != 0 {
+				t.Errorf("%q expected %q, got %q", key, refval, val)
+			}
+		}
+	}
+	info := snap.Info()
+	hits, misses := info.Int64("n_hits"), info.Int64("n_misses")
+	t.Logf("hits: %v, misses: %v", hits, misses)
+	if misses == 0 || hits <= misses {
+		t.Errorf("unexpected hits %v, misses %v", hits, misses)
+	}
+
+	// range scans read z-blocks via the cache.
+	for i := 0; i < 100; i++ {
+		low := []byte(fmt.Sprintf("key%015d", rand.Intn(n*2)))
+		reverse := rand.Intn(2) == 1
+		refiter := mi.Range(low, nil, "both", reverse)
+		iter := snap.Range(low, nil, "both", reverse)
+		refkey, refval, _, refdel, referr := refiter(false /*fin*/)
+		key, val, _, del, err := iter(false /*fin*/)
+		for referr == nil && err == nil {
+			if bytes.Compare(key, refkey) != 0 {
+				t.Fatalf("expected %q, got %q", refkey, key)
+			} else if del != refdel {
+				t.Fatalf("%q expected %v, got %v", key, refdel, del)
+			} else if refdel == false && bytes.Compare(val, refval) != 0 {
+				t.Fatalf("%q expected %q, got %q", key, refval, val)
+			}
+			refkey, refval, _, refdel, referr = refiter(false /*fin*/)
+			key, val, _, del, err = iter(false /*fin*/)
+		}
+		if referr != io.EOF || err != io.EOF {
+			t.Fatalf("%q %v: expected %v, got %v", low, reverse, referr, err)
+		}
+		refiter(true /*fin*/)
+		iter(true /*fin*/)
+	}
+	if x := cache.Info().Int64("n_blocks"); x == 0 {
+		t.Errorf("expected cached blocks")
+	}
+	snap.Log()
+}
@@ -0,0 +1,152 @@
+package bubt
+
+import "fmt"
+import "io"
+import "encoding/binary"
+
+import "github.com/bnclabs/gostore/lib"
+
+// bloomMagic marks the tail of bloom section in m-index file, older
+// snapshots end with metadata whose length is a multiple of mblocksize
+// and can never be same as bloomMagic.
+const bloomMagic = uint64(0xB100B100B100B1FF)
+
+// bloom filter over all keys in a snapshot, including deleted keys,
+// used to rule out point lookups without reading m-blocks and z-blocks.
+type bloom struct {
+	nhash uint64
+	nbits uint64
+	bits  []byte
+}
+
+func newbloom(hashes []uint64, bitsperkey int) *bloom {
+	nbits := uint64(len(hashes) * bitsperkey)
+	if nbits < 64 {
+		nbits = 64
+	}
+	nbits = ((nbits + 7) / 8) * 8
+	// optimal number of hash functions is bitsperkey * ln(2).
+	nhash := uint64(float64(bitsperkey) * 0.69)
+	if nhash < 1 {
+		nhash = 1
+	} else if nhash > 30 {
+		nhash = 30
+	}
+	bf := &bloom{nhash: nhash, nbits: nbits, bits: make([]byte, nbits/8)}
+	for _, hash := range hashes {
+		bf.add(hash)
+	}
+	return bf
+}
+
+func (bf *bloom) add(hash uint64) {
+	delta := (hash >> 33) | (hash << 31)
+	for i := uint64(0); i < bf.nhash; i++ {
+		bit := hash % bf.nbits
+		bf.bits[bit/8] |= 1 << (bit % 8)
+		hash += delta
+	}
+}
+
+// mayhave return false if key is definitely not in the snapshot.
+func (bf *bloom) mayhave(key []byte) bool {
+	hash := bloomhash(key)
+	delta := (hash >> 33) | (hash << 31)
+	for i := uint64(0); i < bf.nhash; i++ {
+		bit := hash % bf.nbits
+		if bf.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+		hash += delta
+	}
+	return true
+}
+
+// encode bloom filter as a section of blocksize multiple.
+//
+//   nhash  uint64
+//   nbits  uint64
+//   bits   [nbits/8]byte
+//   padding
+//   length uint64 - length of the section
+//   magic  uint64 - bloomMagic
+func (bf *bloom) encode(blocksize int64) []byte {
+	ln := int64(8 + 8 + len(bf.bits) + 8 + 8)
+	ln = (((ln - 1) / blocksize) + 1) * blocksize
+	block := make([]byte, ln)
+	binary.BigEndian.PutUint64(block, bf.nhash)
+	binary.BigEndian.PutUint64(block[8:], bf.nbits)
+	copy(block[16:], bf.bits)
+	binary.BigEndian.PutUint64(block[ln-16:], uint64(ln))
+	binary.BigEndian.PutUint64(block[ln-8:], bloomMagic)
+	return block
+}
+
+// readbloomlen return the length of bloom section in m-index file,
+// return 0 if snapshot was built without bloom filter.
+func readbloomlen(r io.ReaderAt) (int64, error) {
+	tomblen, err := readrangetomblen(r)
+	if err != nil {
+		return 0, err
+	}
+	fsize := filesize(r)
+	fpos := fsize - MarkerBlocksize - tomblen // skip markerblock, tombstones
+	if fpos -= 16; fpos < 0 {
+		return 0, fmt.Errorf("bubt.snap.nobloomtail")
+	}
+
+	var scratch [16]byte
+	n, err := r.ReadAt(scratch[:], fpos)
+	if err != nil {
+		return 0, err
+	} else if n < len(scratch) {
+		return 0, fmt.Errorf("bubt.snap.partialbloomtail")
+	} else if binary.BigEndian.Uint64(scratch[8:]) != bloomMagic {
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint64(scratch[:])), nil
+}
+
+// readbloom from m-index file, return the bloom filter and the length
+// of its section. If snapshot was built without bloom filter return nil.
+func readbloom(r io.ReaderAt) (*bloom, int64, error) {
+	ln, err := readbloomlen(r)
+	if err != nil || ln == 0 {
+		return nil, 0, err
+	}
+	tomblen, err := readrangetomblen(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	fpos := filesize(r) - MarkerBlocksize - tomblen - ln
+	if fpos < 0 {
+		return nil, 0, fmt.Errorf("bubt.snap.nobloom")
+	}
+
+	block := lib.Fixbuffer(nil, ln)
+	n, err := r.ReadAt(block, fpos)
+	if err != nil {
+		return nil, 0, err
+	} else if n < len(block) {
+		return nil, 0, fmt.Errorf("bubt.snap.partialbloom")
+	}
+	bf := &bloom{
+		nhash: binary.BigEndian.Uint64(block),
+		nbits: binary.BigEndian.Uint64(block[8:]),
+	}
+	if bf.nhash == 0 || bf.nbits == 0 || int64(bf.nbits/8) > ln-32 {
+		return nil, 0, fmt.Errorf("bubt.snap.invalidbloom")
+	}
+	bf.bits = block[16 : 16+(bf.nbits/8)]
+	return bf, ln, nil
+}
+
+// bloomhash is 64-bit FNV-1a hash of key.
+func bloomhash(key []byte) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, c := range key {
+		hash ^= uint64(c)
+		hash *= 1099511628211
+	}
+	return hash
+}
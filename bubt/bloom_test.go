@@ -0,0 +1,33 @@
+package bubt
+
+import "fmt"
+import "testing"
+
+func TestBloomFilter(t *testing.T) {
+	n, hashes := 100000, []uint64{}
+	for i := 0; i < n; i++ {
+		hashes = append(hashes, bloomhash([]byte(fmt.Sprintf("key%v", i))))
+	}
+	bf := newbloom(hashes, 10)
+	for i := 0; i < n; i++ {
+		if key := []byte(fmt.Sprintf("key%v", i)); !bf.mayhave(key) {
+			t.Fatalf("expected %q in filter", key)
+		}
+	}
+	falsepositives := 0
+	for i := n; i < 2*n; i++ {
+		if bf.mayhave([]byte(fmt.Sprintf("key%v", i))) {
+			falsepositives++
+		}
+	}
+	rate := float64(falsepositives) / float64(n)
+	t.Logf("false positive rate %.4f", rate)
+	if rate > 0.02 {
+		t.Errorf("false positive rate %v exceeds %v", rate, 0.02)
+	}
+
+	// empty filter rules out every key.
+	if newbloom(nil, 10).mayhave([]byte("key")) {
+		t.Errorf("unexpected key in empty filter")
+	}
+}
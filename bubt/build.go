@@ -33,7 +33,17 @@ type Bubt struct {
 	vfiles     []string
 	vmode      string
 	appendid   string
+	vrewrite   map[int64]bool // value log shards to rewrite.
 	mdok       bool
+	zcodec     string
+	vcodec     string
+	comparator string
+	bloombits  int
+	restart    int
+	hashes     []uint64 // bloom hash of keys, while building.
+	filter     *bloom
+	tombs      api.Rangetombs
+	tombspurge bool
 
 	// settings, will be flushed to the tip of indexfile.
 	mblocksize int64
@@ -63,6 +73,9 @@ func NewBubt(
 		vblocksize: vblocksize,
 		tombpurge:  false,
 		mdok:       false,
+		zcodec:     CodecNone,
+		vcodec:     CodecNone,
+		comparator: api.Binarycomparator,
 	}
 	mpath, zpaths := tree.pickmzpath(paths)
 	tree.logprefix = fmt.Sprintf("BUBT [%s]", name)
@@ -97,6 +110,64 @@ func (tree *Bubt) TombstonePurge(what bool) {
 	tree.tombpurge = what
 }
 
+// Compression to compress each z-block using zcodec and each value in
+// value log using vcodec. Codec can be "none" or "snappy". Values are
+// compressed individually, and stored compressed only if that saves
+// space. Value log is used only when vblocksize is > 0.
+func (tree *Bubt) Compression(zcodec, vcodec string) {
+	if err := validatecodec(zcodec); err != nil {
+		panic(err)
+	} else if err := validatecodec(vcodec); err != nil {
+		panic(err)
+	}
+	tree.zcodec, tree.vcodec = zcodec, vcodec
+}
+
+// Comparator to remember the name of the comparator, registered via
+// api.Registercomparator, used to sort the entries that are built
+// into the snapshot. Snapshot shall use the same comparator for
+// lookups, and refuse to open if the comparator is not registered.
+func (tree *Bubt) Comparator(name string) {
+	if _, err := api.Getcomparator(name); err != nil {
+		panic(err)
+	}
+	tree.comparator = name
+}
+
+// Bloom to build a bloom filter with bitsperkey for all the keys in
+// the snapshot, point lookups for missing keys can then be answered
+// without reading the disk. Bloom filter is persisted after metadata
+// when the builder is closed. If bitsperkey is 0, bloom filter is not
+// built.
+func (tree *Bubt) Bloom(bitsperkey int) {
+	if bitsperkey < 0 {
+		panic(fmt.Errorf("bubt.invalidbloom %v", bitsperkey))
+	}
+	tree.bloombits = bitsperkey
+}
+
+// Restartinterval to prefix compress keys in z-blocks and m-blocks,
+// every interval-th key is stored in full as a restart point and rest
+// of the keys only store the suffix that differ from the previous key.
+// Lengths are uvarint encoded in prefix compressed blocks. Lookups
+// binary search the restart points and then scan linearly. If interval
+// is 0, keys are stored in full, which is the default.
+func (tree *Bubt) Restartinterval(interval int) {
+	if interval < 0 {
+		panic(fmt.Errorf("bubt.invalidrestartinterval %v", interval))
+	}
+	tree.restart = interval
+}
+
+// Rangetombs to persist range tombstones along with the snapshot,
+// entries covered by tombs are dropped while building the snapshot.
+// Range tombstones are persisted after bloom filter when the builder is
+// closed. If purge is true, or if TombstonePurge is enabled, tombs are
+// not persisted, typically when building the oldest level of an LSM.
+func (tree *Bubt) Rangetombs(tombs api.Rangetombs, purge bool) {
+	tree.tombs, tree.tombspurge = api.Mergerangetombs(tombs), purge
+}
+
 // AppendValuelogs builder should use `valuelogs` files instead of
 // creating a new set of value-logs corresponding to each z-index
 // files, vblocksize should be same as used while creating `valuelogs`.
@@ -130,6 +201,34 @@ func (tree *Bubt) AppendValuelogs(vblocksize int64, appendid string, valuelogs [
 	tree.vmode = "appendlink"
 }
 
+// Rewritevaluelogs to rewrite `valuelogs`, a subset of value logs
+// supplied to AppendValuelogs, instead of appending to them. Values
+// in appendid index that refer to these value logs are copied into
+// new value logs, leaving behind values that are no more referred.
+// Typically used when only a small fraction of a value log is live,
+// refer Snapshot.Valuelogratios. Old value logs are left untouched,
+// and shall be reclaimed when snapshots referring to them are
+// destroyed.
+func (tree *Bubt) Rewritevaluelogs(valuelogs []string) {
+	if tree.vmode != "appendlink" {
+		panic(fmt.Errorf("rewrite value logs only after AppendValuelogs"))
+	}
+	tree.vrewrite = make(map[int64]bool)
+	for _, file := range valuelogs {
+		vshard := -1
+		for idx, vlink := range tree.vlinks {
+			if vlink == file {
+				vshard = idx
+			}
+		}
+		if vshard < 0 {
+			panic(fmt.Errorf("value log %q is not appended", file))
+		}
+		tree.vlinks[vshard] = ""
+		tree.vrewrite[int64(vshard+1)] = true
+	}
+}
+
 func (tree *Bubt) makezflushers(zpaths []string) []*bubtflusher {
 	zflushers := make([]*bubtflusher, 0)
 	for idx, zpath := range zpaths {
@@ -151,8 +250,11 @@ func (tree *Bubt) makevflushers(vfiles []string) ([]*bubtflusher, uint64) {
 	}
 	vflushers, n_ablocks := make([]*bubtflusher, 0), int64(0)
 	for idx, vfile := range vfiles {
-		vlink, vsize := tree.vlinks[idx], tree.vblocksize
-		vflusher, err := startflusher(idx+1, vsize, vlink, vfile, tree.vmode)
+		vlink, vsize, vmode := tree.vlinks[idx], tree.vblocksize, tree.vmode
+		if vlink == "" { // value log to be rewritten.
+			vmode = "create"
+		}
+		vflusher, err := startflusher(idx+1, vsize, vlink, vfile, vmode)
 		if err != nil {
 			panic(err)
 		}
@@ -170,7 +272,9 @@ func (tree *Bubt) makevflushers(vfiles []string) ([]*bubtflusher, uint64) {
 }
 
 // Build starts building the tree from iterator, iterator is expected
-// to be a full-table scan over another data-store.
+// to be a full-table scan over another data-store. Merge operands are
+// not allowed, they shall be resolved into full values, refer
+// lsm.YMergeEntries.
 func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 	debugf("%v starting bottoms up build ...\n", tree.logprefix)
 
@@ -178,19 +282,47 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 
 	tree.vflushers, n_ablocks = tree.makevflushers(tree.vfiles)
 
+	cmp, err := api.Getcomparator(tree.comparator)
+	if err != nil {
+		return err
+	}
+
 	start := time.Now()
-	maxseqno, keymem, valmem := uint64(0), uint64(0), uint64(0)
+	// account seqno of range tombstones, even if they are purged.
+	maxseqno, keymem, valmem := tree.tombs.Maxseqno(), uint64(0), uint64(0)
 	n_count, n_deleted, paddingmem := int64(0), int64(0), int64(0)
 	n_zblocks, n_mblocks, n_vblocks := int64(0), uint64(0), n_ablocks
+	zmem := int64(0)
 	compiter := func(
-		fin bool) (key, val []byte,
-		valuelen uint64, vlogpos int64, seqno uint64, del bool, e error) {
+		fin bool) (key, val []byte, valuelen uint64, vlogpos int64,
+		seqno, expiry uint64, del bool, e error) {
 
 		entry := itere(fin)
 		key, seqno, del, e = entry.Key()
-		if len(tree.appendid) > 0 && entry.ID() == tree.appendid {
+		for e == nil { // drop entries covered by range tombstones.
+			if _, ok := tree.tombs.Covers(key, seqno, cmp); !ok {
+				break
+			} else if maxseqno < seqno {
+				maxseqno = seqno
+			}
+			entry = itere(fin)
+			key, seqno, del, e = entry.Key()
+		}
+		if e == nil && entry.Ismerge() { // operands shall be resolved.
+			e = fmt.Errorf("bubt.unresolvedoperand %q", key)
+		}
+		if expiry = entry.Expiry(); del == false && api.Isexpired(expiry) {
+			del = true // expired entries are written, or purged, as deleted.
+		}
+		if del {
+			val, valuelen, vlogpos, expiry = nil, 0, -1, 0
+		} else if len(tree.appendid) > 0 && entry.ID() == tree.appendid {
 			val = nil
 			valuelen, vlogpos = entry.Valueref()
+			if vlogpos >= 0 && tree.vrewrite[vlogpos>>56] { // copy value.
+				val = entry.Value()
+				valuelen, vlogpos = uint64(len(val)), -1
+			}
 		} else {
 			val = entry.Value()
 			valuelen, vlogpos = uint64(len(val)), -1
@@ -203,10 +335,13 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 			}
 			if tree.tombpurge && del { // skip accounting for deleted entries
 				// wish there is tail-recursion !!
-				return key, val, valuelen, vlogpos, seqno, del, e
+				return key, val, valuelen, vlogpos, seqno, expiry, del, e
 			}
 			// account everything else for non-deleted entries.
 			keymem = keymem + uint64(len(key))
+			if tree.bloombits > 0 {
+				tree.hashes = append(tree.hashes, bloomhash(key))
+			}
 			if del {
 				n_deleted++
 			} else {
@@ -214,11 +349,16 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 			}
 			n_count++
 		}
-		return key, val, valuelen, vlogpos, seqno, del, e
+		return key, val, valuelen, vlogpos, seqno, expiry, del, e
 	}
 
+	// live bytes in each value log, referred by this snapshot.
+	vlivemem := make([]int64, len(tree.vflushers))
+
 	scratchvlog := make([]byte, tree.vblocksize)
 	z := newz(tree.zblocksize, tree.vblocksize)
+	z.vcodec, z.restart, z.vlive = tree.vcodec, tree.restart, vlivemem
+	var scratchz []byte
 
 	shardidx := 0
 	pickzflusher := func() (zflusher, vflusher *bubtflusher) {
@@ -244,9 +384,15 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 				n_zblocks++
 			}
 
-			if err := zflusher.writedata(z.block); err != nil {
+			block := z.block
+			if tree.zcodec != CodecNone {
+				scratchz = compressblock(tree.zcodec, scratchz, z.block)
+				block = scratchz
+			}
+			if err := zflusher.writedata(block); err != nil {
 				panic(err)
 			}
+			zmem += int64(len(block))
 			vpos := int64(zflusher.idx<<56) | fpos
 			//fmt.Printf("flushzblock %s %x\n", z.firstkey, vpos)
 			return z.vlog, vpos
@@ -311,7 +457,7 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 	var key, value []byte
 	var valuelen uint64
 	var vlogpos int64
-	var seqno uint64
+	var seqno, expiry uint64
 	var deleted bool
 
 	buildz := func() {
@@ -321,20 +467,25 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 
 		ok := true
 		if (tree.tombpurge && deleted == false) || tree.tombpurge == false {
-			ok = z.insert(key, value, valuelen, vlogpos, seqno, deleted)
+			ok = z.insert(
+				key, value, valuelen, vlogpos, seqno, expiry, deleted,
+			)
 			if ok == false {
 				panic("first insert to zblock, check whether key > zblocksize")
 			}
 		}
 		for ok {
-			key, value, valuelen, vlogpos, seqno, deleted, err = compiter(false)
+			key, value, valuelen, vlogpos, seqno, expiry, deleted, err =
+				compiter(false)
 			if err == io.EOF {
 				break
 			} else if err != nil {
 				panic(err)
 			}
 			if (tree.tombpurge && deleted == false) || tree.tombpurge == false {
-				ok = z.insert(key, value, valuelen, vlogpos, seqno, deleted)
+				ok = z.insert(
+					key, value, valuelen, vlogpos, seqno, expiry, deleted,
+				)
 			}
 		}
 		return
@@ -415,7 +566,8 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 	// start building the tree, with maximum fill possible rate.
 	var root int64
 	if itere != nil {
-		key, value, valuelen, vlogpos, seqno, deleted, err = compiter(false)
+		key, value, valuelen, vlogpos, seqno, expiry, deleted, err =
+			compiter(false)
 		if err != nil && err.Error() != io.EOF.Error() {
 			panic(err)
 
@@ -451,6 +603,16 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 		vflusher.vlog = vflusher.vlog[:0]
 	}
 
+	if tree.bloombits > 0 {
+		tree.filter = newbloom(tree.hashes, tree.bloombits)
+		tree.hashes = nil
+	}
+
+	vlives := make([]string, 0, len(vlivemem))
+	for _, vlive := range vlivemem {
+		vlives = append(vlives, fmt.Sprintf("%d", vlive))
+	}
+
 	// flush 1 MarkerBlocksize of infoblock
 	block := make([]byte, MarkerBlocksize)
 	infoblock := s.Settings{
@@ -459,18 +621,26 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 		"zblocksize": tree.zblocksize,
 		"mblocksize": tree.mblocksize,
 		"vblocksize": tree.vblocksize,
+		"zcodec":     tree.zcodec,
+		"vcodec":     tree.vcodec,
+		"comparator": tree.comparator,
+		"checksum":   ChecksumCRC32C,
+		"bloombits":  tree.bloombits,
+		"restart":    tree.restart,
 		"buildtime":  fmt.Sprintf("%d", time.Since(start)),
 		"epoch":      fmt.Sprintf("%d", time.Now().Unix()),
 		"seqno":      fmt.Sprintf("%d", maxseqno),
 		"keymem":     fmt.Sprintf("%d", keymem),
 		"valmem":     fmt.Sprintf("%d", valmem),
 		"paddingmem": fmt.Sprintf("%d", paddingmem),
+		"zmem":       fmt.Sprintf("%d", zmem),
 		"n_zblocks":  fmt.Sprintf("%d", n_zblocks),
 		"n_mblocks":  fmt.Sprintf("%d", n_mblocks),
 		"n_vblocks":  fmt.Sprintf("%d", n_vblocks),
 		"n_ablocks":  fmt.Sprintf("%d", n_ablocks),
 		"n_count":    fmt.Sprintf("%d", n_count),
 		"n_deleted":  fmt.Sprintf("%d", n_deleted),
+		"vlivemem":   vlives,
 	}
 	data, _ := json.Marshal(infoblock)
 	if x, y := len(data)+8, len(block); x > y {
@@ -499,11 +669,7 @@ func (tree *Bubt) Build(itere api.EntryIterator, metadata []byte) (err error) {
 }
 
 func (tree *Bubt) Writemetadata(metadata []byte) (int, error) {
-	ln := (((int64(len(metadata)+15) / tree.mblocksize) + 1) * tree.mblocksize)
-	block := make([]byte, ln)
-	binary.BigEndian.PutUint64(block, uint64(len(metadata)))
-	copy(block[8:], metadata)
-	binary.BigEndian.PutUint64(block[ln-8:], uint64(ln))
+	block := encodemetadata(metadata, tree.mblocksize)
 	if err := tree.mflusher.writedata(block); err != nil {
 		panic(err)
 	}
@@ -520,6 +686,27 @@ func (tree *Bubt) Close() {
 		tree.Writemetadata(metadataMarker)
 		tree.mdok = true
 	}
+	// bloom filter is flushed after metadata.
+	if tree.filter != nil {
+		block := tree.filter.encode(tree.mblocksize)
+		if err := tree.mflusher.writedata(block); err != nil {
+			panic(err)
+		}
+		fmsg := "%v wrote %v bytes bloom filter"
+		infof(fmsg, tree.logprefix, len(block))
+		tree.filter = nil
+	}
+	// range tombstones are flushed last, just before the marker block.
+	purge := tree.tombpurge || tree.tombspurge
+	if len(tree.tombs) > 0 && purge == false {
+		block := encoderangetombs(tree.tombs, tree.mblocksize)
+		if err := tree.mflusher.writedata(block); err != nil {
+			panic(err)
+		}
+		fmsg := "%v wrote %v bytes for %v range tombstones"
+		infof(fmsg, tree.logprefix, len(block), len(tree.tombs))
+	}
+	tree.tombs = nil
 
 	if tree.mflusher != nil {
 		tree.mflusher.close()
@@ -532,6 +719,17 @@ func (tree *Bubt) Close() {
 	}
 }
 
+// encode metadata into one or more m-blocks, suffixed with the length
+// of the encoded section.
+func encodemetadata(metadata []byte, mblocksize int64) []byte {
+	ln := (((int64(len(metadata)+15) / mblocksize) + 1) * mblocksize)
+	block := make([]byte, ln)
+	binary.BigEndian.PutUint64(block, uint64(len(metadata)))
+	copy(block[8:], metadata)
+	binary.BigEndian.PutUint64(block[ln-8:], uint64(ln))
+	return block
+}
+
 func (tree *Bubt) pickmzpath(paths []string) (string, []string) {
 	// TODO: Intelligently pick mpath.
 	mpath, zpaths := paths[0], []string{}
@@ -9,6 +9,7 @@ import "testing"
 import "math/rand"
 import "path/filepath"
 
+import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/llrb"
 import s "github.com/bnclabs/gosettings"
 
@@ -1049,6 +1050,221 @@ func TestAppendValuelogs(t *testing.T) {
 	diter(true /*fin*/)
 }
 
+func TestValuelogGC(t *testing.T) {
+	n := 20000
+	mi, _ := makeLLRBEven(n)
+	defer mi.Destroy()
+
+	paths := makepaths123(-1)
+	msize, zsize, vsize := int64(4096), int64(4096), int64(4096)
+	vcodec := []string{CodecNone, CodecSnappy}[rand.Intn(100000)%2]
+	t.Logf("paths: %v, vcodec: %v", len(paths), vcodec)
+
+	build := func(
+		name string, itere api.EntryIterator, snap *Snapshot, rewrite bool) {
+
+		bt, err := NewBubt(name, paths, msize, zsize, vsize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bt.Compression(CodecNone, vcodec)
+		if snap != nil {
+			bt.AppendValuelogs(vsize, snap.ID(), snap.Valuelogs())
+			if rewrite {
+				bt.Rewritevaluelogs(snap.Valuelogs())
+			}
+		}
+		if err := bt.Build(itere, []byte("this is metadata")); err != nil {
+			t.Fatal(err)
+		}
+		bt.Close()
+	}
+	open := func(name string) *Snapshot {
+		snap, err := OpenSnapshot(name, paths, rand.Intn(2) == 1 /*mmap*/)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snap
+	}
+
+	itere := mi.ScanEntries()
+	build("testvloggc1", itere, nil, false)
+	itere(true /*fin*/)
+	snap1 := open("testvloggc1")
+	defer snap1.Destroy()
+	defer snap1.Close()
+	for i, ratio := range snap1.Valuelogratios() {
+		if ratio < 0.8 {
+			t.Errorf("%v unexpected ratio %v", i, ratio)
+		}
+	}
+
+	// drop every other entry, values are left behind in value log.
+	count, diter := 0, snap1.ScanEntries()
+	build("testvloggc2", func(fin bool) api.IndexEntry {
+		entry := diter(fin)
+		for count++; count%2 == 0; count++ {
+			entry = diter(fin)
+		}
+		return entry
+	}, snap1, false)
+	diter(true /*fin*/)
+	snap2 := open("testvloggc2")
+	defer snap2.Destroy()
+	defer snap2.Close()
+	for i, ratio := range snap2.Valuelogratios() {
+		if ratio > 0.6 {
+			t.Errorf("%v unexpected ratio %v", i, ratio)
+		}
+	}
+	info2 := snap2.Info()
+	if x := info2.Int64("vreclaim"); x <= 0 {
+		t.Errorf("unexpected vreclaim %v", x)
+	}
+
+	// rewrite value logs, only the live values are copied.
+	diter = snap2.ScanEntries()
+	build("testvloggc3", diter, snap2, true)
+	diter(true /*fin*/)
+	snap3 := open("testvloggc3")
+	defer snap3.Destroy()
+	defer snap3.Close()
+	for i, ratio := range snap3.Valuelogratios() {
+		if ratio < 0.8 {
+			t.Errorf("%v unexpected ratio %v", i, ratio)
+		}
+	}
+	info3 := snap3.Info()
+	if x, y := info3.Int64("vreclaim"), info2.Int64("vreclaim"); x >= y {
+		t.Errorf("expected vreclaim %v < %v", x, y)
+	} else if x, y := info3.Int64("vlivemem"), info2.Int64("vlivemem"); x > y {
+		t.Errorf("expected vlivemem %v <= %v", x, y)
+	}
+	t.Logf("vreclaim %v -> %v", info2.Int64("vreclaim"), info3.Int64("vreclaim"))
+
+	refiter, iter := snap2.Scan(), snap3.Scan()
+	refkey, refval, refseqno, refdel, referr := refiter(false /*fin*/)
+	key, val, seqno, del, err := iter(false /*fin*/)
+	for referr == nil && err == nil {
+		if bytes.Compare(key, refkey) != 0 {
+			t.Fatalf("expected %q, got %q", refkey, key)
+		} else if seqno != refseqno {
+			t.Fatalf("%q expected %v, got %v", key, refseqno, seqno)
+		} else if del != refdel {
+			t.Fatalf("%q expected %v, got %v", key, refdel, del)
+		} else if del == false && bytes.Compare(val, refval) != 0 {
+			t.Fatalf("%q expected %q, got %q", key, refval, val)
+		}
+		refkey, refval, refseqno, refdel, referr = refiter(false /*fin*/)
+		key, val, seqno, del, err = iter(false /*fin*/)
+	}
+	if referr != io.EOF || err != io.EOF {
+		t.Errorf("expected %v, got %v", referr, err)
+	}
+	refiter(true /*fin*/)
+	iter(true /*fin*/)
+	snap3.Validate()
+}
+
+func TestCompression(t *testing.T) {
+	n := 20000
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	mi := llrb.NewLLRB("buildllrb", setts)
+	defer mi.Destroy()
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i*2))
+		fmsg := `{"id": %v, "name": "user%v", "tags": ["alpha", "beta"]}`
+		mi.Set(key, []byte(fmt.Sprintf(fmsg, i, i)), nil)
+		if i%10 == 0 {
+			mi.Delete(key, nil, true /*lsm*/)
+		}
+	}
+
+	paths := makepaths123(-1)
+	rand.Seed(time.Now().UnixNano())
+	name, msize := "testbuild", int64(4096)
+	zsize := []int64{msize, msize * 2}[rand.Intn(100000)%2]
+	vsize := []int64{0, zsize}[rand.Intn(100000)%2]
+	mmap := []bool{false, true}[rand.Intn(10000)%2]
+	fmsg := "paths: %v, zsize: %v, vsize: %v, mmap: %v"
+	t.Logf(fmsg, len(paths), zsize, vsize, mmap)
+	bubt, err := NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bubt.Compression(CodecSnappy, CodecSnappy)
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	info := snap.Info()
+	if x := info.String("zcodec"); x != CodecSnappy {
+		t.Errorf("expected %v, got %v", CodecSnappy, x)
+	} else if x := info.String("vcodec"); x != CodecSnappy {
+		t.Errorf("expected %v, got %v", CodecSnappy, x)
+	}
+	zmem, n_zblocks := info.Int64("zmem"), info.Int64("n_zblocks")
+	t.Logf("zmem: %v, n_zblocks: %v", zmem, n_zblocks)
+	if zmem >= (n_zblocks*zsize)/2 {
+		t.Errorf("expected compression, %v >= %v", zmem, n_zblocks*zsize/2)
+	}
+	snap.Validate()
+
+	// point lookups.
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%015d", rand.Intn(n*2)))
+		refval, refseqno, refdel, refok := mi.Get(key, []byte{})
+		val, seqno, del, ok := snap.Get(key, []byte{})
+		if ok != refok {
+			t.Fatalf("%q expected %v, got %v", key, refok, ok)
+		} else if ok == false {
+			continue
+		} else if seqno != refseqno {
+			t.Errorf("%q expected %v, got %v", key, refseqno, seqno)
+		} else if del != refdel {
+			t.Errorf("%q expected %v, got %v", key, refdel, del)
+		} else if del == false && bytes.Compare(val, refval) != 0 {
+			t.Errorf("%q expected %q, got %q", key, refval, val)
+		}
+	}
+
+	// range scans, cursors are positioned from m-index.
+	for i := 0; i < 100; i++ {
+		low := []byte(fmt.Sprintf("key%015d", rand.Intn(n*2)))
+		reverse := rand.Intn(2) == 1
+		refiter := mi.Range(low, nil, "both", reverse)
+		iter := snap.Range(low, nil, "both", reverse)
+		refkey, refval, _, refdel, referr := refiter(false /*fin*/)
+		key, val, _, del, err := iter(false /*fin*/)
+		for referr == nil && err == nil {
+			if bytes.Compare(key, refkey) != 0 {
+				t.Fatalf("expected %q, got %q", refkey, key)
+			} else if del != refdel {
+				t.Fatalf("%q expected %v, got %v", key, refdel, del)
+			} else if refdel == false && bytes.Compare(val, refval) != 0 {
+				t.Fatalf("%q expected %q, got %q", key, refval, val)
+			}
+			refkey, refval, _, refdel, referr = refiter(false /*fin*/)
+			key, val, _, del, err = iter(false /*fin*/)
+		}
+		if referr != io.EOF || err != io.EOF {
+			t.Fatalf("%q %v: expected %v, got %v", low, reverse, referr, err)
+		}
+		refiter(true /*fin*/)
+		iter(true /*fin*/)
+	}
+}
+
 func TestView(t *testing.T) {
 	n := 1000000
 	paths := makepaths123(-1)
@@ -12,6 +12,9 @@ type mblock struct {
 	entries   []byte // points into buffer
 	block     []byte // points into buffer
 	next      *mblock
+	restart   int // restart interval, if keys are prefix compressed.
+	count     int
+	lastkey   []byte
 }
 
 func putm(tree *Bubt, m *mblock) {
@@ -27,6 +30,9 @@ func putm(tree *Bubt, m *mblock) {
 // n_entries uint32   - 4-byte count of number entries in this mblock.
 // blkindex  []uint32 - 4 byte offset into mblock for each entry.
 // mentries           - array of mentries.
+// crc32c    uint32   - 4-byte checksum, at the end of the block.
+//
+// If keys are prefix compressed, refer pblock for the shape of block.
 func newm(tree *Bubt, blocksize int64) (m *mblock) {
 	if tree == nil || tree.headmblock == nil {
 		m = &mblock{
@@ -49,11 +55,17 @@ func newm(tree *Bubt, blocksize int64) (m *mblock) {
 	}
 	m.blocksize = blocksize
 	m.entries = m.buffer[blocksize:blocksize]
+	m.restart, m.count, m.lastkey = 0, 0, m.lastkey[:0]
+	if tree != nil {
+		m.restart = tree.restart
+	}
 	return m
 }
 
 func (m *mblock) insert(key []byte, vpos int64) (ok bool) {
-	if m.isoverflow(key) == false {
+	if m.restart > 0 {
+		return m.pinsert(key, vpos)
+	} else if m.isoverflow(key) == false {
 		return false
 	}
 
@@ -70,16 +82,55 @@ func (m *mblock) insert(key []byte, vpos int64) (ok bool) {
 	return true
 }
 
+// pinsert add entry with its key prefix compressed, refer pmentry.
+func (m *mblock) pinsert(key []byte, vpos int64) bool {
+	me := pmentry{vpos: uint64(vpos)}
+	restart := (m.count % m.restart) == 0
+	if restart == false {
+		me.shared = sharedprefix(m.lastkey, key)
+	}
+	me.unshared = len(key) - me.shared
+
+	var scratch [pmentrymaxsize]byte
+	hdr := me.encode(scratch[:0])
+	nrestarts := len(m.index)
+	if restart {
+		nrestarts++
+	}
+	entrysz := int64(len(hdr) + me.unshared)
+	total := int64(len(m.entries)) + entrysz + int64(8+(nrestarts*4))
+	if total+crcsize > m.blocksize {
+		return false
+	}
+
+	if restart {
+		m.index = append(m.index, uint32(len(m.entries)))
+	}
+	m.entries = append(m.entries, hdr...)
+	m.entries = append(m.entries, key[me.shared:]...)
+
+	m.count++
+	m.lastkey = append(m.lastkey[:0], key...)
+	m.setfirstkey(key)
+	return true
+}
+
 func (m *mblock) finalize() (int64, bool) {
 	if len(m.index) == 0 {
 		return 0, false
 	}
-	indexlen := m.index.footprint()
+	indexlen, n := m.index.footprint(), 4
+	if m.restart > 0 {
+		indexlen, n = indexlen+4, 8
+	}
 	block := m.buffer[m.blocksize-indexlen : int64(len(m.buffer))-indexlen]
-	// 4-byte length of index array.
-	binary.BigEndian.PutUint32(block, uint32(m.index.length()))
+	if m.restart > 0 { // entry count, restart interval and restarts.
+		binary.BigEndian.PutUint32(block, uint32(m.count)|blockPrefixed)
+		binary.BigEndian.PutUint32(block[4:], uint32(m.restart))
+	} else { // 4-byte length of index array.
+		binary.BigEndian.PutUint32(block, uint32(m.index.length()))
+	}
 	// each index entry is 4 byte, index point into m-block for zentry.
-	n := 4
 	for _, entryoff := range m.index {
 		binary.BigEndian.PutUint32(block[n:], uint32(indexlen)+entryoff)
 		n += 4
@@ -90,6 +141,7 @@ func (m *mblock) finalize() (int64, bool) {
 	for i := range block[n:] {
 		block[n+i] = 0
 	}
+	setblockcrc(block)
 	m.block = block
 	return int64(padded), true
 }
@@ -99,7 +151,7 @@ func (m *mblock) finalize() (int64, bool) {
 func (m *mblock) isoverflow(key []byte) bool {
 	entrysz := int64(len(key) + mentrysize)
 	total := int64(len(m.entries)) + entrysz + m.index.nextfootprint()
-	if total > m.blocksize {
+	if total+crcsize > m.blocksize {
 		return false
 	}
 	return true
@@ -1,6 +1,7 @@
 package bubt
 
 import "fmt"
+import "bytes"
 import "testing"
 
 func TestMBlock(t *testing.T) {
@@ -34,7 +35,7 @@ func TestMBlock(t *testing.T) {
 	index := ms.getindex(blkindex{})
 	j, k := 0, fmt.Sprintf("%16d", 0)
 	for j < i {
-		level, fpos := ms.findkey(0, index, []byte(k))
+		level, fpos := ms.findkey(0, index, []byte(k), bytes.Compare, nil)
 		if level != byte(j%4) {
 			t.Errorf("expected %v, got %v", j%4, level)
 		} else if fpos != int64(j) {
@@ -44,7 +45,8 @@ func TestMBlock(t *testing.T) {
 		k = fmt.Sprintf("%16d", j)
 	}
 
-	level, fpos := ms.findkey(0, index, []byte(fmt.Sprintf("%17d", 100)))
+	key := []byte(fmt.Sprintf("%17d", 100))
+	level, fpos := ms.findkey(0, index, key, bytes.Compare, nil)
 	if level != 2 {
 		t.Errorf("expected %v, got %v", 2, level)
 	} else if fpos != 10 {
@@ -52,6 +54,57 @@ func TestMBlock(t *testing.T) {
 	}
 }
 
+func TestMBlockPrefixed(t *testing.T) {
+	mblocksize := int64(4 * 1024)
+	keyat := func(i int) string {
+		return fmt.Sprintf("tenant/0000-1111/table/%16d", i*2)
+	}
+
+	m := newm(nil, mblocksize)
+	m.restart = 4
+	i := 0
+	k, vpos := keyat(i), (((i % 4) << 56) | i)
+	for m.insert([]byte(k), int64(vpos)) {
+		i++
+		k, vpos = keyat(i), (((i % 4) << 56) | i)
+	}
+	t.Logf("Inserted %v items", i)
+	if _, ok := m.finalize(); ok == false {
+		t.Errorf("unexpected false")
+	}
+
+	ms := msnap(m.block)
+	if x := ms.count(); x != i {
+		t.Errorf("expected %v, got %v", i, x)
+	}
+	index := ms.getindex(blkindex{})
+	for j := 0; j < i; j++ {
+		// exact key, and a key just after it, shall pick the same child.
+		for _, k := range []string{keyat(j), keyat(j) + "0"} {
+			level, fpos := ms.findkey(0, index, []byte(k), bytes.Compare, nil)
+			if level != byte(j%4) {
+				t.Errorf("expected %v, got %v", j%4, level)
+			} else if fpos != int64(j) {
+				t.Errorf("expected %v, got %v", j, fpos)
+			}
+		}
+		vpos, ok := ms.findlt(index, []byte(keyat(j)), bytes.Compare, nil)
+		if j == 0 && ok {
+			t.Errorf("unexpected %v", vpos)
+		} else if j > 0 && (ok == false || int(vpos&0xFFFFFF) != j-1) {
+			t.Errorf("expected %v, got %v %v", j-1, vpos, ok)
+		}
+		if x := ms.findgt(index, []byte(keyat(j)), bytes.Compare, nil); x != j+1 {
+			t.Errorf("expected %v, got %v", j+1, x)
+		}
+	}
+	// key less than all entries pick the left most child.
+	level, fpos := ms.findkey(0, index, []byte("a"), bytes.Compare, nil)
+	if level != 0 || fpos != 0 {
+		t.Errorf("unexpected %v %v", level, fpos)
+	}
+}
+
 func BenchmarkMInsert(b *testing.B) {
 	blocksize := int64(4096)
 	k, vpos := []byte("aaaaaaaaaaaaaaaaaaaaaaa"), int64(1023)
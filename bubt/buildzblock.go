@@ -14,10 +14,16 @@ type zblock struct {
 	index      blkindex
 	vlog       []byte // value buffer will be valid if vblocksize is > 0
 	vlogpos    int64
+	vcodec     string
 	buffer     []byte
+	restart    int // restart interval, if keys are prefix compressed.
+	count      int
+	lastkey    []byte
+	vlive      []int64 // live bytes in value log, for each shard.
 
 	// working buffer
 	zerovbuff []byte
+	vscratch  []byte // compressed value
 	entries   []byte // points into buffer
 	block     []byte // points into buffer
 }
@@ -28,12 +34,16 @@ type zblock struct {
 // n_entries uint32   - 4-byte count of number entries in this zblock.
 // blkindex  []uint32 - 4 byte offset into zblock for each entry.
 // zentries           - array of zentries.
+// crc32c    uint32   - 4-byte checksum, at the end of the block.
+//
+// If keys are prefix compressed, refer pblock for the shape of block.
 func newz(zblocksize, vblocksize int64) (z *zblock) {
 	z = &zblock{
 		zblocksize: zblocksize,
 		vblocksize: vblocksize,
 		firstkey:   make([]byte, 0, 256),
 		index:      make(blkindex, 0, 64),
+		vcodec:     CodecNone,
 		buffer:     make([]byte, zblocksize*2),
 	}
 	if z.vblocksize > 0 {
@@ -50,16 +60,19 @@ func (z *zblock) reset(vlogpos int64, vlog []byte) *zblock {
 	z.buffer = z.buffer[:z.zblocksize*2]
 	z.entries = z.entries[:0]
 	z.block = nil
+	z.count, z.lastkey = 0, z.lastkey[:0]
 	return z
 }
 
 func (z *zblock) insert(
 	key, value []byte, valuelen uint64, vlogpos int64,
-	seqno uint64, deleted bool) bool {
+	seqno, expiry uint64, deleted bool) bool {
 
 	//fmt.Println(len(key), len(value), z.zblocksize)
 	if key == nil {
 		return false
+	} else if z.restart > 0 {
+		return z.pinsert(key, value, valuelen, vlogpos, seqno, expiry, deleted)
 	} else if z.isoverflow(key, value, deleted) {
 		return false
 	}
@@ -68,7 +81,7 @@ func (z *zblock) insert(
 
 	var scratch [24]byte
 	ze := zentry(scratch[:])
-	ze = ze.setseqno(seqno).setkeylen(uint64(len(key)))
+	ze = ze.setseqno(seqno).setkeylen(uint64(len(key))).setexpiry(expiry)
 
 	if deleted {
 		ze.setdeleted().setvaluelen(0)
@@ -86,6 +99,7 @@ func (z *zblock) insert(
 		z.entries = append(z.entries, key...)
 		binary.BigEndian.PutUint64(scratch[:8], uint64(vlogpos))
 		z.entries = append(z.entries, scratch[:8]...)
+		z.addvlive(vlogpos, int64(valuelen))
 
 	} else {
 		var ok bool
@@ -93,11 +107,19 @@ func (z *zblock) insert(
 		var vlogpos int64
 
 		valuelen = uint64(len(value))
+		vlvalue, flags := value, uint64(0)
+		if z.vblocksize > 0 {
+			z.vscratch, ok = compressvalue(z.vcodec, z.vscratch, value)
+			if ok {
+				vlvalue, flags = z.vscratch, vlogCompressed
+			}
+		}
 		ok, vlogpos, z.vlogpos, z.vlog = vle.serialize(
-			z.vblocksize, z.vlogpos, value, z.vlog, z.zerovbuff,
+			z.vblocksize, z.vlogpos, flags, vlvalue, z.vlog, z.zerovbuff,
 		)
 		if ok { // value in vlog file
 			ze.setvlog()
+			z.addvlive(vlogpos, int64(len(vlvalue)))
 		}
 		ze.cleardeleted().setvaluelen(valuelen)
 		z.entries = append(z.entries, scratch[:]...)
@@ -115,16 +137,95 @@ func (z *zblock) insert(
 	return true
 }
 
+// pinsert add entry with its key prefix compressed, refer pzentry.
+func (z *zblock) pinsert(
+	key, value []byte, valuelen uint64, vlogpos int64,
+	seqno, expiry uint64, deleted bool) bool {
+
+	ze := pzentry{seqno: seqno, expiry: expiry}
+	restart := (z.count % z.restart) == 0
+	if restart == false {
+		ze.shared = sharedprefix(z.lastkey, key)
+	}
+	ze.unshared = len(key) - ze.shared
+
+	payload := 0
+	if deleted {
+		ze.flags = zflagDeleted
+	} else if len(value) == 0 && vlogpos >= 0 { // value-ref to value-log
+		ze.flags, ze.valuelen, payload = zflagVlog, valuelen, 8
+	} else if len(value) > 0 && z.vblocksize > 0 { // value in value-log
+		ze.flags, ze.valuelen, payload = zflagVlog, uint64(len(value)), 8
+	} else if len(value) > 0 { // value in z-block
+		ze.valuelen, payload = uint64(len(value)), len(value)
+	}
+
+	var hdrscratch [pzentrymaxsize]byte
+	hdr := ze.encode(hdrscratch[:0])
+	entrysz := int64(len(hdr) + ze.unshared + payload)
+	nrestarts := len(z.index)
+	if restart {
+		nrestarts++
+	}
+	total := int64(len(z.entries)) + entrysz + int64(8+(nrestarts*4))
+	if total+crcsize > z.zblocksize {
+		return false
+	}
+
+	if restart {
+		z.index = append(z.index, uint32(len(z.entries)))
+	}
+	z.entries = append(z.entries, hdr...)
+	z.entries = append(z.entries, key[ze.shared:]...)
+
+	var scratch [8]byte
+	if len(value) > 0 && ze.isvlog() {
+		var vle vlogentry
+
+		vlvalue, flags := value, uint64(0)
+		var ok bool
+		z.vscratch, ok = compressvalue(z.vcodec, z.vscratch, value)
+		if ok {
+			vlvalue, flags = z.vscratch, vlogCompressed
+		}
+		_, vlogpos, z.vlogpos, z.vlog = vle.serialize(
+			z.vblocksize, z.vlogpos, flags, vlvalue, z.vlog, z.zerovbuff,
+		)
+		binary.BigEndian.PutUint64(scratch[:], uint64(vlogpos))
+		z.entries = append(z.entries, scratch[:]...)
+		z.addvlive(vlogpos, int64(len(vlvalue)))
+
+	} else if ze.isvlog() {
+		binary.BigEndian.PutUint64(scratch[:], uint64(vlogpos))
+		z.entries = append(z.entries, scratch[:]...)
+		z.addvlive(vlogpos, int64(valuelen))
+
+	} else if payload > 0 {
+		z.entries = append(z.entries, value...)
+	}
+
+	z.count++
+	z.lastkey = append(z.lastkey[:0], key...)
+	z.setfirstkey(key)
+	return true
+}
+
 func (z *zblock) finalize() (int64, bool) {
 	if len(z.index) == 0 {
 		return 0, false
 	}
-	indexlen := z.index.footprint()
+	indexlen, n := z.index.footprint(), 4
+	if z.restart > 0 {
+		indexlen, n = indexlen+4, 8
+	}
 	block := z.buffer[z.zblocksize-indexlen : int64(len(z.buffer))-indexlen]
-	// 4-byte length of index array.
-	binary.BigEndian.PutUint32(block, uint32(z.index.length()))
+	if z.restart > 0 { // entry count, restart interval and restarts.
+		binary.BigEndian.PutUint32(block, uint32(z.count)|blockPrefixed)
+		binary.BigEndian.PutUint32(block[4:], uint32(z.restart))
+	} else { // 4-byte length of index array.
+		binary.BigEndian.PutUint32(block, uint32(z.index.length()))
+	}
 	// each index entry is 4 byte, index point into z-block for zentry.
-	n := 4
 	for _, entryoff := range z.index {
 		binary.BigEndian.PutUint32(block[n:], uint32(indexlen)+entryoff)
 		n += 4
@@ -135,12 +236,24 @@ func (z *zblock) finalize() (int64, bool) {
 	for i := range block[n:] {
 		block[n+i] = 0
 	}
+	setblockcrc(block)
 	z.block = block
 	return int64(padded), true
 }
 
 //---- local methods
 
+// addvlive account a value of length n, in value log entry at vlogpos,
+// as live. Values referred from appended value logs are accounted by
+// their actual length, which can be larger than the stored length if
+// value is compressed.
+func (z *zblock) addvlive(vlogpos, n int64) {
+	shardidx := int(uint64(vlogpos) >> 56)
+	if shardidx > 0 && shardidx <= len(z.vlive) {
+		z.vlive[shardidx-1] += vlogentrysize + crcsize + n
+	}
+}
+
 func (z *zblock) isoverflow(key, value []byte, deleted bool) bool {
 	entrysz := int64(zentrysize + len(key))
 	if deleted == false {
@@ -151,7 +264,7 @@ func (z *zblock) isoverflow(key, value []byte, deleted bool) bool {
 		}
 	}
 	total := int64(len(z.entries)) + entrysz + z.index.nextfootprint()
-	if total > z.zblocksize {
+	if total+crcsize > z.zblocksize {
 		return true
 	}
 	return false
@@ -2,6 +2,7 @@ package bubt
 
 import "fmt"
 import "reflect"
+import "bytes"
 import "testing"
 
 func TestZBlock1(t *testing.T) {
@@ -16,7 +17,7 @@ func TestZBlock1(t *testing.T) {
 		i := uint64(0)
 		k := fmt.Sprintf("%16d", i)
 		v, seqno, deleted := k, i, true
-		for z.insert([]byte(k), []byte(v), 0, -1, seqno, deleted) {
+		for z.insert([]byte(k), []byte(v), 0, -1, seqno, 0, deleted) {
 			//t.Logf("insert %s", k)
 			i++
 			k = fmt.Sprintf("%16d", i)
@@ -39,8 +40,9 @@ func TestZBlock1(t *testing.T) {
 		index := zs.getindex(blkindex{})
 		j, k := uint64(0), fmt.Sprintf("%16d", 0)
 		for j < i {
-			_, _, lv, seqno, deleted, ok := zs.findkey(0, index, []byte(k))
-			value, _ := lv.getactual(nil, nil)
+			_, _, lv, seqno, deleted, ok :=
+				zs.findkey(0, index, []byte(k), bytes.Compare, nil)
+			value, _, _ := lv.getactual(nil, nil)
 			if ok == false {
 				t.Errorf("unexpected false")
 			} else if deleted != ((j % 4) == 0) {
@@ -54,8 +56,9 @@ func TestZBlock1(t *testing.T) {
 			k = fmt.Sprintf("%16d", j)
 		}
 		k = fmt.Sprintf("%17d", 100)
-		idx, _, lv, seqno, deleted, ok := zs.findkey(0, index, []byte(k))
-		value, _ := lv.getactual(nil, nil)
+		idx, _, lv, seqno, deleted, ok :=
+			zs.findkey(0, index, []byte(k), bytes.Compare, nil)
+		value, _, _ := lv.getactual(nil, nil)
 		out := []interface{}{idx, value, seqno, deleted, ok}
 		ref := []interface{}{11, []byte(nil), uint64(0), false, false}
 		if reflect.DeepEqual(ref, out) == false {
@@ -80,7 +83,7 @@ func TestZBlock2(t *testing.T) {
 		i := uint64(0)
 		k := fmt.Sprintf("%16d", i)
 		seqno, deleted, vlogpos := i, true, int64(i*100)
-		for z.insert([]byte(k), nil, 16, vlogpos, seqno, deleted) {
+		for z.insert([]byte(k), nil, 16, vlogpos, seqno, 0, deleted) {
 			//t.Logf("insert %s", k)
 			i++
 			k = fmt.Sprintf("%16d", i)
@@ -103,7 +106,8 @@ func TestZBlock2(t *testing.T) {
 		index := zs.getindex(blkindex{})
 		j, k := uint64(0), fmt.Sprintf("%16d", 0)
 		for j < i {
-			_, _, lv, seqno, deleted, ok := zs.findkey(0, index, []byte(k))
+			_, _, lv, seqno, deleted, ok :=
+				zs.findkey(0, index, []byte(k), bytes.Compare, nil)
 			if ok == false {
 				t.Errorf("unexpected false")
 			} else if deleted != ((j % 4) == 0) {
@@ -126,8 +130,9 @@ func TestZBlock2(t *testing.T) {
 			k = fmt.Sprintf("%16d", j)
 		}
 		k = fmt.Sprintf("%17d", 100)
-		idx, _, lv, seqno, deleted, ok := zs.findkey(0, index, []byte(k))
-		value, _ := lv.getactual(nil, nil)
+		idx, _, lv, seqno, deleted, ok :=
+			zs.findkey(0, index, []byte(k), bytes.Compare, nil)
+		value, _, _ := lv.getactual(nil, nil)
 		out := []interface{}{idx, value, seqno, deleted, ok}
 		ref := []interface{}{11, []byte(nil), uint64(0), false, false}
 		if reflect.DeepEqual(ref, out) == false {
@@ -140,12 +145,92 @@ func TestZBlock2(t *testing.T) {
 	//doverify(doinsert())
 }
 
+func TestZBlockPrefixed(t *testing.T) {
+	zblocksize := int64(4 * 1024)
+	keyat := func(i uint64) string {
+		return fmt.Sprintf("tenant/0000-1111/table/%16d", i)
+	}
+
+	for _, vblocksize := range []int64{-1, 4096} {
+		z := newz(zblocksize, vblocksize)
+		z.restart = 4
+
+		i := uint64(0)
+		k := keyat(i)
+		v, seqno, deleted := k, i, true
+		for z.insert([]byte(k), []byte(v), 0, -1, seqno, 0, deleted) {
+			i++
+			k = keyat(i)
+			v, seqno, deleted = k, i, (i%4) == 0
+		}
+		t.Logf("Inserted %v items", i)
+		if _, ok := z.finalize(); ok == false {
+			t.Errorf("unexpected false")
+		}
+
+		zs := zsnap(z.block)
+		if x := pblock(zs).count(); x != int(i) {
+			t.Errorf("expected %v, got %v", i, x)
+		}
+		index := zs.getindex(blkindex{})
+		for j := uint64(0); j < i; j++ {
+			k = keyat(j)
+			idx, key, lv, seqno, deleted, ok :=
+				zs.findkey(0, index, []byte(k), bytes.Compare, nil)
+			if ok == false {
+				t.Errorf("unexpected false")
+			} else if idx != int(j) || string(key) != k {
+				t.Errorf("expected %v %q, got %v %q", j, k, idx, key)
+			} else if deleted != ((j % 4) == 0) {
+				t.Errorf("%q expected %v, got %v", k, ((j % 4) == 0), deleted)
+			} else if seqno != j {
+				t.Errorf("%q expected %v, got %v", k, j, seqno)
+			} else if deleted == false && vblocksize > 0 {
+				if lv.valuelen != int64(len(k)) || lv.fpos < 0 {
+					t.Errorf("%q unexpected %v %v", k, lv.valuelen, lv.fpos)
+				}
+			} else if deleted == false {
+				if value, _, _ := lv.getactual(nil, nil); string(value) != k {
+					t.Errorf("expected %s, got %s", k, value)
+				}
+			}
+			if j > 0 {
+				key, _, _, _ := zs.getprev(int(j), nil)
+				if ref := keyat(j - 1); string(key) != ref {
+					t.Errorf("expected %q, got %q", ref, key)
+				}
+			}
+		}
+		// sequential and random reads via decoder.
+		zd := zdecoder{index: -1}
+		for _, j := range []int{0, 1, 2, 3, 4, 5, 5, 9, 2, 3, int(i) - 1} {
+			key, _, seqno, _ := zs.pentrynext(j, &zd)
+			if ref := keyat(uint64(j)); string(key) != ref {
+				t.Errorf("expected %q, got %q", ref, key)
+			} else if seqno != uint64(j) {
+				t.Errorf("%q expected %v, got %v", ref, j, seqno)
+			}
+		}
+		// missing keys.
+		k = keyat(0)[:len(keyat(0))-1]
+		idx, _, _, _, _, ok := zs.findkey(0, index, []byte(k), bytes.Compare, nil)
+		if ok || idx != 0 {
+			t.Errorf("unexpected %v %v", idx, ok)
+		}
+		k = keyat(i)
+		idx, _, _, _, _, ok = zs.findkey(0, index, []byte(k), bytes.Compare, nil)
+		if ok || idx != int(i) {
+			t.Errorf("unexpected %v %v", idx, ok)
+		}
+	}
+}
+
 func BenchmarkZInsert(b *testing.B) {
 	blocksize := int64(4096)
 	k, value := []byte("aaaaaaaaaaaaaaaaaaaaaaa"), []byte("bbbbbbbbbbbbb")
 	z := newz(blocksize, -1)
 	for i := 0; i < b.N; i++ {
-		if z.insert(k, value, 0, -1, 0, false) == false {
+		if z.insert(k, value, 0, -1, 0, 0, false) == false {
 			z.reset(0, nil)
 		}
 	}
@@ -0,0 +1,41 @@
+package bubt
+
+import "fmt"
+import "hash/crc32"
+import "encoding/binary"
+
+// crcsize, every m-block and z-block ends with 4-byte CRC32C checksum
+// computed over rest of the block. Value log entries carry the same
+// checksum computed over the value, as stored on disk.
+const crcsize = 4
+
+// ChecksumCRC32C is the only checksum algorithm supported now.
+const ChecksumCRC32C = "crc32c"
+
+var crctable = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptError is returned when a block or an entry read from disk
+// fails its integrity check.
+type CorruptError struct {
+	File   string // file containing the corrupt block.
+	Fpos   int64  // file position of the block.
+	Block  string // can be "m-block", "z-block" or "vlog-entry".
+	Reason string
+}
+
+func (err *CorruptError) Error() string {
+	fmsg := "bubt.snap.corrupt %v at %v in %q: %v"
+	return fmt.Sprintf(fmsg, err.Block, err.Fpos, err.File, err.Reason)
+}
+
+func setblockcrc(block []byte) {
+	n := len(block) - crcsize
+	crc := crc32.Checksum(block[:n], crctable)
+	binary.BigEndian.PutUint32(block[n:], crc)
+}
+
+func checkblockcrc(block []byte) bool {
+	n := len(block) - crcsize
+	crc := crc32.Checksum(block[:n], crctable)
+	return binary.BigEndian.Uint32(block[n:]) == crc
+}
@@ -0,0 +1,43 @@
+package bubt
+
+import "bytes"
+import "testing"
+
+func TestBlockCRC(t *testing.T) {
+	block := make([]byte, 4096)
+	copy(block, []byte("hello world"))
+	setblockcrc(block)
+	if checkblockcrc(block) == false {
+		t.Errorf("expected valid checksum")
+	}
+	block[1] ^= 0xFF
+	if checkblockcrc(block) {
+		t.Errorf("expected checksum mismatch")
+	}
+}
+
+func TestVlogCRC(t *testing.T) {
+	var vle vlogentry
+
+	value := []byte("this is a value")
+	_, _, _, vlog := vle.serialize(4096, 0, 0, value, nil, nil)
+	out, flags, n, ok := vle.deserialize(vlog)
+	if ok == false {
+		t.Fatalf("unexpected partial entry")
+	} else if n != int64(len(vlog)) {
+		t.Errorf("expected %v, got %v", len(vlog), n)
+	} else if flags != vlogChecksum {
+		t.Errorf("expected %x, got %x", vlogChecksum, flags)
+	} else if bytes.Compare(out, value) != 0 {
+		t.Errorf("expected %q, got %q", value, out)
+	} else if vle.verify(vlog, out, flags) == false {
+		t.Errorf("expected valid checksum")
+	}
+	if _, _, _, ok := vle.deserialize(vlog[:len(vlog)-1]); ok {
+		t.Errorf("expected partial entry")
+	}
+	vlog[len(vlog)-1] ^= 0xFF
+	if vle.verify(vlog, out, flags) {
+		t.Errorf("expected checksum mismatch")
+	}
+}
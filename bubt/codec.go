@@ -0,0 +1,100 @@
+package bubt
+
+import "fmt"
+import "encoding/binary"
+
+import "github.com/golang/snappy"
+
+// Codecs supported for compressing z-blocks and value-log entries.
+const (
+	// CodecNone store blocks as is.
+	CodecNone = "none"
+	// CodecSnappy compress blocks using snappy.
+	CodecSnappy = "snappy"
+)
+
+// zhdrsize, compressed z-block on disk is prefixed with 4-byte length.
+const zhdrsize = 4
+
+// vlogCompressed flag in value-log entry header, rest of the header is
+// the length of compressed value.
+const vlogCompressed = uint64(1) << 63
+
+func validatecodec(codec string) error {
+	switch codec {
+	case CodecNone, CodecSnappy:
+		return nil
+	}
+	return fmt.Errorf("bubt.invalidcodec %q", codec)
+}
+
+// compressblock encode src using codec, and return the compressed block
+// prefixed with its length.
+func compressblock(codec string, dst, src []byte) []byte {
+	switch codec {
+	case CodecSnappy:
+		n := zhdrsize + snappy.MaxEncodedLen(len(src))
+		if cap(dst) < n {
+			dst = make([]byte, n)
+		}
+		dst = dst[:n]
+		out := snappy.Encode(dst[zhdrsize:], src)
+		binary.BigEndian.PutUint32(dst, uint32(len(out)))
+		return dst[:zhdrsize+len(out)]
+	}
+	panic(fmt.Errorf("bubt.invalidcodec %q", codec))
+}
+
+// decompressblock decode src, prefixed with its length, into dst.
+// Return the number of bytes consumed from src.
+func decompressblock(codec string, dst, src []byte) (int, error) {
+	if len(src) < zhdrsize {
+		return 0, fmt.Errorf("bubt.snap.zblock.partialread")
+	}
+	n := zhdrsize + int(binary.BigEndian.Uint32(src))
+	if len(src) < n {
+		return 0, fmt.Errorf("bubt.snap.zblock.partialread")
+	}
+	switch codec {
+	case CodecSnappy:
+		out, err := snappy.Decode(dst, src[zhdrsize:n])
+		if err != nil {
+			return 0, err
+		} else if len(out) != len(dst) {
+			fmsg := "bubt.snap.zblock.decompress %v != %v"
+			return 0, fmt.Errorf(fmsg, len(out), len(dst))
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("bubt.invalidcodec %q", codec)
+}
+
+// compressvalue encode value using codec, if compression does not save
+// any space return false.
+func compressvalue(codec string, dst, value []byte) ([]byte, bool) {
+	switch codec {
+	case CodecNone:
+		return dst, false
+	case CodecSnappy:
+		if cap(dst) < snappy.MaxEncodedLen(len(value)) {
+			dst = make([]byte, snappy.MaxEncodedLen(len(value)))
+		}
+		dst = snappy.Encode(dst[:cap(dst)], value)
+		return dst, len(dst) < len(value)
+	}
+	panic(fmt.Errorf("bubt.invalidcodec %q", codec))
+}
+
+// compressbound return the maximum size of a compressed block, along
+// with its length prefix, for n bytes of input.
+func compressbound(codec string, n int) int {
+	switch codec {
+	case CodecSnappy:
+		return zhdrsize + snappy.MaxEncodedLen(n)
+	}
+	return n
+}
+
+func decompressvalue(dst, src []byte) ([]byte, error) {
+	return snappy.Decode(dst, src)
+}
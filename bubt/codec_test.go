@@ -0,0 +1,42 @@
+package bubt
+
+import "bytes"
+import "testing"
+
+func TestCodec(t *testing.T) {
+	if err := validatecodec("zlib"); err == nil {
+		t.Errorf("expected error")
+	}
+
+	src := bytes.Repeat([]byte(`{"key": "value"}`), 256)
+	block := compressblock(CodecSnappy, nil, src)
+	if len(block) >= len(src) {
+		t.Errorf("expected compression, %v >= %v", len(block), len(src))
+	}
+	// block followed by other bytes.
+	block = append(block, 0xAB, 0xAB)
+	dst := make([]byte, len(src))
+	if n, err := decompressblock(CodecSnappy, dst, block); err != nil {
+		t.Fatal(err)
+	} else if n != len(block)-2 {
+		t.Errorf("expected %v, got %v", len(block)-2, n)
+	} else if bytes.Compare(dst, src) != 0 {
+		t.Errorf("unexpected %q", dst)
+	}
+	if _, err := decompressblock(CodecSnappy, dst, block[:10]); err == nil {
+		t.Errorf("expected error")
+	}
+
+	// incompressible values are stored as is.
+	if _, ok := compressvalue(CodecSnappy, nil, []byte("a")); ok {
+		t.Errorf("unexpected compression")
+	}
+	value, ok := compressvalue(CodecSnappy, nil, src)
+	if ok == false {
+		t.Errorf("expected compression")
+	} else if out, err := decompressvalue(nil, value); err != nil {
+		t.Fatal(err)
+	} else if bytes.Compare(out, src) != 0 {
+		t.Errorf("unexpected %q", out)
+	}
+}
@@ -1,7 +1,6 @@
 package bubt
 
 import "io"
-import "fmt"
 
 // Cursor object maintains an active pointer into index. Use OpenCursor
 // on Txn object to create a new cursor.
@@ -10,6 +9,7 @@ type Cursor struct {
 	ynext    bool
 	shardidx byte
 	fposs    []int64
+	znext    int64 // fpos of next z-block in current shard.
 
 	index    int
 	buf      *readbuffers
@@ -21,37 +21,75 @@ func (cur *Cursor) opencursor(
 
 	cur.buf = buf
 	if key == nil { // from beginning
-		cur.shardidx = 0
 		for i := 0; i < len(snap.readzs); i++ {
 			cur.fposs[i] = 0
 		}
-
 		cur.shardidx, cur.index = 0, 0
 		// populate zblock
-		n, err := snap.readzs[cur.shardidx].ReadAt(cur.buf.zblock, 0)
+		znext, err := snap.readzblock(cur.shardidx, 0, cur.buf)
 		if err != nil {
 			return nil, err
-		} else if n < len(cur.buf.zblock) {
-			return nil, fmt.Errorf("bubt.snap.mblock.partialread")
 		}
+		cur.znext = znext
 		return cur, nil
 	}
 
-	shardidx, fpos := snap.findinmblock(key, buf)
-	cur.index, _, _, _, _, _ = snap.findinzblock(shardidx, fpos, key, buf)
-	cur.shardidx = shardidx
-	for i := byte(0); i < cur.shardidx; i++ {
-		cur.fposs[i] = fpos + snap.zblocksize
+	shardidx, fpos, err := snap.findinmblock(key, buf)
+	if err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return nil, err
 	}
-	for i := cur.shardidx; i < byte(len(snap.readzs)); i++ {
-		cur.fposs[i] = fpos
+	cur.index, _, _, _, _, _, err = snap.findinzblock(shardidx, fpos, key, buf)
+	if err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return nil, err
 	}
 	// populate zblock
-	n, err := snap.readzs[shardidx].ReadAt(cur.buf.zblock, fpos)
+	if err := cur.readzblock(shardidx, fpos); err != nil {
+		return nil, err
+	}
+	return cur, nil
+}
+
+// openreverse position the cursor at the last entry that is less than
+// or equal to key, if key is nil position the cursor at the last entry.
+func (cur *Cursor) openreverse(
+	snap *Snapshot, key []byte, buf *readbuffers) (*Cursor, error) {
+
+	cur.buf = buf
+	if key == nil {
+		shardidx, fpos, err := snap.findlastinmblock(buf)
+		if err != nil {
+			errorf("%v %v", snap.logprefix, err)
+			return nil, err
+		} else if err := cur.readzblock(shardidx, fpos); err != nil {
+			return nil, err
+		}
+		cur.index = zsnap(cur.buf.zblock).lastindex()
+		return cur, nil
+	}
+
+	shardidx, fpos, err := snap.findinmblock(key, buf)
 	if err != nil {
+		errorf("%v %v", snap.logprefix, err)
 		return nil, err
-	} else if n < len(cur.buf.zblock) {
-		return nil, fmt.Errorf("bubt.snap.mblock.partialread")
+	}
+	index, _, _, _, _, ok, err := snap.findinzblock(shardidx, fpos, key, buf)
+	if err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return nil, err
+	} else if err := cur.readzblock(shardidx, fpos); err != nil {
+		return nil, err
+	}
+	if cur.index = index; ok == false { // index points to entry > key
+		cur.index = index - 1
+	}
+	if cur.index < 0 {
+		if err := cur.prevblock(snap); err == io.EOF {
+			cur.finished = true // all entries are greater than key
+		} else if err != nil {
+			return nil, err
+		}
 	}
 	return cur, nil
 }
@@ -64,29 +102,32 @@ func (cur *Cursor) Key() (key []byte, deleted bool) {
 
 	z := zsnap(cur.buf.zblock)
 	if z.isbounded(cur.index) {
-		key, _, _, deleted = z.entryat(cur.index)
+		key, _, _, deleted = cur.entryat(cur.index)
 	} else {
 		key, _, _, deleted, _ = cur.getnext()
 	}
 	return
 }
 
-// Value return value at cursor.
+// Value return value at cursor, shall panic with *CorruptError if
+// value fails its checksum.
 func (cur *Cursor) Value() (value []byte) {
 	if cur.finished {
 		return nil
 	}
 
 	var lv lazyvalue
+	var err error
 
 	z := zsnap(cur.buf.zblock)
 	if z.isbounded(cur.index) {
-		_, lv, _, _ = z.entryat(cur.index)
-		value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
-
+		_, lv, _, _ = cur.entryat(cur.index)
 	} else {
 		_, lv, _, _, _ = cur.getnext()
-		value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
+	}
+	value, cur.buf.vblock, err = lv.getactual(cur.snap, cur.buf.vblock)
+	if err != nil {
+		panic(err)
 	}
 	return
 }
@@ -97,7 +138,21 @@ func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	var lv lazyvalue
 
 	key, lv, _, deleted, err = cur.getnext()
-	value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
+	if err == nil {
+		value, cur.buf.vblock, err = lv.getactual(cur.snap, cur.buf.vblock)
+	}
+	return
+}
+
+// GetPrev move cursor to previous entry and return its key, value,
+// whether it is deleted, err will be io.EOF or any other disk error.
+func (cur *Cursor) GetPrev() (key, value []byte, deleted bool, err error) {
+	var lv lazyvalue
+
+	key, lv, _, deleted, err = cur.getprev()
+	if err == nil {
+		value, cur.buf.vblock, err = lv.getactual(cur.snap, cur.buf.vblock)
+	}
 	return
 }
 
@@ -108,18 +163,20 @@ func (cur *Cursor) getnext() (
 		return nil, lv, 0, false, io.EOF
 	}
 
-	key, lv, seqno, deleted = zsnap(cur.buf.zblock).getnext(cur.index)
+	if zsnap(cur.buf.zblock).isbounded(cur.index + 1) {
+		key, lv, seqno, deleted = cur.entryat(cur.index + 1)
+	}
 	//fmt.Printf("getnext %q\n", key)
 	if key != nil {
 		cur.index++
 		return key, lv, seqno, deleted, nil
 	}
 
-	cur.fposs[cur.shardidx] += cur.snap.zblocksize
+	cur.fposs[cur.shardidx] = cur.znext
 	cur.shardidx = (cur.shardidx + 1) % byte(len(cur.fposs))
 	err = cur.nextblock(cur.snap)
 	if err == nil {
-		key, lv, seqno, deleted = zsnap(cur.buf.zblock).entryat(cur.index)
+		key, lv, seqno, deleted = cur.entryat(cur.index)
 		//fmt.Printf("getnext-next %s\n", key)
 		if key != nil {
 			return key, lv, seqno, deleted, nil
@@ -129,6 +186,31 @@ func (cur *Cursor) getnext() (
 	return nil, lv, 0, false, err
 }
 
+func (cur *Cursor) getprev() (
+	key []byte, lv lazyvalue, seqno uint64, deleted bool, err error) {
+
+	if cur.finished {
+		return nil, lv, 0, false, io.EOF
+	}
+
+	if zsnap(cur.buf.zblock).isbounded(cur.index - 1) {
+		key, lv, seqno, deleted = cur.entryat(cur.index - 1)
+	}
+	if key != nil {
+		cur.index--
+		return key, lv, seqno, deleted, nil
+	}
+
+	if err = cur.prevblock(cur.snap); err == nil {
+		key, lv, seqno, deleted = cur.entryat(cur.index)
+		if key != nil {
+			return key, lv, seqno, deleted, nil
+		}
+		panic("impossible situation")
+	}
+	return nil, lv, 0, false, err
+}
+
 // YNext can be used for lsm-sort. Similar to GetNext, but includes the
 // seqno at which the entry was created/updated/deleted.
 func (cur *Cursor) YNext(fin bool) (key,
@@ -145,13 +227,29 @@ func (cur *Cursor) YNext(fin bool) (key,
 		z := zsnap(cur.buf.zblock)
 		cur.ynext = true
 		if z.isbounded(cur.index) {
-			key, lv, seqno, deleted = z.entryat(cur.index)
-			value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
+			key, lv, seqno, deleted = cur.entryat(cur.index)
+			value, cur.buf.vblock, err = lv.getactual(cur.snap, cur.buf.vblock)
 			return
 		}
 	}
 	key, lv, seqno, deleted, err = cur.getnext()
-	value, cur.buf.vblock = lv.getactual(cur.snap, cur.buf.vblock)
+	if err == nil {
+		value, cur.buf.vblock, err = lv.getactual(cur.snap, cur.buf.vblock)
+	}
+	return
+}
+
+// YPrev can be used for reverse lsm-sort. Similar to GetPrev, but
+// includes the seqno at which the entry was created/updated/deleted.
+func (cur *Cursor) YPrev(fin bool) (key,
+	value []byte, seqno uint64, deleted bool, err error) {
+
+	var lv lazyvalue
+
+	key, lv, seqno, deleted, err = cur.yprev(fin)
+	if err == nil {
+		value, cur.buf.vblock, err = lv.getactual(cur.snap, cur.buf.vblock)
+	}
 	return
 }
 
@@ -166,7 +264,7 @@ func (cur *Cursor) ynextentry(fin bool) (key []byte,
 		z := zsnap(cur.buf.zblock)
 		cur.ynext = true
 		if z.isbounded(cur.index) {
-			key, lv, seqno, deleted = z.entryat(cur.index)
+			key, lv, seqno, deleted = cur.entryat(cur.index)
 			return
 		}
 	}
@@ -174,22 +272,96 @@ func (cur *Cursor) ynextentry(fin bool) (key []byte,
 	return
 }
 
+// yprev is similar to ynextentry, but moves the cursor backwards.
+func (cur *Cursor) yprev(fin bool) (key []byte,
+	lv lazyvalue, seqno uint64, deleted bool, err error) {
+
+	if fin {
+		cur.finished = true
+	}
+	if cur.finished {
+		return nil, lv, 0, false, io.EOF
+
+	} else if cur.ynext == false {
+		z := zsnap(cur.buf.zblock)
+		cur.ynext = true
+		if z.isbounded(cur.index) {
+			key, lv, seqno, deleted = cur.entryat(cur.index)
+			return
+		}
+	}
+	key, lv, seqno, deleted, err = cur.getprev()
+	return
+}
+
+// expiry of the entry under the cursor, in unix seconds.
+func (cur *Cursor) expiry() uint64 {
+	if z := zsnap(cur.buf.zblock); z.isbounded(cur.index) {
+		return z.expiryat(cur.index)
+	}
+	return 0
+}
+
+// prevblock move the cursor to the last entry of previous z-block,
+// looked up from m-index using the first key of current z-block.
+func (cur *Cursor) prevblock(snap *Snapshot) error {
+	firstkey, _, _, _ := cur.entryat(0)
+	shardidx, fpos, ok, err := snap.findltinmblock(firstkey, cur.buf)
+	if err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return err
+	} else if ok == false {
+		cur.finished = true
+		return io.EOF
+	}
+	if err := cur.readzblock(shardidx, fpos); err != nil {
+		return err
+	}
+	cur.index = zsnap(cur.buf.zblock).lastindex()
+	return nil
+}
+
+// readzblock at fpos from shard, and make it the current z-block.
+func (cur *Cursor) readzblock(shardidx byte, fpos int64) error {
+	snap := cur.snap
+	znext, err := snap.readzblock(shardidx, fpos, cur.buf)
+	if err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return err
+	}
+	cur.shardidx, cur.znext = shardidx, znext
+	if snap.zcodec != CodecNone {
+		// z-blocks are of variable size, position of z-blocks in other
+		// shards shall be looked up from m-index.
+		for i := range cur.fposs {
+			cur.fposs[i] = -1
+		}
+		cur.fposs[cur.shardidx] = fpos
+		return nil
+	}
+	for i := byte(0); i < cur.shardidx; i++ {
+		cur.fposs[i] = fpos + snap.zblocksize
+	}
+	for i := cur.shardidx; i < byte(len(snap.readzs)); i++ {
+		cur.fposs[i] = fpos
+	}
+	return nil
+}
+
 func (cur *Cursor) nextblock(snap *Snapshot) error {
 	for i := 0; i < len(cur.fposs); i++ {
-		till := snap.zsizes[cur.shardidx] - MarkerBlocksize
 		fpos := cur.fposs[cur.shardidx]
+		if fpos < 0 { // position not known for compressed z-blocks.
+			return cur.lookupnext(snap)
+		}
+		till := snap.zsizes[cur.shardidx] - MarkerBlocksize
 		if fpos < till {
-			readz := snap.readzs[cur.shardidx]
-			n, err := readz.ReadAt(cur.buf.zblock, fpos)
+			znext, err := snap.readzblock(cur.shardidx, fpos, cur.buf)
 			if err != nil {
 				errorf("%v %v", cur.snap.logprefix, err)
 				return err
-			} else if x := len(cur.buf.zblock); n < x {
-				err := fmt.Errorf("read %v bytes for zblock %v", n, x)
-				errorf("%v %v", cur.snap.logprefix, err)
-				return err
 			}
-			cur.index = 0
+			cur.znext, cur.index = znext, 0
 			return nil
 		}
 		// try next shard
@@ -199,6 +371,41 @@ func (cur *Cursor) nextblock(snap *Snapshot) error {
 	return io.EOF
 }
 
+// lookupnext z-block from m-index, using the first key of current
+// z-block, and make it the current z-block.
+func (cur *Cursor) lookupnext(snap *Snapshot) error {
+	firstkey, _, _, _ := cur.entryat(0)
+	shardidx, fpos, ok, err := snap.findnextinmblock(firstkey, cur.buf)
+	if err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return err
+	} else if ok == false {
+		cur.finished = true
+		return io.EOF
+	}
+	znext, err := snap.readzblock(shardidx, fpos, cur.buf)
+	if err != nil {
+		errorf("%v %v", cur.snap.logprefix, err)
+		return err
+	}
+	cur.shardidx, cur.znext, cur.index = shardidx, znext, 0
+	cur.fposs[shardidx] = fpos
+	return nil
+}
+
+// entryat return the entry at index in current z-block, sequential
+// reads on prefix compressed z-block continue decoding from the
+// previous entry.
+func (cur *Cursor) entryat(
+	index int) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
+
+	z := zsnap(cur.buf.zblock)
+	if pblock(z).isprefixed() {
+		return z.pentrynext(index, &cur.buf.zdec)
+	}
+	return z.entryat(index, cur.buf.zkey)
+}
+
 // Set not allowed.
 func (cur *Cursor) Set(key, value, oldvalue []byte) []byte {
 	panic("Set not allowed on view-cursor")
@@ -34,6 +34,16 @@ func readmarker(r io.ReaderAt) error {
 func readmetadata(r io.ReaderAt) (metadata []byte, err error) {
 	fsize := filesize(r)
 	fpos := fsize - MarkerBlocksize // skip markerblock
+	// skip range tombstones and bloom filter
+	tomblen, err := readrangetomblen(r)
+	if err != nil {
+		return nil, err
+	}
+	bloomlen, err := readbloomlen(r)
+	if err != nil {
+		return nil, err
+	}
+	fpos -= tomblen + bloomlen
 	if fpos -= 8; fpos < 0 {
 		return nil, fmt.Errorf("bubt.snap.nomdlen")
 	}
@@ -67,6 +77,16 @@ func readinfoblock(r io.ReaderAt) (fpos int64, info s.Settings, err error) {
 	fsize := filesize(r)
 	// skip markerblock
 	fpos = fsize - MarkerBlocksize
+	// skip range tombstones and bloom filter
+	tomblen, err := readrangetomblen(r)
+	if err != nil {
+		return fpos, nil, err
+	}
+	bloomlen, err := readbloomlen(r)
+	if err != nil {
+		return fpos, nil, err
+	}
+	fpos -= tomblen + bloomlen
 	// skip metadata
 	var scratch [8]byte
 	n, err := r.ReadAt(scratch[:], fpos-8)
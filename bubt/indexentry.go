@@ -9,6 +9,7 @@ type indexentry struct {
 	lv      lazyvalue
 	seqno   uint64
 	deleted bool
+	expiry  uint64
 	err     error
 	vblock  []byte
 }
@@ -22,6 +23,7 @@ func (entry *indexentry) set(
 	entry.lv = lv
 
 	entry.seqno, entry.deleted, entry.err = seqno, deleted, err
+	entry.expiry = 0
 	return entry
 }
 
@@ -29,11 +31,16 @@ func (entry *indexentry) Key() (key []byte, seqno uint64, del bool, err error) {
 	return entry.key, entry.seqno, entry.deleted, entry.err
 }
 
+// Value shall panic with *CorruptError if value fails its checksum.
 func (entry *indexentry) Value() (value []byte) {
 	if entry.err != nil {
 		return nil
 	}
-	value, entry.vblock = entry.lv.getactual(entry.snap, entry.vblock)
+	var err error
+	value, entry.vblock, err = entry.lv.getactual(entry.snap, entry.vblock)
+	if err != nil {
+		panic(err)
+	}
 	return value
 }
 
@@ -45,3 +52,11 @@ func (entry *indexentry) Valueref() (valuelen uint64, vlogpos int64) {
 	valuelen, vlogpos = uint64(entry.lv.valuelen), entry.lv.vlogpos
 	return
 }
+
+func (entry *indexentry) Expiry() uint64 {
+	return entry.expiry
+}
+
+func (entry *indexentry) Ismerge() bool {
+	return false
+}
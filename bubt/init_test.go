@@ -1,9 +1,11 @@
 package bubt
 
 import "fmt"
+import "bytes"
 import "net/http"
 
 import "github.com/bnclabs/golog"
+import "github.com/bnclabs/gostore/api"
 import _ "net/http/pprof"
 
 var _ = fmt.Sprintf("dummy")
@@ -15,6 +17,11 @@ func init() {
 	}
 	log.SetLogger(nil, setts)
 	LogComponents("self")
+
+	reverse := func(a, b []byte) int { return bytes.Compare(b, a) }
+	if err := api.Registercomparator("testreverse", reverse); err != nil {
+		panic(err)
+	}
 	go func() {
 		log.Infof("%v", http.ListenAndServe("localhost:6060", nil))
 	}()
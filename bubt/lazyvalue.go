@@ -23,26 +23,49 @@ func (lv *lazyvalue) setfields(valuelen, vlogpos int64, value []byte) {
 	lv.fpos = int64(uint64(vlogpos) & 0x00FFFFFFFFFFFFFF)
 }
 
-func (lv *lazyvalue) getactual(snap *Snapshot, vblock []byte) ([]byte, []byte) {
+func (lv *lazyvalue) getactual(
+	snap *Snapshot, vblock []byte) ([]byte, []byte, error) {
+
+	var vle vlogentry
+
 	if len(lv.actual) > 0 {
-		return lv.actual, vblock
+		return lv.actual, vblock, nil
 
 	} else if lv.valuelen == 0 {
-		return nil, vblock
+		return nil, vblock, nil
 	}
 
-	vblock = lib.Fixbuffer(vblock, lv.valuelen+vlogentrysize)
+	// stored value is never larger than the actual value, reserve room
+	// after the entry to decompress the value.
+	n := lv.valuelen + vlogentrysize + crcsize
+	vblock = lib.Fixbuffer(vblock, n+lv.valuelen)
 	r := snap.readvs[lv.shardidx-1]
-	n, err := r.ReadAt(vblock, lv.fpos)
-	if err != nil {
-		panic(err)
+	// entry can be the last entry in the file, hence can fall short of
+	// n bytes.
+	m, err := r.ReadAt(vblock[:n], lv.fpos)
+	value, flags, x, ok := vle.deserialize(vblock[:m])
+	if ok == false && err != nil {
+		return nil, vblock, err
+	} else if ok == false {
+		err := fmt.Errorf("bubt.snap.partialvlog %v < %v", m, x)
+		return nil, vblock, err
+	}
 
-	} else if n < len(vblock) {
-		err := fmt.Errorf("bubt.snap.partialvlog %v < %v", n, len(vblock))
-		panic(err)
+	corrupt := &CorruptError{
+		File: snap.vfiles[lv.shardidx-1], Fpos: lv.fpos, Block: "vlog-entry",
+	}
+	if vle.verify(vblock, value, flags) == false {
+		corrupt.Reason = "checksum mismatch"
+		return nil, vblock, corrupt
+	} else if flags&vlogCompressed == 0 {
+		return value, vblock, nil
+	}
+	value, err = decompressvalue(vblock[n:], value)
+	if err != nil {
+		corrupt.Reason = err.Error()
+		return nil, vblock, corrupt
 	}
-	value := vblock[vlogentrysize:]
-	return value, vblock
+	return value, vblock, nil
 }
 
 func (lv *lazyvalue) inlinevalue() []byte {
@@ -0,0 +1,126 @@
+package bubt
+
+import "io"
+import "os"
+import "fmt"
+import "encoding/json"
+import "path/filepath"
+import "encoding/binary"
+
+// Link this snapshot as a new snapshot by name under paths, with its
+// metadata replaced by the supplied metadata, without rebuilding the
+// btree. Z-index files are hard-linked, or copied if they cannot be
+// linked. Value logs are always copied, for they can be appended by
+// snapshots built later. M-index file is rewritten with new name and
+// metadata. Number of paths shall be same as that of this snapshot,
+// and this snapshot is left untouched.
+func (snap *Snapshot) Link(
+	name string, paths []string, metadata []byte) (err error) {
+
+	if int64(len(paths)) != snap.numpaths {
+		fmsg := "bubt.link.numpaths %v, expected %v"
+		return fmt.Errorf(fmsg, len(paths), snap.numpaths)
+	}
+
+	defer func() {
+		if err != nil {
+			errorf("%v Link(%q): %v", snap.logprefix, name, err)
+			PurgeSnapshot(name, paths)
+		}
+	}()
+
+	for i, zfile := range snap.zfiles {
+		dir := filepath.Join(paths[i], name)
+		if err = os.MkdirAll(dir, 0770); err != nil {
+			return err
+		}
+		zlink := filepath.Join(dir, filepath.Base(zfile))
+		if err = os.Link(zfile, zlink); err != nil {
+			if err = copyfile(zlink, zfile); err != nil {
+				return err
+			}
+		}
+		if len(snap.vfiles) > 0 {
+			vfile := snap.vfiles[i]
+			vcopy := filepath.Join(dir, filepath.Base(vfile))
+			if err = copyfile(vcopy, vfile); err != nil {
+				return err
+			}
+		}
+	}
+	mfile := filepath.Join(paths[0], name, filepath.Base(snap.mfile))
+	return snap.rewritemindex(mfile, name, metadata)
+}
+
+// rewritemindex copy m-index file into mfile, with infoblock and
+// metadata updated for the new name. M-blocks, bloom filter and range
+// tombstones are copied as is, their file position remain the same.
+func (snap *Snapshot) rewritemindex(
+	mfile, name string, metadata []byte) error {
+
+	r := snap.readm
+	fpos, info, err := readinfoblock(r)
+	if err != nil {
+		return err
+	}
+	tomblen, err := readrangetomblen(r)
+	if err != nil {
+		return err
+	}
+	bloomlen, err := readbloomlen(r)
+	if err != nil {
+		return err
+	}
+	tail := filesize(r) - MarkerBlocksize - tomblen - bloomlen
+
+	info["name"] = name
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	} else if x := len(data) + 8; x > MarkerBlocksize {
+		return fmt.Errorf("infoblock(%v) > MarkerBlocksize", x)
+	}
+	infoblock := make([]byte, MarkerBlocksize)
+	binary.BigEndian.PutUint64(infoblock, uint64(len(data)))
+	copy(infoblock[8:], data)
+
+	fd, err := os.Create(mfile)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fd, io.NewSectionReader(r, 0, fpos))
+	if err == nil {
+		_, err = fd.Write(infoblock)
+	}
+	if err == nil {
+		_, err = fd.Write(encodemetadata(metadata, snap.mblocksize))
+	}
+	if err == nil {
+		_, err = io.Copy(fd, io.NewSectionReader(r, tail, filesize(r)-tail))
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+func copyfile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
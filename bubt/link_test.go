@@ -0,0 +1,79 @@
+package bubt
+
+import "fmt"
+import "bytes"
+import "testing"
+
+import "github.com/bnclabs/gostore/llrb"
+
+func TestLink(t *testing.T) {
+	paths := makepaths123(3)
+	mi := llrb.NewLLRB("linkllrb", llrb.Defaultsettings())
+	defer mi.Destroy()
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i))
+		mi.Set(key, []byte(fmt.Sprintf("val%015d", i)), nil)
+	}
+	mi.DeleteRange([]byte(fmt.Sprintf("key%015d", 100)), nil)
+
+	name := "testlink"
+	bubt, err := NewBubt(name, paths, 4096, 4096, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bubt.Bloom(10)
+	bubt.Rangetombs(mi.Rangetombs(), false /*purge*/)
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	if err := snap.Link("testlinked", paths[:2], nil); err == nil {
+		t.Errorf("expected error")
+	}
+	metadata := bytes.Repeat([]byte("linked"), 1000)
+	if err := snap.Link("testlinked", paths, metadata); err != nil {
+		t.Fatal(err)
+	}
+	linked, err := OpenSnapshot("testlinked", paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer linked.Destroy()
+	defer linked.Close()
+	linked.Validate()
+
+	if x := string(linked.Metadata()); x != string(metadata) {
+		t.Errorf("unexpected metadata %q", x)
+	} else if string(snap.Metadata()) != "metadata" {
+		t.Errorf("unexpected metadata %q", snap.Metadata())
+	} else if x, y := linked.Count(), snap.Count(); x != y {
+		t.Errorf("expected %v, got %v", y, x)
+	} else if x, y := linked.Getseqno(), snap.Getseqno(); x != y {
+		t.Errorf("expected %v, got %v", y, x)
+	} else if x := len(linked.Rangetombs()); x != 1 {
+		t.Errorf("expected %v, got %v", 1, x)
+	} else if linked.filter == nil {
+		t.Errorf("expected bloom filter")
+	}
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i))
+		value, _, deleted, ok := linked.Get(key, []byte{})
+		if !ok {
+			t.Errorf("missing %q", key)
+		} else if i >= 100 && !deleted {
+			t.Errorf("expected %q as deleted", key)
+		} else if i < 100 && string(value) != fmt.Sprintf("val%015d", i) {
+			t.Errorf("%q unexpected %q", key, value)
+		}
+	}
+}
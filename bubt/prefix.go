@@ -0,0 +1,211 @@
+package bubt
+
+import "encoding/binary"
+
+// blockPrefixed is set in n_entries field of z-blocks and m-blocks
+// whose keys are prefix compressed, refer Bubt.Restartinterval.
+const blockPrefixed = uint32(0x80000000)
+
+// pblock represents the shape of prefix compressed z-blocks and
+// m-blocks:
+//
+// n_entries uint32   - 4-byte count of entries, with blockPrefixed set.
+// interval  uint32   - 4-byte restart interval.
+// restarts  []uint32 - 4 byte offset into block for each restart point.
+// entries            - array of pzentry or pmentry.
+// crc32c    uint32   - 4-byte checksum, at the end of the block.
+//
+// Key of every interval-th entry, the restart point, is stored in
+// full, rest of the entries only store the suffix that differ from
+// the previous key.
+type pblock []byte
+
+func (b pblock) isprefixed() bool {
+	return (binary.BigEndian.Uint32(b[:4]) & blockPrefixed) != 0
+}
+
+func (b pblock) count() int {
+	return int(binary.BigEndian.Uint32(b[:4]) &^ blockPrefixed)
+}
+
+func (b pblock) interval() int {
+	return int(binary.BigEndian.Uint32(b[4:8]))
+}
+
+func (b pblock) restarts() int {
+	n, interval := b.count(), b.interval()
+	return (n + interval - 1) / interval
+}
+
+func (b pblock) restartat(r int) int {
+	off := 8 + (r * 4)
+	return int(binary.BigEndian.Uint32(b[off : off+4]))
+}
+
+// keydecoder decode the entry at offset x, and return its key built
+// on top of the previous key, along with offset of the next entry.
+type keydecoder func(x int, prevkey []byte) (key []byte, next int)
+
+// seek to entry at index, return its key and offset. Keys are built in
+// kbuf, decoding from the nearest restart point.
+func (b pblock) seek(
+	index int, kbuf []byte, decode keydecoder) (key []byte, x int) {
+
+	r, interval := index/b.interval(), b.interval()
+	i, x, key := r*interval, b.restartat(r), kbuf[:0]
+	for {
+		var next int
+		if key, next = decode(x, key); i == index {
+			return key, x
+		}
+		i, x = i+1, next
+	}
+}
+
+// search return the count of leading entries whose key satisfy pred,
+// pred shall hold true for a prefix of the entries, like bytes.Compare
+// on sorted keys. Restart points are binary searched and then entries
+// between the restart points are scanned linearly.
+func (b pblock) search(
+	kbuf []byte, decode keydecoder, pred func(key []byte) bool) int {
+
+	lo, hi := 0, b.restarts()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if key, _ := decode(b.restartat(mid), kbuf[:0]); pred(key) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0
+	}
+	n, interval := b.count(), b.interval()
+	i, x, key := (lo-1)*interval, b.restartat(lo-1), kbuf[:0]
+	for ; i < n && i < lo*interval; i++ {
+		var next int
+		if key, next = decode(x, key); pred(key) == false {
+			return i
+		}
+		x = next
+	}
+	return i
+}
+
+// sharedprefix return the length of common prefix between a and b.
+func sharedprefix(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// pzentry represents the binary layout of each entry in prefix
+// compressed z-block, lengths are uvarint encoded:
+//
+// shared   - bytes shared with the previous key, ZERO at restart point.
+// unshared - length of key suffix.
+// flags    - 1 byte, zflagDeleted and zflagVlog.
+// seqno    - uvarint.
+// expiry   - uvarint, in unix seconds.
+// valuelen - uvarint.
+// byte array of key suffix.
+// 8-byte fpos into value log, if zflagVlog is set,
+//  or byte array of value, if value is present.
+type pzentry struct {
+	shared   int
+	unshared int
+	flags    byte
+	seqno    uint64
+	expiry   uint64
+	valuelen uint64
+	keyoff   int // offset of key suffix in block.
+}
+
+// maximum size of pzentry header.
+const pzentrymaxsize = 1 + (5 * binary.MaxVarintLen64)
+
+func (ze *pzentry) encode(buf []byte) []byte {
+	var scratch [pzentrymaxsize]byte
+	n := binary.PutUvarint(scratch[:], uint64(ze.shared))
+	n += binary.PutUvarint(scratch[n:], uint64(ze.unshared))
+	scratch[n] = ze.flags
+	n++
+	n += binary.PutUvarint(scratch[n:], ze.seqno)
+	n += binary.PutUvarint(scratch[n:], ze.expiry)
+	n += binary.PutUvarint(scratch[n:], ze.valuelen)
+	return append(buf, scratch[:n]...)
+}
+
+// decode entry at offset x in block, return offset of next entry.
+func (ze *pzentry) decode(block []byte, x int) int {
+	v, n := binary.Uvarint(block[x:])
+	ze.shared, x = int(v), x+n
+	v, n = binary.Uvarint(block[x:])
+	ze.unshared, x = int(v), x+n
+	ze.flags, x = block[x], x+1
+	ze.seqno, n = binary.Uvarint(block[x:])
+	x += n
+	ze.expiry, n = binary.Uvarint(block[x:])
+	x += n
+	ze.valuelen, n = binary.Uvarint(block[x:])
+	ze.keyoff = x + n
+	if ze.isvlog() {
+		return ze.valueoff() + 8
+	}
+	return ze.valueoff() + int(ze.valuelen)
+}
+
+func (ze *pzentry) valueoff() int {
+	return ze.keyoff + ze.unshared
+}
+
+func (ze *pzentry) isdeleted() bool {
+	return (ze.flags & zflagDeleted) != 0
+}
+
+func (ze *pzentry) isvlog() bool {
+	return (ze.flags & zflagVlog) != 0
+}
+
+// pmentry represents the binary layout of each entry in prefix
+// compressed m-block:
+//
+// shared   - uvarint, bytes shared with the previous key.
+// unshared - uvarint, length of key suffix.
+// vpos     - 8-byte, child block pointer.
+// byte array of key suffix.
+type pmentry struct {
+	shared   int
+	unshared int
+	vpos     uint64
+	keyoff   int // offset of key suffix in block.
+}
+
+// maximum size of pmentry header.
+const pmentrymaxsize = 8 + (2 * binary.MaxVarintLen64)
+
+func (me *pmentry) encode(buf []byte) []byte {
+	var scratch [pmentrymaxsize]byte
+	n := binary.PutUvarint(scratch[:], uint64(me.shared))
+	n += binary.PutUvarint(scratch[n:], uint64(me.unshared))
+	binary.BigEndian.PutUint64(scratch[n:], me.vpos)
+	return append(buf, scratch[:n+8]...)
+}
+
+// decode entry at offset x in block, return offset of next entry.
+func (me *pmentry) decode(block []byte, x int) int {
+	v, n := binary.Uvarint(block[x:])
+	me.shared, x = int(v), x+n
+	v, n = binary.Uvarint(block[x:])
+	me.unshared, x = int(v), x+n
+	me.vpos, me.keyoff = binary.BigEndian.Uint64(block[x:]), x+8
+	return me.keyoff + me.unshared
+}
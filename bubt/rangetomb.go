@@ -0,0 +1,115 @@
+package bubt
+
+import "fmt"
+import "io"
+import "encoding/binary"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+
+// rangetombMagic marks the tail of range tombstone section in m-index
+// file, which is the last section before the marker block. Snapshots
+// built without range tombstones end with bloom filter or metadata.
+const rangetombMagic = uint64(0x7042B7042B7042FF)
+
+// encoderangetombs as a section of blocksize multiple.
+//
+//   count  uint64
+//   for each range tombstone:
+//     seqno   uint64
+//     lowlen  uint32 - ZERO if low is unbounded
+//     highlen uint32 - ZERO if high is unbounded
+//     low     [lowlen]byte
+//     high    [highlen]byte
+//   padding
+//   length uint64 - length of the section
+//   magic  uint64 - rangetombMagic
+func encoderangetombs(tombs api.Rangetombs, blocksize int64) []byte {
+	ln := int64(8 + 8 + 8)
+	for _, tomb := range tombs {
+		ln += int64(8 + 4 + 4 + len(tomb.Low) + len(tomb.High))
+	}
+	ln = (((ln - 1) / blocksize) + 1) * blocksize
+	block := make([]byte, ln)
+	binary.BigEndian.PutUint64(block, uint64(len(tombs)))
+	off := 8
+	for _, tomb := range tombs {
+		binary.BigEndian.PutUint64(block[off:], tomb.Seqno)
+		binary.BigEndian.PutUint32(block[off+8:], uint32(len(tomb.Low)))
+		binary.BigEndian.PutUint32(block[off+12:], uint32(len(tomb.High)))
+		off += 16
+		off += copy(block[off:], tomb.Low)
+		off += copy(block[off:], tomb.High)
+	}
+	binary.BigEndian.PutUint64(block[ln-16:], uint64(ln))
+	binary.BigEndian.PutUint64(block[ln-8:], rangetombMagic)
+	return block
+}
+
+// readrangetomblen return the length of range tombstone section in
+// m-index file, return 0 if snapshot was built without range tombstones.
+func readrangetomblen(r io.ReaderAt) (int64, error) {
+	fsize := filesize(r)
+	fpos := fsize - MarkerBlocksize // skip markerblock
+	if fpos -= 16; fpos < 0 {
+		return 0, fmt.Errorf("bubt.snap.norangetombtail")
+	}
+
+	var scratch [16]byte
+	n, err := r.ReadAt(scratch[:], fpos)
+	if err != nil {
+		return 0, err
+	} else if n < len(scratch) {
+		return 0, fmt.Errorf("bubt.snap.partialrangetombtail")
+	} else if binary.BigEndian.Uint64(scratch[8:]) != rangetombMagic {
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint64(scratch[:])), nil
+}
+
+// readrangetombs from m-index file, return the range tombstones and the
+// length of its section. If snapshot was built without range
+// tombstones return nil.
+func readrangetombs(r io.ReaderAt) (api.Rangetombs, int64, error) {
+	ln, err := readrangetomblen(r)
+	if err != nil || ln == 0 {
+		return nil, 0, err
+	}
+	fpos := filesize(r) - MarkerBlocksize - ln
+	if fpos < 0 {
+		return nil, 0, fmt.Errorf("bubt.snap.norangetombs")
+	}
+
+	block := lib.Fixbuffer(nil, ln)
+	n, err := r.ReadAt(block, fpos)
+	if err != nil {
+		return nil, 0, err
+	} else if n < len(block) {
+		return nil, 0, fmt.Errorf("bubt.snap.partialrangetombs")
+	}
+
+	invalid := fmt.Errorf("bubt.snap.invalidrangetombs")
+	count, off := binary.BigEndian.Uint64(block), int64(8)
+	tombs := make(api.Rangetombs, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if off+16 > ln-16 {
+			return nil, 0, invalid
+		}
+		seqno := binary.BigEndian.Uint64(block[off:])
+		lowlen := int64(binary.BigEndian.Uint32(block[off+8:]))
+		highlen := int64(binary.BigEndian.Uint32(block[off+12:]))
+		if off += 16; off+lowlen+highlen > ln-16 {
+			return nil, 0, invalid
+		}
+		var low, high []byte
+		if lowlen > 0 {
+			low = block[off : off+lowlen]
+		}
+		if off += lowlen; highlen > 0 {
+			high = block[off : off+highlen]
+		}
+		off += highlen
+		tombs = append(tombs, api.Rangetomb{Low: low, High: high, Seqno: seqno})
+	}
+	return tombs, ln, nil
+}
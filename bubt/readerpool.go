@@ -24,6 +24,10 @@ type readbuffers struct {
 	zblock []byte
 	mblock []byte
 	vblock []byte
+	zcomp  []byte // compressed z-block
+	zkey   []byte // key from prefix compressed z-block
+	mkey   []byte // key from prefix compressed m-block
+	zdec   zdecoder
 	next   unsafe.Pointer // *readbuffers
 }
 
@@ -37,6 +41,9 @@ func (pool *readerpool) getreadbuffer(msize, zsize, vsize int64) *readbuffers {
 					mblock: make([]byte, msize),
 					zblock: make([]byte, zsize),
 					vblock: make([]byte, vsize),
+					zkey:   make([]byte, 0, zsize),
+					mkey:   make([]byte, 0, msize),
+					zdec:   zdecoder{index: -1, key: make([]byte, 0, zsize)},
 				}
 
 			} else if pool.head != nil {
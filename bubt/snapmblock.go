@@ -1,61 +1,166 @@
 package bubt
 
 import "fmt"
-import "bytes"
 import "encoding/binary"
 
+import "github.com/bnclabs/gostore/api"
+
+// msnap methods that compare keys take a kbuf, of mblocksize capacity,
+// to build keys from prefix compressed m-block.
 type msnap []byte
 
 func (m msnap) findkey(
-	adjust int, index blkindex, key []byte) (level byte, fpos int64) {
+	adjust int, index blkindex, key []byte,
+	cmpfn api.Comparator, kbuf []byte) (level byte, fpos int64) {
 
 	//fmt.Printf("mfindkey %v %v %q\n", adjust, len(index), key)
 
+	if pblock(m).isprefixed() {
+		// child block of the last entry <= key, or the left most.
+		i := pblock(m).search(kbuf, m.pkeyat, func(k []byte) bool {
+			return cmpfn(key, k) >= 0
+		})
+		if i > 0 {
+			i--
+		}
+		vpos := m.vposat(i)
+		return byte(vpos >> 56), int64(vpos & 0x00FFFFFFFFFFFFFF)
+	}
+
 	switch len(index) {
 	case 0:
 		panic(fmt.Errorf("impossible situation"))
 
 	case 1:
-		cmp, vpos := m.compareat(adjust, key)
-		if cmp >= 0 { // key >= adjust
-			level, fpos = byte(vpos>>56), int64(vpos&0x00FFFFFFFFFFFFFF)
-			//fmt.Printf("mfindkey %x %x\n", level, fpos)
-			return
-		}
-		//fmt.Println("mfindkey", 0)
-		return 0, 0
+		// if key < adjust, adjust is the left most entry, and its child
+		// block is where entries >= key begin.
+		_, vpos := m.compareat(adjust, key, cmpfn)
+		level, fpos = byte(vpos>>56), int64(vpos&0x00FFFFFFFFFFFFFF)
+		//fmt.Printf("mfindkey %x %x\n", level, fpos)
+		return
 
 	default:
 		half := len(index) / 2
-		cmp, vpos := m.compareat(adjust+half, key)
+		cmp, vpos := m.compareat(adjust+half, key, cmpfn)
 		if cmp == 0 { // key == adjust+half
 			//fmt.Println("mfindkey", "default")
 			return byte(vpos >> 56), int64(vpos & 0x00FFFFFFFFFFFFFF)
 
 		} else if cmp > 0 { // key > adjust+half
-			return m.findkey(adjust+half, index[half:], key)
+			return m.findkey(adjust+half, index[half:], key, cmpfn, kbuf)
 
 		} else if len(index) == 2 || len(index) == 3 {
-			_, vpos := m.compareat(adjust, key)
+			vpos := m.vposat(adjust)
 			return byte(vpos >> 56), int64(vpos & 0x00FFFFFFFFFFFFFF)
 		}
-		return m.findkey(adjust, index[:half], key)
+		return m.findkey(adjust, index[:half], key, cmpfn, kbuf)
 	}
 	panic("unreachable code")
 }
 
-func (m msnap) compareat(i int, key []byte) (int, uint64) {
+// findlt return the child block containing entries that are strictly
+// less than key, return false if there is no such entry.
+func (m msnap) findlt(
+	index blkindex, key []byte,
+	cmpfn api.Comparator, kbuf []byte) (vpos uint64, ok bool) {
+
+	if pblock(m).isprefixed() {
+		i := pblock(m).search(kbuf, m.pkeyat, func(k []byte) bool {
+			return cmpfn(key, k) > 0
+		})
+		if i == 0 {
+			return 0, false
+		}
+		return m.vposat(i - 1), true
+	}
+
+	lo, hi := 0, len(index)
+	for lo < hi { // find first entry >= key
+		mid := (lo + hi) / 2
+		if cmp, _ := m.compareat(mid, key, cmpfn); cmp > 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 {
+		return 0, false
+	}
+	return m.vposat(lo - 1), true
+}
+
+// findgt return the index of the first entry that is strictly greater
+// than key, return count of entries if there is no such entry.
+func (m msnap) findgt(
+	index blkindex, key []byte, cmpfn api.Comparator, kbuf []byte) int {
+
+	if pblock(m).isprefixed() {
+		return pblock(m).search(kbuf, m.pkeyat, func(k []byte) bool {
+			return cmpfn(key, k) >= 0
+		})
+	}
+
+	lo, hi := 0, len(index)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if cmp, _ := m.compareat(mid, key, cmpfn); cmp >= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+func (m msnap) compareat(
+	i int, key []byte, cmpfn api.Comparator) (int, uint64) {
+
 	offset := 4 + (i * 4)
 	x := binary.BigEndian.Uint32(m[offset : offset+4])
 	me := mentry(m[x : x+mentrysize])
 	ln, vpos := uint32(me.keylen()), me.vpos()
 	x += mentrysize
-	cmp := bytes.Compare(key, m[x:x+ln])
+	cmp := cmpfn(key, m[x:x+ln])
 	//fmt.Printf("m.compareat %v %s %s %v\n", i, key, m[x:x+ln], cmp)
 	return cmp, vpos
 }
 
+// pkeyat is keydecoder for prefix compressed m-block.
+func (m msnap) pkeyat(x int, prevkey []byte) ([]byte, int) {
+	var me pmentry
+	next := me.decode(m, x)
+	return append(prevkey[:me.shared], m[me.keyoff:next]...), next
+}
+
+// vposat return the child block pointer for i-th entry.
+func (m msnap) vposat(i int) uint64 {
+	if b := pblock(m); b.isprefixed() {
+		var me pmentry
+		_, x := b.seek(i, nil, func(x int, key []byte) ([]byte, int) {
+			return key, me.decode(m, x)
+		})
+		me.decode(m, x)
+		return me.vpos
+	}
+	offset := 4 + (i * 4)
+	x := binary.BigEndian.Uint32(m[offset : offset+4])
+	return mentry(m[x : x+mentrysize]).vpos()
+}
+
+// count return the number of entries in this block.
+func (m msnap) count() int {
+	return pblock(m).count()
+}
+
+// getindex return offsets of all entries, or offsets of restart points
+// for prefix compressed m-block.
 func (m msnap) getindex(index blkindex) blkindex {
+	if b := pblock(m); b.isprefixed() {
+		for r := 0; r < b.restarts(); r++ {
+			index = append(index, uint32(b.restartat(r)))
+		}
+		return index
+	}
 	nums, n := binary.BigEndian.Uint32(m[:4]), 4
 	for i := uint32(0); i < nums; i++ {
 		index = append(index, binary.BigEndian.Uint32(m[n:n+4]))
@@ -1,6 +1,7 @@
 package bubt
 
 import "fmt"
+import "bytes"
 import "testing"
 
 func BenchmarkMGetIndex(b *testing.B) {
@@ -20,7 +21,7 @@ func BenchmarkMFindkey(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		m.findkey(0, index, keys[i%len(keys)])
+		m.findkey(0, index, keys[i%len(keys)], bytes.Compare, nil)
 	}
 }
 
@@ -9,6 +9,7 @@ import "regexp"
 import "strings"
 import "strconv"
 import "runtime"
+import "sync/atomic"
 import "io/ioutil"
 import "path/filepath"
 
@@ -21,6 +22,10 @@ import s "github.com/bnclabs/gosettings"
 // no writes are allowed on the btree, any number of snapshots can be
 // opened for reading.
 type Snapshot struct {
+	// atomic access, 8-byte aligned
+	cachehits   int64
+	cachemisses int64
+
 	name     string
 	root     int64 // fpos into m-index
 	metadata []byte
@@ -33,17 +38,30 @@ type Snapshot struct {
 	readvs   []io.ReaderAt
 	rw       *flock.RWMutex
 	zsizes   []int64
+	vsizes   []int64
+	filter   *bloom // nil if snapshot is built without bloom filter.
+	tombs    api.Rangetombs
+	cmp      api.Comparator
 
 	// from info block
 	zblocksize int64
 	mblocksize int64
 	vblocksize int64
+	zcodec     string
+	vcodec     string
+	comparator string
+	checksum   bool
+	bloombits  int64
+	restart    int64
+	bloomsize  int64
+	tombsize   int64
 	buildtime  int64
 	epoch      int64
 	seqno      int64
 	keymem     int64
 	valmem     int64
 	paddingmem int64
+	zmem       int64
 	numpaths   int64
 	n_zblocks  int64
 	n_mblocks  int64
@@ -51,12 +69,21 @@ type Snapshot struct {
 	n_ablocks  int64
 	n_count    int64
 	n_deleted  int64
+	vlivemem   []int64 // nil for older snapshots.
 	footprint  int64
 	logprefix  string
 
 	viewcache chan *View
 	curcache  chan *Cursor
 	rdpool    *readerpool
+
+	// block cache
+	mmap      bool
+	cache     *Blockcache
+	mfileid   uint64
+	zfileids  []uint64
+	pinlevels int64
+	pinned    map[int64][]byte // fpos -> m-block
 }
 
 // OpenSnapshot from paths.
@@ -66,6 +93,7 @@ func OpenSnapshot(
 	max := runtime.GOMAXPROCS(-1) * 4
 	snap = &Snapshot{
 		name:      name,
+		mmap:      mmap,
 		viewcache: make(chan *View, max),
 		curcache:  make(chan *Cursor, max),
 		logprefix: fmt.Sprintf("BUBT [%s]", name),
@@ -83,6 +111,14 @@ func OpenSnapshot(
 	if _, err = snap.readheader(snap.readm); err != nil {
 		return
 	}
+	if snap.filter, snap.bloomsize, err = readbloom(snap.readm); err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return
+	}
+	if snap.tombs, snap.tombsize, err = readrangetombs(snap.readm); err != nil {
+		errorf("%v %v", snap.logprefix, err)
+		return
+	}
 	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
 	snap.rdpool = newreaderpool(msize, zsize, vsize, int64(max))
 
@@ -110,6 +146,58 @@ func PurgeSnapshot(name string, paths []string) {
 	}
 }
 
+// Setblockcache share cache with this snapshot, to cache m-blocks and
+// z-blocks read from disk, effective only if the snapshot is opened
+// with mmap as false. Additionally, if pinlevels is > 0, m-blocks from
+// top pinlevels of the m-index are read once and pinned in memory till
+// the snapshot is closed, outside the capacity of cache. Shall be
+// called before reading from the snapshot.
+func (snap *Snapshot) Setblockcache(
+	cache *Blockcache, pinlevels int64) error {
+
+	if snap.mmap || cache == nil {
+		return nil
+	}
+	snap.cache, snap.pinlevels = cache, pinlevels
+	snap.mfileid = cache.newfileid()
+	snap.zfileids = make([]uint64, len(snap.readzs))
+	for i := range snap.zfileids {
+		snap.zfileids[i] = cache.newfileid()
+	}
+	return snap.pinmblocks()
+}
+
+// pinmblocks read m-blocks from top pinlevels of the m-index.
+func (snap *Snapshot) pinmblocks() error {
+	if snap.pinlevels <= 0 || snap.n_count == 0 {
+		return nil
+	}
+	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
+	buf := snap.rdpool.getreadbuffer(msize, zsize, vsize)
+	defer snap.rdpool.putreadbuffer(buf)
+
+	pinned, fposs := map[int64][]byte{}, []int64{snap.root}
+	for level := int64(0); level < snap.pinlevels && len(fposs) > 0; level++ {
+		children := []int64{}
+		for _, fpos := range fposs {
+			if err := snap.loadmblock(fpos, buf.mblock); err != nil {
+				errorf("%v pinmblocks: %v", snap.logprefix, err)
+				return err
+			}
+			pinned[fpos] = append([]byte(nil), buf.mblock...)
+			m := msnap(buf.mblock)
+			for i := 0; i < m.count(); i++ {
+				if vpos := m.vposat(i); byte(vpos>>56) == 0 { // m-block
+					children = append(children, int64(vpos))
+				}
+			}
+		}
+		fposs = children
+	}
+	snap.pinned = pinned
+	return nil
+}
+
 func (snap *Snapshot) loadreaders(
 	name string, paths []string, mmap bool) error {
 
@@ -205,12 +293,49 @@ func (snap *Snapshot) readheader(r io.ReaderAt) (*Snapshot, error) {
 	snap.zblocksize = info.Int64("zblocksize")
 	snap.mblocksize = info.Int64("mblocksize")
 	snap.vblocksize = info.Int64("vblocksize")
+	snap.zcodec, snap.vcodec = CodecNone, CodecNone
+	if _, ok := info["zcodec"]; ok { // older snapshots are not compressed.
+		snap.zcodec, snap.vcodec = info.String("zcodec"), info.String("vcodec")
+	}
+	if err := validatecodec(snap.zcodec); err != nil {
+		errorf("%v Read infoblock: %v", snap.logprefix, err)
+		return snap, err
+	} else if err := validatecodec(snap.vcodec); err != nil {
+		errorf("%v Read infoblock: %v", snap.logprefix, err)
+		return snap, err
+	}
+	if _, ok := info["checksum"]; ok { // older snapshots have no checksum.
+		if x := info.String("checksum"); x != ChecksumCRC32C {
+			err := fmt.Errorf("bubt.snap.invalidchecksum %q", x)
+			errorf("%v Read infoblock: %v", snap.logprefix, err)
+			return snap, err
+		}
+		snap.checksum = true
+	}
+	if _, ok := info["bloombits"]; ok {
+		snap.bloombits = info.Int64("bloombits")
+	}
+	if _, ok := info["restart"]; ok {
+		snap.restart = info.Int64("restart")
+	}
+	snap.comparator = api.Binarycomparator
+	if _, ok := info["comparator"]; ok { // older snapshots are binary.
+		snap.comparator = info.String("comparator")
+	}
+	if snap.cmp, err = api.Getcomparator(snap.comparator); err != nil {
+		errorf("%v Read infoblock: %v", snap.logprefix, err)
+		return snap, err
+	}
 	snap.buildtime = info.Int64("buildtime")
 	snap.epoch = info.Int64("epoch")
 	snap.seqno = info.Int64("seqno")
 	snap.keymem = info.Int64("keymem")
 	snap.valmem = info.Int64("valmem")
 	snap.paddingmem = info.Int64("paddingmem")
+	snap.zmem = snap.zblocksize * info.Int64("n_zblocks")
+	if _, ok := info["zmem"]; ok {
+		snap.zmem = info.Int64("zmem")
+	}
 	snap.numpaths = info.Int64("numpaths")
 	snap.n_zblocks = info.Int64("n_zblocks")
 	snap.n_mblocks = info.Int64("n_mblocks")
@@ -218,6 +343,16 @@ func (snap *Snapshot) readheader(r io.ReaderAt) (*Snapshot, error) {
 	snap.n_ablocks = info.Int64("n_ablocks")
 	snap.n_count = info.Int64("n_count")
 	snap.n_deleted = info.Int64("n_deleted")
+	if _, ok := info["vlivemem"]; ok { // older snapshots don't track.
+		for _, x := range info.Strings("vlivemem") {
+			vlive, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				errorf("%v Read infoblock: %v", snap.logprefix, err)
+				return snap, err
+			}
+			snap.vlivemem = append(snap.vlivemem, vlive)
+		}
+	}
 
 	snap.root = fpos - snap.mblocksize
 	return snap, nil
@@ -248,17 +383,56 @@ func (snap *Snapshot) Getseqno() uint64 {
 func (snap *Snapshot) diskfootprint() int64 {
 	footprint := filesize(snap.readm)
 	snap.zsizes = make([]int64, len(snap.readzs))
+	snap.vsizes = make([]int64, len(snap.readvs))
 	for i := range snap.zfiles {
 		zsize := filesize(snap.readzs[i])
 		footprint += zsize
 		if len(snap.readvs) > 0 {
-			footprint += filesize(snap.readvs[i])
+			snap.vsizes[i] = filesize(snap.readvs[i])
+			footprint += snap.vsizes[i]
 		}
 		snap.zsizes[i] = zsize
 	}
 	return footprint
 }
 
+// Valuelogratios return the fraction of each value log, refer
+// Valuelogs, that is still referred by this snapshot. Rest of the
+// value log is garbage left behind by values that were updated or
+// deleted after being appended. Return nil if snapshot has no value
+// logs, or if snapshot was built without tracking live values.
+func (snap *Snapshot) Valuelogratios() []float64 {
+	if len(snap.vlivemem) == 0 || len(snap.vlivemem) != len(snap.vsizes) {
+		return nil
+	}
+	ratios := make([]float64, len(snap.vsizes))
+	for i, vsize := range snap.vsizes {
+		ratios[i] = 1
+		if vsize -= MarkerBlocksize; vsize > 0 {
+			ratios[i] = float64(snap.vlivemem[i]) / float64(vsize)
+		}
+		if ratios[i] > 1 { // compressed values are accounted in full.
+			ratios[i] = 1
+		}
+	}
+	return ratios
+}
+
+// vlogreclaimable return total bytes in value logs that are no more
+// referred by this snapshot.
+func (snap *Snapshot) vlogreclaimable() (vlivemem, reclaimable int64) {
+	if len(snap.vlivemem) != len(snap.vsizes) {
+		return 0, 0
+	}
+	for i, vsize := range snap.vsizes {
+		vlivemem += snap.vlivemem[i]
+		if x := vsize - MarkerBlocksize - snap.vlivemem[i]; x > 0 {
+			reclaimable += x
+		}
+	}
+	return vlivemem, reclaimable
+}
+
 func (snap *Snapshot) Valuelogs() []string {
 	if snap.vblocksize > 0 && len(snap.vfiles) > 0 {
 		return snap.vfiles
@@ -266,6 +440,12 @@ func (snap *Snapshot) Valuelogs() []string {
 	return nil
 }
 
+// Rangetombs return range tombstones persisted in this snapshot.
+// Returned list must not be modified.
+func (snap *Snapshot) Rangetombs() api.Rangetombs {
+	return snap.tombs
+}
+
 // Metadata return metadata blob associated with this snapshot.
 func (snap *Snapshot) Metadata() []byte {
 	return snap.metadata
@@ -280,12 +460,23 @@ func (snap *Snapshot) Metadata() []byte {
 //   zblocksize : block size used for z-index file.
 //   mblocksize : block size used for m-index file.
 //   vblocksize : block size used for value log.
+//   zcodec     : codec used to compress z-blocks.
+//   vcodec     : codec used to compress values in value log.
+//   comparator : name of the comparator used to sort keys.
+//   checksum   : checksum used for blocks, empty for older snapshots.
+//   bloombits  : bits per key used for bloom filter, 0 if not built.
+//   bloomsize  : bytes on disk for bloom filter.
+//   restart    : restart interval for prefix compressed keys, 0 if keys
+//                are stored in full.
+//   rangetombs : number of range tombstones persisted.
+//   tombsize   : bytes on disk for range tombstones.
 //   buildtime  : time taken, in nanoseconds, to build this snapshot.
 //   epoch      : snapshot born time, in nanosec, after January 1, 1970 UTC.
 //   seqno      : maximum seqno contained in this snapshot.
 //   keymem     : total payload size for all keys.
 //   valmem     : total payload size for all values.
 //   paddingmem : total bytes used for padding m-block and z-block alignment.
+//   zmem       : total bytes on disk for z-blocks, after compression.
 //   numpaths   : number of paths for this instance.
 //   n_zblocks  : total number of blocks in z-index files.
 //   n_mblocks  : total number of blocks in m-index files.
@@ -294,7 +485,15 @@ func (snap *Snapshot) Metadata() []byte {
 //   n_count    : number of entries in this snapshot, includes deleted.
 //   n_deleted  : number of entries marked as deleted.
 //   footprint  : disk footprint for this snapshot.
+//   n_hits     : number of block reads served from block cache,
+//                including pinned m-blocks.
+//   n_misses   : number of block reads that missed the block cache.
+//   n_pinned   : number of m-blocks pinned in memory.
+//   vlivemem   : bytes in value logs referred by this snapshot.
+//   vreclaim   : bytes in value logs no more referred by this snapshot,
+//                can be reclaimed by rewriting value logs.
 func (snap *Snapshot) Info() s.Settings {
+	vlivemem, vreclaim := snap.vlogreclaimable()
 	return s.Settings{
 		"mfile":      snap.mfile,
 		"zfiles":     snap.zfiles,
@@ -302,12 +501,22 @@ func (snap *Snapshot) Info() s.Settings {
 		"zblocksize": snap.zblocksize,
 		"mblocksize": snap.mblocksize,
 		"vblocksize": snap.vblocksize,
+		"zcodec":     snap.zcodec,
+		"vcodec":     snap.vcodec,
+		"comparator": snap.comparator,
+		"checksum":   snap.checksumname(),
+		"bloombits":  snap.bloombits,
+		"bloomsize":  snap.bloomsize,
+		"restart":    snap.restart,
+		"rangetombs": len(snap.tombs),
+		"tombsize":   snap.tombsize,
 		"buildtime":  snap.buildtime,
 		"epoch":      snap.epoch,
 		"seqno":      snap.seqno,
 		"keymem":     snap.keymem,
 		"valmem":     snap.valmem,
 		"paddingmem": snap.paddingmem,
+		"zmem":       snap.zmem,
 		"numpaths":   snap.numpaths,
 		"n_zblocks":  snap.n_zblocks,
 		"n_mblocks":  snap.n_mblocks,
@@ -316,7 +525,19 @@ func (snap *Snapshot) Info() s.Settings {
 		"n_count":    snap.n_count,
 		"n_deleted":  snap.n_deleted,
 		"footprint":  snap.footprint,
+		"n_hits":     atomic.LoadInt64(&snap.cachehits),
+		"n_misses":   atomic.LoadInt64(&snap.cachemisses),
+		"n_pinned":   len(snap.pinned),
+		"vlivemem":   vlivemem,
+		"vreclaim":   vreclaim,
+	}
+}
+
+func (snap *Snapshot) checksumname() string {
+	if snap.checksum {
+		return ChecksumCRC32C
 	}
+	return ""
 }
 
 // Log vital information
@@ -345,6 +566,35 @@ func (snap *Snapshot) Log() {
 	vsize := info.Int64("vblocksize")
 	infof(fmsg, snap.logprefix, zsize, msize, vsize)
 
+	fmsg = "%v z-codec:%v v-codec:%v, %v bytes of z-blocks on disk"
+	zcodec, vcodec := info.String("zcodec"), info.String("vcodec")
+	infof(fmsg, snap.logprefix, zcodec, vcodec, info.Int64("zmem"))
+
+	if bloombits := info.Int64("bloombits"); bloombits > 0 {
+		fmsg = "%v bloom filter with %v bits per key, %v bytes on disk"
+		infof(fmsg, snap.logprefix, bloombits, info.Int64("bloomsize"))
+	}
+	if restart := info.Int64("restart"); restart > 0 {
+		fmsg = "%v keys prefix compressed with restart interval %v"
+		infof(fmsg, snap.logprefix, restart)
+	}
+	if n := info.Int64("rangetombs"); n > 0 {
+		fmsg = "%v %v range tombstones, %v bytes on disk"
+		infof(fmsg, snap.logprefix, n, info.Int64("tombsize"))
+	}
+
+	if len(snap.vsizes) > 0 {
+		fmsg = "%v value logs have %v live bytes, %v bytes reclaimable"
+		vlive, vreclaim := info.Int64("vlivemem"), info.Int64("vreclaim")
+		infof(fmsg, snap.logprefix, vlive, vreclaim)
+	}
+
+	if snap.cache != nil {
+		fmsg = "%v block cache hits:%v misses:%v, %v m-blocks pinned"
+		hits, misses := info.Int64("n_hits"), info.Int64("n_misses")
+		infof(fmsg, snap.logprefix, hits, misses, info.Int64("n_pinned"))
+	}
+
 	fmsg = "%v built at %v, took %v to build -- {m:%v, z:%v, a: %v, v:%v}"
 	epoch := time.Unix(info.Int64("epoch"), 0)
 	took := time.Duration(info.Int64("buildtime")).Round(time.Second)
@@ -379,7 +629,7 @@ func (snap *Snapshot) Validate() {
 			valmem += int64(len(val))
 		}
 		if len(prevkey) > 0 {
-			if bytes.Compare(prevkey, key) >= 0 {
+			if snap.cmp(prevkey, key) >= 0 {
 				fmsg := "%v key %q comes before %q"
 				panic(fmt.Errorf(fmsg, snap.name, prevkey, key))
 			}
@@ -409,6 +659,63 @@ func (snap *Snapshot) Validate() {
 	snap.validatequick()
 }
 
+// Scrub walk every m-block, z-block and value-log entry of this
+// snapshot, verifying their checksum. Return the list of corrupt
+// blocks, an empty list if snapshot is intact. This is a costly call,
+// use it only for administration purpose.
+func (snap *Snapshot) Scrub() []*CorruptError {
+	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
+	buf := snap.rdpool.getreadbuffer(msize, zsize, vsize)
+	defer snap.rdpool.putreadbuffer(buf)
+
+	corrupts := []*CorruptError{}
+	report := func(file string, fpos int64, block string, err error) {
+		if cerr, ok := err.(*CorruptError); ok {
+			corrupts = append(corrupts, cerr)
+			return
+		}
+		cerr := &CorruptError{
+			File: file, Fpos: fpos, Block: block, Reason: err.Error(),
+		}
+		corrupts = append(corrupts, cerr)
+	}
+
+	for fpos := int64(0); fpos < snap.n_mblocks*msize; fpos += msize {
+		if err := snap.loadmblock(fpos, buf.mblock); err != nil {
+			report(snap.mfile, fpos, "m-block", err)
+		}
+	}
+
+	for i := range snap.readzs {
+		shardidx := byte(i)
+		fpos, till := int64(0), snap.zsizes[i]-MarkerBlocksize
+		for fpos < till {
+			znext, err := snap.loadzblock(shardidx, fpos, buf)
+			if err != nil && snap.zcodec != CodecNone {
+				// position of next compressed block is not known.
+				report(snap.zfiles[i], fpos, "z-block", err)
+				break
+			} else if err != nil {
+				report(snap.zfiles[i], fpos, "z-block", err)
+				fpos += snap.zblocksize
+				continue
+			}
+			// verify values referred by this z-block.
+			z := zsnap(buf.zblock)
+			for index := 0; z.isbounded(index); index++ {
+				_, lv, _, _ := z.entryat(index, buf.zkey)
+				_, buf.vblock, err = lv.getactual(snap, buf.vblock)
+				if err != nil {
+					vfile := snap.vfiles[lv.shardidx-1]
+					report(vfile, lv.fpos, "vlog-entry", err)
+				}
+			}
+			fpos = znext
+		}
+	}
+	return corrupts
+}
+
 func (snap *Snapshot) validatequick() {
 	// validate epoch
 	epochtm, now := time.Unix(0, snap.epoch), time.Now()
@@ -417,10 +724,11 @@ func (snap *Snapshot) validatequick() {
 		panic(fmt.Errorf(fmsg, epochtm, now))
 	}
 	// validate footprint
-	computed := (snap.n_zblocks * snap.zblocksize)
+	computed := snap.zmem
 	computed += (snap.n_mblocks * snap.mblocksize)
 	computed += (snap.n_vblocks * snap.vblocksize)
 	computed += MarkerBlocksize + MarkerBlocksize /*infoblock*/
+	computed += snap.bloomsize + snap.tombsize
 	ln := int64(len(snap.metadata))
 	computed += (((ln - 1) / snap.mblocksize) + 1) * snap.mblocksize
 	computed += MarkerBlocksize * int64(len(snap.readzs))
@@ -462,6 +770,13 @@ func (snap *Snapshot) Close() {
 			errorf("%v close: %q: %v", snap.logprefix, snap.vfiles[i], err)
 		}
 	}
+	if snap.cache != nil {
+		snap.cache.purge(snap.mfileid)
+		for _, zfileid := range snap.zfileids {
+			snap.cache.purge(zfileid)
+		}
+		snap.pinned = nil
+	}
 	if snap.rw != nil {
 		snap.rw.RUnlock()
 	}
@@ -513,76 +828,329 @@ func (snap *Snapshot) Destroy() {
 // Get value for key, if value argument is not nil it will be used to
 // copy the entry's value. Also returns entry's cas, whether entry is
 // marked as deleted by LSM. If ok is false, then key is not found.
+// Disk errors and blocks that fail their checksum on the lookup path
+// are logged and key is reported as not found, use GetE to handle them
+// as *CorruptError. If snapshot has a bloom filter, lookup for missing
+// keys are mostly answered without reading the disk. Keys covered by a
+// range tombstone are returned as deleted, with the seqno of range
+// tombstone as cas, even if they are not found.
 func (snap *Snapshot) Get(
 	key, value []byte) (actualvalue []byte, cas uint64, deleted, ok bool) {
 
+	var err error
+	actualvalue, cas, _, deleted, ok, err = snap.getexpiry(key, value)
+	if err != nil {
+		errorf("%v Get(%q): %v", snap.logprefix, key, err)
+		return actualvalue, 0, false, false
+	}
+	return actualvalue, cas, deleted, ok
+}
+
+// GetE is same as Get, except that disk errors, partial reads and
+// checksum failures are returned as error instead of panic. Corrupt
+// blocks are returned as *CorruptError.
+func (snap *Snapshot) GetE(
+	key, value []byte) (
+	actualvalue []byte, cas uint64, deleted, ok bool, err error) {
+
+	actualvalue, cas, _, deleted, ok, err = snap.getexpiry(key, value)
+	return actualvalue, cas, deleted, ok, err
+}
+
+// Getexpiry is same as GetE, additionally return entry's expiry in unix
+// seconds, ZERO if entry never expires.
+func (snap *Snapshot) Getexpiry(
+	key, value []byte) (
+	actualvalue []byte, cas, expiry uint64, deleted, ok bool, err error) {
+
+	return snap.getexpiry(key, value)
+}
+
+func (snap *Snapshot) getexpiry(
+	key, value []byte) (
+	actualvalue []byte, cas, expiry uint64, deleted, ok bool, err error) {
+
+	actualvalue, cas, expiry, deleted, ok, err = snap.lookup(key, value)
+	if err == nil && ok == false && len(snap.tombs) > 0 {
+		// entries covered by range tombstones are dropped while building
+		// the snapshot, hence an entry if found is newer than them.
+		if tombseqno, covered := snap.tombs.Covers(key, 0, snap.cmp); covered {
+			return actualvalue, tombseqno, 0, true, true, nil
+		}
+	}
+	return actualvalue, cas, expiry, deleted, ok, err
+}
+
+func (snap *Snapshot) lookup(
+	key, value []byte) (
+	actualvalue []byte, cas, expiry uint64, deleted, ok bool, err error) {
+
+	var index int
 	var wkey []byte
 	var lv lazyvalue
 	var v []byte
 
+	if snap.filter != nil && snap.filter.mayhave(key) == false {
+		return nil, 0, 0, false, false, nil
+	}
+
 	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
 	buf := snap.rdpool.getreadbuffer(msize, zsize, vsize)
+	defer snap.rdpool.putreadbuffer(buf)
 
-	shardidx, fpos := snap.findinmblock(key, buf)
-	_, wkey, lv, cas, deleted, ok = snap.findinzblock(shardidx, fpos, key, buf)
+	shardidx, fpos, err := snap.findinmblock(key, buf)
+	if err != nil {
+		return nil, 0, 0, false, false, err
+	}
+	index, wkey, lv, cas, deleted, ok, err =
+		snap.findinzblock(shardidx, fpos, key, buf)
+	if err != nil {
+		return nil, 0, 0, false, false, err
+	}
 
 	cmp := bytes.Compare(wkey, key)
 	if cmp == 0 && value != nil {
-		v, buf.vblock = lv.getactual(snap, buf.vblock)
+		v, buf.vblock, err = lv.getactual(snap, buf.vblock)
+		if err != nil {
+			return nil, 0, 0, false, false, err
+		}
 		actualvalue = lib.Fixbuffer(value, int64(len(v)))
 		copy(actualvalue, v)
 	}
-
-	snap.rdpool.putreadbuffer(buf)
-	return actualvalue, cas, deleted, ok
+	if ok {
+		expiry = zsnap(buf.zblock).expiryat(index)
+	}
+	return actualvalue, cas, expiry, deleted, ok, nil
 }
 
-func (snap *Snapshot) findinmblock(
-	key []byte, buf *readbuffers) (shardidx byte, fpos int64) {
+// readmblock at fpos into buf.mblock, from pinned m-blocks or block
+// cache if available, else from disk.
+func (snap *Snapshot) readmblock(fpos int64, buf *readbuffers) error {
+	if snap.cache == nil {
+		return snap.loadmblock(fpos, buf.mblock)
+	} else if block, ok := snap.pinned[fpos]; ok {
+		atomic.AddInt64(&snap.cachehits, 1)
+		copy(buf.mblock, block)
+		return nil
+	} else if _, ok := snap.cache.get(snap.mfileid, fpos, buf.mblock); ok {
+		atomic.AddInt64(&snap.cachehits, 1)
+		return nil
+	}
+	atomic.AddInt64(&snap.cachemisses, 1)
+	if err := snap.loadmblock(fpos, buf.mblock); err != nil {
+		return err
+	}
+	snap.cache.put(snap.mfileid, fpos, buf.mblock, fpos+snap.mblocksize)
+	return nil
+}
 
-	mblock := buf.mblock
-	n, err := snap.readm.ReadAt(mblock, snap.root)
+// loadmblock at fpos from disk into mblock, and verify its checksum.
+func (snap *Snapshot) loadmblock(fpos int64, mblock []byte) error {
+	n, err := snap.readm.ReadAt(mblock, fpos)
 	if err != nil {
-		panic(err)
+		return err
 	} else if n < len(mblock) {
-		panic(fmt.Errorf("bubt.snap.mblock.partialread"))
+		return &CorruptError{
+			File: snap.mfile, Fpos: fpos, Block: "m-block",
+			Reason: "partial read",
+		}
+	} else if snap.checksum && checkblockcrc(mblock) == false {
+		return &CorruptError{
+			File: snap.mfile, Fpos: fpos, Block: "m-block",
+			Reason: "checksum mismatch",
+		}
 	}
-	m, mbindex := msnap(mblock), buf.index[:0]
-	mbindex = m.getindex(mbindex[:0])
-	shardidx, fpos = m.findkey(0, mbindex, key)
+	return nil
+}
+
+func (snap *Snapshot) findinmblock(
+	key []byte, buf *readbuffers) (shardidx byte, fpos int64, err error) {
+
+	fpos = snap.root
 	for shardidx == 0 {
-		n, err = snap.readm.ReadAt(mblock, fpos)
-		if err != nil {
-			panic(err)
-		} else if n < len(mblock) {
-			panic(fmt.Errorf("bubt.snap.mblock.partialread"))
+		if err = snap.readmblock(fpos, buf); err != nil {
+			return 0, 0, err
 		}
-		m, mbindex = msnap(mblock), m.getindex(mbindex[:0])
-		shardidx, fpos = m.findkey(0, mbindex, key)
+		m, mbindex := msnap(buf.mblock), buf.index[:0]
+		mbindex = m.getindex(mbindex[:0])
+		shardidx, fpos = m.findkey(0, mbindex, key, snap.cmp, buf.mkey)
 	}
-	return shardidx - 1, fpos
+	return shardidx - 1, fpos, nil
+}
+
+// findlastinmblock return the last z-block in the index.
+func (snap *Snapshot) findlastinmblock(
+	buf *readbuffers) (shardidx byte, fpos int64, err error) {
+
+	fpos = snap.root
+	for shardidx == 0 {
+		if err = snap.readmblock(fpos, buf); err != nil {
+			return 0, 0, err
+		}
+		m := msnap(buf.mblock)
+		vpos := m.vposat(m.count() - 1)
+		shardidx, fpos = byte(vpos>>56), int64(vpos&0x00FFFFFFFFFFFFFF)
+	}
+	return shardidx - 1, fpos, nil
+}
+
+// findltinmblock return the z-block that contains the entry just before
+// key, return false if key is the first entry in the index.
+func (snap *Snapshot) findltinmblock(
+	key []byte, buf *readbuffers) (
+	shardidx byte, fpos int64, ok bool, err error) {
+
+	var vpos uint64
+
+	fpos = snap.root
+	for shardidx == 0 {
+		if err = snap.readmblock(fpos, buf); err != nil {
+			return 0, 0, false, err
+		}
+		m, mbindex := msnap(buf.mblock), buf.index[:0]
+		mbindex = m.getindex(mbindex[:0])
+		if vpos, ok = m.findlt(mbindex, key, snap.cmp, buf.mkey); !ok {
+			return 0, 0, false, nil
+		}
+		shardidx, fpos = byte(vpos>>56), int64(vpos&0x00FFFFFFFFFFFFFF)
+	}
+	return shardidx - 1, fpos, true, nil
 }
 
 func (snap *Snapshot) findinzblock(
 	shardidx byte, fpos int64,
 	key []byte, buf *readbuffers) (
-	index int, k []byte, lv lazyvalue, cas uint64, deleted, ok bool) {
+	index int, k []byte, lv lazyvalue, cas uint64, deleted, ok bool,
+	err error) {
 
-	zblock := buf.zblock
-	readz := snap.readzs[shardidx]
-	n, err := readz.ReadAt(zblock, fpos)
-	if err != nil {
-		panic(err)
-	} else if n < len(zblock) {
-		panic(fmt.Errorf("bubt.snap.zblock.partialread"))
+	if _, err = snap.readzblock(shardidx, fpos, buf); err != nil {
+		return
 	}
-	z, zbindex := zsnap(zblock), buf.index[:0]
+	z, zbindex := zsnap(buf.zblock), buf.index[:0]
 	zbindex = z.getindex(zbindex[:0])
-	index, k, lv, cas, deleted, ok = z.findkey(0, zbindex, key)
+	index, k, lv, cas, deleted, ok =
+		z.findkey(0, zbindex, key, snap.cmp, buf.zkey)
 
 	return
 }
 
+// findnextinmblock return the z-block that follows the z-block
+// containing key, return false if it is the last z-block in the index.
+func (snap *Snapshot) findnextinmblock(
+	key []byte, buf *readbuffers) (
+	shardidx byte, fpos int64, ok bool, err error) {
+
+	var nextvpos uint64
+
+	fpos = snap.root
+	for shardidx == 0 {
+		if err = snap.readmblock(fpos, buf); err != nil {
+			return 0, 0, false, err
+		}
+		m, mbindex := msnap(buf.mblock), buf.index[:0]
+		mbindex = m.getindex(mbindex[:0])
+		// remember the sibling of the deepest child that contains key.
+		i := m.findgt(mbindex, key, snap.cmp, buf.mkey)
+		if i < m.count() {
+			nextvpos = m.vposat(i)
+			ok = true
+		}
+		if i == 0 {
+			i = 1
+		}
+		vpos := m.vposat(i - 1)
+		shardidx, fpos = byte(vpos>>56), int64(vpos&0x00FFFFFFFFFFFFFF)
+	}
+	if ok == false {
+		return 0, 0, false, nil
+	}
+
+	// left most z-block under the sibling.
+	shardidx = byte(nextvpos >> 56)
+	fpos = int64(nextvpos & 0x00FFFFFFFFFFFFFF)
+	for shardidx == 0 {
+		if err = snap.readmblock(fpos, buf); err != nil {
+			return 0, 0, false, err
+		}
+		vpos := msnap(buf.mblock).vposat(0)
+		shardidx, fpos = byte(vpos>>56), int64(vpos&0x00FFFFFFFFFFFFFF)
+	}
+	return shardidx - 1, fpos, true, nil
+}
+
+// readzblock at fpos from shard into buf.zblock, from block cache if
+// available, else from disk. Return file position of the next z-block
+// in the same shard.
+func (snap *Snapshot) readzblock(
+	shardidx byte, fpos int64, buf *readbuffers) (int64, error) {
+
+	buf.zdec.reset()
+	if snap.cache == nil {
+		return snap.loadzblock(shardidx, fpos, buf)
+	}
+	fileid := snap.zfileids[shardidx]
+	if znext, ok := snap.cache.get(fileid, fpos, buf.zblock); ok {
+		atomic.AddInt64(&snap.cachehits, 1)
+		return znext, nil
+	}
+	atomic.AddInt64(&snap.cachemisses, 1)
+	znext, err := snap.loadzblock(shardidx, fpos, buf)
+	if err != nil {
+		return znext, err
+	}
+	snap.cache.put(fileid, fpos, buf.zblock, znext)
+	return znext, nil
+}
+
+// loadzblock at fpos from shard into buf.zblock, decompress the block
+// if z-blocks are compressed and verify its checksum. Return file
+// position of the next z-block in the same shard.
+func (snap *Snapshot) loadzblock(
+	shardidx byte, fpos int64, buf *readbuffers) (int64, error) {
+
+	zblock, readz := buf.zblock, snap.readzs[shardidx]
+	if snap.zcodec == CodecNone {
+		n, err := readz.ReadAt(zblock, fpos)
+		if err != nil {
+			return -1, err
+		} else if n < len(zblock) {
+			return -1, snap.zcorrupt(shardidx, fpos, "partial read")
+		} else if snap.checksum && checkblockcrc(zblock) == false {
+			return -1, snap.zcorrupt(shardidx, fpos, "checksum mismatch")
+		}
+		return fpos + snap.zblocksize, nil
+	}
+
+	// compressed block is prefixed with its length, it is read in one
+	// go along with the bytes that follow, file ends with marker block.
+	till := snap.zsizes[shardidx] - fpos
+	ln := int64(compressbound(snap.zcodec, int(snap.zblocksize)))
+	if ln > till {
+		ln = till
+	}
+	buf.zcomp = lib.Fixbuffer(buf.zcomp, ln)
+	n, err := readz.ReadAt(buf.zcomp, fpos)
+	if err != nil && err != io.EOF {
+		return -1, err
+	}
+	m, err := decompressblock(snap.zcodec, zblock, buf.zcomp[:n])
+	if err != nil {
+		return -1, snap.zcorrupt(shardidx, fpos, err.Error())
+	} else if snap.checksum && checkblockcrc(zblock) == false {
+		return -1, snap.zcorrupt(shardidx, fpos, "checksum mismatch")
+	}
+	return fpos + int64(m), nil
+}
+
+func (snap *Snapshot) zcorrupt(
+	shardidx byte, fpos int64, reason string) *CorruptError {
+
+	return &CorruptError{
+		File: snap.zfiles[shardidx], Fpos: fpos, Block: "z-block",
+		Reason: reason,
+	}
+}
+
 // BeginTxn is not allowed.
 func (snap *Snapshot) BeginTxn(id uint64) api.Transactor {
 	panic("not allowed")
@@ -601,21 +1169,31 @@ func (snap *Snapshot) abortview(view *View) error {
 
 // Scan return a full table iterator, if iteration is stopped before
 // reaching end of table (io.EOF), application should call iterator
-// with fin as true. EG: iter(true)
+// with fin as true. EG: iter(true). Return nil if cursor could not be
+// opened on the snapshot, use ScanE to get the error.
 func (snap *Snapshot) Scan() api.Iterator {
+	iter, err := snap.ScanE()
+	if err != nil {
+		fmsg := "%v Scan(): %v"
+		errorf(fmsg, snap.logprefix, err)
+		return nil
+	}
+	return iter
+}
+
+// ScanE is same as Scan, except that it return the error, like a
+// *CorruptError, if cursor could not be opened on the snapshot.
+func (snap *Snapshot) ScanE() (api.Iterator, error) {
 	view := snap.getview(0xC0FFEE)
 	cur, err := view.OpenCursor(nil)
 	if err != nil {
 		view.Abort()
-		fmsg := "%v view(%v).OpenCursor(nil): %v"
-		errorf(fmsg, snap.logprefix, view.id, err)
-		return nil
+		return nil, err
 
 	} else if cur == nil {
 		view.Abort()
-		fmsg := "%v view(%v).OpenCursor(nil) cursor is nil"
-		errorf(fmsg, snap.logprefix, view.id)
-		return nil
+		fmsg := "view(%v).OpenCursor(nil) cursor is nil"
+		return nil, fmt.Errorf(fmsg, view.id)
 	}
 
 	var key, value []byte
@@ -637,7 +1215,103 @@ func (snap *Snapshot) Scan() api.Iterator {
 			return nil, nil, 0, false, err
 		}
 		return key, value, seqno, deleted, err
+	}, nil
+}
+
+// Range return an iterator over entries whose key falls between low
+// and high, incl can be "none", "low", "high" or "both". A nil bound is
+// treated as unbounded and if reverse is true, entries are iterated in
+// descending order. Cursor is positioned using the m-index, and z-blocks
+// are read only till the end of range. If iteration is stopped before
+// reaching end of range (io.EOF), application should call iterator with
+// fin as true. EG: iter(true). Return nil if cursor could not be opened
+// on the snapshot, use RangeE to get the error.
+func (snap *Snapshot) Range(
+	low, high []byte, incl string, reverse bool) api.Iterator {
+
+	iter, err := snap.RangeE(low, high, incl, reverse)
+	if err != nil {
+		fmsg := "%v Range(%q, %q, %v): %v"
+		errorf(fmsg, snap.logprefix, low, high, reverse, err)
+		return nil
 	}
+	return iter
+}
+
+// RangeE is same as Range, except that it return the error, like a
+// *CorruptError, if cursor could not be opened on the snapshot. Return
+// nil iterator, without error, if snapshot is empty.
+func (snap *Snapshot) RangeE(
+	low, high []byte, incl string, reverse bool) (api.Iterator, error) {
+
+	lowincl, highincl, err := api.Rangeincl(incl)
+	if err != nil {
+		panic(err)
+	} else if snap.n_count == 0 {
+		return nil, nil
+	}
+	if low != nil {
+		low = append(make([]byte, 0, len(low)), low...)
+	}
+	if high != nil {
+		high = append(make([]byte, 0, len(high)), high...)
+	}
+
+	view := snap.getview(0xC0FFEE)
+	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
+	buf := snap.rdpool.getreadbuffer(msize, zsize, vsize)
+	cur := view.getcursor()
+	if reverse {
+		_, err = cur.openreverse(snap, high, buf)
+	} else {
+		_, err = cur.opencursor(snap, low, buf)
+	}
+	if err != nil {
+		view.Abort()
+		return nil, err
+	}
+
+	var key, value []byte
+	var lv lazyvalue
+	var seqno uint64
+	var deleted bool
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if err != nil {
+			return nil, nil, 0, false, err
+
+		} else if fin {
+			err = io.EOF
+			view.Abort()
+			return nil, nil, 0, false, err
+		}
+
+		for {
+			if reverse {
+				key, lv, seqno, deleted, err = cur.yprev(false /*fin*/)
+			} else {
+				key, lv, seqno, deleted, err = cur.ynextentry(false /*fin*/)
+			}
+			if err != nil {
+				view.Abort()
+				return nil, nil, 0, false, err
+			}
+			cmp := snap.cmp.Rangecmp(key, low, high, lowincl, highincl)
+			if (reverse && cmp < 0) || (!reverse && cmp > 0) { // end of range
+				err = io.EOF
+				view.Abort()
+				return nil, nil, 0, false, err
+
+			} else if cmp == 0 {
+				value, cur.buf.vblock, err = lv.getactual(snap, cur.buf.vblock)
+				if err != nil {
+					view.Abort()
+					return nil, nil, 0, false, err
+				}
+				return key, value, seqno, deleted, nil
+			}
+			// skip entries before the start of range.
+		}
+	}, nil
 }
 
 // ScanEntry return a full table iterator, if iteration is stopped before
@@ -676,7 +1350,9 @@ func (snap *Snapshot) ScanEntries() api.EntryIterator {
 			view.Abort()
 			return re.set(nil, lv, 0, false, err)
 		}
-		return re.set(key, lv, seqno, deleted, err)
+		re.set(key, lv, seqno, deleted, err)
+		re.expiry = cur.(*Cursor).expiry()
+		return re
 	}
 }
 
@@ -698,6 +1374,11 @@ func (snap *Snapshot) Delete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 	panic("not allowed")
 }
 
+// Apply is not allowed.
+func (snap *Snapshot) Apply(batch *api.Batch) error {
+	panic("not allowed")
+}
+
 //---- local methods
 
 func (snap *Snapshot) getview(id uint64) (view *View) {
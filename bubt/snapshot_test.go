@@ -1,9 +1,17 @@
 package bubt
 
+import "io"
+import "os"
+import "fmt"
 import "time"
+import "bytes"
 import "testing"
 import "math/rand"
 
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/llrb"
+import s "github.com/bnclabs/gosettings"
+
 func TestValidate(t *testing.T) {
 	n := 1000000
 	paths := makepaths123(-1)
@@ -290,3 +298,698 @@ func makeBubt(n int, msize, zsize, vsize int64) (*Snapshot, [][]byte) {
 	}
 	return snap, keys
 }
+
+func TestSnapshotRange(t *testing.T) {
+	n := 100000
+	paths := makepaths123(-1)
+	mi, keys := makeLLRBEven(n)
+	defer mi.Destroy()
+
+	rand.Seed(time.Now().UnixNano())
+	name, msize := "testbuild", int64(4096)
+	zsize := []int64{0, msize, msize * 2}[rand.Intn(100000)%2]
+	vsize := []int64{0, zsize, zsize * 2}[rand.Intn(100000)%2]
+	mmap := []bool{false, true}[rand.Intn(10000)%2]
+	t.Logf("zsize: %v, vsize: %v, mmap: %v", zsize, vsize, mmap)
+	bubt, err := NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	bounds := [][]byte{nil, []byte("a"), []byte("z")}
+	for i := 0; i < 20; i++ {
+		// odd keys are missing in the index.
+		key := fmt.Sprintf("key%015d", rand.Intn(n*2))
+		bounds = append(bounds, []byte(key))
+	}
+	bounds = append(bounds, keys[0], keys[len(keys)-1])
+
+	for i := 0; i < 200; i++ {
+		low := bounds[rand.Intn(len(bounds))]
+		high := bounds[rand.Intn(len(bounds))]
+		incl := []string{"none", "low", "high", "both"}[rand.Intn(4)]
+		reverse := rand.Intn(2) == 1
+
+		refiter := mi.Range(low, high, incl, reverse)
+		iter := snap.Range(low, high, incl, reverse)
+		count := 0
+		refkey, refval, refseqno, refdel, referr := refiter(false /*fin*/)
+		key, val, seqno, del, err := iter(false /*fin*/)
+		for referr == nil && err == nil {
+			if bytes.Compare(key, refkey) != 0 {
+				t.Fatalf("expected %q, got %q", refkey, key)
+			} else if refdel == false && bytes.Compare(val, refval) != 0 {
+				t.Fatalf("%q expected %q, got %q", key, refval, val)
+			} else if seqno != refseqno {
+				t.Fatalf("%q expected %v, got %v", key, refseqno, seqno)
+			} else if del != refdel {
+				t.Fatalf("%q expected %v, got %v", key, refdel, del)
+			}
+			count++
+			refkey, refval, refseqno, refdel, referr = refiter(false /*fin*/)
+			key, val, seqno, del, err = iter(false /*fin*/)
+		}
+		if referr != io.EOF || err != io.EOF {
+			fmsg := "%q %q %v %v: expected %v, got %v"
+			t.Fatalf(fmsg, low, high, incl, reverse, referr, err)
+		}
+		refiter(true /*fin*/)
+		iter(true /*fin*/)
+	}
+}
+
+func TestSnapshotReverseCursor(t *testing.T) {
+	n := 100000
+	paths := makepaths123(-1)
+	mi, keys := makeLLRBEven(n)
+	defer mi.Destroy()
+
+	rand.Seed(time.Now().UnixNano())
+	name, msize := "testbuild", int64(4096)
+	zsize := []int64{0, msize, msize * 2}[rand.Intn(100000)%2]
+	vsize := []int64{0, zsize, zsize * 2}[rand.Intn(100000)%2]
+	mmap := []bool{false, true}[rand.Intn(10000)%2]
+	t.Logf("zsize: %v, vsize: %v, mmap: %v", zsize, vsize, mmap)
+	bubt, err := NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	seeks := [][]byte{nil, []byte("a"), []byte("z")}
+	for i := 0; i < 20; i++ {
+		// odd keys are missing in the index.
+		key := fmt.Sprintf("key%015d", rand.Intn(n*2))
+		seeks = append(seeks, []byte(key))
+	}
+	seeks = append(seeks, keys[0], keys[len(keys)-1])
+
+	refview, view := mi.View(0), snap.View(0)
+	defer refview.Abort()
+	defer view.Abort()
+	for _, seek := range seeks {
+		refcur, err := refview.OpenReverseCursor(seek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cur, err := view.OpenReverseCursor(seek)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refkey, _ := refcur.Key()
+		if key, _ := cur.Key(); bytes.Compare(key, refkey) != 0 {
+			t.Fatalf("%q expected %q, got %q", seek, refkey, key)
+		}
+		for i := 0; i < 1000; i++ {
+			// walk backwards, with an occasional step forward.
+			refmove, move := refcur.GetPrev, cur.GetPrev
+			if rand.Intn(10) == 0 {
+				refmove, move = refcur.GetNext, cur.GetNext
+			}
+			refkey, refval, refdel, referr := refmove()
+			key, val, del, err := move()
+			if referr != nil || err != nil {
+				if referr != io.EOF || err != io.EOF {
+					t.Fatalf("expected %v, got %v", referr, err)
+				}
+				break
+			} else if bytes.Compare(key, refkey) != 0 {
+				t.Fatalf("%q expected %q, got %q", seek, refkey, key)
+			} else if refdel == false && bytes.Compare(val, refval) != 0 {
+				t.Fatalf("%q expected %q, got %q", key, refval, val)
+			} else if del != refdel {
+				t.Fatalf("%q expected %v, got %v", key, refdel, del)
+			}
+		}
+	}
+
+	// full table reverse scan.
+	cur, err := view.OpenReverseCursor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	key, _, _, _, err := cur.YPrev(false /*fin*/)
+	for ; err == nil; key, _, _, _, err = cur.YPrev(false /*fin*/) {
+		if refkey := keys[len(keys)-1-count]; bytes.Compare(key, refkey) != 0 {
+			t.Fatalf("expected %q, got %q", refkey, key)
+		}
+		count++
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	} else if count != len(keys) {
+		t.Errorf("expected %v, got %v", len(keys), count)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	n := 100000
+	paths := makepaths1()
+	mi, keys, _ := makeLLRB(n)
+	defer mi.Destroy()
+
+	name, msize := "testbuild", int64(4096)
+	zsize, vsize := msize, msize
+	bubt, err := NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := snap.Info()
+	if x := info.String("checksum"); x != ChecksumCRC32C {
+		t.Errorf("expected %v, got %v", ChecksumCRC32C, x)
+	} else if corrupts := snap.Scrub(); len(corrupts) > 0 {
+		t.Fatalf("unexpected %v", corrupts)
+	}
+	snap.Close()
+
+	// corrupt first m-block, second z-block and first value-log entry.
+	corrupt := func(file string, fpos int64) {
+		fd, err := os.OpenFile(file, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+		var b [1]byte
+		if _, err := fd.ReadAt(b[:], fpos); err != nil {
+			t.Fatal(err)
+		}
+		b[0] ^= 0xFF
+		if _, err := fd.WriteAt(b[:], fpos); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corrupt(info.String("mfile"), 100)
+	corrupt(info.Strings("zfiles")[0], zsize+100)
+	corrupt(info.Strings("vfiles")[0], vlogentrysize+crcsize+1)
+
+	snap, err = OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	refs := map[string]int64{"m-block": 0, "z-block": zsize, "vlog-entry": 0}
+	corrupts := snap.Scrub()
+	if len(corrupts) != len(refs) {
+		t.Fatalf("expected %v, got %v", len(refs), corrupts)
+	}
+	for _, corrupt := range corrupts {
+		if fpos, ok := refs[corrupt.Block]; !ok || fpos != corrupt.Fpos {
+			t.Errorf("unexpected %v", corrupt)
+		}
+	}
+
+	// iterate till the corrupt blocks.
+	iter := snap.Scan()
+	_, _, _, _, err = iter(false /*fin*/)
+	for err == nil {
+		_, _, _, _, err = iter(false /*fin*/)
+	}
+	if _, ok := err.(*CorruptError); !ok {
+		t.Errorf("unexpected %v", err)
+	}
+
+	// lookup via corrupt m-block.
+	if _, _, _, ok := snap.Get(keys[0], []byte{}); ok {
+		t.Errorf("unexpected ok")
+	}
+	if _, _, _, _, _, err := snap.Getexpiry(keys[0], nil); err == nil {
+		t.Errorf("expected error")
+	}
+	if _, _, _, ok, err := snap.GetE(keys[0], []byte{}); ok {
+		t.Errorf("unexpected ok")
+	} else if _, ok := err.(*CorruptError); !ok {
+		t.Errorf("unexpected %v", err)
+	}
+	if iter, err := snap.ScanE(); err != nil {
+		t.Errorf("unexpected %v", err)
+	} else {
+		iter(true /*fin*/)
+	}
+	// range positioned via corrupt m-block.
+	if _, err := snap.RangeE(keys[0], nil, "both", false); err == nil {
+		t.Errorf("expected error")
+	} else if _, ok := err.(*CorruptError); !ok {
+		t.Errorf("unexpected %v", err)
+	} else if iter := snap.Range(keys[0], nil, "both", false); iter != nil {
+		t.Errorf("expected nil iterator")
+	}
+}
+
+func TestSnapshotBloom(t *testing.T) {
+	n := 100000
+	paths := makepaths123(-1)
+	mi, keys := makeLLRBEven(n)
+	defer mi.Destroy()
+
+	name, msize := "testbuild", int64(4096)
+	bubt, err := NewBubt(name, paths, msize, msize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bubt.Bloom(10)
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	info := snap.Info()
+	if x := info.Int64("bloombits"); x != 10 {
+		t.Errorf("expected %v, got %v", 10, x)
+	} else if x := info.Int64("bloomsize"); x == 0 {
+		t.Errorf("expected bloom filter on disk")
+	} else if x := string(snap.Metadata()); x != "this is metadata" {
+		t.Errorf("unexpected metadata %q", x)
+	}
+	snap.Validate()
+
+	// keys, including deleted keys, are never ruled out.
+	for _, key := range keys {
+		if _, _, _, ok := snap.Get(key, nil); !ok {
+			t.Fatalf("expected %q", key)
+		}
+	}
+	// missing keys.
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i*2+1))
+		if _, _, _, ok := snap.Get(key, nil); ok {
+			t.Fatalf("unexpected %q", key)
+		}
+	}
+}
+
+func TestSnapshotPrefixed(t *testing.T) {
+	n := 20000
+	paths := makepaths123(-1)
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	mi := llrb.NewLLRB("prefixllrb", setts)
+	defer mi.Destroy()
+	keys := [][]byte{}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("tenant/%08d/table/key%015d", i/1000, i*2))
+		if (i*2)%10 == 0 {
+			mi.Delete(key, nil, true /*lsm*/)
+		} else {
+			mi.Set(key, []byte(fmt.Sprintf("val%015d", i*2)), nil)
+		}
+		keys = append(keys, key)
+	}
+
+	rand.Seed(time.Now().UnixNano())
+	msize := int64(4096)
+	vsize := []int64{0, msize}[rand.Intn(100000)%2]
+	mmap := []bool{false, true}[rand.Intn(10000)%2]
+	t.Logf("vsize: %v, mmap: %v", vsize, mmap)
+
+	build := func(name string, restart int) *Snapshot {
+		bubt, err := NewBubt(name, paths, msize, msize, vsize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bubt.Restartinterval(restart)
+		mitere := mi.ScanEntries()
+		if err := bubt.Build(mitere, []byte("this is metadata")); err != nil {
+			t.Fatal(err)
+		}
+		mitere(true /*fin*/)
+		bubt.Close()
+		snap, err := OpenSnapshot(name, paths, mmap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snap
+	}
+
+	fullsnap := build("testfull", 0)
+	defer fullsnap.Destroy()
+	defer fullsnap.Close()
+	snap := build("testprefix", 16)
+	defer snap.Destroy()
+	defer snap.Close()
+
+	info, fullinfo := snap.Info(), fullsnap.Info()
+	if x := info.Int64("restart"); x != 16 {
+		t.Errorf("expected %v, got %v", 16, x)
+	} else if x := fullinfo.Int64("restart"); x != 0 {
+		t.Errorf("expected %v, got %v", 0, x)
+	}
+	x, y := info.Int64("n_zblocks"), fullinfo.Int64("n_zblocks")
+	t.Logf("n_zblocks prefixed: %v, full: %v", x, y)
+	if x*2 > y {
+		t.Errorf("expected less than %v z-blocks, got %v", y/2, x)
+	}
+	snap.Validate()
+	if errs := snap.Scrub(); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+
+	for _, key := range keys {
+		refval, refseqno, refdel, refok := fullsnap.Get(key, nil)
+		val, seqno, del, ok := snap.Get(key, nil)
+		if ok != refok || del != refdel || seqno != refseqno {
+			t.Fatalf("%q expected %v %v %v, got %v %v %v",
+				key, refok, refdel, refseqno, ok, del, seqno)
+		} else if bytes.Compare(val, refval) != 0 {
+			t.Fatalf("%q expected %q, got %q", key, refval, val)
+		}
+		// missing key just after key.
+		missing := append(append([]byte{}, key...), '0')
+		if _, _, _, ok := snap.Get(missing, nil); ok {
+			t.Fatalf("unexpected %q", missing)
+		}
+	}
+
+	bounds := [][]byte{nil, []byte("a"), []byte("z"), keys[0], keys[n-1]}
+	for i := 0; i < 20; i++ {
+		bounds = append(bounds, keys[rand.Intn(n)][:rand.Intn(34)])
+	}
+	for i := 0; i < 100; i++ {
+		low := bounds[rand.Intn(len(bounds))]
+		high := bounds[rand.Intn(len(bounds))]
+		incl := []string{"none", "low", "high", "both"}[rand.Intn(4)]
+		reverse := rand.Intn(2) == 1
+
+		refiter := fullsnap.Range(low, high, incl, reverse)
+		iter := snap.Range(low, high, incl, reverse)
+		refkey, refval, refseqno, refdel, referr := refiter(false /*fin*/)
+		key, val, seqno, del, err := iter(false /*fin*/)
+		for referr == nil && err == nil {
+			if bytes.Compare(key, refkey) != 0 {
+				t.Fatalf("expected %q, got %q", refkey, key)
+			} else if bytes.Compare(val, refval) != 0 {
+				t.Fatalf("%q expected %q, got %q", key, refval, val)
+			} else if seqno != refseqno || del != refdel {
+				t.Fatalf("%q expected %v %v, got %v %v",
+					key, refseqno, refdel, seqno, del)
+			}
+			refkey, refval, refseqno, refdel, referr = refiter(false /*fin*/)
+			key, val, seqno, del, err = iter(false /*fin*/)
+		}
+		if referr != io.EOF || err != io.EOF {
+			fmsg := "%q %q %v %v: expected %v, got %v"
+			t.Fatalf(fmsg, low, high, incl, reverse, referr, err)
+		}
+		refiter(true /*fin*/)
+		iter(true /*fin*/)
+	}
+}
+
+func TestSnapshotTTL(t *testing.T) {
+	paths := makepaths123(-1)
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	mi := llrb.NewLLRB("ttlllrb", setts)
+	defer mi.Destroy()
+
+	mi.Set([]byte("key1"), []byte("val1"), nil)
+	mi.SetTTL([]byte("key2"), []byte("val2"), nil, time.Hour)
+	mi.SetTTL([]byte("key3"), []byte("val3"), nil, time.Second)
+	mi.SetExpiry([]byte("key4"), []byte("val4"), nil, 1 /*long back*/)
+
+	build := func(name string, itere api.EntryIterator, purge bool) *Snapshot {
+		bubt, err := NewBubt(name, paths, 4096, 4096, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bubt.TombstonePurge(purge)
+		if err := bubt.Build(itere, []byte("metadata")); err != nil {
+			t.Fatal(err)
+		}
+		itere(true /*fin*/)
+		bubt.Close()
+		snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snap
+	}
+
+	snap := build("ttlbuild1", mi.ScanEntries(), false /*purge*/)
+	defer snap.Destroy()
+	defer snap.Close()
+
+	_, _, expiry2, _, _, _ := snap.Getexpiry([]byte("key2"), nil)
+	_, _, expiry3, _, _, _ := snap.Getexpiry([]byte("key3"), nil)
+	if expiry2 == 0 || expiry3 == 0 {
+		t.Fatalf("expected expiry, got %v %v", expiry2, expiry3)
+	}
+	for api.Isexpired(expiry3) == false {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	refs := []struct {
+		key, value string
+		deleted    bool
+	}{
+		{"key1", "val1", false},
+		{"key2", "val2", false},
+		{"key3", "", true},
+		{"key4", "", true},
+	}
+	for _, ref := range refs {
+		value, _, deleted, ok := snap.Get([]byte(ref.key), []byte{})
+		if ok == false {
+			t.Errorf("%v expected key", ref.key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.deleted, deleted)
+		} else if string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+	iter := snap.Scan()
+	for _, ref := range refs {
+		key, value, _, deleted, err := iter(false /*fin*/)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(key) != ref.key {
+			t.Errorf("expected %q, got %q", ref.key, key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.deleted, deleted)
+		} else if string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+	iter(true /*fin*/)
+
+	// expired entries are purged along with tombstones.
+	purged := build("ttlbuild2", snap.ScanEntries(), true /*purge*/)
+	defer purged.Destroy()
+	defer purged.Close()
+
+	if n := purged.Count(); n != 2 {
+		t.Errorf("expected %v, got %v", 2, n)
+	}
+	_, _, expiry, deleted, ok, err := purged.Getexpiry([]byte("key2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if ok == false || deleted {
+		t.Errorf("unexpected %v %v", ok, deleted)
+	} else if expiry != expiry2 {
+		t.Errorf("expected %v, got %v", expiry2, expiry)
+	}
+	for _, key := range []string{"key3", "key4"} {
+		if _, _, _, ok := purged.Get([]byte(key), nil); ok {
+			t.Errorf("unexpected %q", key)
+		}
+	}
+}
+
+func TestSnapshotComparator(t *testing.T) {
+	n, paths := 10000, makepaths123(-1)
+	setts := s.Settings{
+		"memcapacity": 1024 * 1024 * 1024, "comparator": "testreverse",
+	}
+	mi := llrb.NewLLRB("cmpllrb", setts)
+	defer mi.Destroy()
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i*2))
+		mi.Set(key, []byte(fmt.Sprintf("val%015d", i*2)), nil)
+	}
+
+	name := "testcomparator"
+	bubt, err := NewBubt(name, paths, 4096, 4096, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bubt.Comparator("testreverse")
+	mitere := mi.ScanEntries()
+	if err := bubt.Build(mitere, []byte("metadata")); err != nil {
+		t.Fatal(err)
+	}
+	mitere(true /*fin*/)
+	bubt.Close()
+
+	snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Destroy()
+	defer snap.Close()
+
+	if x := snap.Info().String("comparator"); x != "testreverse" {
+		t.Errorf("expected %q, got %q", "testreverse", x)
+	}
+	snap.Validate()
+
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i*2))
+		value, _, _, ok := snap.Get(key, []byte{})
+		if x := fmt.Sprintf("val%015d", i*2); !ok || string(value) != x {
+			t.Fatalf("%q expected %q, got %v %q", key, x, ok, value)
+		}
+		key = []byte(fmt.Sprintf("key%015d", i*2+1))
+		if _, _, _, ok := snap.Get(key, nil); ok {
+			t.Fatalf("unexpected %q", key)
+		}
+	}
+
+	check := func(iter api.Iterator, from, till int) {
+		defer iter(true /*fin*/)
+		incr := -2
+		if from < till {
+			incr = 2
+		}
+		for i := from; i != till+incr; i += incr {
+			key, _, _, _, err := iter(false /*fin*/)
+			if err != nil {
+				t.Fatal(err)
+			} else if x := fmt.Sprintf("key%015d", i); string(key) != x {
+				t.Fatalf("expected %q, got %q", x, key)
+			}
+		}
+		if _, _, _, _, err := iter(false /*fin*/); err != io.EOF {
+			t.Errorf("expected %v, got %v", io.EOF, err)
+		}
+	}
+	check(snap.Scan(), (n-1)*2, 0)
+	low := []byte(fmt.Sprintf("key%015d", 8000))
+	high := []byte(fmt.Sprintf("key%015d", 2000))
+	check(snap.Range(low, high, "both", false /*reverse*/), 8000, 2000)
+	check(snap.Range(low, high, "none", true /*reverse*/), 2002, 7998)
+}
+
+func TestSnapshotRangetombs(t *testing.T) {
+	paths := makepaths123(-1)
+	mi := llrb.NewLLRB("tombllrb", llrb.Defaultsettings())
+	defer mi.Destroy()
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%015d", i*2))
+		mi.Set(key, []byte(fmt.Sprintf("val%015d", i*2)), nil)
+	}
+	low, high := fmt.Sprintf("key%015d", 100), fmt.Sprintf("key%015d", 200)
+	seqno := mi.DeleteRange([]byte(low), []byte(high))
+	tombs := mi.Rangetombs()
+
+	for _, purge := range []bool{false, true} {
+		name := "testrangetombs"
+		bubt, err := NewBubt(name, paths, 4096, 4096, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bubt.Rangetombs(tombs, purge)
+		mitere := mi.ScanEntries()
+		if err := bubt.Build(mitere, []byte("metadata")); err != nil {
+			t.Fatal(err)
+		}
+		mitere(true /*fin*/)
+		bubt.Close()
+
+		snap, err := OpenSnapshot(name, paths, false /*mmap*/)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap.Validate()
+
+		ntombs := 1
+		if purge {
+			ntombs = 0
+		}
+		info := snap.Info()
+		if x := snap.Count(); x != 950 {
+			t.Errorf("expected %v, got %v", 950, x)
+		} else if x := info.Int64("rangetombs"); x != int64(ntombs) {
+			t.Errorf("expected %v, got %v", ntombs, x)
+		} else if x := len(snap.Rangetombs()); x != ntombs {
+			t.Errorf("expected %v, got %v", ntombs, x)
+		} else if x := snap.Getseqno(); x != seqno {
+			t.Errorf("expected %v, got %v", seqno, x)
+		}
+
+		// keys covered by range tombstones are reported as deleted.
+		for i := 0; i < 200; i++ {
+			key := []byte(fmt.Sprintf("key%015d", i))
+			_, cas, deleted, ok := snap.Get(key, nil)
+			if covered := i >= 100 && purge == false; covered {
+				if !ok || !deleted || cas != seqno {
+					t.Errorf("%s unexpected %v %v %v", key, ok, deleted, cas)
+				}
+			} else if i >= 100 && ok {
+				t.Errorf("%s unexpected entry", key)
+			} else if i < 100 && ok != (i%2 == 0) {
+				t.Errorf("%s unexpected %v", key, ok)
+			}
+		}
+		// covered entries are dropped from scan.
+		iter, count := snap.Scan(), 0
+		key, _, _, _, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, _, err = iter(false /*fin*/) {
+			if string(key) >= low && string(key) < high {
+				t.Errorf("unexpected %s", key)
+			}
+			count++
+		}
+		iter(true /*fin*/)
+		if count != 950 {
+			t.Errorf("expected %v, got %v", 950, count)
+		}
+
+		snap.Close()
+		snap.Destroy()
+	}
+}
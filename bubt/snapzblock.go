@@ -1,30 +1,42 @@
 package bubt
 
 import "fmt"
-import "bytes"
 import "encoding/binary"
 
+import "github.com/bnclabs/gostore/api"
+
 //---- znode for reading entries.
 
+// zsnap methods that return keys take a kbuf, of zblocksize capacity,
+// to build keys from prefix compressed z-block. Returned key shall
+// point into kbuf for prefix compressed z-block, and into the block
+// otherwise.
 type zsnap []byte
 
 func (z zsnap) findkey(
 	adjust int, index blkindex,
-	key []byte) (
+	key []byte, cmpfn api.Comparator, kbuf []byte) (
 	idx int, actualkey []byte, lv lazyvalue, seqno uint64, del, ok bool) {
 
 	//fmt.Printf("zfindkey %v %v %q\n", adjust, len(index), key)
 
+	if pblock(z).isprefixed() {
+		return z.pfindkey(key, cmpfn, kbuf)
+	}
+
 	var cmp int
 	switch len(index) {
 	case 0:
 		panic(fmt.Errorf("impossible situation"))
 
 	case 1:
-		cmp, actualkey, lv, seqno, del = z.compareat(adjust, key)
+		cmp, actualkey, lv, seqno, del = z.compareat(adjust, key, cmpfn)
 		if cmp == 0 { // adjust+half >= key
 			//fmt.Printf("zfindkey-1 %v %v %q\n", adjust, 0, actualkey)
 			return adjust, actualkey, lv, seqno, del, true
+
+		} else if cmp > 0 { // key is less than all entries in this block
+			return adjust, actualkey, lv, 0, false, false
 		}
 		// cmp < 0
 		//fmt.Printf("zfindkey-2 %v %v %q\n", adjust, -1, actualkey)
@@ -33,21 +45,116 @@ func (z zsnap) findkey(
 	default:
 		half := len(index) / 2
 		arg1 := adjust + half
-		cmp, actualkey, lv, seqno, del = z.compareat(arg1, key)
+		cmp, actualkey, lv, seqno, del = z.compareat(arg1, key, cmpfn)
 		if cmp == 0 {
 			//fmt.Println("zfindkey", adjust+half, 0)
 			return adjust + half, actualkey, lv, seqno, del, true
 
 		} else if cmp < 0 { // adjust+half < key
-			return z.findkey(adjust+half, index[half:], key)
+			return z.findkey(adjust+half, index[half:], key, cmpfn, kbuf)
 		}
-		return z.findkey(adjust, index[:half], key)
+		return z.findkey(adjust, index[:half], key, cmpfn, kbuf)
 	}
 	panic("unreachable code")
 }
 
+// pfindkey is findkey on prefix compressed z-block, return the index
+// of the first entry >= key.
+func (z zsnap) pfindkey(
+	key []byte, cmpfn api.Comparator, kbuf []byte) (
+	idx int, actualkey []byte, lv lazyvalue, seqno uint64, del, ok bool) {
+
+	idx = pblock(z).search(kbuf, z.pkeyat, func(k []byte) bool {
+		return cmpfn(k, key) < 0
+	})
+	if idx >= pblock(z).count() {
+		return idx, nil, lv, 0, false, false
+	}
+	actualkey, lv, seqno, del = z.pentryat(idx, kbuf)
+	if cmpfn(actualkey, key) != 0 {
+		lv.setfields(0, 0, nil)
+		return idx, actualkey, lv, 0, false, false
+	}
+	return idx, actualkey, lv, seqno, del, true
+}
+
+// pkeyat is keydecoder for prefix compressed z-block.
+func (z zsnap) pkeyat(x int, prevkey []byte) ([]byte, int) {
+	var ze pzentry
+	next := ze.decode(z, x)
+	return append(prevkey[:ze.shared], z[ze.keyoff:ze.valueoff()]...), next
+}
+
+// pentryat is entryat on prefix compressed z-block.
+func (z zsnap) pentryat(
+	index int,
+	kbuf []byte) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
+
+	var ze pzentry
+
+	key, x := pblock(z).seek(index, kbuf, z.pkeyat)
+	ze.decode(z, x)
+	lv, seqno, deleted = z.pentryvalue(&ze)
+	return
+}
+
+// zdecoder remember the last decoded entry in a prefix compressed
+// z-block, so that sequential reads continue decoding from there,
+// instead of from the restart point. Shall be reset whenever a new
+// z-block is read into the buffer.
+type zdecoder struct {
+	index int    // index of the last decoded entry, -1 if none.
+	x     int    // offset of the last decoded entry.
+	next  int    // offset of the entry after the last decoded entry.
+	key   []byte // key of the last decoded entry.
+}
+
+func (zd *zdecoder) reset() {
+	zd.index = -1
+}
+
+// pentrynext is pentryat using zd, the key is built in zd.key.
+func (z zsnap) pentrynext(
+	index int,
+	zd *zdecoder) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
+
+	var ze pzentry
+
+	switch {
+	case zd.index >= 0 && index == zd.index:
+	case zd.index >= 0 && index == zd.index+1:
+		zd.x = zd.next
+		zd.key, zd.next = z.pkeyat(zd.x, zd.key)
+	default:
+		zd.key, zd.x = pblock(z).seek(index, zd.key, z.pkeyat)
+	}
+	zd.index, zd.next = index, ze.decode(z, zd.x)
+	lv, seqno, deleted = z.pentryvalue(&ze)
+	return zd.key, lv, seqno, deleted
+}
+
+// pentryvalue return the value and attributes of decoded entry ze.
+func (z zsnap) pentryvalue(
+	ze *pzentry) (lv lazyvalue, seqno uint64, deleted bool) {
+
+	seqno, deleted = ze.seqno, ze.isdeleted()
+	x, valuelen := ze.valueoff(), int(ze.valuelen)
+	if api.Isexpired(ze.expiry) { // expired entries are treated as deleted.
+		deleted = true
+		lv.setfields(0, 0, nil)
+	} else if ze.isvlog() {
+		vlogpos := int64(binary.BigEndian.Uint64(z[x : x+8]))
+		lv.setfields(int64(valuelen), vlogpos, nil)
+	} else if valuelen > 0 {
+		lv.setfields(int64(valuelen), 0, z[x:x+valuelen])
+	} else {
+		lv.setfields(0, 0, nil)
+	}
+	return
+}
+
 func (z zsnap) compareat(
-	i int, key []byte) (
+	i int, key []byte, cmpfn api.Comparator) (
 	cmp int, currkey []byte, lv lazyvalue, cas uint64, deleted bool) {
 
 	offset := 4 + (i * 4)
@@ -56,13 +163,15 @@ func (z zsnap) compareat(
 	ln := int(ze.keylen())
 	x += zentrysize
 	currkey, cas, deleted = z[x:x+ln], 0, false
-	cmp = bytes.Compare(currkey, key)
+	cmp = cmpfn(currkey, key)
 	//fmt.Printf("z.compareat %v %s %s %v\n", i, key, z[x:x+ln], cmp)
 	lv.setfields(0, 0, nil)
 	if cmp >= 0 {
 		x, ln = x+ln, int(ze.valuelen())
 		cas, deleted = ze.seqno(), ze.isdeleted()
-		if ze.isvlog() {
+		if ze.isexpired() { // expired entries are treated as deleted.
+			deleted = true
+		} else if ze.isvlog() {
 			vlogpos := int64(binary.BigEndian.Uint64(z[x : x+8]))
 			lv.setfields(int64(ln), vlogpos, nil)
 		} else if ln > 0 {
@@ -72,7 +181,15 @@ func (z zsnap) compareat(
 	return cmp, currkey, lv, cas, deleted
 }
 
+// getindex return offsets of all entries, or offsets of restart points
+// for prefix compressed z-block.
 func (z zsnap) getindex(index blkindex) blkindex {
+	if b := pblock(z); b.isprefixed() {
+		for r := 0; r < b.restarts(); r++ {
+			index = append(index, uint32(b.restartat(r)))
+		}
+		return index
+	}
 	nums, n := binary.BigEndian.Uint32(z[:4]), 4
 	for i := uint32(0); i < nums; i++ {
 		index = append(index, binary.BigEndian.Uint32(z[n:n+4]))
@@ -82,7 +199,12 @@ func (z zsnap) getindex(index blkindex) blkindex {
 }
 
 func (z zsnap) entryat(
-	index int) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
+	index int,
+	kbuf []byte) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
+
+	if pblock(z).isprefixed() {
+		return z.pentryat(index, kbuf)
+	}
 
 	x := int((index * 4) + 4)
 	x = int(binary.BigEndian.Uint32(z[x : x+4]))
@@ -94,7 +216,10 @@ func (z zsnap) entryat(
 	//fmt.Printf("z-entryat %v %v %v\n", index, x, keylen)
 	key = z[x : x+keylen]
 	x += keylen
-	if vlogok {
+	if ze.isexpired() { // expired entries are treated as deleted.
+		deleted = true
+		lv.setfields(0, 0, nil)
+	} else if vlogok {
 		vlogpos := int64(binary.BigEndian.Uint64(z[x : x+8]))
 		lv.setfields(int64(valuelen), vlogpos, nil)
 	} else if valuelen > 0 {
@@ -105,16 +230,47 @@ func (z zsnap) entryat(
 	return
 }
 
+// expiryat return the expiry of entry at index, in unix seconds.
+func (z zsnap) expiryat(index int) uint64 {
+	if b := pblock(z); b.isprefixed() {
+		var ze pzentry
+		_, x := b.seek(index, nil, func(x int, key []byte) ([]byte, int) {
+			return key, ze.decode(z, x)
+		})
+		ze.decode(z, x)
+		return ze.expiry
+	}
+	x := int((index * 4) + 4)
+	x = int(binary.BigEndian.Uint32(z[x : x+4]))
+	return zentry(z[x : x+zentrysize]).expiry()
+}
+
 func (z zsnap) getnext(
-	index int) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
+	index int,
+	kbuf []byte) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
 
 	if index >= 0 && z.isbounded(index+1) {
-		return z.entryat(index + 1)
+		return z.entryat(index+1, kbuf)
 	}
 	return key, lv, 0, false
 }
 
+// getprev return the entry before index.
+func (z zsnap) getprev(
+	index int,
+	kbuf []byte) (key []byte, lv lazyvalue, seqno uint64, deleted bool) {
+
+	if z.isbounded(index - 1) {
+		return z.entryat(index-1, kbuf)
+	}
+	return key, lv, 0, false
+}
+
+// lastindex return the index of the last entry in this block.
+func (z zsnap) lastindex() int {
+	return pblock(z).count() - 1
+}
+
 func (z zsnap) isbounded(index int) bool {
-	idxlen := int(binary.BigEndian.Uint32(z[:4]))
-	return (index >= 0) && (index < idxlen)
+	return (index >= 0) && (index < pblock(z).count())
 }
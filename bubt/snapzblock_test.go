@@ -1,14 +1,15 @@
 package bubt
 
 import "fmt"
+import "bytes"
 import "testing"
 
 func TestZGetNext(t *testing.T) {
 	z, keys := makezsnap(t)
 	for i := range keys {
 		for j := i + 1; j < len(keys); j++ {
-			key, lv, seqno, deleted := z.getnext(j - 1)
-			value, _ := lv.getactual(nil, nil)
+			key, lv, seqno, deleted := z.getnext(j-1, nil)
+			value, _, _ := lv.getactual(nil, nil)
 			if string(key) != string(keys[j]) {
 				t.Errorf("expected %q, got %q", keys[j], key)
 			} else if deleted != ((j % 4) == 0) {
@@ -39,7 +40,7 @@ func BenchmarkZFindkey(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		z.findkey(0, index, keys[i%len(keys)])
+		z.findkey(0, index, keys[i%len(keys)], bytes.Compare, nil)
 	}
 }
 
@@ -49,9 +50,9 @@ func BenchmarkZGetNext(b *testing.B) {
 	b.ResetTimer()
 	index := 0
 	for i := 0; i < b.N; i++ {
-		if key, _, _, _ := z.getnext(index); key == nil {
+		if key, _, _, _ := z.getnext(index, nil); key == nil {
 			index = 0
-			if key, _, _, _ = z.getnext(index); key != nil {
+			if key, _, _, _ = z.getnext(index, nil); key != nil {
 				panic("unexpected")
 			}
 		}
@@ -65,7 +66,7 @@ func makezsnap(tb testing.TB) (zsnap, [][]byte) {
 	i := uint64(0)
 	k := fmt.Sprintf("%16d", i)
 	v, seqno, deleted := k, i, true
-	for z.insert([]byte(k), []byte(v), 0, -1, seqno, deleted) {
+	for z.insert([]byte(k), []byte(v), 0, -1, seqno, 0, deleted) {
 		keys = append(keys, []byte(k))
 		i++
 		k = fmt.Sprintf("%16d", i)
@@ -1,5 +1,7 @@
 package bubt
 
+import "time"
+
 import "github.com/bnclabs/gostore/api"
 
 // View read only transaction instance.
@@ -25,11 +27,28 @@ func (view *View) OpenCursor(key []byte) (api.Cursor, error) {
 	return cur, err
 }
 
+// OpenReverseCursor open an active cursor inside the index, point at
+// the last entry less than or equal to key.
+func (view *View) OpenReverseCursor(key []byte) (api.Cursor, error) {
+	snap := view.snap
+	msize, zsize, vsize := snap.mblocksize, snap.zblocksize, snap.vblocksize
+	buf := snap.rdpool.getreadbuffer(msize, zsize, vsize)
+	cur, err := view.getcursor().openreverse(view.snap, key, buf)
+	return cur, err
+}
+
 // Set not allowed.
 func (view *View) Set(key, value, oldvalue []byte) []byte {
 	panic("Set not allowed on view")
 }
 
+// SetTTL is not allowed.
+func (view *View) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) []byte {
+
+	panic("SetTTL not allowed on view")
+}
+
 // Delete not allowed.
 func (view *View) Delete(key, oldvalue []byte, lsm bool) []byte {
 	panic("Delete not allowed on view")
@@ -1,8 +1,13 @@
 package bubt
 
 import "unsafe"
+import "hash/crc32"
 import "encoding/binary"
 
+// vlogentry represents the binary layout of each entry in value log.
+// hdr:   flags[64:62] value-len[62:0]
+// crc:   4-byte crc32c of value, present only if vlogChecksum is set.
+// byte array of value, compressed if vlogCompressed is set.
 type vlogentry struct {
 	valuelen uint64
 	value    unsafe.Pointer
@@ -10,8 +15,15 @@ type vlogentry struct {
 
 var vlogentrysize = int64(unsafe.Sizeof(vlogentry{})) - 8
 
+// vlogChecksum flag in value-log entry header.
+const vlogChecksum = uint64(1) << 62
+
+const vlogflags = vlogCompressed | vlogChecksum
+
+// serialize value into value log, if value is compressed, flags shall
+// be vlogCompressed and value shall be the compressed bytes.
 func (vle *vlogentry) serialize(
-	vsize, vlogpos int64,
+	vsize, vlogpos int64, flags uint64,
 	value, vlog, zerovbuff []byte) (bool, int64, int64, []byte) {
 
 	var scratch [8]byte
@@ -28,10 +40,44 @@ func (vle *vlogentry) serialize(
 		}
 	}
 	vlogpos0 := vlogpos
-	binary.BigEndian.PutUint64(scratch[:], uint64(len(value)))
+	flags |= vlogChecksum
+	binary.BigEndian.PutUint64(scratch[:], uint64(len(value))|flags)
 	vlog = append(vlog, scratch[:]...)
+	binary.BigEndian.PutUint32(scratch[:], crc32.Checksum(value, crctable))
+	vlog = append(vlog, scratch[:crcsize]...)
 	vlog = append(vlog, value...)
-	vlogpos += int64(len(scratch) + len(value))
+	vlogpos += int64(len(scratch) + crcsize + len(value))
 	//fmt.Println("addtovalueblock", len(vlog))
 	return true, vlogpos0, vlogpos, vlog
 }
+
+// deserialize value log entry from buf, return the value as stored on
+// disk, its flags and size of the entry. If buf is too short to hold
+// the entry, return false.
+func (vle *vlogentry) deserialize(
+	buf []byte) (value []byte, flags uint64, n int64, ok bool) {
+
+	if int64(len(buf)) < vlogentrysize {
+		return nil, 0, 0, false
+	}
+	hdr := binary.BigEndian.Uint64(buf)
+	flags, n = hdr&vlogflags, vlogentrysize
+	if flags&vlogChecksum != 0 {
+		n += crcsize
+	}
+	ln := int64(hdr &^ vlogflags)
+	if int64(len(buf)) < n+ln {
+		return nil, flags, n + ln, false
+	}
+	return buf[n : n+ln], flags, n + ln, true
+}
+
+// verify value against its checksum, entries from older value logs
+// may not have a checksum.
+func (vle *vlogentry) verify(buf, value []byte, flags uint64) bool {
+	if flags&vlogChecksum == 0 {
+		return true
+	}
+	crc := binary.BigEndian.Uint32(buf[vlogentrysize:])
+	return crc32.Checksum(value, crctable) == crc
+}
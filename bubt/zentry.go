@@ -2,6 +2,8 @@ package bubt
 
 import "encoding/binary"
 
+import "github.com/bnclabs/gostore/api"
+
 const (
 	zflagDeleted byte = 0x1
 	zflagVlog    byte = 0x2
@@ -9,7 +11,7 @@ const (
 
 // zentry represents the binary layout of each entry in the leaf(z) block.
 // hdr1: flags[64:60] seqno[60:0]
-// hdr2: 8 bytes // key-len
+// hdr2: expiry[64:32] keylen[32:0], expiry in unix seconds.
 // hdr3: 8 bytes // value-len
 // byte array of key
 // 8-byte fpos into value log, if value is present, and stored in value-log.
@@ -63,12 +65,29 @@ func (ze zentry) seqno() uint64 {
 }
 
 func (ze zentry) setkeylen(keylen uint64) zentry {
-	binary.BigEndian.PutUint64(ze[8:16], keylen)
+	hdr2 := binary.BigEndian.Uint64(ze[8:16])
+	hdr2 = (hdr2 & 0xFFFFFFFF00000000) | (keylen & 0xFFFFFFFF)
+	binary.BigEndian.PutUint64(ze[8:16], hdr2)
 	return ze
 }
 
 func (ze zentry) keylen() uint64 {
-	return binary.BigEndian.Uint64(ze[8:16])
+	return binary.BigEndian.Uint64(ze[8:16]) & 0xFFFFFFFF
+}
+
+func (ze zentry) setexpiry(expiry uint64) zentry {
+	hdr2 := binary.BigEndian.Uint64(ze[8:16])
+	hdr2 = (hdr2 & 0xFFFFFFFF) | (expiry << 32)
+	binary.BigEndian.PutUint64(ze[8:16], hdr2)
+	return ze
+}
+
+func (ze zentry) expiry() uint64 {
+	return binary.BigEndian.Uint64(ze[8:16]) >> 32
+}
+
+func (ze zentry) isexpired() bool {
+	return api.Isexpired(ze.expiry())
 }
 
 func (ze zentry) setvaluelen(keylen uint64) zentry {
@@ -25,6 +25,17 @@ func TestZentry(t *testing.T) {
 	if ze.setkeylen(keylen); ze.keylen() != keylen {
 		t.Errorf("expected %x, got %x", keylen, ze.keylen())
 	}
+	expiry := uint64(0x12345678) // already in the past.
+	if ze.setexpiry(expiry); ze.expiry() != expiry {
+		t.Errorf("expected %x, got %x", expiry, ze.expiry())
+	} else if ze.keylen() != keylen {
+		t.Errorf("expected %x, got %x", keylen, ze.keylen())
+	} else if ze.isexpired() == false {
+		t.Errorf("expected expired")
+	}
+	if ze.setkeylen(keylen + 1); ze.expiry() != expiry {
+		t.Errorf("expected %x, got %x", expiry, ze.expiry())
+	}
 	valuelen := uint64(0x12345678)
 	if ze.setvaluelen(valuelen); ze.valuelen() != valuelen {
 		t.Errorf("expected %x, got %x", valuelen, ze.valuelen())
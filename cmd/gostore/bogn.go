@@ -0,0 +1,48 @@
+package main
+
+import "io"
+import "fmt"
+import "text/tabwriter"
+
+import "github.com/bnclabs/gostore/bogn"
+import humanize "github.com/dustin/go-humanize"
+
+// show disk levels, including older versions, of a bogn index.
+func cmdlevels(args []string, w io.Writer) error {
+	var opts options
+	var diskstore string
+	f := newflagset("levels", &opts)
+	f.StringVar(&diskstore, "diskstore", "bubt", "disk store for index")
+	if err := parseflags(f, args, &opts); err != nil {
+		return err
+	}
+	levels, err := bogn.Disklevels(opts.name, diskstore, opts.paths)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmsg := "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n"
+	fmt.Fprintf(
+		tw, fmsg, "LEVEL", "VERSION", "SEQNO", "FLUSHUNIX", "COUNT",
+		"FOOTPRINT", "WRITTEN", "ID",
+	)
+	total := int64(0)
+	for _, level := range levels {
+		footprint := uint64(level.Int64("footprint"))
+		written := level.Int64("n_written")
+		fmt.Fprintf(
+			tw, fmsg, level.Int64("level"), level.Int64("version"),
+			level.Uint64("seqno"), level.String("flushunix"),
+			level.Int64("n_count"), humanize.Bytes(footprint),
+			humanize.Bytes(uint64(written)), level.String("id"),
+		)
+		total += written
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmsg = "%v levels, %v written to disk\n"
+	_, err = fmt.Fprintf(w, fmsg, len(levels), humanize.Bytes(uint64(total)))
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import "io"
+import "fmt"
+import "encoding/json"
+
+import "github.com/bnclabs/gostore/bubt"
+
+// entry is a single line of output for dump and get sub-commands, key
+// and value can be binary, hence they are encoded as base64.
+type entry struct {
+	Key     []byte `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Seqno   uint64 `json:"seqno"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// print the info block, metadata and footprint.
+func cmdinfo(args []string, w io.Writer) error {
+	var opts options
+	f := newflagset("info", &opts)
+	if err := parseflags(f, args, &opts); err != nil {
+		return err
+	}
+	snap, err := bubt.OpenSnapshot(opts.name, opts.paths, opts.mmap)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	var metadata interface{} = string(snap.Metadata())
+	if data := snap.Metadata(); json.Valid(data) {
+		metadata = json.RawMessage(data)
+	}
+	out := map[string]interface{}{
+		"info":      snap.Info(),
+		"metadata":  metadata,
+		"footprint": snap.Footprint(),
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// stream entries as json lines, optionally bounded by -from and -to.
+func cmddump(args []string, w io.Writer) error {
+	var opts options
+	var from, to string
+	f := newflagset("dump", &opts)
+	f.StringVar(&from, "from", "", "dump entries from this key, inclusive")
+	f.StringVar(&to, "to", "", "dump entries upto this key, inclusive")
+	if err := parseflags(f, args, &opts); err != nil {
+		return err
+	}
+	snap, err := bubt.OpenSnapshot(opts.name, opts.paths, opts.mmap)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	var low, high []byte
+	if from != "" {
+		low = []byte(from)
+	}
+	if to != "" {
+		high = []byte(to)
+	}
+	iter, err := snap.RangeE(low, high, "both", false /*reverse*/)
+	if err != nil {
+		return fmt.Errorf("dump %v: %v", opts.name, err)
+	} else if iter == nil { // empty snapshot.
+		return nil
+	}
+	defer iter(true /*fin*/)
+
+	enc := json.NewEncoder(w)
+	key, value, seqno, deleted, err := iter(false /*fin*/)
+	for err == nil {
+		e := entry{Key: key, Seqno: seqno, Deleted: deleted}
+		if !deleted {
+			e.Value = value
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		key, value, seqno, deleted, err = iter(false /*fin*/)
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// look up a single key.
+func cmdget(args []string, w io.Writer) error {
+	var opts options
+	var key string
+	f := newflagset("get", &opts)
+	f.StringVar(&key, "key", "", "key to lookup")
+	if err := parseflags(f, args, &opts); err != nil {
+		return err
+	} else if key == "" {
+		return fmt.Errorf("get: missing -key")
+	}
+	snap, err := bubt.OpenSnapshot(opts.name, opts.paths, opts.mmap)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	value, seqno, deleted, ok := snap.Get([]byte(key), []byte{})
+	if !ok {
+		return fmt.Errorf("key %q not found", key)
+	}
+	e := entry{Key: []byte(key), Seqno: seqno, Deleted: deleted}
+	if !deleted {
+		e.Value = value
+	}
+	return json.NewEncoder(w).Encode(e)
+}
+
+// run Snapshot.Validate, which panics on inconsistencies.
+func cmdvalidate(args []string, w io.Writer) (err error) {
+	var opts options
+	f := newflagset("validate", &opts)
+	if err := parseflags(f, args, &opts); err != nil {
+		return err
+	}
+	snap, err := bubt.OpenSnapshot(opts.name, opts.paths, opts.mmap)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("validate %v: %v", opts.name, r)
+		}
+	}()
+	snap.Validate()
+	_, err = fmt.Fprintf(w, "%v: ok, %v entries\n", opts.name, snap.Count())
+	return err
+}
@@ -0,0 +1,106 @@
+// Command gostore inspect, dump and validate bubt snapshots and bogn
+// disk levels. It works directly on files, without a running process.
+//
+//	gostore info     -name <snapshot> -paths <path1,path2,...>
+//	gostore dump     -name <snapshot> -paths <paths> [-from key] [-to key]
+//	gostore get      -name <snapshot> -paths <paths> -key <key>
+//	gostore validate -name <snapshot> -paths <paths>
+//	gostore levels   -name <index> -paths <paths> [-diskstore bubt]
+//
+// Keys and values are printed as base64, since they can be binary.
+// Snapshots built with a comparator registered via
+// api.Registercomparator cannot be opened, comparator is application
+// code that is not available to this command.
+package main
+
+import "io"
+import "os"
+import "fmt"
+import "flag"
+import "sort"
+import "strings"
+
+import "github.com/bnclabs/gostore/api"
+
+var subcommands = map[string]func(args []string, w io.Writer) error{
+	"info":     cmdinfo,
+	"dump":     cmddump,
+	"get":      cmdget,
+	"validate": cmdvalidate,
+	"levels":   cmdlevels,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "gostore: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		usage()
+		return fmt.Errorf("missing sub-command")
+	}
+	cmd, ok := subcommands[args[0]]
+	if !ok {
+		usage()
+		return fmt.Errorf("invalid sub-command %q", args[0])
+	}
+	return cmd(args[1:], w)
+}
+
+func usage() {
+	names := []string{}
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmsg := "usage: gostore <%v> [options]\n"
+	fmt.Fprintf(os.Stderr, fmsg, strings.Join(names, "|"))
+	fmt.Fprintf(os.Stderr, "  keys and values are printed as base64.\n")
+	fmsg = "  snapshots sorted by comparator other than %q cannot be opened.\n"
+	fmt.Fprintf(os.Stderr, fmsg, api.Binarycomparator)
+}
+
+// common options for all sub-commands.
+type options struct {
+	name  string
+	paths []string
+	mmap  bool
+}
+
+func newflagset(cmdname string, opts *options) *flag.FlagSet {
+	f := flag.NewFlagSet(cmdname, flag.ContinueOnError)
+	f.StringVar(&opts.name, "name", "", "name of snapshot or index")
+	f.Var((*pathsflag)(&opts.paths), "paths", "comma separated disk paths")
+	f.BoolVar(&opts.mmap, "mmap", false, "mmap m-index files")
+	return f
+}
+
+func parseflags(f *flag.FlagSet, args []string, opts *options) error {
+	if err := f.Parse(args); err != nil {
+		return err
+	} else if opts.name == "" {
+		return fmt.Errorf("%v: missing -name", f.Name())
+	} else if len(opts.paths) == 0 {
+		return fmt.Errorf("%v: missing -paths", f.Name())
+	}
+	return nil
+}
+
+// pathsflag implement flag.Value for comma separated list of paths.
+type pathsflag []string
+
+func (paths *pathsflag) String() string {
+	return strings.Join(*paths, ",")
+}
+
+func (paths *pathsflag) Set(value string) error {
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			*paths = append(*paths, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import "os"
+import "fmt"
+import "bytes"
+import "strings"
+import "testing"
+import "encoding/json"
+import "encoding/base64"
+import "path/filepath"
+
+import "github.com/bnclabs/gostore/bubt"
+import "github.com/bnclabs/gostore/bogn"
+import "github.com/bnclabs/gostore/llrb"
+
+func TestSubcommands(t *testing.T) {
+	name, paths := "testcmd", makepaths(t)
+	snap := makesnapshot(t, name, paths, 100)
+	defer snap.Destroy()
+	defer snap.Close()
+	pathsarg := strings.Join(paths, ",")
+
+	// info
+	out := runcmd(t, "info", "-name", name, "-paths", pathsarg)
+	info := map[string]interface{}{}
+	if err := json.Unmarshal(out, &info); err != nil {
+		t.Fatal(err)
+	} else if x := info["metadata"].(map[string]interface{}); x["n"] != "100" {
+		t.Errorf("unexpected metadata %v", x)
+	}
+
+	// dump with bounds
+	out = runcmd(
+		t, "dump", "-name", name, "-paths", pathsarg,
+		"-from", "key010", "-to", "key019",
+	)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("expected %v, got %v", 10, len(lines))
+	}
+	for i, line := range lines {
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		} else if key := fmt.Sprintf("key%03d", 10+i); string(e.Key) != key {
+			t.Errorf("expected %q, got %q", key, e.Key)
+		} else if e.Deleted != (i == 0) {
+			t.Errorf("%q expected deleted %v", e.Key, i == 0)
+		}
+	}
+
+	// get
+	out = runcmd(t, "get", "-name", name, "-paths", pathsarg, "-key", "key011")
+	var e entry
+	if err := json.Unmarshal(out, &e); err != nil {
+		t.Fatal(err)
+	} else if string(e.Key) != "key011" || string(e.Value) != "val011" {
+		t.Errorf("unexpected %v", e)
+	}
+	// keys and values are base64 encoded.
+	ref := base64.StdEncoding.EncodeToString([]byte("val011"))
+	if !bytes.Contains(out, []byte(ref)) {
+		t.Errorf("expected %q in %s", ref, out)
+	}
+	err := run(
+		[]string{"get", "-name", name, "-paths", pathsarg, "-key", "xyz"},
+		&bytes.Buffer{},
+	)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+
+	// validate
+	out = runcmd(t, "validate", "-name", name, "-paths", pathsarg)
+	if ref := "testcmd: ok, 100 entries\n"; string(out) != ref {
+		t.Errorf("expected %q, got %q", ref, out)
+	}
+
+	// invalid sub-command and options.
+	if err := run([]string{"xyz"}, &bytes.Buffer{}); err == nil {
+		t.Errorf("expected error")
+	} else if err := run([]string{"info"}, &bytes.Buffer{}); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestLevels(t *testing.T) {
+	name, paths := "testlevels", makepaths(t)
+	pathsarg := strings.Join(paths, ",")
+	setts := bogn.Defaultsettings()
+	setts["bubt.diskpaths"] = pathsarg
+	setts["durable"] = true
+	bogn.PurgeIndex(name, setts.String("logpath"), "bubt", paths)
+	defer bogn.PurgeIndex(name, setts.String("logpath"), "bubt", paths)
+
+	index, err := bogn.New(name, setts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Start()
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(val), nil)
+	}
+	index.Close() // flush to disk.
+
+	out := runcmd(t, "levels", "-name", name, "-paths", pathsarg)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output %s", out)
+	}
+	fields := strings.Fields(lines[0])
+	if fields[6] != "WRITTEN" {
+		t.Errorf("expected %q, got %q", "WRITTEN", fields[6])
+	}
+	fields = strings.Fields(lines[1])
+	if fields[2] != "100" || fields[4] != "100" {
+		t.Errorf("unexpected level %v", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "1 levels, ") {
+		t.Errorf("unexpected summary %q", lines[2])
+	}
+	err = run(
+		[]string{"levels", "-name", name, "-paths", "x", "-diskstore", "x"},
+		&bytes.Buffer{},
+	)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func runcmd(t *testing.T, args ...string) []byte {
+	buf := &bytes.Buffer{}
+	if err := run(args, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makesnapshot(
+	t *testing.T, name string, paths []string, n int) *bubt.Snapshot {
+
+	mi := llrb.NewLLRB("cmd", llrb.Defaultsettings())
+	defer mi.Destroy()
+	for i := 0; i < n; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		mi.Set([]byte(key), []byte(val), nil)
+		if i%10 == 0 {
+			mi.Delete([]byte(key), nil, true /*lsm*/)
+		}
+	}
+	tree, err := bubt.NewBubt(name, paths, 4096, 4096, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itere := mi.ScanEntries()
+	metadata := []byte(fmt.Sprintf(`{"n":"%v"}`, n))
+	if err := tree.Build(itere, metadata); err != nil {
+		t.Fatal(err)
+	}
+	itere(true /*fin*/)
+	tree.Close()
+
+	snap, err := bubt.OpenSnapshot(name, paths, false /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
+
+func makepaths(t *testing.T) []string {
+	paths := []string{}
+	for _, base := range []string{"1", "2"} {
+		path := filepath.Join(os.TempDir(), "gostorecmd", base)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestDumpCorrupt(t *testing.T) {
+	name, paths := "testcorrupt", makepaths(t)
+	snap := makesnapshot(t, name, paths, 1000)
+	mfile := snap.Info().String("mfile")
+	snap.Close()
+	defer func() {
+		if snap, err := bubt.OpenSnapshot(name, paths, false); err == nil {
+			snap.Close()
+			snap.Destroy()
+		}
+	}()
+
+	// corrupt the m-block, range from a key cannot be positioned.
+	fd, err := os.OpenFile(mfile, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b [1]byte
+	if _, err := fd.ReadAt(b[:], 100); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xFF
+	if _, err := fd.WriteAt(b[:], 100); err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	pathsarg := strings.Join(paths, ",")
+	args := []string{
+		"dump", "-name", name, "-paths", pathsarg, "-from", "key000",
+	}
+	if err := run(args, &bytes.Buffer{}); err == nil {
+		t.Errorf("expected error")
+	}
+}
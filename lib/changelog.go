@@ -0,0 +1,219 @@
+package lib
+
+import "io"
+import "sort"
+import "sync"
+import "bytes"
+
+// Changelog remembers the latest mutations on an index, in seqno order,
+// and wakes up feeds tailing the index. Mutations are remembered only
+// while there is at least one active feed, and only the latest
+// `capacity` mutations are remembered.
+type Changelog struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	capacity int
+	feeds    int64
+	total    uint64        // number of mutations remembered so far.
+	entries  []changeentry // allocated when the first feed is opened.
+	closed   bool
+}
+
+type changeentry struct {
+	key     []byte
+	value   []byte
+	seqno   uint64
+	deleted bool
+}
+
+// NewChangelog create a changelog that can remember upto capacity
+// latest mutations.
+func NewChangelog(capacity int) *Changelog {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	cl := &Changelog{capacity: capacity}
+	cl.cond = sync.NewCond(&cl.mu)
+	return cl
+}
+
+// Append a mutation to changelog. Index shall call Append after the
+// mutation is applied, while holding its write lock, so that
+// mutations are appended in seqno order.
+func (cl *Changelog) Append(key, value []byte, seqno uint64, deleted bool) {
+	cl.mu.Lock()
+	if cl.feeds > 0 {
+		entry := &cl.entries[cl.total%uint64(len(cl.entries))]
+		entry.key = append(entry.key[:0], key...)
+		entry.value = entry.value[:0]
+		if deleted == false { // value of deleted entry is not fed.
+			entry.value = append(entry.value, value...)
+		}
+		entry.seqno, entry.deleted = seqno, deleted
+		cl.total++
+		cl.cond.Broadcast()
+	}
+	cl.mu.Unlock()
+}
+
+// Close changelog, all active feeds shall return io.EOF.
+func (cl *Changelog) Close() {
+	cl.mu.Lock()
+	cl.closed = true
+	cl.cond.Broadcast()
+	cl.mu.Unlock()
+}
+
+// Feed return a blocking iterator over mutations with seqno greater
+// than fromseqno, in seqno order. To begin with, latest version of
+// every entry with seqno greater than fromseqno is read from the index
+// using scan. Subsequently, iterator blocks for live mutations. scan
+// shall return a full table iterator that includes all mutations
+// applied before the call. Call iterator with fin as true to close the
+// feed, it is okay to do so from a different go-routine while the
+// iterator is blocked.
+//
+// If a feed falls behind by more than capacity mutations, it will
+// catch up again using scan. While catching up, only latest version
+// of an entry is returned, and entries removed from the index without
+// leaving a tombstone are not returned. Catching up scans the index
+// once and holds all entries newer than the last returned seqno in
+// memory, sorted by seqno.
+func (cl *Changelog) Feed(
+	fromseqno uint64,
+	scan func() func(bool) ([]byte, []byte, uint64, bool, error),
+) func(fin bool) ([]byte, []byte, uint64, bool, error) {
+
+	f := &changefeed{cl: cl, scan: scan, last: fromseqno}
+	cl.mu.Lock()
+	if cl.entries == nil {
+		cl.entries = make([]changeentry, cl.capacity)
+	}
+	cl.feeds++
+	f.pos, f.catchup = cl.total, true
+	cl.mu.Unlock()
+	return f.next
+}
+
+type changefeed struct {
+	cl      *Changelog
+	scan    func() func(bool) ([]byte, []byte, uint64, bool, error)
+	last    uint64 // seqno of the last returned mutation.
+	pos     uint64 // next position to read from changelog.
+	catchup bool
+	batch   []changeentry
+	entry   changeentry
+	closed  bool
+	err     error
+}
+
+func (f *changefeed) next(fin bool) ([]byte, []byte, uint64, bool, error) {
+	if fin {
+		f.close()
+		return nil, nil, 0, false, io.EOF
+	}
+
+	for {
+		if err := f.iseof(); err != nil {
+			return nil, nil, 0, false, err
+		}
+		if len(f.batch) > 0 {
+			entry := f.batch[0]
+			f.batch = f.batch[1:]
+			f.last = entry.seqno
+			return entry.key, entry.value, entry.seqno, entry.deleted, nil
+		}
+		if f.catchup {
+			// mutations applied during the pass are in changelog,
+			// switch to live mutations after a single pass.
+			f.batch, f.catchup = f.pass(), false
+			continue
+		}
+		if ok := f.read(); ok && f.entry.seqno > f.last {
+			entry := &f.entry
+			f.last = entry.seqno
+			return entry.key, entry.value, entry.seqno, entry.deleted, nil
+		}
+	}
+}
+
+// read next live mutation from changelog, return false if feed has
+// fallen behind, or if it is closed.
+func (f *changefeed) read() bool {
+	cl := f.cl
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for f.pos == cl.total && f.closed == false && cl.closed == false {
+		cl.cond.Wait()
+	}
+	if f.closed || cl.closed {
+		return false
+	}
+	if cl.total-f.pos > uint64(len(cl.entries)) { // fallen behind.
+		f.pos, f.catchup = cl.total, true
+		return false
+	}
+	entry := &cl.entries[f.pos%uint64(len(cl.entries))]
+	f.entry.key = append(f.entry.key[:0], entry.key...)
+	f.entry.value = append(f.entry.value[:0], entry.value...)
+	f.entry.seqno, f.entry.deleted = entry.seqno, entry.deleted
+	f.pos++
+	return true
+}
+
+// pass over the full index once, and return entries with seqno
+// greater than the last returned seqno, sorted by seqno.
+func (f *changefeed) pass() []changeentry {
+	batch := make([]changeentry, 0)
+	iter := f.scan()
+	if iter == nil {
+		return batch
+	}
+	key, value, seqno, deleted, err := iter(false /*fin*/)
+	for err == nil {
+		if seqno > f.last {
+			entry := changeentry{seqno: seqno, deleted: deleted}
+			entry.key = append(entry.key, key...)
+			if deleted == false { // value of deleted entry is not fed.
+				entry.value = append(entry.value, value...)
+			}
+			batch = append(batch, entry)
+		}
+		key, value, seqno, deleted, err = iter(false /*fin*/)
+	}
+	iter(true /*fin*/)
+	if err != io.EOF {
+		f.err = err
+	}
+	sort.Slice(batch, func(i, j int) bool {
+		if batch[i].seqno == batch[j].seqno {
+			return bytes.Compare(batch[i].key, batch[j].key) < 0
+		}
+		return batch[i].seqno < batch[j].seqno
+	})
+	return batch
+}
+
+func (f *changefeed) iseof() error {
+	cl := f.cl
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	} else if f.closed || cl.closed {
+		return io.EOF
+	}
+	return nil
+}
+
+func (f *changefeed) close() {
+	cl := f.cl
+	cl.mu.Lock()
+	if f.closed == false {
+		f.closed = true
+		cl.feeds--
+		cl.cond.Broadcast()
+	}
+	cl.mu.Unlock()
+}
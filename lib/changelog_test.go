@@ -0,0 +1,149 @@
+package lib
+
+import "io"
+import "fmt"
+import "sort"
+import "sync"
+import "time"
+import "testing"
+
+func TestChangelogFeed(t *testing.T) {
+	store := newteststore(100)
+	for i := 0; i < 100; i++ {
+		store.set(fmt.Sprintf("key%v", i), fmt.Sprintf("val%v", i))
+	}
+
+	feed := store.cl.Feed(50, store.scan)
+	// entries in the index.
+	for seqno := uint64(51); seqno <= 100; seqno++ {
+		testfeednext(t, feed, seqno, fmt.Sprintf("key%v", seqno-1))
+	}
+	// live mutations.
+	go func() {
+		for i := 0; i < 100; i++ {
+			store.set(fmt.Sprintf("live%v", i), fmt.Sprintf("val%v", i))
+		}
+		store.del("key0")
+	}()
+	for seqno := uint64(101); seqno <= 200; seqno++ {
+		testfeednext(t, feed, seqno, fmt.Sprintf("live%v", seqno-101))
+	}
+	key, _, seqno, deleted, err := feed(false /*fin*/)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(key) != "key0" || seqno != 201 || deleted == false {
+		t.Errorf("unexpected %q %v %v", key, seqno, deleted)
+	}
+
+	// close the feed while it is blocked.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		feed(true /*fin*/)
+	}()
+	if _, _, _, _, err := feed(false /*fin*/); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestChangelogBehind(t *testing.T) {
+	store := newteststore(8)
+	store.set("key", "val")
+
+	feed := store.cl.Feed(0, store.scan)
+	defer feed(true /*fin*/)
+	testfeednext(t, feed, 1, "key")
+
+	// fall behind by more than capacity.
+	for i := 0; i < 100; i++ {
+		store.set(fmt.Sprintf("key%v", i), fmt.Sprintf("val%v", i))
+	}
+	for seqno := uint64(2); seqno <= 101; seqno++ {
+		testfeednext(t, feed, seqno, fmt.Sprintf("key%v", seqno-2))
+	}
+	// initial catch up and one more after falling behind.
+	if store.scans != 2 {
+		t.Errorf("expected %v, got %v", 2, store.scans)
+	}
+
+	// closing the changelog shall close all its feeds.
+	store.cl.Close()
+	if _, _, _, _, err := feed(false /*fin*/); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func testfeednext(
+	t *testing.T,
+	feed func(bool) ([]byte, []byte, uint64, bool, error),
+	seqno uint64, key string) {
+
+	k, _, s, _, err := feed(false /*fin*/)
+	if err != nil {
+		t.Fatal(err)
+	} else if s != seqno {
+		t.Fatalf("expected %v, got %v", seqno, s)
+	} else if string(k) != key {
+		t.Fatalf("expected %q, got %q", key, k)
+	}
+}
+
+type testentry struct {
+	value   string
+	seqno   uint64
+	deleted bool
+}
+
+type teststore struct {
+	mu      sync.Mutex
+	seqno   uint64
+	scans   int // number of full table scans.
+	entries map[string]testentry
+	cl      *Changelog
+}
+
+func newteststore(capacity int) *teststore {
+	store := &teststore{entries: make(map[string]testentry)}
+	store.cl = NewChangelog(capacity)
+	return store
+}
+
+func (store *teststore) set(key, value string) {
+	store.mu.Lock()
+	store.seqno++
+	store.entries[key] = testentry{value: value, seqno: store.seqno}
+	store.cl.Append([]byte(key), []byte(value), store.seqno, false)
+	store.mu.Unlock()
+}
+
+func (store *teststore) del(key string) {
+	store.mu.Lock()
+	store.seqno++
+	store.entries[key] = testentry{seqno: store.seqno, deleted: true}
+	store.cl.Append([]byte(key), nil, store.seqno, true)
+	store.mu.Unlock()
+}
+
+func (store *teststore) scan() func(
+	bool) ([]byte, []byte, uint64, bool, error) {
+
+	store.mu.Lock()
+	store.scans++
+	keys := make([]string, 0, len(store.entries))
+	entries := make(map[string]testentry)
+	for key, entry := range store.entries {
+		keys = append(keys, key)
+		entries[key] = entry
+	}
+	store.mu.Unlock()
+
+	sort.Strings(keys)
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if fin || len(keys) == 0 {
+			return nil, nil, 0, false, io.EOF
+		}
+		key := keys[0]
+		keys = keys[1:]
+		entry := entries[key]
+		return []byte(key), []byte(entry.value), entry.seqno, entry.deleted, nil
+	}
+}
@@ -2,10 +2,10 @@ package llrb
 
 import "fmt"
 import "math"
-import "bytes"
 import "errors"
 import "unsafe"
 
+import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/lib"
 
 type llrbstats struct { // TODO: add json tags.
@@ -43,7 +43,10 @@ func maxheight(entries int64) float64 {
 	return 2 * math.Log2(float64(entries)) // 2x breathing space
 }
 
-func validatetree(root *Llrbnode, logprefix string, n, kmem, vmem int64) {
+func validatetree(
+	root *Llrbnode, logprefix string, n, kmem, vmem int64,
+	cmp api.Comparator) {
+
 	if root == nil {
 		return
 	}
@@ -52,7 +55,7 @@ func validatetree(root *Llrbnode, logprefix string, n, kmem, vmem int64) {
 
 	h := lib.NewhistorgramInt64(1, 256, 1)
 	blacks, depth, fromred := int64(0), int64(1), root.isred()
-	nblacks, km, vm := validatellrbtree(root, fromred, blacks, depth, h)
+	nblacks, km, vm := validatellrbtree(root, fromred, blacks, depth, h, cmp)
 	if km != keymemory {
 		fmsg := "validate(): keymemory:%v != actual:%v"
 		panic(fmt.Errorf(fmsg, keymemory, km))
@@ -86,7 +89,8 @@ following expectations on the tree should be met.
 */
 func validatellrbtree(
 	nd *Llrbnode, fromred bool, blacks, depth int64,
-	h *lib.HistogramInt64) (nblacks, keymem, valmem int64) {
+	h *lib.HistogramInt64,
+	cmp api.Comparator) (nblacks, keymem, valmem int64) {
 
 	if nd == nil {
 		return blacks, 0, 0
@@ -103,9 +107,9 @@ func validatellrbtree(
 	}
 
 	lblacks, lkm, lvm := validatellrbtree(
-		nd.left, nd.isred(), blacks, depth+1, h)
+		nd.left, nd.isred(), blacks, depth+1, h, cmp)
 	rblacks, rkm, rvm := validatellrbtree(
-		nd.right, nd.isred(), blacks, depth+1, h)
+		nd.right, nd.isred(), blacks, depth+1, h, cmp)
 
 	if lblacks != rblacks {
 		fmsg := "unbalancedblacks Left:%v Right:%v}"
@@ -113,11 +117,11 @@ func validatellrbtree(
 	}
 
 	key := nd.getkey()
-	if nd.left != nil && bytes.Compare(nd.left.getkey(), key) >= 0 {
+	if nd.left != nil && cmp(nd.left.getkey(), key) >= 0 {
 		fmsg := "validate(): sort order, left node %v is >= node %v"
 		panic(fmt.Errorf(fmsg, nd.left.getkey(), key))
 	}
-	if nd.left != nil && bytes.Compare(nd.left.getkey(), key) >= 0 {
+	if nd.left != nil && cmp(nd.left.getkey(), key) >= 0 {
 		fmsg := "validate(): sort order, node %v is >= right node %v"
 		panic(fmt.Errorf(fmsg, nd.right.getkey(), key))
 	}
@@ -151,6 +155,7 @@ func (meta *txnsmeta) gettxn(id uint64, db, snap interface{}) (txn *Txn) {
 		txn = newtxn(id, db, snap, meta.records, meta.cursors)
 	}
 	txn.db, txn.snapshot = db, snap
+	txn.optimistic, txn.rlocked = false, false
 	if txn.id = id; txn.id == 0 {
 		switch snap := txn.snapshot.(type) {
 		case *LLRB:
@@ -171,6 +176,14 @@ func (meta *txnsmeta) puttxn(txn *Txn) {
 		}
 		delete(txn.writes, index)
 	}
+	for index, head := range txn.reads {
+		for head != nil {
+			next := head.next
+			txn.putrecord(head)
+			head = next
+		}
+		delete(txn.reads, index)
+	}
 	for _, cur := range txn.cursors {
 		txn.putcursor(cur)
 	}
@@ -1,5 +1,6 @@
 package llrb
 
+import "github.com/bnclabs/gostore/api"
 import s "github.com/bnclabs/gosettings"
 import "github.com/cloudfoundry/gosigar"
 
@@ -15,12 +16,56 @@ import "github.com/cloudfoundry/gosigar"
 // "allocator" (string, default: "flist")
 //      Type of allocator to use.
 //
+// "txnisolation" (string, default: "snapshot")
+//      Used only in MVCC, isolation level for transactions. With
+//      "snapshot" isolation, commit will rollback if any of the keys
+//      written by the transaction was updated after it was read, first
+//      committer wins. With "serializable" isolation, commit will also
+//      rollback if any of the keys read by the transaction was updated.
+//      LLRB transactions are always serializable.
+//
+// "changelog" (int64, default: 10000)
+//      Number of latest mutations to remember for feeds tailing the
+//      index, refer Feed(). A feed falling behind by more mutations
+//      shall catch up by scanning the index.
+//
+// "checkpoints" (int64, default: 16)
+//      Used only in MVCC, maximum number of versions that can be pinned
+//      via Checkpoint(). Oldest checkpoint is retired to make room for
+//      a new one.
+//
+// "checkpointage" (int64, default: 0)
+//      Used only in MVCC, time period in seconds, after which a pinned
+//      version is retired. If ZERO, checkpoints are retained until
+//      released.
+//
+// "comparator" (string, default: "binary")
+//      Name of the comparator, registered via api.Registercomparator,
+//      to sort keys in the index.
+//
+// "mergeoperator" (string, default: "")
+//      Name of the merge operator, registered via
+//      api.Registermergeoperator, to resolve entries added by Merge().
+//      If empty, Merge() is not allowed.
+//
+// "accesstime" (bool, default: false)
+//      Record the access time of entries, in milliseconds since the
+//      index was created, on every Get, so that least recently accessed
+//      entries can be evicted, refer Evict().
+//
 func Defaultsettings() s.Settings {
 	_, _, freeram := getsysmem()
 	setts := s.Settings{
-		"memcapacity":  freeram,
-		"snapshottick": 4,
-		"allocator":    "flist",
+		"memcapacity":   freeram,
+		"snapshottick":  4,
+		"allocator":     "flist",
+		"txnisolation":  "snapshot",
+		"changelog":     10000,
+		"checkpoints":   16,
+		"checkpointage": 0,
+		"comparator":    api.Binarycomparator,
+		"mergeoperator": "",
+		"accesstime":    false,
 	}
 	return setts
 }
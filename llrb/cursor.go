@@ -4,28 +4,69 @@ import "io"
 import "fmt"
 import "unsafe"
 
+import "github.com/bnclabs/gostore/api"
+
 var _ = fmt.Sprintf("")
 
 // Cursor object maintains an active pointer into the index. Use OpenCursor
 // on Txn object to create a new cursor.
 type Cursor struct {
-	txn   *Txn
-	ynext bool
-	stack []uintptr
+	txn     *Txn
+	root    *Llrbnode
+	ynext   bool
+	reverse bool // stack is positioned for reverse traversal.
+	cmp     api.Comparator
+	tombs   api.Rangetombs
+	merge   api.Mergeoperator
+	stack   []uintptr
 }
 
 func (cur *Cursor) opencursor(txn *Txn, snapshot interface{}, key []byte) *Cursor {
 	cur.txn = txn // will be nil if opened on a view.
 
-	var root *Llrbnode
+	cur.root, cur.cmp, cur.tombs = cur.getroot(snapshot)
+	cur.merge = getmergeoperator(snapshot)
+	cur.stack, cur.ynext = cur.first(cur.root, key, cur.stack), false
+	cur.reverse = false
+	return cur
+}
+
+// openreverse position the cursor at the last entry less than or equal
+// to key. If key is nil, cursor is positioned at the last entry.
+func (cur *Cursor) openreverse(
+	txn *Txn, snapshot interface{}, key []byte) *Cursor {
+
+	cur.txn = txn // will be nil if opened on a view.
+	cur.root, cur.cmp, cur.tombs = cur.getroot(snapshot)
+	cur.merge = getmergeoperator(snapshot)
+	cur.stack, cur.ynext = cur.last(cur.root, key, cur.stack), false
+	cur.reverse = true
+	return cur
+}
+
+func (cur *Cursor) getroot(
+	snapshot interface{}) (*Llrbnode, api.Comparator, api.Rangetombs) {
+
 	switch snap := snapshot.(type) {
 	case *LLRB:
-		root = snap.getroot()
+		return snap.getroot(), snap.cmp, snap.tombs
 	case *mvccsnapshot:
-		root = snap.getroot()
+		return snap.getroot(), snap.mvcc.cmp, snap.gettombs()
 	}
-	cur.stack, cur.ynext = cur.first(root, key, cur.stack), false
-	return cur
+	return nil, nil, nil
+}
+
+// entry return node's key, value, seqno and whether it is deleted,
+// entries covered by a range tombstone are returned as deleted and
+// merge operands are resolved against nil value.
+func (cur *Cursor) entry(
+	nd *Llrbnode) (key, value []byte, seqno uint64, deleted bool) {
+
+	key, seqno = nd.getkey(), nd.getseqno()
+	if tombseqno, ok := cur.tombs.Covers(key, seqno, cur.cmp); ok {
+		return key, nil, tombseqno, true
+	}
+	return key, nd.mergedvalue(cur.merge), seqno, nd.istombstone()
 }
 
 // Key return current key under the cursor. Returned byte slice will
@@ -37,7 +78,8 @@ func (cur *Cursor) Key() (key []byte, deleted bool) {
 	}
 	ptr := cur.stack[len(cur.stack)-1]
 	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-	return nd.getkey(), nd.isdeleted()
+	key, _, _, deleted = cur.entry(nd)
+	return key, deleted
 }
 
 // Value return current value under the cursor. Returned byte slice will
@@ -49,7 +91,22 @@ func (cur *Cursor) Value() []byte {
 	}
 	ptr := cur.stack[len(cur.stack)-1]
 	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-	return nd.Value()
+	_, value, _, _ := cur.entry(nd)
+	return value
+}
+
+// Expiry return expiry time of current entry under the cursor, in
+// unix seconds, ZERO if entry never expires.
+func (cur *Cursor) Expiry() uint64 {
+	if len(cur.stack) == 0 {
+		return 0
+	}
+	ptr := cur.stack[len(cur.stack)-1]
+	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
+	if _, _, _, deleted := cur.entry(nd); deleted {
+		return 0
+	}
+	return nd.getexpiry()
 }
 
 // GetNext move cursor to next entry in snapshot and return its key and
@@ -60,6 +117,7 @@ func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	if len(cur.stack) == 0 {
 		return nil, nil, false, io.EOF
 	}
+	cur.forward()
 	cur.stack = cur.next(cur.stack)
 	if len(cur.stack) == 0 {
 		return nil, nil, false, io.EOF
@@ -69,6 +127,23 @@ func (cur *Cursor) GetNext() (key, value []byte, deleted bool, err error) {
 	return
 }
 
+// GetPrev move cursor to previous entry in snapshot and return its key
+// and value. Returned byte slices will be a reference to index entry,
+// hence must not be used after transaction is committed or aborted.
+func (cur *Cursor) GetPrev() (key, value []byte, deleted bool, err error) {
+	if len(cur.stack) == 0 {
+		return nil, nil, false, io.EOF
+	}
+	cur.backward()
+	cur.stack = cur.prev(cur.stack)
+	if len(cur.stack) == 0 {
+		return nil, nil, false, io.EOF
+	}
+	key, deleted = cur.Key()
+	value = cur.Value()
+	return
+}
+
 // Set is an alias to txn.Set call. The current position of the cursor
 // does not affect the set operation.
 func (cur *Cursor) Set(key, value, oldvalue []byte) []byte {
@@ -108,27 +183,72 @@ func (cur *Cursor) YNext(
 		cur.ynext = true
 		ptr := cur.stack[len(cur.stack)-1]
 		nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-		key, seqno, deleted = nd.getkey(), nd.getseqno(), nd.isdeleted()
-		value = nd.Value()
+		key, value, seqno, deleted = cur.entry(nd)
 		return
 	}
+	cur.forward()
 	cur.stack = cur.next(cur.stack)
 	if len(cur.stack) == 0 {
 		return nil, nil, 0, false, io.EOF
 	}
 	ptr := cur.stack[len(cur.stack)-1]
 	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
-	key, seqno, deleted = nd.getkey(), nd.getseqno(), nd.isdeleted()
-	value = nd.Value()
+	key, value, seqno, deleted = cur.entry(nd)
+	return
+}
+
+// YPrev implements Iterator api, to iterate over the index in reverse
+// order. Typically used for reverse lsm-sort.
+func (cur *Cursor) YPrev(
+	fin bool) (key, value []byte, seqno uint64, deleted bool, err error) {
+
+	if len(cur.stack) == 0 {
+		return nil, nil, 0, false, io.EOF
+	}
+	if cur.ynext == false {
+		cur.ynext = true
+		ptr := cur.stack[len(cur.stack)-1]
+		nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
+		key, value, seqno, deleted = cur.entry(nd)
+		return
+	}
+	cur.backward()
+	cur.stack = cur.prev(cur.stack)
+	if len(cur.stack) == 0 {
+		return nil, nil, 0, false, io.EOF
+	}
+	ptr := cur.stack[len(cur.stack)-1]
+	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
+	key, value, seqno, deleted = cur.entry(nd)
 	return
 }
 
+// forward rebuild the stack, for forward traversal, if cursor was
+// positioned for reverse traversal. Stack shall point to the same entry.
+func (cur *Cursor) forward() {
+	if cur.reverse {
+		key, _ := cur.Key()
+		cur.stack = cur.first(cur.root, key, cur.stack[:0])
+		cur.reverse = false
+	}
+}
+
+// backward rebuild the stack, for reverse traversal, if cursor was
+// positioned for forward traversal. Stack shall point to the same entry.
+func (cur *Cursor) backward() {
+	if cur.reverse == false {
+		key, _ := cur.Key()
+		cur.stack = cur.last(cur.root, key, cur.stack[:0])
+		cur.reverse = true
+	}
+}
+
 func (cur *Cursor) first(
 	root *Llrbnode, key []byte, stack []uintptr) []uintptr {
 
 	for nd := root; nd != nil; {
 		ptr := (uintptr)(unsafe.Pointer(nd))
-		if nd != nil && nd.ltkey(key, true) {
+		if nd != nil && nd.ltkey(key, true, cur.cmp) {
 			stack = append(stack, ptr|0x3)
 			nd = nd.right
 			continue
@@ -139,6 +259,32 @@ func (cur *Cursor) first(
 	return cur.popout(stack)
 }
 
+// last is reverse of first, nodes greater than key and nodes whose
+// right sub-tree is traversed are marked as visited.
+func (cur *Cursor) last(
+	root *Llrbnode, key []byte, stack []uintptr) []uintptr {
+
+	for nd := root; nd != nil; {
+		ptr := (uintptr)(unsafe.Pointer(nd))
+		if key != nil && nd.gtkey(key, false, cur.cmp) {
+			stack = append(stack, ptr|0x3)
+			nd = nd.left
+			continue
+		}
+		stack = append(stack, ptr|0x0)
+		nd = nd.right
+	}
+	return cur.popout(stack)
+}
+
+func (cur *Cursor) prev(stack []uintptr) []uintptr {
+	ptr := stack[len(stack)-1]
+	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
+	stack[len(stack)-1] = ptr | 0x3
+	stack = cur.rightmost(nd.left, stack)
+	return cur.popout(stack)
+}
+
 func (cur *Cursor) next(stack []uintptr) []uintptr {
 	ptr := stack[len(stack)-1]
 	nd := (*Llrbnode)(unsafe.Pointer(ptr & (^uintptr(0x3))))
@@ -171,3 +317,10 @@ func (cur *Cursor) leftmost(nd *Llrbnode, stack []uintptr) []uintptr {
 	}
 	return stack
 }
+
+func (cur *Cursor) rightmost(nd *Llrbnode, stack []uintptr) []uintptr {
+	for ; nd != nil; nd = nd.right {
+		stack = append(stack, (uintptr)(unsafe.Pointer(nd))|0x0)
+	}
+	return stack
+}
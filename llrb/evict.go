@@ -0,0 +1,96 @@
+package llrb
+
+import "sort"
+import "time"
+
+import "github.com/bnclabs/gostore/api"
+
+// evictwindow number of entries sampled, in key order, in every round
+// of eviction, of which the least recently accessed quarter is evicted.
+const evictwindow = 64
+
+type accesssample struct {
+	key    []byte
+	access uint64
+	size   int64 // memory held by the entry.
+}
+
+// sampleaccess collect upto len(samples) entries, in key order, whose
+// key is greater than hand, or from the smallest key if hand is nil.
+// Return the number of entries collected.
+func sampleaccess(
+	nd *Llrbnode, hand []byte, cmp api.Comparator,
+	samples []accesssample, n int) int {
+
+	if nd == nil || n == len(samples) {
+		return n
+	}
+	key := nd.getkey()
+	if hand != nil && cmp(key, hand) <= 0 {
+		return sampleaccess(nd.right, hand, cmp, samples, n)
+	}
+	n = sampleaccess(nd.left, hand, cmp, samples, n)
+	if n < len(samples) {
+		samples[n].key = append(samples[n].key[:0], key...)
+		samples[n].access = nd.getaccess()
+		samples[n].size = int64(nodesize + len(key))
+		if nv := nd.nodevalue(); nv != nil {
+			samples[n].size += int64(nvaluesize + len(nv.value()))
+		}
+		n++
+	}
+	return sampleaccess(nd.right, hand, cmp, samples, n)
+}
+
+// evictcold is common to LLRB and MVCC. Sweep the index, a window at a
+// time starting after hand, removing least recently accessed entries
+// in each window until atleast `excess` bytes of entries are removed.
+// Sweep wraps around the end of the index, and stop after evicting
+// count entries. Return the number of entries evicted and the new
+// position of hand.
+func evictcold(
+	excess, count int64,
+	sample func(hand []byte, samples []accesssample) int,
+	remove func(key []byte), hand []byte) (int64, []byte) {
+
+	n := int64(0)
+	samples := make([]accesssample, evictwindow)
+	for n < count && excess > 0 {
+		m := sample(hand, samples)
+		if m == 0 && hand == nil { // empty index.
+			break
+		} else if m == 0 { // wrap around.
+			hand = nil
+			continue
+		}
+		window := samples[:m]
+		hand = append(hand[:0], window[m-1].key...)
+		sort.Slice(window, func(i, j int) bool {
+			return window[i].access < window[j].access
+		})
+		for _, sample := range window[:(m+3)/4] {
+			remove(sample.key)
+			excess, n = excess-sample.size, n+1
+		}
+	}
+	return n, hand
+}
+
+// entrymemory approximate the memory held by entries in index, from its
+// statistics. Unlike node.alloc and value.alloc, this does not include
+// nodes waiting to be reclaimed.
+func entrymemory(stats map[string]interface{}) int64 {
+	count := stats["n_count"].(int64)
+	kmem, vmem := stats["keymemory"].(int64), stats["valmemory"].(int64)
+	return kmem + vmem + (count * int64(nodesize+nvaluesize))
+}
+
+// accessclock return the time elapsed since epoch in milliseconds, to
+// be recorded as node's access time. Return ZERO if accesstime is not
+// enabled.
+func accessclock(accesstime bool, epoch time.Time) uint64 {
+	if accesstime == false {
+		return 0
+	}
+	return uint64(time.Since(epoch) / time.Millisecond)
+}
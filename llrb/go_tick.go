@@ -20,6 +20,7 @@ loop:
 			break loop
 		default:
 		}
-		mvcc.makesnapshot(false /*init*/)
+		mvcc.expirecheckpoints()
+		mvcc.makesnapshot(false /*init*/, false /*pin*/)
 	}
 }
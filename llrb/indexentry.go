@@ -8,6 +8,8 @@ type indexentry struct {
 	value   []byte
 	seqno   uint64
 	deleted bool
+	expiry  uint64
+	merge   bool
 	err     error
 }
 
@@ -20,6 +22,7 @@ func (entry *indexentry) set(
 	copy(entry.value, value)
 
 	entry.seqno, entry.deleted, entry.err = seqno, deleted, err
+	entry.expiry, entry.merge = 0, false
 	return entry
 }
 
@@ -38,3 +41,11 @@ func (entry *indexentry) ID() string {
 func (entry *indexentry) Valueref() (valuelen uint64, vlogpos int64) {
 	return uint64(len(entry.value)), -1
 }
+
+func (entry *indexentry) Expiry() uint64 {
+	return entry.expiry
+}
+
+func (entry *indexentry) Ismerge() bool {
+	return entry.merge
+}
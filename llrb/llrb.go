@@ -29,26 +29,39 @@ type LLRB struct { // tree container
 	valarena  api.Mallocer
 	root      unsafe.Pointer // *Llrbnode
 	seqno     uint64
+	tombs     api.Rangetombs // copy on write, refer DeleteRange.
 	rw        sync.RWMutex
 	finch     chan struct{}
 	txnsmeta
+	changelog *lib.Changelog
+	ownlog    bool // changelog is not supplied via Setchangelog.
 
 	// settings
 	memcapacity int64
 	allocator   string
+	nchangelog  int64
+	cmp         api.Comparator
+	merge       api.Mergeoperator
+	accesstime  bool
 	setts       s.Settings
 	logprefix   string
+
+	// eviction
+	epoch     time.Time
+	evicthand []byte // evict from key after evicthand, refer Evict.
 }
 
 // NewLLRB a new instance of in-memory sorted index.
 func NewLLRB(name string, setts s.Settings) *LLRB {
-	llrb := &LLRB{name: name, finch: make(chan struct{})}
+	llrb := &LLRB{name: name, finch: make(chan struct{}), epoch: time.Now()}
 	llrb.logprefix = fmt.Sprintf("LLRB [%s]", name)
 	llrb.inittxns()
 
 	setts = make(s.Settings).Mixin(Defaultsettings(), setts)
 	llrb.readsettings(setts)
 	llrb.setts = setts
+	llrb.changelog = lib.NewChangelog(int(llrb.nchangelog))
+	llrb.ownlog = true
 
 	llrb.nodearena = malloc.NewArena(llrb.memcapacity, llrb.allocator)
 	llrb.valarena = malloc.NewArena(llrb.memcapacity, llrb.allocator)
@@ -87,6 +100,18 @@ func LoadLLRB(name string, setts s.Settings, iter api.Iterator) *LLRB {
 func (llrb *LLRB) readsettings(setts s.Settings) *LLRB {
 	llrb.memcapacity = setts.Int64("memcapacity")
 	llrb.allocator = setts.String("allocator")
+	llrb.nchangelog = setts.Int64("changelog")
+	cmp, err := api.Getcomparator(setts.String("comparator"))
+	if err != nil {
+		panic(err)
+	}
+	llrb.cmp = cmp
+	if name := setts.String("mergeoperator"); name != "" {
+		if llrb.merge, err = api.Getmergeoperator(name); err != nil {
+			panic(err)
+		}
+	}
+	llrb.accesstime = setts.Bool("accesstime")
 	return llrb
 }
 
@@ -101,10 +126,12 @@ func (llrb *LLRB) setroot(root *Llrbnode) {
 func (llrb *LLRB) newnode(k, v []byte) *Llrbnode {
 	ptr := llrb.nodearena.Alloc(int64(nodesize + len(k)))
 	nd := (*Llrbnode)(ptr)
-	nd.setdirty().setred().setkey(k)
+	nd.setdirty().setred().setkey(k).clearmerge()
+	nd.setaccess(accessclock(llrb.accesstime, llrb.epoch))
 	if len(v) > 0 {
 		ptr = llrb.valarena.Alloc(int64(nvaluesize + len(v)))
 		nv := (*nodevalue)(ptr)
+		nv.hdr = 0
 		nd.setnodevalue(nv.setvalue(v))
 	}
 	llrb.n_nodes++
@@ -200,36 +227,100 @@ func (llrb *LLRB) Getseqno() uint64 {
 // Set a key, value pair in the index, if key is already present,
 // its value will be over-written. Make sure key is not nil.
 // Return old value if oldvalue points to valid buffer.
+// Values larger than Maxvaluesize shall panic.
 func (llrb *LLRB) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	return llrb.SetExpiry(key, value, oldvalue, 0)
+}
+
+// SetTTL is same as Set, but the entry shall expire after ttl duration
+// from now. Once expired, the entry is treated as deleted by readers.
+func (llrb *LLRB) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) (ov []byte, cas uint64) {
+
+	return llrb.SetExpiry(key, value, oldvalue, api.Ttlexpiry(ttl))
+}
+
+// SetExpiry is same as Set, but the entry shall expire at expiry,
+// specified as unix seconds. Expiry of ZERO never expires.
+func (llrb *LLRB) SetExpiry(
+	key, value, oldvalue []byte, expiry uint64) (ov []byte, cas uint64) {
+
+	if err := checkvalue(value); err != nil {
+		panic(fmt.Errorf("%v SetExpiry(%q): %v", llrb.logprefix, key, err))
+	}
 	if !llrb.lock() {
 		return
 	}
+	ov, cas = llrb.setexpiry(key, value, oldvalue, expiry)
+	llrb.unlock()
+	return ov, cas
+}
+
+func (llrb *LLRB) setexpiry(
+	key, value, oldvalue []byte, expiry uint64) ([]byte, uint64) {
 
 	llrb.seqno++
 
 	root, newnd, oldnd := llrb.upsert(llrb.getroot(), 1 /*depth*/, key, value)
 	root.setblack()
 	newnd.cleardeleted()
+	newnd.clearmerge()
 	newnd.cleardirty()
 	newnd.setseqno(llrb.seqno)
+	newnd.setexpiry(expiry, llrb.valarena)
 	seqno := llrb.seqno
 
 	llrb.setroot(root)
 	llrb.upsertcounts(key, value, oldnd)
+	llrb.changelog.Append(key, newnd.livevalue(), seqno, newnd.istombstone())
 
 	if oldvalue != nil {
 		var val []byte
-		if oldnd != nil && oldnd.isdeleted() == false {
+		if oldnd != nil && oldnd.istombstone() == false {
 			val = oldnd.Value()
+			if oldnd.ismerge() {
+				val = llrb.merge(key, nil, val)
+			}
 		}
 		oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
 		copy(oldvalue, val)
 	}
 
 	llrb.freenode(oldnd)
+	return oldvalue, seqno
+}
 
+// Apply mutations in batch under a single lock acquisition, refer
+// api.Batch. Successful mutations are assigned contiguous seqnos.
+// Return api.ErrorInvalidCAS if one or more SetCAS failed.
+func (llrb *LLRB) Apply(batch *api.Batch) (err error) {
+	ops := batch.Sorted(llrb.cmp)
+	if !llrb.lock() {
+		return fmt.Errorf("closed")
+	}
+	for _, op := range ops {
+		switch op.Cmd {
+		case api.BatchSet:
+			if op.Err = checkvalue(op.Value); op.Err != nil {
+				err = op.Err
+				continue
+			}
+			_, op.Seqno = llrb.setexpiry(op.Key, op.Value, nil, 0)
+		case api.BatchSetCAS:
+			if op.Err = checkvalue(op.Value); op.Err != nil {
+				err = op.Err
+				continue
+			}
+			_, op.Seqno, op.Err = llrb.setcas(op.Key, op.Value, nil, op.Cas, 0)
+			if op.Err != nil {
+				err = op.Err
+			}
+		case api.BatchDelete:
+			_, op.Seqno = llrb.dodelete(op.Key, nil, op.Lsm)
+		}
+	}
 	llrb.unlock()
-	return oldvalue, seqno
+	return err
 }
 
 // returns root, newnd, oldnd
@@ -247,9 +338,9 @@ func (llrb *LLRB) upsert(
 
 	nd = llrb.walkdownrot23(nd)
 
-	if nd.gtkey(key, false) {
+	if nd.gtkey(key, false, llrb.cmp) {
 		nd.left, newnd, oldnd = llrb.upsert(nd.left, depth+1, key, value)
-	} else if nd.ltkey(key, false) {
+	} else if nd.ltkey(key, false, llrb.cmp) {
 		nd.right, newnd, oldnd = llrb.upsert(nd.right, depth+1, key, value)
 	} else {
 		oldnd, dirty = llrb.clonenode(nd), false
@@ -260,6 +351,7 @@ func (llrb *LLRB) upsert(
 		if len(value) > 0 { // add new value if req.
 			ptr := llrb.valarena.Alloc(int64(nvaluesize + len(value)))
 			nv := (*nodevalue)(ptr)
+			nv.hdr = 0
 			nd, dirty = nd.setnodevalue(nv.setvalue(value)), true
 		}
 		newnd = nd
@@ -280,17 +372,21 @@ func (llrb *LLRB) upsert(
 func (llrb *LLRB) SetCAS(
 	key, value, oldvalue []byte, cas uint64) ([]byte, uint64, error) {
 
+	if err := checkvalue(value); err != nil {
+		return oldvalue, 0, err
+	}
 	if !llrb.lock() {
 		return nil, 0, fmt.Errorf("closed")
 	}
-	oldvalue, cas, err := llrb.setcas(key, value, oldvalue, cas)
+	oldvalue, cas, err := llrb.setcas(key, value, oldvalue, cas, 0)
 	llrb.unlock()
 
 	return oldvalue, cas, err
 }
 
 func (llrb *LLRB) setcas(
-	key, value, oldvalue []byte, cas uint64) ([]byte, uint64, error) {
+	key, value, oldvalue []byte,
+	cas, expiry uint64) ([]byte, uint64, error) {
 
 	// CAS matches, go ahead with upsert.
 	root, depth := llrb.getroot(), int64(1)
@@ -304,17 +400,23 @@ func (llrb *LLRB) setcas(
 	llrb.seqno++
 	root.setblack()
 	newnd.cleardeleted()
+	newnd.clearmerge()
 	newnd.cleardirty()
 	newnd.setseqno(llrb.seqno)
+	newnd.setexpiry(expiry, llrb.valarena)
 	seqno := llrb.seqno
 
 	llrb.setroot(root)
 	llrb.upsertcounts(key, value, oldnd)
+	llrb.changelog.Append(key, newnd.livevalue(), seqno, newnd.istombstone())
 
 	if oldvalue != nil {
 		var val []byte
-		if oldnd != nil && oldnd.isdeleted() == false {
+		if oldnd != nil && oldnd.istombstone() == false {
 			val = oldnd.Value()
+			if oldnd.ismerge() {
+				val = llrb.merge(key, nil, val)
+			}
 		}
 		oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
 		copy(oldvalue, val)
@@ -343,22 +445,26 @@ func (llrb *LLRB) upsertcas(
 
 	nd = llrb.walkdownrot23(nd)
 
-	if nd.gtkey(key, false) {
+	if nd.gtkey(key, false, llrb.cmp) {
 		depth++
 		nd.left, newnd, oldnd, err =
 			llrb.upsertcas(nd.left, depth, key, value, cas)
 
-	} else if nd.ltkey(key, false) {
+	} else if nd.ltkey(key, false, llrb.cmp) {
 		depth++
 		nd.right, newnd, oldnd, err =
 			llrb.upsertcas(nd.right, depth, key, value, cas)
 
 	} else /*equal*/ {
-		if nd.isdeleted() && (cas != 0 && cas != nd.getseqno()) {
+		seqno, tombstone := nd.getseqno(), nd.istombstone()
+		if tombseqno, ok := llrb.tombs.Covers(key, seqno, llrb.cmp); ok {
+			seqno, tombstone = tombseqno, true
+		}
+		if tombstone && (cas != 0 && cas != seqno) {
 			newnd = nd
 			err = api.ErrorInvalidCAS
 
-		} else if nd.isdeleted() == false && cas != nd.getseqno() {
+		} else if tombstone == false && cas != seqno {
 			newnd = nd
 			err = api.ErrorInvalidCAS
 
@@ -371,6 +477,7 @@ func (llrb *LLRB) upsertcas(
 			if len(value) > 0 { // add new value if req.
 				ptr := llrb.valarena.Alloc(int64(nvaluesize + len(value)))
 				nv := (*nodevalue)(ptr)
+				nv.hdr = 0
 				nd, dirty = nd.setnodevalue(nv.setvalue(value)), true
 			}
 			newnd = nd
@@ -385,6 +492,52 @@ func (llrb *LLRB) upsertcas(
 	return nd, newnd, oldnd, err
 }
 
+// Merge operand into the value for key, using the merge operator
+// configured via "mergeoperator" settings. Operand is resolved right
+// away if key has a value, is deleted, or is covered by a range
+// tombstone. Otherwise operand is stored as is, and resolved against
+// nil value by readers, refer Getmerge. Return the seqno of mutation.
+func (llrb *LLRB) Merge(key, operand []byte) uint64 {
+	if llrb.merge == nil {
+		panic(fmt.Errorf("%v mergeoperator not configured", llrb.logprefix))
+	}
+	if err := checkvalue(operand); err != nil {
+		panic(fmt.Errorf("%v Merge(%q): %v", llrb.logprefix, key, err))
+	}
+	if !llrb.lock() {
+		return 0
+	}
+
+	seqno := uint64(0)
+	nd, _ := llrb.getkey(llrb.getroot(), key)
+	if nd != nil {
+		seqno = nd.getseqno()
+	}
+	_, covered := llrb.tombs.Covers(key, seqno, llrb.cmp)
+	value, ismerge := mergeoperand(llrb.merge, nd, covered, key, operand)
+
+	llrb.seqno++
+
+	root, newnd, oldnd := llrb.upsert(llrb.getroot(), 1 /*depth*/, key, value)
+	root.setblack()
+	newnd.cleardeleted()
+	if newnd.clearmerge(); ismerge {
+		newnd.setmerge()
+	}
+	newnd.cleardirty()
+	newnd.setseqno(llrb.seqno)
+	newnd.setexpiry(0, llrb.valarena)
+	seqno = llrb.seqno
+
+	llrb.setroot(root)
+	llrb.upsertcounts(key, value, oldnd)
+	llrb.changelog.Append(key, newnd.mergedvalue(llrb.merge), seqno, false)
+	llrb.freenode(oldnd)
+
+	llrb.unlock()
+	return seqno
+}
+
 // Delete key from index. Key should not be nil, if key found
 // return its value. If lsm is true, then don't delete the node
 // instead mark the node as deleted. Again, if lsm is true
@@ -411,11 +564,16 @@ func (llrb *LLRB) dodelete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 	if lsm {
 		if nd, ok := llrb.getkey(llrb.getroot(), key); ok {
 			nd.setseqnodeleted(llrb.seqno)
+			nd.setexpiry(0, llrb.valarena)
 			if oldvalue != nil {
 				val = nd.Value()
+				if nd.ismerge() {
+					val = llrb.merge(key, nil, val)
+				}
 				oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
 				copy(oldvalue, val)
 			}
+			nd.clearmerge()
 
 		} else {
 			root, newnd, oldnd := llrb.upsert(root, 1 /*depth*/, key, nil)
@@ -426,9 +584,11 @@ func (llrb *LLRB) dodelete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 			newnd.setdeleted()
 			newnd.cleardirty()
 			newnd.setseqno(llrb.seqno)
+			newnd.setexpiry(0, llrb.valarena)
 			llrb.setroot(root)
 			llrb.upsertcounts(key, nil, oldnd /*nil*/)
 		}
+		llrb.changelog.Append(key, nil, seqno, true /*deleted*/)
 
 	} else {
 		root, deleted := llrb.delete(root, key)
@@ -439,21 +599,58 @@ func (llrb *LLRB) dodelete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 		llrb.delcounts(deleted)
 		if deleted != nil && oldvalue != nil {
 			val = deleted.Value()
+			if deleted.ismerge() {
+				val = llrb.merge(key, nil, val)
+			}
 			oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
 			copy(oldvalue, val)
 			llrb.freenode(deleted)
 		}
+		if deleted != nil {
+			llrb.changelog.Append(key, nil, seqno, true /*deleted*/)
+		}
 	}
 
 	return oldvalue, seqno
 }
 
+// DeleteRange mark all keys between low, inclusive, and high,
+// exclusive, as deleted by recording a range tombstone at the next
+// seqno. Covered entries are reported as deleted, with the seqno of
+// the range tombstone, by Get, scans and cursors, while keys set after
+// this call are not affected. A nil bound is treated as unbounded.
+// Return the seqno of the range tombstone.
+func (llrb *LLRB) DeleteRange(low, high []byte) uint64 {
+	if err := api.Validaterange(low, high, llrb.cmp); err != nil {
+		panic(err)
+	}
+	if !llrb.lock() {
+		return 0
+	}
+	llrb.seqno++
+	seqno := llrb.seqno
+	llrb.tombs = llrb.tombs.Add(low, high, seqno)
+	llrb.unlock()
+	return seqno
+}
+
+// Rangetombs return range tombstones recorded so far via DeleteRange.
+// Returned list must not be modified.
+func (llrb *LLRB) Rangetombs() api.Rangetombs {
+	if !llrb.rlock() {
+		return nil
+	}
+	tombs := llrb.tombs
+	llrb.runlock()
+	return tombs
+}
+
 func (llrb *LLRB) delete(nd *Llrbnode, key []byte) (newnd, deleted *Llrbnode) {
 	if nd == nil {
 		return nil, nil
 	}
 
-	if nd.gtkey(key, false) {
+	if nd.gtkey(key, false, llrb.cmp) {
 		if nd.left == nil { // key not present. Nothing to delete
 			return nd, nil
 		}
@@ -467,14 +664,14 @@ func (llrb *LLRB) delete(nd *Llrbnode, key []byte) (newnd, deleted *Llrbnode) {
 			nd = llrb.rotateright(nd)
 		}
 		// If @key equals @h.Item and no right children at @h
-		if !nd.ltkey(key, false) && nd.right == nil {
+		if !nd.ltkey(key, false, llrb.cmp) && nd.right == nil {
 			return nil, nd
 		}
 		if nd.right != nil && !nd.right.isred() && !nd.right.left.isred() {
 			nd = llrb.moveredright(nd)
 		}
 		// If @key equals @h.Item, and (from above) 'h.Right != nil'
-		if !nd.ltkey(key, false) {
+		if !nd.ltkey(key, false, llrb.cmp) {
 			var subdeleted *Llrbnode
 			nd.right, subdeleted = llrb.deletemin(nd.right)
 			if subdeleted == nil {
@@ -533,8 +730,26 @@ func (llrb *LLRB) BeginTxn(id uint64) api.Transactor {
 	return txn
 }
 
+// BeginOptimistic is same as BeginTxn, except that structure is not
+// locked while the transaction is open. Reads are applied under read
+// lock, writes are applied under write lock during Commit, and cursors
+// hold the read lock until the transaction is released, refer
+// Txn.Release. Reads are not repeatable, it is upto the caller to
+// validate them before commit.
+func (llrb *LLRB) BeginOptimistic(id uint64) api.Transactor {
+	atomic.AddInt64(&llrb.activetxns, 1)
+	atomic.AddInt64(&llrb.n_txns, 1)
+	txn := llrb.gettxn(id, llrb /*db*/, llrb /*snap*/)
+	txn.optimistic = true
+	return txn
+}
+
 // rollback will never happen B-)
 func (llrb *LLRB) commit(txn *Txn) error {
+	if txn.optimistic {
+		txn.Release()
+		llrb.lock()
+	}
 	for _, head := range txn.writes {
 		prevkey := []byte(nil)
 		for head != nil {
@@ -555,7 +770,7 @@ func (llrb *LLRB) commit(txn *Txn) error {
 func (llrb *LLRB) commitrecord(rec *record) (err error) {
 	switch rec.cmd {
 	case cmdSet:
-		_, _, err = llrb.setcas(rec.key, rec.value, nil, rec.seqno)
+		_, _, err = llrb.setcas(rec.key, rec.value, nil, rec.seqno, rec.expiry)
 	case cmdDelete:
 		llrb.dodelete(rec.key, nil, rec.lsm)
 	}
@@ -563,6 +778,10 @@ func (llrb *LLRB) commitrecord(rec *record) (err error) {
 }
 
 func (llrb *LLRB) aborttxn(txn *Txn) error {
+	if txn.optimistic {
+		txn.Release()
+		llrb.lock()
+	}
 	llrb.puttxn(txn)
 	llrb.n_aborts++
 	atomic.AddInt64(&llrb.activetxns, -1)
@@ -606,29 +825,61 @@ func (llrb *LLRB) Get(
 	return value, cas, deleted, ok
 }
 
+// Getmerge is same as Get, except that a value stored as merge operand
+// is returned as is, along with merge as true. Refer Merge.
+func (llrb *LLRB) Getmerge(
+	key, value []byte) (v []byte, cas uint64, deleted, merge, ok bool) {
+
+	if !llrb.rlock() {
+		return
+	}
+	value, cas, deleted, merge, ok = llrb.getmerge(key, value)
+	llrb.runlock()
+	return value, cas, deleted, merge, ok
+}
+
 func (llrb *LLRB) get(
 	key, value []byte) (v []byte, cas uint64, deleted, ok bool) {
 
+	v, cas, deleted, merge, ok := llrb.getmerge(key, value)
+	if merge {
+		v = resolveoperand(llrb.merge, key, v)
+	}
+	return v, cas, deleted, ok
+}
+
+func (llrb *LLRB) getmerge(
+	key, value []byte) (v []byte, cas uint64, deleted, merge, ok bool) {
+
 	deleted, seqno := false, uint64(0)
 	nd, ok := llrb.getkey(llrb.getroot(), key)
 	if ok {
+		if llrb.accesstime {
+			nd.touch(accessclock(true, llrb.epoch))
+		}
 		if value != nil {
-			val := nd.Value()
+			val := nd.livevalue()
 			value = lib.Fixbuffer(value, int64(len(val)))
 			copy(value, val)
 		}
-		seqno, deleted = nd.getseqno(), nd.isdeleted()
-	} else if value != nil {
+		seqno, deleted, merge = nd.getseqno(), nd.istombstone(), nd.ismerge()
+	}
+	if tombseqno, covered := llrb.tombs.Covers(key, seqno, llrb.cmp); covered {
+		seqno, deleted, merge, ok = tombseqno, true, false, true
+		if value != nil {
+			value = lib.Fixbuffer(value, 0)
+		}
+	} else if ok == false && value != nil {
 		value = lib.Fixbuffer(value, 0)
 	}
-	return value, seqno, deleted, ok
+	return value, seqno, deleted, merge, ok
 }
 
 func (llrb *LLRB) getkey(nd *Llrbnode, k []byte) (*Llrbnode, bool) {
 	for nd != nil {
-		if nd.gtkey(k, false) {
+		if nd.gtkey(k, false, llrb.cmp) {
 			nd = nd.left
-		} else if nd.ltkey(k, false) {
+		} else if nd.ltkey(k, false, llrb.cmp) {
 			nd = nd.right
 		} else {
 			return nd, true
@@ -673,10 +924,12 @@ func (llrb *LLRB) Scan() api.Iterator {
 
 // ScanEntries return a full table iterator, if iteration is stopped
 // before reaching end of table (io.EOF), application should call
-// iterator with fin as true. EG: iter(true)
+// iterator with fin as true. EG: iter(true). Entries covered by range
+// tombstones are returned as is, refer Rangetombs.
 func (llrb *LLRB) ScanEntries() api.EntryIterator {
 	currkey := []byte(nil)
 	sb := makescanbuf()
+	sb.entries = true
 
 	re := &indexentry{id: llrb.ID()}
 	leseqno := llrb.startscan(nil, sb, 0)
@@ -702,16 +955,90 @@ func (llrb *LLRB) ScanEntries() api.EntryIterator {
 		}
 		currkey = lib.Fixbuffer(currkey, int64(len(key)))
 		copy(currkey, key)
-		return re.set(key, value, seqno, deleted, nil)
+		re.set(key, value, seqno, deleted, nil)
+		re.expiry, re.merge = sb.expiry(), sb.ismerge()
+		return re
 	}
 }
 
+// Range return an iterator over entries whose key falls between low
+// and high, incl can be "none", "low", "high" or "both". A nil bound is
+// treated as unbounded and if reverse is true, entries are iterated in
+// descending order. If iteration is stopped before reaching end of range
+// (io.EOF), application should call iterator with fin as true.
+// EG: iter(true)
+func (llrb *LLRB) Range(
+	low, high []byte, incl string, reverse bool) api.Iterator {
+
+	currkey := []byte(nil)
+	r, sb := makescanrange(low, high, incl, reverse, llrb.cmp), makescanbuf()
+
+	var err error
+	leseqno := llrb.startrange(r, sb, 0, true /*first*/)
+
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if err != nil {
+			return nil, nil, 0, false, err
+		} else if fin {
+			err, sb = io.EOF, nil
+			return nil, nil, 0, false, err
+		}
+
+		key, value, seqno, deleted := sb.pop()
+		if key == nil && currkey != nil {
+			r.resume(currkey)
+			llrb.startrange(r, sb, leseqno, false /*first*/)
+			key, value, seqno, deleted = sb.pop()
+		}
+		if key == nil {
+			err, sb = io.EOF, nil
+			return nil, nil, 0, false, err
+		}
+		currkey = lib.Fixbuffer(currkey, int64(len(key)))
+		copy(currkey, key)
+		return key, value, seqno, deleted, nil
+	}
+}
+
+// Feed return an iterator over mutations with seqno greater than
+// fromseqno, in seqno order. Entries already in the index are returned
+// first, and then the iterator blocks for live mutations, including
+// deletes. Call iterator with fin as true to close the feed, it is
+// okay to do so from a different go-routine. Refer lib.Changelog
+// for details.
+func (llrb *LLRB) Feed(fromseqno uint64) api.Iterator {
+	scan := func() func(bool) ([]byte, []byte, uint64, bool, error) {
+		return llrb.Scan()
+	}
+	return llrb.changelog.Feed(fromseqno, scan)
+}
+
+func (llrb *LLRB) startrange(
+	r *scanrange, sb *scanbuf, leseqno uint64, first bool) uint64 {
+
+	if !llrb.rlock() {
+		return leseqno
+	}
+	if first {
+		leseqno = llrb.seqno
+		sb.tombs, sb.cmp, sb.merge = llrb.tombs, llrb.cmp, llrb.merge
+	}
+
+	sb.preparewrite()
+	r.walk(llrb.getroot(), sb, leseqno)
+	sb.prepareread()
+
+	llrb.runlock()
+	return leseqno
+}
+
 func (llrb *LLRB) startscan(key []byte, sb *scanbuf, leseqno uint64) uint64 {
 	if !llrb.rlock() {
 		return leseqno
 	}
 	if key == nil {
 		leseqno = llrb.seqno
+		sb.tombs, sb.cmp, sb.merge = llrb.tombs, llrb.cmp, llrb.merge
 	}
 
 	sb.preparewrite()
@@ -728,7 +1055,7 @@ func (llrb *LLRB) scan(
 	if nd == nil {
 		return true
 	}
-	if key != nil && nd.lekey(key, false) {
+	if key != nil && nd.lekey(key, false, llrb.cmp) {
 		return llrb.scan(nd.right, key, sb, leseqno)
 	}
 	if !llrb.scan(nd.left, key, sb, leseqno) {
@@ -736,7 +1063,7 @@ func (llrb *LLRB) scan(
 	}
 	seqno := nd.getseqno()
 	if seqno <= leseqno {
-		n := sb.append(nd.getkey(), nd.Value(), seqno, nd.isdeleted())
+		n := sb.appendnode(nd, seqno)
 		if n >= scanlimit {
 			return false
 		}
@@ -832,7 +1159,7 @@ func (llrb *LLRB) Validate() {
 	n := stats["n_count"].(int64)
 	kmem, vmem := stats["keymemory"].(int64), stats["valmemory"].(int64)
 
-	validatetree(llrb.getroot(), llrb.logprefix, n, kmem, vmem)
+	validatetree(llrb.getroot(), llrb.logprefix, n, kmem, vmem, llrb.cmp)
 	llrb.validatestats(stats)
 }
 
@@ -930,6 +1257,7 @@ func (llrb *LLRB) Clone(name string) *LLRB {
 	newllrb.llrbstats = llrb.llrbstats
 	newllrb.h_upsertdepth = llrb.h_upsertdepth.Clone()
 	newllrb.seqno = llrb.seqno
+	newllrb.tombs = llrb.tombs
 
 	newllrb.setroot(newllrb.clonetree(llrb.getroot()))
 
@@ -937,6 +1265,43 @@ func (llrb *LLRB) Clone(name string) *LLRB {
 	return newllrb
 }
 
+// Evict least recently accessed entries until memory held by entries
+// drops below `memory` bytes, applicable only when "accesstime"
+// is enabled. Entries are sampled in key order, a window at a time,
+// continuing from where the previous call left off, and the least
+// recently accessed entries in each window are removed, an
+// approximation of LRU. Unlike Delete, evicted entries are not logged
+// and seqno is not incremented. Return the number of entries evicted.
+// Concurrent calls to Evict are not allowed.
+func (llrb *LLRB) Evict(memory int64) (n int64) {
+	sample := func(hand []byte, samples []accesssample) (m int) {
+		if llrb.rlock() {
+			m = sampleaccess(llrb.getroot(), hand, llrb.cmp, samples, 0)
+			llrb.runlock()
+		}
+		return m
+	}
+	excess := entrymemory(llrb.Stats()) - memory
+	n, llrb.evicthand = evictcold(
+		excess, llrb.Count(), sample, llrb.evictkey, llrb.evicthand,
+	)
+	return n
+}
+
+func (llrb *LLRB) evictkey(key []byte) {
+	if !llrb.lock() {
+		return
+	}
+	root, deleted := llrb.delete(llrb.getroot(), key)
+	if root != nil {
+		root.setblack()
+	}
+	llrb.setroot(root)
+	llrb.delcounts(deleted)
+	llrb.freenode(deleted)
+	llrb.unlock()
+}
+
 func (llrb *LLRB) clonetree(nd *Llrbnode) *Llrbnode {
 	if nd == nil {
 		return nil
@@ -956,6 +1321,20 @@ func (llrb *LLRB) Footprint() int64 {
 	return stats["node.heap"].(int64) + stats["value.heap"].(int64)
 }
 
+// Setchangelog use cl to remember mutations on this index, instead of
+// its own changelog. Useful when mutations on several indexes are to
+// be tailed in a single feed.
+func (llrb *LLRB) Setchangelog(cl *lib.Changelog) {
+	if !llrb.lock() {
+		return
+	}
+	if llrb.ownlog {
+		llrb.changelog.Close()
+	}
+	llrb.changelog, llrb.ownlog = cl, false
+	llrb.unlock()
+}
+
 // Close does nothing.
 func (llrb *LLRB) Close() {
 	return
@@ -965,6 +1344,9 @@ func (llrb *LLRB) Close() {
 // method call are allowed after Destroy.
 func (llrb *LLRB) Destroy() {
 	close(llrb.finch)
+	if llrb.ownlog {
+		llrb.changelog.Close()
+	}
 	for llrb.dodestory() == false {
 		time.Sleep(100 * time.Millisecond)
 	}
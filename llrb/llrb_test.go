@@ -2,6 +2,7 @@ package llrb
 
 import "io"
 import "fmt"
+import "time"
 import "bytes"
 import "testing"
 import "io/ioutil"
@@ -868,6 +869,51 @@ func TestLLRBTxn(t *testing.T) {
 	}
 }
 
+func TestLLRBOptimistic(t *testing.T) {
+	llrb := NewLLRB("optimistic", Defaultsettings())
+	defer llrb.Destroy()
+
+	llrb.Set([]byte("key1"), []byte("val1"), nil)
+
+	// writes can proceed while transaction is open.
+	txn := llrb.BeginOptimistic(0x1234)
+	txn.Set([]byte("key2"), []byte("txn"), nil)
+	llrb.Set([]byte("key1"), []byte("set"), nil)
+	value, _, _, _ := txn.Get([]byte("key1"), []byte{})
+	if string(value) != "set" {
+		t.Errorf("expected %q, got %q", "set", value)
+	}
+
+	// cursors hold the read lock until released.
+	cur, _ := txn.OpenCursor(nil)
+	if key, _ := cur.Key(); string(key) != "key1" {
+		t.Errorf("expected %q, got %q", "key1", key)
+	}
+	donech := make(chan bool)
+	go func() {
+		llrb.Set([]byte("key3"), []byte("set"), nil)
+		close(donech)
+	}()
+	select {
+	case <-donech:
+		t.Errorf("expected writer to wait for cursor")
+	case <-time.After(100 * time.Millisecond):
+	}
+	txn.(*Txn).Release()
+	<-donech
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	refs := map[string]string{"key1": "set", "key2": "txn", "key3": "set"}
+	for key, ref := range refs {
+		value, _, _, _ = llrb.Get([]byte(key), []byte{})
+		if string(value) != ref {
+			t.Errorf("%v expected %q, got %q", key, ref, value)
+		}
+	}
+}
+
 func TestLLRBView(t *testing.T) {
 	llrb := NewLLRB("view", Defaultsettings())
 	defer llrb.Destroy()
@@ -1513,3 +1559,763 @@ func makeLLRB(n int) (*LLRB, [][]byte) {
 //buf := bytes.NewBuffer(nil)
 //llrb.Dotdump(buf)
 //ioutil.WriteFile("out.dot", buf.Bytes(), 0664)
+
+func TestLLRBRange(t *testing.T) {
+	llrb := NewLLRB("range", Defaultsettings())
+	defer llrb.Destroy()
+
+	n, keys := 1000, []string{}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%08v", i*2)
+		llrb.Set([]byte(key), []byte(fmt.Sprintf("val%08v", i*2)), nil)
+		if i%10 == 0 {
+			llrb.Delete([]byte(key), nil, true /*lsm*/)
+		}
+		keys = append(keys, key)
+	}
+
+	testrange(t, llrb, keys, llrb.Range)
+}
+
+func testrange(
+	t *testing.T, index api.Index, keys []string,
+	rangefn func([]byte, []byte, string, bool) api.Iterator) {
+
+	check := func(low, high []byte, incl string, reverse bool) {
+		lowincl, highincl, _ := api.Rangeincl(incl)
+		refkeys := []string{}
+		for _, key := range keys {
+			k := []byte(key)
+			if api.Rangecmp(k, low, high, lowincl, highincl) == 0 {
+				refkeys = append(refkeys, key)
+			}
+		}
+		if reverse {
+			for i, j := 0, len(refkeys)-1; i < j; i, j = i+1, j-1 {
+				refkeys[i], refkeys[j] = refkeys[j], refkeys[i]
+			}
+		}
+
+		iter := rangefn(low, high, incl, reverse)
+		defer iter(true /*fin*/)
+		for _, refkey := range refkeys {
+			key, val, seqno, del, err := iter(false /*fin*/)
+			if err != nil {
+				t.Fatalf("%q %q %v %v: %v", low, high, incl, reverse, err)
+			} else if string(key) != refkey {
+				t.Fatalf("expected %q, got %q", refkey, key)
+			}
+			refval, refseqno, refdel, _ := index.Get(key, []byte{})
+			if del == false && string(val) != string(refval) {
+				t.Errorf("%q expected %q, got %q", key, refval, val)
+			} else if seqno != refseqno {
+				t.Errorf("%q expected %v, got %v", key, refseqno, seqno)
+			} else if del != refdel {
+				t.Errorf("%q expected %v, got %v", key, refdel, del)
+			}
+		}
+		if _, _, _, _, err := iter(false /*fin*/); err != io.EOF {
+			t.Errorf("%q %q %v %v: %v", low, high, incl, reverse, err)
+		}
+	}
+
+	bounds := [][]byte{
+		nil, []byte("a"), []byte("key00000000"), []byte("key00000001"),
+		[]byte("key00000100"), []byte("key00000555"), []byte("key00001998"),
+		[]byte("key00001999"), []byte("z"),
+	}
+	for _, low := range bounds {
+		for _, high := range bounds {
+			for _, incl := range []string{"none", "low", "high", "both"} {
+				check(low, high, incl, false /*reverse*/)
+				check(low, high, incl, true /*reverse*/)
+			}
+		}
+	}
+}
+
+func TestLLRBSetTTL(t *testing.T) {
+	llrb := NewLLRB("ttl", Defaultsettings())
+	defer llrb.Destroy()
+
+	testttl(t, llrb, llrb.SetTTL, llrb.SetExpiry, func() {})
+}
+
+// testttl set key1 without expiry, key2 to expire after an hour and key3
+// with an expiry that has already passed.
+func testttl(
+	t *testing.T, index api.Index,
+	setttl func([]byte, []byte, []byte, time.Duration) ([]byte, uint64),
+	setexpiry func([]byte, []byte, []byte, uint64) ([]byte, uint64),
+	settle func()) {
+
+	index.Set([]byte("key1"), []byte("val1"), nil)
+	setttl([]byte("key2"), []byte("val2"), nil, time.Hour)
+	setexpiry([]byte("key3"), []byte("val3"), nil, 1 /*long back*/)
+	settle()
+
+	refs := []struct {
+		key, value string
+		deleted    bool
+		expiry     bool
+	}{
+		{"key1", "val1", false, false},
+		{"key2", "val2", false, true},
+		{"key3", "", true, false},
+	}
+
+	for _, ref := range refs {
+		value, _, deleted, ok := index.Get([]byte(ref.key), []byte{})
+		if ok == false {
+			t.Errorf("%v expected key", ref.key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.deleted, deleted)
+		} else if string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+
+	iter := index.Scan()
+	for _, ref := range refs {
+		key, value, _, deleted, err := iter(false /*fin*/)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(key) != ref.key {
+			t.Errorf("expected %q, got %q", ref.key, key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.deleted, deleted)
+		} else if string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+	iter(true /*fin*/)
+
+	itere := index.ScanEntries()
+	for _, ref := range refs {
+		entry := itere(false /*fin*/)
+		key, _, deleted, err := entry.Key()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(key) != ref.key {
+			t.Errorf("expected %q, got %q", ref.key, key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.deleted, deleted)
+		} else if x := entry.Expiry() > 0; x != ref.expiry {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.expiry, x)
+		}
+	}
+	itere(true /*fin*/)
+
+	view := index.View(0)
+	cur, _ := view.OpenCursor([]byte("key3"))
+	if key, deleted := cur.Key(); string(key) != "key3" {
+		t.Errorf("expected %q, got %q", "key3", key)
+	} else if deleted == false {
+		t.Errorf("expected expired entry to be deleted")
+	} else if value := cur.Value(); len(value) > 0 {
+		t.Errorf("unexpected %q", value)
+	}
+	view.Abort()
+
+	// expired entries are treated as missing for CAS.
+	_, _, err := index.SetCAS([]byte("key3"), []byte("val33"), nil, 0)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// ttl on transactions.
+	txn := index.BeginTxn(0)
+	txn.SetTTL([]byte("key4"), []byte("val4"), nil, time.Hour)
+	if _, _, deleted, _ := txn.Get([]byte("key4"), nil); deleted {
+		t.Errorf("unexpected deleted")
+	}
+	txn.Set([]byte("key1"), []byte("val11"), nil)
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	settle()
+	refs = []struct {
+		key, value string
+		deleted    bool
+		expiry     bool
+	}{
+		{"key1", "val11", false, false},
+		{"key3", "val33", false, false},
+		{"key4", "val4", false, true},
+	}
+	for _, ref := range refs {
+		value, _, deleted, ok := index.Get([]byte(ref.key), []byte{})
+		if ok == false {
+			t.Errorf("%v expected key", ref.key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected %v, got %v", ref.key, ref.deleted, deleted)
+		} else if string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+
+	// entries without value, continue to have nil value with expiry.
+	setttl([]byte("key5"), nil, nil, time.Hour)
+	settle()
+	view = index.View(0)
+	cur, _ = view.OpenCursor([]byte("key5"))
+	if key, _ := cur.Key(); string(key) != "key5" {
+		t.Errorf("expected %q, got %q", "key5", key)
+	} else if value := cur.Value(); value != nil {
+		t.Errorf("expected nil, got %q", value)
+	}
+	view.Abort()
+}
+
+func TestLLRBFeed(t *testing.T) {
+	llrb := NewLLRB("feed", Defaultsettings())
+	defer llrb.Destroy()
+
+	testfeed(t, llrb, llrb.Feed)
+}
+
+// testfeed load 100 entries, tail the index from the 50th mutation and
+// verify live mutations, including deletes, are fed in seqno order.
+func testfeed(t *testing.T, index api.Index, feed func(uint64) api.Iterator) {
+	for i := 0; i < 100; i++ {
+		key, value := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(value), nil)
+	}
+
+	type ref struct {
+		key, value string
+		seqno      uint64
+		deleted    bool
+	}
+	refs := []ref{}
+	for i := 50; i < 100; i++ {
+		key, value := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		refs = append(refs, ref{key, value, uint64(i + 1), false})
+	}
+	refs = append(refs, ref{"key000", "newval", 101, false})
+	refs = append(refs, ref{"key001", "", 102, true})
+	refs = append(refs, ref{"key002", "", 103, true})
+
+	iter := feed(50)
+	go func() {
+		index.Set([]byte("key000"), []byte("newval"), nil)
+		index.Delete([]byte("key001"), nil, true /*lsm*/)
+		index.Delete([]byte("key002"), nil, false /*lsm*/)
+	}()
+	for _, ref := range refs {
+		key, value, seqno, deleted, err := iter(false /*fin*/)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(key) != ref.key || seqno != ref.seqno {
+			t.Fatalf("expected %v, got %q %v", ref, key, seqno)
+		} else if deleted != ref.deleted {
+			t.Errorf("%q expected %v, got %v", key, ref.deleted, deleted)
+		} else if string(value) != ref.value {
+			t.Errorf("%q expected %q, got %q", key, ref.value, value)
+		}
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		iter(true /*fin*/)
+	}()
+	if _, _, _, _, err := iter(false /*fin*/); err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestLLRBComparator(t *testing.T) {
+	setts := Defaultsettings()
+	setts["comparator"] = "testreverse"
+	llrb := NewLLRB("comparator", setts)
+	defer llrb.Destroy()
+
+	testcomparator(t, llrb, llrb.Validate, func() {})
+}
+
+// testcomparator load 100 entries into an index sorted by testreverse
+// comparator and verify that lookups and iterations honour the
+// comparator.
+func testcomparator(
+	t *testing.T, index api.Index, validate func(), settle func()) {
+
+	for i := 0; i < 100; i++ {
+		key, value := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		index.Set([]byte(key), []byte(value), nil)
+	}
+	settle()
+	validate()
+
+	value, _, _, ok := index.Get([]byte("key050"), []byte{})
+	if ok == false || string(value) != "val050" {
+		t.Errorf("unexpected %v %q", ok, value)
+	}
+
+	check := func(iter api.Iterator, from, till int) {
+		defer iter(true /*fin*/)
+		incr := -1
+		if from < till {
+			incr = 1
+		}
+		for i := from; i != till+incr; i += incr {
+			key, _, _, _, err := iter(false /*fin*/)
+			if err != nil {
+				t.Fatal(err)
+			} else if x := fmt.Sprintf("key%03d", i); string(key) != x {
+				t.Fatalf("expected %q, got %q", x, key)
+			}
+		}
+		if _, _, _, _, err := iter(false /*fin*/); err != io.EOF {
+			t.Errorf("expected %v, got %v", io.EOF, err)
+		}
+	}
+	check(index.Scan(), 99, 0)
+	low, high := []byte("key080"), []byte("key020")
+	check(index.Range(low, high, "both", false /*reverse*/), 80, 20)
+	check(index.Range(low, high, "none", true /*reverse*/), 21, 79)
+
+	view := index.View(0x1234)
+	defer view.Abort()
+	cur, err := view.OpenCursor([]byte("key050"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := cur.Key(); string(key) != "key050" {
+		t.Errorf("expected %q, got %q", "key050", key)
+	}
+	for _, ref := range []string{"key049", "key048"} {
+		if key, _, _, err := cur.GetNext(); err != nil {
+			t.Fatal(err)
+		} else if string(key) != ref {
+			t.Errorf("expected %q, got %q", ref, key)
+		}
+	}
+}
+
+func TestLLRBReverseCursor(t *testing.T) {
+	llrb := NewLLRB("reverse", Defaultsettings())
+	defer llrb.Destroy()
+
+	n, keys := 1000, []string{}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%08v", i*2)
+		llrb.Set([]byte(key), []byte(fmt.Sprintf("val%08v", i*2)), nil)
+		if i%10 == 0 {
+			llrb.Delete([]byte(key), nil, true /*lsm*/)
+		}
+		keys = append(keys, key)
+	}
+
+	view := llrb.View(0)
+	defer view.Abort()
+	testreverse(t, view, keys)
+
+	// YPrev returns the entry under the cursor first.
+	cur, _ := view.OpenReverseCursor([]byte("key00000101"))
+	for i := 50; i >= 0; i-- {
+		key, _, _, _, err := cur.YPrev(false /*fin*/)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(key) != keys[i] {
+			t.Errorf("expected %q, got %q", keys[i], key)
+		}
+	}
+	if _, _, _, _, err := cur.YPrev(false /*fin*/); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func testreverse(t *testing.T, tr api.Transactor, keys []string) {
+	seeks := []string{
+		"", "a", "key00000000", "key00000001", "key00000998",
+		"key00000999", "key00001998", "z",
+	}
+	for _, seek := range seeks {
+		var seekkey []byte
+		if seek != "" {
+			seekkey = []byte(seek)
+		}
+		from := len(keys) - 1
+		for from >= 0 && seekkey != nil && keys[from] > seek {
+			from--
+		}
+		cur, err := tr.OpenReverseCursor(seekkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key, _ := cur.Key(); from >= 0 && string(key) != keys[from] {
+			t.Fatalf("%q expected %q, got %q", seek, keys[from], key)
+		}
+		for i := from - 1; i >= 0; i-- {
+			key, _, _, err := cur.GetPrev()
+			if err != nil {
+				t.Fatalf("%q: %v", seek, err)
+			} else if string(key) != keys[i] {
+				t.Fatalf("%q expected %q, got %q", seek, keys[i], key)
+			}
+		}
+		if _, _, _, err := cur.GetPrev(); err != io.EOF {
+			t.Errorf("%q expected io.EOF, got %v", seek, err)
+		}
+	}
+
+	// change direction in the middle.
+	cur, err := tr.OpenReverseCursor([]byte(keys[100]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refkeys := []int{99, 98, 99, 100, 101, 100, 99}
+	movefns := []func() ([]byte, []byte, bool, error){
+		cur.GetPrev, cur.GetPrev, cur.GetNext, cur.GetNext, cur.GetNext,
+		cur.GetPrev, cur.GetPrev,
+	}
+	for i, movefn := range movefns {
+		key, _, _, err := movefn()
+		if err != nil {
+			t.Fatal(err)
+		} else if string(key) != keys[refkeys[i]] {
+			t.Errorf("expected %q, got %q", keys[refkeys[i]], key)
+		}
+	}
+}
+
+func TestLLRBDeleteRange(t *testing.T) {
+	llrb := NewLLRB("deleterange", Defaultsettings())
+	defer llrb.Destroy()
+
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		llrb.Set([]byte(key), []byte(val), nil)
+	}
+	testdeleterange(t, llrb, func() {})
+
+	// clone shall carry the range tombstones.
+	newllrb := llrb.Clone("clone")
+	defer newllrb.Destroy()
+	if tombs := newllrb.Rangetombs(); len(tombs) != 2 {
+		t.Errorf("expected %v, got %v", 2, len(tombs))
+	}
+}
+
+type deleteranger interface {
+	api.Index
+	DeleteRange(low, high []byte) uint64
+	Rangetombs() api.Rangetombs
+}
+
+func testdeleterange(t *testing.T, index deleteranger, sync func()) {
+	seqno := index.DeleteRange([]byte("key010"), []byte("key020"))
+	index.Set([]byte("key015"), []byte("newval"), nil)
+	index.DeleteRange([]byte("key090"), nil)
+	if tombs := index.Rangetombs(); len(tombs) != 2 {
+		t.Fatalf("unexpected %v", tombs)
+	}
+	sync()
+
+	// key015 was set after the range was deleted.
+	isdeleted := func(key string) bool {
+		return key != "key015" &&
+			((key >= "key010" && key < "key020") || key >= "key090")
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		value, cas, deleted, ok := index.Get([]byte(key), []byte{})
+		if ok == false {
+			t.Errorf("%v expected to be found", key)
+		} else if deleted != isdeleted(key) {
+			t.Errorf("%v expected deleted %v", key, isdeleted(key))
+		} else if deleted && len(value) != 0 {
+			t.Errorf("%v unexpected value %s", key, value)
+		} else if key == "key011" && cas != seqno {
+			t.Errorf("%v expected %v, got %v", key, seqno, cas)
+		}
+	}
+	// missing keys within a deleted range are reported as deleted.
+	if _, _, deleted, ok := index.Get([]byte("key0101"), nil); !ok {
+		t.Errorf("expected to be found")
+	} else if !deleted {
+		t.Errorf("expected deleted")
+	} else if _, _, _, ok := index.Get([]byte("key0001"), nil); ok {
+		t.Errorf("expected missing")
+	}
+
+	// scan and range.
+	iters := []api.Iterator{
+		index.Scan(), index.Range(nil, nil, "both", false /*reverse*/),
+	}
+	for _, iter := range iters {
+		count := 0
+		key, _, _, deleted, err := iter(false /*fin*/)
+		for ; err == nil; key, _, _, deleted, err = iter(false /*fin*/) {
+			if deleted != isdeleted(string(key)) {
+				t.Errorf("%s expected deleted %v", key, !deleted)
+			}
+			count++
+		}
+		if count != 100 {
+			t.Errorf("expected %v, got %v", 100, count)
+		}
+		iter(true /*fin*/)
+	}
+
+	// cursor.
+	view := index.View(0)
+	cur, err := view.OpenCursor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	key, value, _, deleted, err := cur.YNext(false /*fin*/)
+	for ; err == nil; key, value, _, deleted, err = cur.YNext(false) {
+		if deleted != isdeleted(string(key)) {
+			t.Errorf("%s expected deleted %v", key, !deleted)
+		} else if deleted && len(value) != 0 {
+			t.Errorf("%s unexpected value %s", key, value)
+		}
+		count++
+	}
+	if count != 100 {
+		t.Errorf("expected %v, got %v", 100, count)
+	}
+	view.Abort()
+
+	// SetCAS treats covered entries as deleted, with tombstone's seqno.
+	_, _, err = index.SetCAS([]byte("key012"), []byte("val"), nil, 1)
+	if err.Error() != api.ErrorInvalidCAS.Error() {
+		t.Errorf("expected %v, got %v", api.ErrorInvalidCAS, err)
+	}
+	_, _, err = index.SetCAS([]byte("key012"), []byte("val"), nil, 0)
+	if err != nil {
+		t.Error(err)
+	}
+	value, _, deleted, _ = index.Get([]byte("key012"), []byte{})
+	if deleted {
+		t.Errorf("unexpected deleted")
+	} else if string(value) != "val" {
+		t.Errorf("expected %q, got %q", "val", value)
+	}
+
+	// invalid range.
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		index.DeleteRange([]byte("key020"), []byte("key010"))
+	}()
+}
+
+func TestLLRBMerge(t *testing.T) {
+	setts := Defaultsettings()
+	setts["mergeoperator"] = "testappend"
+	llrb := NewLLRB("merge", setts)
+	defer llrb.Destroy()
+	testmerge(t, llrb, func() {})
+
+	// merge operator is mandatory for Merge.
+	nomerge := NewLLRB("nomerge", Defaultsettings())
+	defer nomerge.Destroy()
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		nomerge.Merge([]byte("key"), []byte("operand"))
+	}()
+}
+
+type merger interface {
+	deleteranger
+	Merge(key, operand []byte) uint64
+	Getmerge(key, value []byte) ([]byte, uint64, bool, bool, bool)
+}
+
+func testmerge(t *testing.T, index merger, sync func()) {
+	index.Set([]byte("key001"), []byte("a"), nil)
+	index.Merge([]byte("key001"), []byte("b"))
+	index.Merge([]byte("key002"), []byte("x"))
+	index.Merge([]byte("key002"), []byte("y"))
+	index.Set([]byte("key003"), []byte("c"), nil)
+	index.Delete([]byte("key003"), nil, true /*lsm*/)
+	index.Merge([]byte("key003"), []byte("z"))
+	index.Set([]byte("key015"), []byte("d"), nil)
+	index.DeleteRange([]byte("key010"), []byte("key020"))
+	index.Merge([]byte("key015"), []byte("w"))
+	index.Merge([]byte("key016"), []byte("v"))
+	seqno := index.Merge([]byte("key004"), []byte("u"))
+	index.Delete([]byte("key004"), nil, true /*lsm*/)
+	sync()
+
+	refs := map[string]struct {
+		value   string
+		deleted bool
+		merge   bool
+	}{
+		"key001": {"ab", false, false},
+		"key002": {"xy", false, true},
+		"key003": {"z", false, false},
+		"key004": {"", true, false},
+		"key015": {"w", false, false},
+		"key016": {"v", false, false},
+	}
+	for key, ref := range refs {
+		value, _, deleted, ok := index.Get([]byte(key), []byte{})
+		if ok == false {
+			t.Errorf("%v expected to be found", key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%v expected deleted %v", key, ref.deleted)
+		} else if !deleted && string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", key, ref.value, value)
+		}
+		value, cas, _, merge, _ := index.Getmerge([]byte(key), []byte{})
+		if merge != ref.merge {
+			t.Errorf("%v expected merge %v", key, ref.merge)
+		} else if merge && string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", key, ref.value, value)
+		} else if key == "key004" && cas <= seqno {
+			t.Errorf("%v expected cas > %v, got %v", key, seqno, cas)
+		}
+	}
+
+	// full table scan resolves operands, while ScanEntries return them
+	// as is.
+	iter := index.Scan()
+	for key, value, _, deleted, err := iter(false); err == nil; {
+		if ref, ok := refs[string(key)]; !ok {
+			t.Errorf("unexpected key %s", key)
+		} else if deleted != ref.deleted {
+			t.Errorf("%s expected deleted %v", key, ref.deleted)
+		} else if !deleted && string(value) != ref.value {
+			t.Errorf("%s unexpected %q %v", key, value, deleted)
+		}
+		key, value, _, deleted, err = iter(false)
+	}
+	iter(true /*fin*/)
+	n, itere := 0, index.ScanEntries()
+	for entry := itere(false); entry != nil; entry = itere(false) {
+		key, _, _, err := entry.Key()
+		if err != nil {
+			break
+		} else if entry.Ismerge() != refs[string(key)].merge {
+			t.Errorf("%s expected merge %v", key, refs[string(key)].merge)
+		}
+		n++
+	}
+	if n != len(refs) {
+		t.Errorf("expected %v, got %v", len(refs), n)
+	}
+}
+
+func TestLLRBEvict(t *testing.T) {
+	setts := Defaultsettings()
+	setts["accesstime"] = true
+	llrb := NewLLRB("evict", setts)
+	defer llrb.Destroy()
+	testevict(t, llrb, func() {})
+	llrb.Validate()
+}
+
+type evicter interface {
+	Set(key, value, oldvalue []byte) ([]byte, uint64)
+	Get(key, value []byte) ([]byte, uint64, bool, bool)
+	Count() int64
+	Stats() map[string]interface{}
+	Evict(memory int64) int64
+}
+
+func testevict(t *testing.T, index evicter, sync func()) {
+	n := 1000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		index.Set(key, []byte(fmt.Sprintf("value%04d", i)), nil)
+	}
+	sync()
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < n; i += 10 { // hot keys
+		index.Get([]byte(fmt.Sprintf("key%04d", i)), nil)
+	}
+
+	if x := index.Evict(1024 * 1024 * 1024); x != 0 {
+		t.Errorf("unexpected %v", x)
+	}
+	evicted := index.Evict(entrymemory(index.Stats()) / 2)
+	sync()
+	if count := index.Count(); count != int64(n)-evicted {
+		t.Errorf("expected %v, got %v", int64(n)-evicted, count)
+	} else if evicted < int64(n)/4 || evicted > (int64(n)*3)/4 {
+		t.Errorf("unexpected %v", evicted)
+	}
+	for i := 0; i < n; i += 10 {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		if _, _, _, ok := index.Get(key, nil); !ok {
+			t.Errorf("hot key %s evicted", key)
+		}
+	}
+}
+
+func TestLLRBApply(t *testing.T) {
+	llrb := NewLLRB("apply", Defaultsettings())
+	defer llrb.Destroy()
+	testapply(t, llrb, func() {})
+	llrb.Validate()
+}
+
+func testapply(t *testing.T, index api.Index, sync func()) {
+	_, cas1 := index.Set([]byte("key1"), []byte("value1"), nil)
+	index.Set([]byte("key2"), []byte("value2"), nil)
+	_, cas3 := index.Set([]byte("key3"), []byte("value3"), nil)
+	sync()
+
+	batch := api.NewBatch(8)
+	batch.Set([]byte("key5"), []byte("value5"))
+	batch.SetCAS([]byte("key1"), []byte("value11"), cas1)
+	batch.SetCAS([]byte("key2"), []byte("value22"), cas3) // mismatch
+	batch.SetCAS([]byte("key4"), []byte("value4"), 0)
+	batch.Delete([]byte("key3"), true /*lsm*/)
+	batch.Set([]byte("key0"), []byte("value0"))
+	batch.Delete([]byte("key0"), false /*lsm*/)
+	if err := index.Apply(batch); err != api.ErrorInvalidCAS {
+		t.Fatalf("expected %v, got %v", api.ErrorInvalidCAS, err)
+	}
+	sync()
+
+	// successful mutations have contiguous seqnos in sort order.
+	seqnos := []uint64{cas3 + 6, cas3 + 3, 0, cas3 + 5, cas3 + 4}
+	seqnos = append(seqnos, cas3+1, cas3+2)
+	for i, seqno := range seqnos {
+		x, err := batch.Result(i)
+		if x != seqno {
+			t.Errorf("%v expected %v, got %v", i, seqno, x)
+		} else if (seqno == 0) != (err == api.ErrorInvalidCAS) {
+			t.Errorf("%v unexpected %v", i, err)
+		}
+	}
+
+	refs := []struct {
+		key     string
+		value   string
+		deleted bool
+		ok      bool
+	}{
+		{"key0", "", false, false},
+		{"key1", "value11", false, true},
+		{"key2", "value2", false, true},
+		{"key3", "", true, true},
+		{"key4", "value4", false, true},
+		{"key5", "value5", false, true},
+	}
+	for _, ref := range refs {
+		value, _, deleted, ok := index.Get([]byte(ref.key), []byte{})
+		if ok != ref.ok || deleted != ref.deleted {
+			t.Errorf("%v unexpected %v %v", ref.key, deleted, ok)
+		} else if ok && !deleted && string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+	if count := index.(interface{ Count() int64 }).Count(); count != 5 {
+		t.Errorf("expected %v, got %v", 5, count)
+	}
+}
@@ -0,0 +1,56 @@
+package llrb
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+
+// mergeoperand compute the value for merging operand into key's node,
+// nd shall be nil if key is missing. Operand is resolved against the
+// node's value, or against nil if node is a tombstone or covered by a
+// range tombstone. If key is missing or node itself is an operand,
+// the result is an operand, as older levels, if any, can still have
+// the value for key.
+func mergeoperand(
+	merge api.Mergeoperator,
+	nd *Llrbnode, covered bool, key, operand []byte) ([]byte, bool) {
+
+	if nd == nil && covered == false {
+		return operand, true
+	} else if nd == nil || covered || nd.istombstone() {
+		return merge(key, nil, operand), false
+	}
+	return merge(key, nd.Value(), operand), nd.ismerge()
+}
+
+// resolveoperand resolve operand, copied into value, against nil
+// value. Value shall be nil if caller is not interested in it.
+func resolveoperand(merge api.Mergeoperator, key, value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	val := merge(key, nil, value)
+	value = lib.Fixbuffer(value, int64(len(val)))
+	copy(value, val)
+	return value
+}
+
+// getmergeoperator return the merge operator for snapshot, which is
+// either *LLRB or *mvccsnapshot.
+func getmergeoperator(snapshot interface{}) api.Mergeoperator {
+	switch snap := snapshot.(type) {
+	case *LLRB:
+		return snap.merge
+	case *mvccsnapshot:
+		return snap.mvcc.merge
+	}
+	return nil
+}
+
+// mergedvalue is same as livevalue, except that operands are resolved
+// against nil value.
+func (nd *Llrbnode) mergedvalue(merge api.Mergeoperator) []byte {
+	val := nd.livevalue()
+	if nd.ismerge() {
+		return merge(nd.getkey(), nil, val)
+	}
+	return val
+}
@@ -36,11 +36,17 @@ type MVCC struct {
 	rwhbf     sync.RWMutex
 	finch     chan struct{}
 	txnsmeta
+	changelog *lib.Changelog
+	ownlog    bool // changelog is not supplied via Setchangelog.
 
 	// mvcc fields
 	snapshot   unsafe.Pointer // *mvccsnapshot
 	h_bulkfree *lib.HistogramInt64
 	h_reclaims *lib.HistogramInt64
+	snapmu     sync.Mutex // serialize makesnapshot
+	// checkpoints
+	ckptmu sync.Mutex
+	ckpts  []*checkpoint // in the order of creation
 	// cache
 	snapcache chan *mvccsnapshot
 
@@ -48,8 +54,19 @@ type MVCC struct {
 	memcapacity int64
 	snaptick    time.Duration // mvcc settings
 	allocator   string
+	serialize   bool // txnisolation is "serializable"
+	nchangelog  int64
+	maxckpts    int64
+	ckptage     time.Duration
+	cmp         api.Comparator
+	merge       api.Mergeoperator
+	accesstime  bool
 	setts       s.Settings
 	logprefix   string
+
+	// eviction
+	epoch     time.Time
+	evicthand []byte // evict from key after evicthand, refer Evict.
 }
 
 // NewMVCC a new instance of in-memory sorted index.
@@ -59,12 +76,15 @@ func NewMVCC(name string, setts s.Settings) *MVCC {
 		finch:     make(chan struct{}),
 		logprefix: fmt.Sprintf("MVCC [%s]", name),
 		snapcache: make(chan *mvccsnapshot, 1024),
+		epoch:     time.Now(),
 	}
 	mvcc.inittxns()
 
 	setts = make(s.Settings).Mixin(Defaultsettings(), setts)
 	mvcc.readsettings(setts)
 	mvcc.setts = setts
+	mvcc.changelog = lib.NewChangelog(int(mvcc.nchangelog))
+	mvcc.ownlog = true
 
 	// setup arena for nodes and node-values.
 	mvcc.nodearena = malloc.NewArena(mvcc.memcapacity, mvcc.allocator)
@@ -77,7 +97,7 @@ func NewMVCC(name string, setts s.Settings) *MVCC {
 
 	mvcc.logarenasettings()
 
-	mvcc.makesnapshot(true /*init*/)
+	mvcc.makesnapshot(true /*init*/, false /*pin*/)
 	go housekeeper(mvcc, mvcc.snaptick, mvcc.finch)
 
 	infof("%v started ...\n", mvcc.logprefix)
@@ -112,6 +132,29 @@ func (mvcc *MVCC) readsettings(setts s.Settings) *MVCC {
 	snaptick := setts.Int64("snapshottick")
 	mvcc.snaptick = time.Duration(snaptick) * time.Millisecond
 	mvcc.allocator = setts.String("allocator")
+	mvcc.nchangelog = setts.Int64("changelog")
+	mvcc.maxckpts = setts.Int64("checkpoints")
+	ckptage := setts.Int64("checkpointage")
+	mvcc.ckptage = time.Duration(ckptage) * time.Second
+	switch isolation := setts.String("txnisolation"); isolation {
+	case "snapshot":
+		mvcc.serialize = false
+	case "serializable":
+		mvcc.serialize = true
+	default:
+		panic(fmt.Errorf("invalid txnisolation %q", isolation))
+	}
+	cmp, err := api.Getcomparator(setts.String("comparator"))
+	if err != nil {
+		panic(err)
+	}
+	mvcc.cmp = cmp
+	if name := setts.String("mergeoperator"); name != "" {
+		if mvcc.merge, err = api.Getmergeoperator(name); err != nil {
+			panic(err)
+		}
+	}
+	mvcc.accesstime = setts.Bool("accesstime")
 	return mvcc
 }
 
@@ -129,6 +172,12 @@ func (mvcc *MVCC) setroot(root *Llrbnode) {
 	mvcc.releasesnapshot(snapshot, snapshot)
 }
 
+func (mvcc *MVCC) settombs(tombs api.Rangetombs) {
+	snapshot := mvcc.acquiresnapshot(nil)
+	snapshot.settombs(tombs)
+	mvcc.releasesnapshot(snapshot, snapshot)
+}
+
 func (mvcc *MVCC) acquiresnapshot(first *mvccsnapshot) *mvccsnapshot {
 	for {
 		old := (uintptr)(atomic.LoadPointer(&mvcc.snapshot))
@@ -165,7 +214,8 @@ func (mvcc *MVCC) releasesnapshot(old *mvccsnapshot, new *mvccsnapshot) {
 func (mvcc *MVCC) newnode(k, v []byte) *Llrbnode {
 	ptr := mvcc.nodearena.Alloc(int64(nodesize + len(k)))
 	nd := (*Llrbnode)(ptr)
-	nd.setdirty().setred().setkey(k).setreclaim()
+	nd.setdirty().setred().setkey(k).setreclaim().clearmerge()
+	nd.setaccess(accessclock(mvcc.accesstime, mvcc.epoch))
 	if len(v) > 0 {
 		ptr = mvcc.valarena.Alloc(int64(nvaluesize + len(v)))
 		nv := (*nodevalue)(ptr)
@@ -341,6 +391,9 @@ func (mvcc *MVCC) stats() map[string]interface{} {
 	m["n_activess"] = atomic.LoadInt64(&mvcc.n_activess)
 	m["n_maxverions"] = atomic.LoadInt64(&mvcc.n_maxverions)
 	m["tm_snapmax"] = atomic.LoadInt64(&mvcc.tm_snapmax)
+	mvcc.ckptmu.Lock()
+	m["n_checkpoints"] = int64(len(mvcc.ckpts))
+	mvcc.ckptmu.Unlock()
 
 	capacity, heap, alloc, overhead := mvcc.nodearena.Info()
 	m["node.capacity"] = capacity
@@ -378,7 +431,7 @@ func (mvcc *MVCC) Validate() {
 	kmem, vmem := stats["keymemory"].(int64), stats["valmemory"].(int64)
 
 	wsnap := mvcc.writesnapshot()
-	validatetree(wsnap.getroot(), mvcc.logprefix, n, kmem, vmem)
+	validatetree(wsnap.getroot(), mvcc.logprefix, n, kmem, vmem, mvcc.cmp)
 	mvcc.validatestats(stats)
 	wsnap.release()
 }
@@ -547,6 +600,7 @@ func (mvcc *MVCC) Clone(name string) *MVCC {
 
 	newmvcc.seqno = atomic.LoadUint64(&mvcc.seqno)
 	newmvcc.setroot(newmvcc.clonetree(wsnap.getroot()))
+	newmvcc.settombs(wsnap.gettombs())
 
 	newmvcc.clonestats(mvcc.stats())
 	newmvcc.h_reclaims = mvcc.h_reclaims.Clone()
@@ -601,18 +655,85 @@ func (mvcc *MVCC) Footprint() int64 {
 	return stats["node.heap"].(int64) + stats["value.heap"].(int64)
 }
 
+// Evict least recently accessed entries until memory held by entries
+// drops below `memory` bytes, applicable only when "accesstime"
+// is enabled. Refer LLRB.Evict for details. Memory held by evicted
+// entries is freed only after the snapshots referring to them are
+// purged.
+func (mvcc *MVCC) Evict(memory int64) (n int64) {
+	sample := func(hand []byte, samples []accesssample) int {
+		wsnap := mvcc.writesnapshot()
+		m := sampleaccess(wsnap.getroot(), hand, mvcc.cmp, samples, 0)
+		wsnap.release()
+		return m
+	}
+	excess := entrymemory(mvcc.Stats()) - memory
+	n, mvcc.evicthand = evictcold(
+		excess, mvcc.Count(), sample, mvcc.evictkey, mvcc.evicthand,
+	)
+	return n
+}
+
+func (mvcc *MVCC) evictkey(key []byte) {
+	if !mvcc.lock() {
+		return
+	}
+	wsnap := mvcc.writesnapshot()
+	reclaim := wsnap.reclaim[:0]
+	root, deleted, reclaim := mvcc.delete(wsnap.getroot(), key, reclaim)
+	if root != nil {
+		root.setblack()
+	}
+	wsnap.setroot(root)
+	mvcc.delcounts(deleted, false /*lsm*/)
+	mvcc.appendreclaim(wsnap, reclaim)
+	wsnap.release()
+	mvcc.unlock()
+}
+
 // Close does nothing
 func (mvcc *MVCC) Close() {
 	return
 }
 
+// Setchangelog use cl to remember mutations on this index, instead of
+// its own changelog. Useful when mutations on several indexes are to
+// be tailed in a single feed.
+func (mvcc *MVCC) Setchangelog(cl *lib.Changelog) {
+	if !mvcc.lock() {
+		return
+	}
+	if mvcc.ownlog {
+		mvcc.changelog.Close()
+	}
+	mvcc.changelog, mvcc.ownlog = cl, false
+	mvcc.unlock()
+}
+
+// Waitseqno block until read snapshots catch up with seqno.
+func (mvcc *MVCC) Waitseqno(seqno uint64) {
+	for {
+		rsnap := mvcc.readsnapshot()
+		ok := rsnap.seqno >= seqno
+		rsnap.release()
+		if ok {
+			return
+		}
+		time.Sleep(mvcc.snaptick)
+	}
+}
+
 // Destroy releases all resources held by the tree. No other
 // method call are allowed after Destroy.
 func (mvcc *MVCC) Destroy() {
 	close(mvcc.finch) // close housekeeping routine
+	if mvcc.ownlog {
+		mvcc.changelog.Close()
+	}
 	for atomic.LoadInt64(&mvcc.n_routines) > 0 {
 		time.Sleep(mvcc.snaptick)
 	}
+	mvcc.releasecheckpoints(func(*checkpoint) bool { return true })
 
 	// n_snapshots should match (n_activess + n_purgedss)
 	n_snapshots := atomic.LoadInt64(&mvcc.n_snapshots)
@@ -638,13 +759,14 @@ func (mvcc *MVCC) destroy() bool {
 	snapshot := mvcc.acquiresnapshot(nil)
 	if snapshot.getref() > 0 {
 		return false
-	} else if mvcc.purgesnapshot(snapshot) == false {
+	} else if mvcc.purgesnapshots(snapshot) == false {
 		return false
 	}
 	next := (*mvccsnapshot)(atomic.LoadPointer(&snapshot.next))
 	if next != nil {
 		panic("impossible case")
 	}
+	mvcc.freesnapshot(snapshot, nil)
 	mvcc.releasesnapshot(snapshot, nil)
 	mvcc.nodearena.Release()
 	mvcc.valarena.Release()
@@ -668,13 +790,33 @@ func (mvcc *MVCC) Getseqno() uint64 {
 // Set a key, value pair in the index, if key is already present,
 // its value will be over-written. Make sure key is not nil.
 // Return old value if oldvalue points to a valid buffer.
+// Values larger than Maxvaluesize shall panic.
 func (mvcc *MVCC) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	return mvcc.SetExpiry(key, value, oldvalue, 0)
+}
+
+// SetTTL is same as Set, but the entry shall expire after ttl duration
+// from now. Once expired, the entry is treated as deleted by readers.
+func (mvcc *MVCC) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) (ov []byte, cas uint64) {
+
+	return mvcc.SetExpiry(key, value, oldvalue, api.Ttlexpiry(ttl))
+}
+
+// SetExpiry is same as Set, but the entry shall expire at expiry,
+// specified as unix seconds. Expiry of ZERO never expires.
+func (mvcc *MVCC) SetExpiry(
+	key, value, oldvalue []byte, expiry uint64) (ov []byte, cas uint64) {
+
+	if err := checkvalue(value); err != nil {
+		panic(fmt.Errorf("%v SetExpiry(%q): %v", mvcc.logprefix, key, err))
+	}
 	if !mvcc.lock() {
 		return
 	}
 
 	wsnap := mvcc.writesnapshot()
-	ov, cas = mvcc.set(wsnap, key, value, oldvalue)
+	ov, cas = mvcc.set(wsnap, key, value, oldvalue, expiry)
 	wsnap.release()
 
 	mvcc.unlock()
@@ -682,7 +824,8 @@ func (mvcc *MVCC) Set(key, value, oldvalue []byte) (ov []byte, cas uint64) {
 }
 
 func (mvcc *MVCC) set(
-	wsnap *mvccsnapshot, key, value, oldvalue []byte) (ov []byte, cas uint64) {
+	wsnap *mvccsnapshot,
+	key, value, oldvalue []byte, expiry uint64) (ov []byte, cas uint64) {
 
 	var newnd, oldnd *Llrbnode
 
@@ -693,16 +836,19 @@ func (mvcc *MVCC) set(
 	root, newnd, oldnd, reclaim = mvcc.upsert(root, 1, key, value, reclaim)
 	root.setblack()
 	newnd.cleardeleted()
+	newnd.clearmerge()
 	newnd.cleardirty()
 	newnd.setseqno(seqno)
+	newnd.setexpiry(expiry, mvcc.valarena)
 
 	wsnap.setroot(root)
 	mvcc.upsertcounts(key, value, oldnd)
+	mvcc.changelog.Append(key, newnd.livevalue(), seqno, newnd.istombstone())
 
 	if oldvalue != nil {
 		var val []byte
 		if oldnd != nil {
-			val = oldnd.Value()
+			val = oldnd.mergedvalue(mvcc.merge)
 		}
 		oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
 		copy(oldvalue, val)
@@ -713,6 +859,45 @@ func (mvcc *MVCC) set(
 	return oldvalue, seqno
 }
 
+// Apply mutations in batch under a single lock acquisition and a
+// single write snapshot, refer api.Batch. Successful mutations are
+// assigned contiguous seqnos. Return api.ErrorInvalidCAS if one or
+// more SetCAS failed.
+func (mvcc *MVCC) Apply(batch *api.Batch) (err error) {
+	ops := batch.Sorted(mvcc.cmp)
+	if !mvcc.lock() {
+		return fmt.Errorf("closed")
+	}
+
+	wsnap := mvcc.writesnapshot()
+	for _, op := range ops {
+		key, value := op.Key, op.Value
+		switch op.Cmd {
+		case api.BatchSet:
+			if op.Err = checkvalue(value); op.Err != nil {
+				err = op.Err
+				continue
+			}
+			_, op.Seqno = mvcc.set(wsnap, key, value, nil, 0)
+		case api.BatchSetCAS:
+			if op.Err = checkvalue(value); op.Err != nil {
+				err = op.Err
+				continue
+			}
+			_, op.Seqno, op.Err = mvcc.setcas(wsnap, key, value, nil, op.Cas, 0)
+			if op.Err != nil {
+				err = op.Err
+			}
+		case api.BatchDelete:
+			_, op.Seqno = mvcc.dodelete(wsnap, key, nil, op.Lsm)
+		}
+	}
+	wsnap.release()
+
+	mvcc.unlock()
+	return err
+}
+
 func (mvcc *MVCC) upsert(
 	nd *Llrbnode, depth int64,
 	key, value []byte,
@@ -726,12 +911,12 @@ func (mvcc *MVCC) upsert(
 	}
 	reclaim = append(reclaim, nd)
 
-	if nd.gtkey(key, false) {
+	if nd.gtkey(key, false, mvcc.cmp) {
 		ndmvcc = mvcc.clonenode(nd, false)
 		//ndmvcc = mvcc.walkdownrot23(ndmvcc)
 		ndmvcc.left, newnd, oldnd, reclaim =
 			mvcc.upsert(ndmvcc.left, depth+1, key, value, reclaim)
-	} else if nd.ltkey(key, false) {
+	} else if nd.ltkey(key, false, mvcc.cmp) {
 		ndmvcc = mvcc.clonenode(nd, false)
 		//ndmvcc = mvcc.walkdownrot23(ndmvcc)
 		ndmvcc.right, newnd, oldnd, reclaim =
@@ -767,12 +952,15 @@ func (mvcc *MVCC) SetCAS(
 
 	var err error
 
+	if err = checkvalue(value); err != nil {
+		return oldvalue, 0, err
+	}
 	if !mvcc.lock() {
 		return nil, 0, fmt.Errorf("closed")
 	}
 
 	wsnap := mvcc.writesnapshot()
-	oldvalue, cas, err = mvcc.setcas(wsnap, key, value, oldvalue, cas)
+	oldvalue, cas, err = mvcc.setcas(wsnap, key, value, oldvalue, cas, 0)
 	wsnap.release()
 
 	mvcc.unlock()
@@ -781,14 +969,20 @@ func (mvcc *MVCC) SetCAS(
 
 func (mvcc *MVCC) setcas(
 	wsnap *mvccsnapshot,
-	key, value, oldvalue []byte, cas uint64) ([]byte, uint64, error) {
+	key, value, oldvalue []byte,
+	cas, expiry uint64) ([]byte, uint64, error) {
 
 	// check for cas match.
 	// if cas > 0, key should be found and its seqno should match cas.
 	// if cas == 0, key should be missing.
-	nd, ok := mvcc.getkey(wsnap.getroot(), key)
-	ok1 := (ok && nd.isdeleted() == false) && nd.getseqno() != cas
-	ok2 := (ok == false || nd.isdeleted()) && cas != 0
+	seqno, tombstone := uint64(0), true
+	if nd, ok := mvcc.getkey(wsnap.getroot(), key); ok {
+		var covered bool
+		seqno, covered = wsnap.getseqno(nd)
+		tombstone = nd.istombstone() || covered
+	}
+	ok1 := tombstone == false && seqno != cas
+	ok2 := tombstone && cas != 0
 	if ok1 || ok2 {
 		if oldvalue != nil {
 			oldvalue = lib.Fixbuffer(oldvalue, 0)
@@ -796,7 +990,7 @@ func (mvcc *MVCC) setcas(
 		//fmt.Printf("SetCAS %q %v %v BadCAS 0\n", key, nd.getseqno(), cas)
 		return oldvalue, 0, api.ErrorInvalidCAS
 	}
-	oldvalue, cas = mvcc.set(wsnap, key, value, oldvalue)
+	oldvalue, cas = mvcc.set(wsnap, key, value, oldvalue, expiry)
 	return oldvalue, cas, nil
 }
 
@@ -819,14 +1013,14 @@ func (mvcc *MVCC) upsertcas(
 	}
 	reclaim = append(reclaim, nd)
 
-	if nd.gtkey(key, false) {
+	if nd.gtkey(key, false, mvcc.cmp) {
 		ndmvcc = mvcc.clonenode(nd, false)
 		// ndmvcc = mvcc.walkdownrot23(ndmvcc)
 		depth++
 		ndmvcc.left, newnd, oldnd, reclaim, err =
 			mvcc.upsertcas(ndmvcc.left, depth, key, value, cas, reclaim)
 
-	} else if nd.ltkey(key, false) {
+	} else if nd.ltkey(key, false, mvcc.cmp) {
 		ndmvcc = mvcc.clonenode(nd, false)
 		// ndmvcc = mvcc.walkdownrot23(ndmvcc)
 		depth++
@@ -836,12 +1030,12 @@ func (mvcc *MVCC) upsertcas(
 	} else /*equal*/ {
 		ndmvcc = mvcc.clonenode(nd, true)
 		// ndmvcc = mvcc.walkdownrot23(ndmvcc)
-		if ndmvcc.isdeleted() && (cas != 0 && cas != ndmvcc.getseqno()) {
+		if ndmvcc.istombstone() && (cas != 0 && cas != ndmvcc.getseqno()) {
 			newnd = ndmvcc
 			//fmt.Printf("SetCAS %q Invalid cas 2\n", key)
 			err = api.ErrorInvalidCAS
 
-		} else if ndmvcc.isdeleted() == false && cas != ndmvcc.getseqno() {
+		} else if ndmvcc.istombstone() == false && cas != ndmvcc.getseqno() {
 			newnd = ndmvcc
 			//fmt.Printf("SetCAS %q Invalid cas 3\n", key)
 			err = api.ErrorInvalidCAS
@@ -867,6 +1061,63 @@ func (mvcc *MVCC) upsertcas(
 	return ndmvcc, newnd, oldnd, reclaim, err
 }
 
+// Merge operand into the value for key, using the merge operator
+// configured via "mergeoperator" settings. Operand is resolved right
+// away if key has a value, is deleted, or is covered by a range
+// tombstone. Otherwise operand is stored as is, and resolved against
+// nil value by readers, refer Getmerge. Return the seqno of mutation.
+func (mvcc *MVCC) Merge(key, operand []byte) uint64 {
+	if mvcc.merge == nil {
+		panic(fmt.Errorf("%v mergeoperator not configured", mvcc.logprefix))
+	}
+	if err := checkvalue(operand); err != nil {
+		panic(fmt.Errorf("%v Merge(%q): %v", mvcc.logprefix, key, err))
+	}
+	if !mvcc.lock() {
+		return 0
+	}
+
+	wsnap := mvcc.writesnapshot()
+	seqno := mvcc.domerge(wsnap, key, operand)
+	wsnap.release()
+
+	mvcc.unlock()
+	return seqno
+}
+
+func (mvcc *MVCC) domerge(wsnap *mvccsnapshot, key, operand []byte) uint64 {
+	var newnd, oldnd *Llrbnode
+
+	nd, _ := mvcc.getkey(wsnap.getroot(), key)
+	covered := false
+	if nd != nil {
+		_, covered = wsnap.getseqno(nd)
+	} else {
+		_, covered = wsnap.gettombs().Covers(key, 0, mvcc.cmp)
+	}
+	value, ismerge := mergeoperand(mvcc.merge, nd, covered, key, operand)
+
+	seqno := atomic.AddUint64(&mvcc.seqno, 1)
+	reclaim := wsnap.reclaim[:0]
+
+	root := wsnap.getroot()
+	root, newnd, oldnd, reclaim = mvcc.upsert(root, 1, key, value, reclaim)
+	root.setblack()
+	newnd.cleardeleted()
+	if newnd.clearmerge(); ismerge {
+		newnd.setmerge()
+	}
+	newnd.cleardirty()
+	newnd.setseqno(seqno)
+	newnd.setexpiry(0, mvcc.valarena)
+
+	wsnap.setroot(root)
+	mvcc.upsertcounts(key, value, oldnd)
+	mvcc.changelog.Append(key, newnd.mergedvalue(mvcc.merge), seqno, false)
+	mvcc.appendreclaim(wsnap, reclaim)
+	return seqno
+}
+
 // Delete key from index. Key should not be nil, if key found
 // return its value. If lsm is true, then don't delete the node
 // instead mark the node as deleted. Again, if lsm is true
@@ -884,6 +1135,38 @@ func (mvcc *MVCC) Delete(key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 	return oldvalue, cas
 }
 
+// DeleteRange mark all keys between low, inclusive, and high,
+// exclusive, as deleted by recording a range tombstone at the next
+// seqno. Covered entries are reported as deleted, with the seqno of
+// the range tombstone, by Get, scans and cursors, while keys set after
+// this call are not affected. A nil bound is treated as unbounded.
+// Return the seqno of the range tombstone.
+func (mvcc *MVCC) DeleteRange(low, high []byte) uint64 {
+	if err := api.Validaterange(low, high, mvcc.cmp); err != nil {
+		panic(err)
+	}
+	if !mvcc.lock() {
+		return 0
+	}
+
+	wsnap := mvcc.writesnapshot()
+	seqno := atomic.AddUint64(&mvcc.seqno, 1)
+	wsnap.settombs(wsnap.gettombs().Add(low, high, seqno))
+	wsnap.release()
+
+	mvcc.unlock()
+	return seqno
+}
+
+// Rangetombs return range tombstones recorded so far via DeleteRange.
+// Returned list must not be modified.
+func (mvcc *MVCC) Rangetombs() api.Rangetombs {
+	wsnap := mvcc.writesnapshot()
+	tombs := wsnap.gettombs()
+	wsnap.release()
+	return tombs
+}
+
 func (mvcc *MVCC) dodelete(
 	wsnap *mvccsnapshot, key, oldvalue []byte, lsm bool) ([]byte, uint64) {
 
@@ -898,15 +1181,21 @@ func (mvcc *MVCC) dodelete(
 		root.setblack()
 		newnd.cleardirty()
 		newnd.setseqnodeleted(seqno)
+		newnd.setexpiry(0, mvcc.valarena)
+		newnd.clearmerge()
 		wsnap.setroot(root)
 		if oldnd == nil {
 			mvcc.upsertcounts(key, nil, oldnd)
 
 		} else if oldvalue != nil {
 			val := oldnd.Value()
+			if oldnd.ismerge() {
+				val = mvcc.merge(key, nil, val)
+			}
 			oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
 			copy(oldvalue, val)
 		}
+		mvcc.changelog.Append(key, nil, seqno, true /*deleted*/)
 
 	} else {
 		root, deleted, reclaim = mvcc.delete(wsnap.getroot(), key, reclaim)
@@ -917,10 +1206,16 @@ func (mvcc *MVCC) dodelete(
 
 		if deleted != nil && oldvalue != nil {
 			val := deleted.Value()
+			if deleted.ismerge() {
+				val = mvcc.merge(key, nil, val)
+			}
 			oldvalue = lib.Fixbuffer(oldvalue, int64(len(val)))
 			copy(oldvalue, val)
 		}
 		mvcc.delcounts(deleted, lsm)
+		if deleted != nil {
+			mvcc.changelog.Append(key, nil, seqno, true /*deleted*/)
+		}
 	}
 
 	mvcc.appendreclaim(wsnap, reclaim)
@@ -941,11 +1236,11 @@ func (mvcc *MVCC) lsmdelete(
 
 	reclaim = append(reclaim, nd)
 
-	if nd.gtkey(key, false) {
+	if nd.gtkey(key, false, mvcc.cmp) {
 		ndmvcc = mvcc.clonenode(nd, false)
 		ndmvcc.left, newnd, oldnd, reclaim =
 			mvcc.lsmdelete(ndmvcc.left, key, reclaim)
-	} else if nd.ltkey(key, false) {
+	} else if nd.ltkey(key, false, mvcc.cmp) {
 		ndmvcc = mvcc.clonenode(nd, false)
 		ndmvcc.right, newnd, oldnd, reclaim =
 			mvcc.lsmdelete(ndmvcc.right, key, reclaim)
@@ -971,7 +1266,7 @@ func (mvcc *MVCC) delete(
 	reclaim = append(reclaim, nd)
 	ndmvcc := mvcc.clonenode(nd, true)
 
-	if ndmvcc.gtkey(key, false) {
+	if ndmvcc.gtkey(key, false, mvcc.cmp) {
 		if ndmvcc.left == nil { // key not present. Nothing to delete
 			return ndmvcc, nil, reclaim
 		}
@@ -986,7 +1281,7 @@ func (mvcc *MVCC) delete(
 		}
 
 		// If @key equals @h.Item and no right children at @h
-		if !ndmvcc.ltkey(key, false) && ndmvcc.right == nil {
+		if !ndmvcc.ltkey(key, false, mvcc.cmp) && ndmvcc.right == nil {
 			reclaim = append(reclaim, ndmvcc)
 			return nil, ndmvcc, reclaim
 		}
@@ -995,7 +1290,7 @@ func (mvcc *MVCC) delete(
 			ndmvcc, reclaim = mvcc.moveredright(ndmvcc, reclaim)
 		}
 		// If @key equals @h.Item, and (from above) 'h.Right != nil'
-		if !ndmvcc.ltkey(key, false) {
+		if !ndmvcc.ltkey(key, false, mvcc.cmp) {
 			var subd *Llrbnode
 			ndmvcc.right, subd, reclaim = mvcc.deletemin(ndmvcc.right, reclaim)
 			if subd == nil {
@@ -1088,42 +1383,56 @@ func (mvcc *MVCC) commit(txn *Txn) error {
 }
 
 func (mvcc *MVCC) docommit(wsnap *mvccsnapshot, txn *Txn) error {
-	// Check whether writes operations match the key's CAS.
+	// Check whether writes operations match the key's CAS, first
+	// committer wins.
+	if mvcc.validaterecords(wsnap, txn.writes) == false {
+		return api.ErrorRollback // rollback
+	}
+	// Check whether keys read by the transaction are still the same.
+	if mvcc.validaterecords(wsnap, txn.reads) == false {
+		return api.ErrorRollback // rollback
+	}
+
+	// CAS matches, proceed to commit.
 	for _, head := range txn.writes {
 		prevkey := []byte(nil)
 		for head != nil {
 			if prevkey == nil || bytes.Compare(head.key, prevkey) != 0 {
-				seqno := uint64(0)
-				if nd, ok := mvcc.getkey(wsnap.getroot(), head.key); ok {
-					seqno = nd.getseqno()
-				}
-				if seqno != head.seqno {
-					return api.ErrorRollback // rollback
-				}
+				mvcc.commitrecord(wsnap, head)
 			}
 			prevkey, head = head.key, head.next
 		}
 	}
 
-	// CAS matches, proceed to commit.
-	for _, head := range txn.writes {
+	mvcc.n_commits++
+	return nil
+}
+
+func (mvcc *MVCC) validaterecords(
+	wsnap *mvccsnapshot, records map[uint32]*record) bool {
+
+	for _, head := range records {
 		prevkey := []byte(nil)
 		for head != nil {
 			if prevkey == nil || bytes.Compare(head.key, prevkey) != 0 {
-				mvcc.commitrecord(wsnap, head)
+				seqno := uint64(0)
+				if nd, ok := mvcc.getkey(wsnap.getroot(), head.key); ok {
+					seqno, _ = wsnap.getseqno(nd)
+				}
+				if seqno != head.seqno {
+					return false
+				}
 			}
 			prevkey, head = head.key, head.next
 		}
 	}
-
-	mvcc.n_commits++
-	return nil
+	return true
 }
 
 func (mvcc *MVCC) commitrecord(wsnap *mvccsnapshot, rec *record) (err error) {
 	switch rec.cmd {
 	case cmdSet:
-		mvcc.set(wsnap, rec.key, rec.value, nil)
+		mvcc.set(wsnap, rec.key, rec.value, nil, rec.expiry)
 	case cmdDelete:
 		mvcc.dodelete(wsnap, rec.key, nil, rec.lsm)
 	}
@@ -1163,6 +1472,118 @@ func (mvcc *MVCC) abortview(view *View) {
 	mvcc.putview(view)
 }
 
+// Checkpoint pin the latest version of the index under name and return
+// the seqno of that version. Pinned version can be read using ViewAt,
+// until it is released via Releasecheckpoint, or retired as per
+// "checkpoints" and "checkpointage" settings. If name is already
+// pinned, its pin is moved to the latest version. A pinned version
+// holds back the reclaim of only those nodes reachable from it, later
+// versions are reclaimed as and when they are released.
+func (mvcc *MVCC) Checkpoint(name string) uint64 {
+	snapshot, seqno := mvcc.makesnapshot(false /*init*/, true /*pin*/)
+	ckpt := &checkpoint{
+		name: name, seqno: seqno, born: time.Now(), snapshot: snapshot,
+	}
+
+	mvcc.ckptmu.Lock()
+	ckpts := mvcc.ckpts[:0]
+	for _, old := range mvcc.ckpts {
+		if old.name == name {
+			old.snapshot.release()
+			continue
+		}
+		ckpts = append(ckpts, old)
+	}
+	ckpts = append(ckpts, ckpt)
+	for int64(len(ckpts)) > mvcc.maxckpts {
+		fmsg := "%v checkpoint %q at seqno %v retired\n"
+		infof(fmsg, mvcc.logprefix, ckpts[0].name, ckpts[0].seqno)
+		ckpts[0].snapshot.release()
+		ckpts = ckpts[1:]
+	}
+	mvcc.ckpts = ckpts
+	mvcc.ckptmu.Unlock()
+	return seqno
+}
+
+// Releasecheckpoint unpin the version checkpointed under name, return
+// false if there is no such checkpoint.
+func (mvcc *MVCC) Releasecheckpoint(name string) bool {
+	n := mvcc.releasecheckpoints(func(ckpt *checkpoint) bool {
+		return ckpt.name == name
+	})
+	return n > 0
+}
+
+// Checkpoints return the seqno of all pinned versions, indexed by
+// checkpoint name.
+func (mvcc *MVCC) Checkpoints() map[string]uint64 {
+	mvcc.ckptmu.Lock()
+	defer mvcc.ckptmu.Unlock()
+
+	seqnos := make(map[string]uint64)
+	for _, ckpt := range mvcc.ckpts {
+		seqnos[ckpt.name] = ckpt.seqno
+	}
+	return seqnos
+}
+
+// ViewAt start a read-only transaction on the latest pinned version
+// whose seqno is less than or equal to seqno, refer Checkpoint. Return
+// error if there is no such checkpoint. Similar to View, the returned
+// transaction should be aborted.
+func (mvcc *MVCC) ViewAt(seqno uint64) (api.Transactor, error) {
+	mvcc.ckptmu.Lock()
+	defer mvcc.ckptmu.Unlock()
+
+	var nearest *checkpoint
+	for _, ckpt := range mvcc.ckpts {
+		if ckpt.seqno > seqno {
+			continue
+		} else if nearest == nil || ckpt.seqno > nearest.seqno {
+			nearest = ckpt
+		}
+	}
+	if nearest == nil {
+		return nil, fmt.Errorf("no checkpoint at or before seqno %v", seqno)
+	}
+	nearest.snapshot.refer()
+	atomic.AddInt64(&mvcc.n_txns, 1)
+	view := mvcc.getview(0, mvcc /*db*/, nearest.snapshot /*snap*/)
+	return view, nil
+}
+
+// expirecheckpoints release checkpoints older than "checkpointage".
+func (mvcc *MVCC) expirecheckpoints() {
+	if mvcc.ckptage <= 0 {
+		return
+	}
+	now := time.Now()
+	n := mvcc.releasecheckpoints(func(ckpt *checkpoint) bool {
+		return now.Sub(ckpt.born) > mvcc.ckptage
+	})
+	if n > 0 {
+		infof("%v %v checkpoint(s) expired\n", mvcc.logprefix, n)
+	}
+}
+
+func (mvcc *MVCC) releasecheckpoints(match func(*checkpoint) bool) int {
+	mvcc.ckptmu.Lock()
+	defer mvcc.ckptmu.Unlock()
+
+	n, ckpts := 0, mvcc.ckpts[:0]
+	for _, ckpt := range mvcc.ckpts {
+		if match(ckpt) {
+			ckpt.snapshot.release()
+			n++
+			continue
+		}
+		ckpts = append(ckpts, ckpt)
+	}
+	mvcc.ckpts = ckpts
+	return n
+}
+
 //---- Exported Read methods
 
 // Get value for key, if value argument points to valid buffer, it will
@@ -1178,11 +1599,23 @@ func (mvcc *MVCC) Get(
 	return
 }
 
+// Getmerge is same as Get, except that a value stored as merge operand
+// is returned as is, along with merge as true. Refer Merge.
+func (mvcc *MVCC) Getmerge(
+	key, value []byte) (v []byte, cas uint64, deleted, merge, ok bool) {
+
+	if wsnap := mvcc.writesnapshot(); wsnap != nil {
+		v, cas, deleted, merge, ok = wsnap.getmerge(key, value)
+		wsnap.release()
+	}
+	return
+}
+
 func (mvcc *MVCC) getkey(nd *Llrbnode, k []byte) (*Llrbnode, bool) {
 	for nd != nil {
-		if nd.gtkey(k, false) {
+		if nd.gtkey(k, false, mvcc.cmp) {
 			nd = nd.left
-		} else if nd.ltkey(k, false) {
+		} else if nd.ltkey(k, false, mvcc.cmp) {
 			nd = nd.right
 		} else {
 			return nd, true
@@ -1242,10 +1675,12 @@ func (mvcc *MVCC) Scan() api.Iterator {
 
 // ScanEntries return a full table iterator, if iteration is stopped
 // before reaching end of table (io.EOF), application should call
-// iterator with fin as true. EG: iter(true)
+// iterator with fin as true. EG: iter(true). Entries covered by range
+// tombstones are returned as is, refer Rangetombs.
 func (mvcc *MVCC) ScanEntries() api.EntryIterator {
 	currkey := []byte(nil)
 	sb := makescanbuf()
+	sb.entries = true
 
 	re := &indexentry{id: mvcc.ID()}
 	leseqno := mvcc.startscan(nil, sb, 0)
@@ -1270,15 +1705,89 @@ func (mvcc *MVCC) ScanEntries() api.EntryIterator {
 		}
 		currkey = lib.Fixbuffer(currkey, int64(len(key)))
 		copy(currkey, key)
-		return re.set(key, value, seqno, deleted, nil)
+		re.set(key, value, seqno, deleted, nil)
+		re.expiry, re.merge = sb.expiry(), sb.ismerge()
+		return re
+	}
+}
+
+// Feed return an iterator over mutations with seqno greater than
+// fromseqno, in seqno order. Entries already in the index are returned
+// first, and then the iterator blocks for live mutations, including
+// deletes. Call iterator with fin as true to close the feed, it is
+// okay to do so from a different go-routine. Refer lib.Changelog
+// for details.
+func (mvcc *MVCC) Feed(fromseqno uint64) api.Iterator {
+	scan := func() func(bool) ([]byte, []byte, uint64, bool, error) {
+		// read snapshot shall include all mutations applied so far.
+		mvcc.Waitseqno(mvcc.Getseqno())
+		return mvcc.Scan()
+	}
+	return mvcc.changelog.Feed(fromseqno, scan)
+}
+
+// Range return an iterator over entries whose key falls between low
+// and high, incl can be "none", "low", "high" or "both". A nil bound is
+// treated as unbounded and if reverse is true, entries are iterated in
+// descending order. If iteration is stopped before reaching end of range
+// (io.EOF), application should call iterator with fin as true.
+// EG: iter(true)
+func (mvcc *MVCC) Range(
+	low, high []byte, incl string, reverse bool) api.Iterator {
+
+	currkey := []byte(nil)
+	r, sb := makescanrange(low, high, incl, reverse, mvcc.cmp), makescanbuf()
+
+	var err error
+	leseqno := mvcc.startrange(r, sb, 0, true /*first*/)
+
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if err != nil {
+			return nil, nil, 0, false, err
+		} else if fin {
+			err, sb = io.EOF, nil
+			return nil, nil, 0, false, err
+		}
+
+		key, value, seqno, deleted := sb.pop()
+		if key == nil && currkey != nil {
+			r.resume(currkey)
+			mvcc.startrange(r, sb, leseqno, false /*first*/)
+			key, value, seqno, deleted = sb.pop()
+		}
+		if key == nil {
+			err, sb = io.EOF, nil
+			return nil, nil, 0, false, err
+		}
+		currkey = lib.Fixbuffer(currkey, int64(len(key)))
+		copy(currkey, key)
+		return key, value, seqno, deleted, nil
 	}
 }
 
+func (mvcc *MVCC) startrange(
+	r *scanrange, sb *scanbuf, leseqno uint64, first bool) uint64 {
+
+	rsnap := mvcc.readsnapshot()
+	if first {
+		leseqno = rsnap.seqno
+		sb.tombs, sb.cmp, sb.merge = rsnap.gettombs(), mvcc.cmp, mvcc.merge
+	}
+
+	sb.preparewrite()
+	r.walk(rsnap.getroot(), sb, leseqno)
+	sb.prepareread()
+
+	rsnap.release()
+	return leseqno
+}
+
 // TODO: can we instead to the snapshot and avoid rlock ?
 func (mvcc *MVCC) startscan(key []byte, sb *scanbuf, leseqno uint64) uint64 {
 	rsnap := mvcc.readsnapshot()
 	if key == nil {
 		leseqno = rsnap.seqno
+		sb.tombs, sb.cmp, sb.merge = rsnap.gettombs(), mvcc.cmp, mvcc.merge
 	}
 
 	sb.preparewrite()
@@ -1295,7 +1804,7 @@ func (mvcc *MVCC) scan(
 	if nd == nil {
 		return true
 	}
-	if key != nil && nd.lekey(key, false) {
+	if key != nil && nd.lekey(key, false, mvcc.cmp) {
 		return mvcc.scan(nd.right, key, sb, leseqno)
 	}
 	if !mvcc.scan(nd.left, key, sb, leseqno) {
@@ -1303,7 +1812,7 @@ func (mvcc *MVCC) scan(
 	}
 	seqno := nd.getseqno()
 	if seqno <= leseqno {
-		n := sb.append(nd.getkey(), nd.Value(), seqno, nd.isdeleted())
+		n := sb.appendnode(nd, seqno)
 		if n >= scanlimit {
 			return false
 		}
@@ -1445,11 +1954,20 @@ func (mvcc *MVCC) cloneifdirty(nd *Llrbnode) (*Llrbnode, bool) {
 
 //---- snapshot routines.
 
-func (mvcc *MVCC) makesnapshot(init bool) {
+// makesnapshot cut a new write snapshot, turning the current write
+// snapshot into the latest read snapshot. If pin is true, return the
+// latest read snapshot with a reference held on it, along with the seqno
+// it is consistent with.
+func (mvcc *MVCC) makesnapshot(
+	init, pin bool) (pinned *mvccsnapshot, seqno uint64) {
+
+	mvcc.snapmu.Lock()
+	defer mvcc.snapmu.Unlock()
+
 	mvcc.lock()
 
 	var currsnap *mvccsnapshot
-	seqno := atomic.LoadUint64(&mvcc.seqno)
+	seqno = atomic.LoadUint64(&mvcc.seqno)
 	nextsnap := mvcc.getsnapshot(seqno)
 	n_snapshots := atomic.AddInt64(&mvcc.n_snapshots, 1)
 	if init {
@@ -1458,6 +1976,10 @@ func (mvcc *MVCC) makesnapshot(init bool) {
 	} else {
 		currsnap = mvcc.acquiresnapshot(nil)
 		nextsnap = nextsnap.initsnapshot(n_snapshots, mvcc, currsnap)
+		if pin {
+			currsnap.refer()
+			pinned = currsnap
+		}
 	}
 	mvcc.releasesnapshot(currsnap, nextsnap)
 
@@ -1478,15 +2000,13 @@ func (mvcc *MVCC) makesnapshot(init bool) {
 	rsnap := (*mvccsnapshot)(atomic.LoadPointer(&wsnap.next))
 	mvcc.releasesnapshot(wsnap, wsnap)
 	if rsnap != nil {
-		next := (*mvccsnapshot)(atomic.LoadPointer(&rsnap.next))
-		if mvcc.purgesnapshot(next) {
-			atomic.StorePointer(&rsnap.next, nil)
-		}
+		mvcc.purgesnapshots(rsnap)
 	}
 	n_maxverions := atomic.LoadInt64(&mvcc.n_maxverions)
 	if n_activess > n_maxverions {
 		atomic.StoreInt64(&mvcc.n_maxverions, n_activess)
 	}
+	return pinned, seqno
 }
 
 func (mvcc *MVCC) writesnapshot() *mvccsnapshot {
@@ -1516,33 +2036,78 @@ loop:
 	panic("unreachable code")
 }
 
-func (mvcc *MVCC) purgesnapshot(snapshot *mvccsnapshot) bool {
-	if snapshot == nil {
-		return true
-	}
-	next := (*mvccsnapshot)(atomic.LoadPointer(&snapshot.next))
-	if mvcc.purgesnapshot(next) {
-		atomic.StorePointer(&snapshot.next, nil)
-		if snapshot.getref() == 0 {
-			mvcc.rwhbf.Lock()
-			mvcc.h_bulkfree.Add(int64(len(snapshot.reclaims)))
-			mvcc.rwhbf.Unlock()
-
-			// all older snapshots are purged, and this snapshot is not refered
-			// by anyone.
-
-			for _, nd := range snapshot.reclaims {
-				mvcc.freenode(nd)
+// purgesnapshots purge all snapshots older than head that are not
+// refered by anyone. A refered snapshot, like a checkpoint, does not
+// hold back the purge of its newer snapshots, instead nodes reclaimed
+// by newer snapshots that are still reachable from a refered snapshot
+// are retained by it, and freed when it is purged. Return true if all
+// snapshots older than head are purged.
+func (mvcc *MVCC) purgesnapshots(head *mvccsnapshot) bool {
+	chain := []*mvccsnapshot{head}
+	snapshot := (*mvccsnapshot)(atomic.LoadPointer(&head.next))
+	for ; snapshot != nil; snapshot = snapshot.getnext() {
+		chain = append(chain, snapshot)
+	}
+
+	live := []*mvccsnapshot{} // refered snapshots, oldest first.
+	for i := len(chain) - 1; i > 0; i-- {
+		snapshot, newer := chain[i], chain[i-1]
+		if snapshot.getref() > 0 {
+			live = append(live, snapshot)
+			continue
+		}
+		atomic.StorePointer(&newer.next, atomic.LoadPointer(&snapshot.next))
+		mvcc.freesnapshot(snapshot, live)
+	}
+	return len(live) == 0
+}
+
+// freesnapshot free nodes reclaimed and retained by snapshot, unless
+// they are reachable from one of the older live snapshots, and recycle
+// the snapshot.
+func (mvcc *MVCC) freesnapshot(
+	snapshot *mvccsnapshot, live []*mvccsnapshot) {
+
+	nodes := [][]*Llrbnode{snapshot.reclaims, snapshot.retain}
+	mvcc.rwhbf.Lock()
+	mvcc.h_bulkfree.Add(int64(len(nodes[0]) + len(nodes[1])))
+	mvcc.rwhbf.Unlock()
+
+	for _, nds := range nodes {
+	loop:
+		for _, nd := range nds {
+			for i := len(live) - 1; i >= 0; i-- {
+				if live[i].getnode(nd.getkey()) == nd {
+					live[i].retain = append(live[i].retain, nd)
+					continue loop
+				}
 			}
-			atomic.AddInt64(&mvcc.n_activess, -1)
-			atomic.AddInt64(&mvcc.n_purgedss, 1)
-			mvcc.putsnapshot(snapshot)
-			snapid := atomic.LoadInt64(&snapshot.id)
-			debugf("%s snapshot %v PURGED...", mvcc.logprefix, snapid)
-			return true
+			mvcc.handovervalue(nd, live)
+			mvcc.freenode(nd)
+		}
+	}
+	atomic.AddInt64(&mvcc.n_activess, -1)
+	atomic.AddInt64(&mvcc.n_purgedss, 1)
+	mvcc.putsnapshot(snapshot)
+	snapid := atomic.LoadInt64(&snapshot.id)
+	debugf("%s snapshot %v PURGED...", mvcc.logprefix, snapid)
+}
+
+// handovervalue hand over the ownership of nd's value to an older
+// version of nd that shares the value and is reachable from one of the
+// live snapshots, refer clonenode.
+func (mvcc *MVCC) handovervalue(nd *Llrbnode, live []*mvccsnapshot) {
+	nv := nd.nodevalue()
+	if nv == nil || nd.isreclaim() == false {
+		return
+	}
+	for i := len(live) - 1; i >= 0; i-- {
+		if x := live[i].getnode(nd.getkey()); x != nil && x.nodevalue() == nv {
+			x.setreclaim()
+			nd.clearreclaim()
+			return
 		}
 	}
-	return false
 }
 
 func (mvcc *MVCC) getsnapshot(seqno uint64) (snapshot *mvccsnapshot) {
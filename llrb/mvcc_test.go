@@ -926,6 +926,56 @@ func TestMVCCView(t *testing.T) {
 	}
 }
 
+func TestMVCCTxnIsolation(t *testing.T) {
+	snaptick := time.Duration(Defaultsettings().Int64("snapshottick") * 2)
+	snaptick = snaptick * time.Millisecond
+
+	// two transactions read both keys and write one key each, write skew.
+	writeskew := func(isolation string) (error, error) {
+		setts := Defaultsettings()
+		setts["txnisolation"] = isolation
+		mvcc := NewMVCC("isolation", setts)
+		defer mvcc.Destroy()
+
+		mvcc.Set([]byte("key1"), []byte("on"), nil)
+		mvcc.Set([]byte("key2"), []byte("on"), nil)
+		time.Sleep(snaptick)
+
+		txn1, txn2 := mvcc.BeginTxn(1), mvcc.BeginTxn(2)
+		for _, txn := range []api.Transactor{txn1, txn2} {
+			txn.Get([]byte("key1"), nil)
+			txn.Get([]byte("key2"), nil)
+		}
+		txn1.Set([]byte("key1"), []byte("off"), nil)
+		txn2.Set([]byte("key2"), []byte("off"), nil)
+		return txn1.Commit(), txn2.Commit()
+	}
+
+	if err1, err2 := writeskew("snapshot"); err1 != nil || err2 != nil {
+		t.Errorf("unexpected %v %v", err1, err2)
+	}
+	err1, err2 := writeskew("serializable")
+	if err1 != nil {
+		t.Errorf("unexpected %v", err1)
+	} else if err2 != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err2)
+	}
+
+	// lost update is detected with snapshot isolation.
+	mvcc := NewMVCC("isolation", Defaultsettings())
+	defer mvcc.Destroy()
+	mvcc.Set([]byte("key1"), []byte("0"), nil)
+	time.Sleep(snaptick)
+	txn1, txn2 := mvcc.BeginTxn(1), mvcc.BeginTxn(2)
+	txn1.Set([]byte("key1"), []byte("1"), nil)
+	txn2.Set([]byte("key1"), []byte("2"), nil)
+	if err := txn1.Commit(); err != nil {
+		t.Errorf("unexpected %v", err)
+	} else if err = txn2.Commit(); err != api.ErrorRollback {
+		t.Errorf("expected %v, got %v", api.ErrorRollback, err)
+	}
+}
+
 func TestMVCCTxnCursor(t *testing.T) {
 	mvcc := NewMVCC("view", Defaultsettings())
 	defer mvcc.Destroy()
@@ -1411,3 +1461,290 @@ func makeBenchMVCC(n int) *MVCC {
 //buf := bytes.NewBuffer(nil)
 //mvcc.Dotdump(buf)
 //ioutil.WriteFile("out.dot", buf.Bytes(), 0664)
+
+func TestMVCCRange(t *testing.T) {
+	mvccsetts := Defaultsettings()
+	mvcc := NewMVCC("range", mvccsetts)
+	defer mvcc.Destroy()
+
+	n, keys := 1000, []string{}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%08v", i*2)
+		mvcc.Set([]byte(key), []byte(fmt.Sprintf("val%08v", i*2)), nil)
+		if i%10 == 0 {
+			mvcc.Delete([]byte(key), nil, true /*lsm*/)
+		}
+		keys = append(keys, key)
+	}
+
+	snaptick := time.Duration(mvccsetts.Int64("snapshottick") * 2)
+	time.Sleep(snaptick * 4 * time.Millisecond)
+
+	testrange(t, mvcc, keys, mvcc.Range)
+}
+
+func TestMVCCSetTTL(t *testing.T) {
+	mvccsetts := Defaultsettings()
+	mvcc := NewMVCC("ttl", mvccsetts)
+	defer mvcc.Destroy()
+
+	snaptick := time.Duration(mvccsetts.Int64("snapshottick") * 2)
+	settle := func() { time.Sleep(snaptick * 4 * time.Millisecond) }
+	testttl(t, mvcc, mvcc.SetTTL, mvcc.SetExpiry, settle)
+}
+
+func TestMVCCComparator(t *testing.T) {
+	setts := Defaultsettings()
+	setts["comparator"] = "testreverse"
+	mvcc := NewMVCC("comparator", setts)
+	defer mvcc.Destroy()
+
+	snaptick := time.Duration(setts.Int64("snapshottick") * 2)
+	settle := func() { time.Sleep(snaptick * 4 * time.Millisecond) }
+	testcomparator(t, mvcc, mvcc.Validate, settle)
+}
+
+func TestMVCCFeed(t *testing.T) {
+	mvcc := NewMVCC("feed", Defaultsettings())
+	defer mvcc.Destroy()
+
+	testfeed(t, mvcc, mvcc.Feed)
+}
+
+func TestMVCCCheckpoint(t *testing.T) {
+	setts := Defaultsettings()
+	setts["checkpoints"] = 2
+	mvcc := NewMVCC("checkpoint", setts)
+	defer mvcc.Destroy()
+
+	snaptick := time.Duration(setts.Int64("snapshottick") * 2)
+	settle := func() { time.Sleep(snaptick * 4 * time.Millisecond) }
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%v", i)
+		mvcc.Set([]byte(key), []byte(fmt.Sprintf("one%v", i)), nil)
+	}
+	seqno1 := mvcc.Checkpoint("one")
+	if seqno1 != 10 {
+		t.Fatalf("unexpected %v", seqno1)
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%v", i)
+		mvcc.Set([]byte(key), []byte(fmt.Sprintf("two%v", i)), nil)
+	}
+	mvcc.Delete([]byte("key0"), nil, false /*lsm*/)
+	seqno2 := mvcc.Checkpoint("two")
+	if seqno2 != 21 {
+		t.Fatalf("unexpected %v", seqno2)
+	}
+
+	view1, err := mvcc.ViewAt(seqno1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view1.Abort()
+	view2, err := mvcc.ViewAt(seqno2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer view2.Abort()
+	// nearest checkpoint at or before seqno.
+	if view, err := mvcc.ViewAt(seqno2 + 5); err != nil {
+		t.Fatal(err)
+	} else {
+		value, _, _, _ := view.Get([]byte("key1"), []byte{})
+		if string(value) != "two1" {
+			t.Errorf("expected %q, got %q", "two1", value)
+		}
+		view.Abort()
+	}
+	if _, err := mvcc.ViewAt(seqno1 - 1); err == nil {
+		t.Errorf("expected error")
+	}
+
+	// more writes, while checkpoints are retired and older versions are
+	// purged, shall not affect open views.
+	mvcc.Checkpoint("three")
+	if _, err := mvcc.ViewAt(seqno1); err == nil {
+		t.Errorf("expected error")
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%v", i)
+		mvcc.Set([]byte(key), []byte(fmt.Sprintf("three%v", i)), nil)
+	}
+	settle()
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%v", i)
+		value, seqno, _, ok := view1.Get([]byte(key), []byte{})
+		if ok == false {
+			t.Errorf("%v missing", key)
+		} else if x := fmt.Sprintf("one%v", i); string(value) != x {
+			t.Errorf("expected %q, got %q", x, value)
+		} else if seqno != uint64(i+1) {
+			t.Errorf("expected %v, got %v", i+1, seqno)
+		}
+		value, _, _, ok = view2.Get([]byte(key), []byte{})
+		if i == 0 && ok {
+			t.Errorf("unexpected %v", key)
+		} else if x := fmt.Sprintf("two%v", i); i > 0 && string(value) != x {
+			t.Errorf("expected %q, got %q", x, value)
+		}
+	}
+
+	if seqnos := mvcc.Checkpoints(); len(seqnos) != 2 {
+		t.Errorf("unexpected %v", seqnos)
+	} else if seqnos["two"] != seqno2 {
+		t.Errorf("expected %v, got %v", seqno2, seqnos["two"])
+	}
+	if mvcc.Releasecheckpoint("two") == false {
+		t.Errorf("expected checkpoint two")
+	} else if mvcc.Releasecheckpoint("two") {
+		t.Errorf("unexpected checkpoint two")
+	}
+	stats := mvcc.Stats()
+	if x := stats["n_checkpoints"].(int64); x != 1 {
+		t.Errorf("unexpected %v", x)
+	}
+}
+
+func TestMVCCCheckpointReclaim(t *testing.T) {
+	setts := Defaultsettings()
+	mvcc := NewMVCC("checkpointreclaim", setts)
+	defer mvcc.Destroy()
+
+	snaptick := time.Duration(setts.Int64("snapshottick"))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%v", i)
+		mvcc.Set([]byte(key), []byte(fmt.Sprintf("one%v", i)), nil)
+	}
+	seqno := mvcc.Checkpoint("one")
+
+	// versions after the checkpoint shall be reclaimed.
+	for round := 0; round < 100; round++ {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key%v", i)
+			value := fmt.Sprintf("round%v-%v", round, i)
+			mvcc.Set([]byte(key), []byte(value), nil)
+		}
+		time.Sleep(snaptick * 2 * time.Millisecond)
+	}
+	stats := mvcc.Stats()
+	if x := stats["n_activess"].(int64); x > 10 {
+		t.Errorf("unexpected %v active snapshots", x)
+	}
+
+	view, err := mvcc.ViewAt(seqno)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%v", i)
+		value, _, _, _ := view.Get([]byte(key), []byte{})
+		if x := fmt.Sprintf("one%v", i); string(value) != x {
+			t.Errorf("expected %q, got %q", x, value)
+		}
+	}
+	view.Abort()
+	mvcc.Releasecheckpoint("one")
+}
+
+func TestMVCCCheckpointAge(t *testing.T) {
+	setts := Defaultsettings()
+	setts["checkpointage"] = 1
+	mvcc := NewMVCC("checkpointage", setts)
+	defer mvcc.Destroy()
+
+	mvcc.Set([]byte("key"), []byte("value"), nil)
+	seqno := mvcc.Checkpoint("age")
+	if view, err := mvcc.ViewAt(seqno); err != nil {
+		t.Fatal(err)
+	} else {
+		view.Abort()
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := mvcc.ViewAt(seqno); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestMVCCReverseCursor(t *testing.T) {
+	mvccsetts := Defaultsettings()
+	mvcc := NewMVCC("reverse", mvccsetts)
+	defer mvcc.Destroy()
+
+	n, keys := 1000, []string{}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%08v", i*2)
+		mvcc.Set([]byte(key), []byte(fmt.Sprintf("val%08v", i*2)), nil)
+		if i%10 == 0 {
+			mvcc.Delete([]byte(key), nil, true /*lsm*/)
+		}
+		keys = append(keys, key)
+	}
+
+	snaptick := time.Duration(mvccsetts.Int64("snapshottick") * 2)
+	time.Sleep(snaptick * 4 * time.Millisecond)
+
+	view := mvcc.View(0)
+	defer view.Abort()
+	testreverse(t, view, keys)
+}
+
+func TestMVCCDeleteRange(t *testing.T) {
+	mvccsetts := Defaultsettings()
+	mvcc := NewMVCC("deleterange", mvccsetts)
+	defer mvcc.Destroy()
+
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		mvcc.Set([]byte(key), []byte(val), nil)
+	}
+	snaptick := time.Duration(mvccsetts.Int64("snapshottick") * 2)
+	testdeleterange(t, mvcc, func() {
+		time.Sleep(snaptick * 4 * time.Millisecond)
+	})
+
+	newmvcc := mvcc.Clone("clone")
+	defer newmvcc.Destroy()
+	if tombs := newmvcc.Rangetombs(); len(tombs) != 2 {
+		t.Errorf("expected %v, got %v", 2, len(tombs))
+	}
+}
+
+func TestMVCCMerge(t *testing.T) {
+	mvccsetts := Defaultsettings()
+	mvccsetts["mergeoperator"] = "testappend"
+	mvcc := NewMVCC("merge", mvccsetts)
+	defer mvcc.Destroy()
+
+	snaptick := time.Duration(mvccsetts.Int64("snapshottick") * 2)
+	testmerge(t, mvcc, func() {
+		time.Sleep(snaptick * 4 * time.Millisecond)
+	})
+}
+
+func TestMVCCApply(t *testing.T) {
+	mvccsetts := Defaultsettings()
+	mvcc := NewMVCC("apply", mvccsetts)
+	defer mvcc.Destroy()
+
+	snaptick := time.Duration(mvccsetts.Int64("snapshottick") * 2)
+	testapply(t, mvcc, func() {
+		time.Sleep(snaptick * 4 * time.Millisecond)
+	})
+	mvcc.Validate()
+}
+
+func TestMVCCEvict(t *testing.T) {
+	mvccsetts := Defaultsettings()
+	mvccsetts["accesstime"] = true
+	mvcc := NewMVCC("evict", mvccsetts)
+	defer mvcc.Destroy()
+
+	snaptick := time.Duration(mvccsetts.Int64("snapshottick") * 2)
+	testevict(t, mvcc, func() {
+		time.Sleep(snaptick * 4 * time.Millisecond)
+	})
+	mvcc.Validate()
+}
@@ -18,12 +18,15 @@ const (
 	ndValreclaim uint64 = 0x8
 )
 
+// hdrMerge is set in the reserved bits of node header.
+const hdrMerge uint64 = 0x1
+
 // Llrbnode defines a node in LLRB tree.
 type Llrbnode struct {
 	left     *Llrbnode
 	right    *Llrbnode
 	seqflags uint64 // seqno[64:4] flags[4:0]
-	hdr      uint64 // klen[64:48] access[48:8] reserved[8:0]
+	hdr      uint64 // klen[64:48] access[48:8] reserved[8:1] merge[1:0]
 	value    unsafe.Pointer
 	key      unsafe.Pointer
 }
@@ -71,6 +74,36 @@ func (nd *Llrbnode) setaccess(access uint64) *Llrbnode {
 	return nd
 }
 
+// touch record access time in node header. Unlike setaccess, node can
+// be touched by concurrent readers, hence header is updated with
+// compare-and-swap.
+func (nd *Llrbnode) touch(access uint64) {
+	access = (access << 8) & 0x0000ffffffffff00
+	for {
+		hdr := nd.gethdr()
+		newhdr := (hdr & 0xffff0000000000ff) | access
+		if hdr == newhdr {
+			return
+		} else if atomic.CompareAndSwapUint64(&nd.hdr, hdr, newhdr) {
+			return
+		}
+	}
+}
+
+// ismerge return true if node's value is a merge operand that is yet
+// to be resolved against an older value, refer LLRB.Merge.
+func (nd *Llrbnode) ismerge() bool {
+	return (nd.gethdr() & hdrMerge) == hdrMerge
+}
+
+func (nd *Llrbnode) setmerge() *Llrbnode {
+	return nd.sethdr(nd.gethdr() | hdrMerge)
+}
+
+func (nd *Llrbnode) clearmerge() *Llrbnode {
+	return nd.sethdr(nd.gethdr() & (^hdrMerge))
+}
+
 func (nd *Llrbnode) getkey() (key []byte) {
 	klen := nd.getkeylen()
 	sl := (*reflect.SliceHeader)(unsafe.Pointer(&key))
@@ -177,6 +210,53 @@ func (nd *Llrbnode) setseqnodeleted(seqno uint64) *Llrbnode {
 	return nd.setseqflags(seqflags)
 }
 
+//---- expiry
+
+// getexpiry return node's expiry in unix seconds, ZERO means never
+// expire. Expiry is held in value header, refer nodevalue.
+func (nd *Llrbnode) getexpiry() uint64 {
+	if nv := nd.nodevalue(); nv != nil {
+		return nv.getexpiry()
+	}
+	return 0
+}
+
+// setexpiry in node's value header, if node does not have a value an
+// empty value is allocated from arena to hold the expiry, readers still
+// see a nil value, refer Value.
+func (nd *Llrbnode) setexpiry(
+	expiry uint64, arena api.Mallocer) *Llrbnode {
+
+	nv := nd.nodevalue()
+	if nv == nil && expiry == 0 {
+		return nd
+	} else if nv == nil {
+		nv = (*nodevalue)(arena.Alloc(int64(nvaluesize)))
+		nv.hdr = 0
+		nd.setnodevalue(nv)
+	}
+	nv.setexpiry(expiry)
+	return nd
+}
+
+func (nd *Llrbnode) isexpired() bool {
+	return api.Isexpired(nd.getexpiry())
+}
+
+// istombstone return true if entry is marked deleted or if it has
+// expired, either case it shall be treated as deleted by readers.
+func (nd *Llrbnode) istombstone() bool {
+	return nd.isdeleted() || nd.isexpired()
+}
+
+// livevalue is same as Value, except that expired entries have no value.
+func (nd *Llrbnode) livevalue() []byte {
+	if nd.isexpired() {
+		return nil
+	}
+	return nd.Value()
+}
+
 func (nd *Llrbnode) setreclaim() *Llrbnode {
 	seqflags := nd.getseqflags()
 	return nd.setseqflags(seqflags | ndValreclaim)
@@ -192,9 +272,10 @@ func (nd *Llrbnode) isreclaim() bool {
 	return (seqflags & ndValreclaim) == ndValreclaim
 }
 
-// Value return the value byte-slice for this entry.
+// Value return the value byte-slice for this entry, nil if entry has
+// no value, including an empty value that only holds the expiry.
 func (nd *Llrbnode) Value() []byte {
-	if nv := nd.nodevalue(); nv != nil {
+	if nv := nd.nodevalue(); nv != nil && nv.valsize() > 0 {
 		return nv.value()
 	}
 	return nil
@@ -260,41 +341,46 @@ func (nd *Llrbnode) ptrdump(prefix string) {
 
 //---- indexer api
 
-func (nd *Llrbnode) ltkey(other []byte, partial bool) bool {
+func (nd *Llrbnode) ltkey(
+	other []byte, partial bool, cmp api.Comparator) bool {
+
 	var key []byte
 	sl := (*reflect.SliceHeader)(unsafe.Pointer(&key))
 	klen := nd.getkeylen()
 	sl.Data = (uintptr)(unsafe.Pointer(&nd.key))
 	sl.Len, sl.Cap = int(klen), int(klen)
-	return api.Binarycmp(key, other, partial) == -1
+	return cmp.Partialcmp(key, other, partial) < 0
 }
 
-func (nd *Llrbnode) lekey(other []byte, partial bool) bool {
+func (nd *Llrbnode) lekey(
+	other []byte, partial bool, cmp api.Comparator) bool {
+
 	var key []byte
 	sl := (*reflect.SliceHeader)(unsafe.Pointer(&key))
 	klen := nd.getkeylen()
 	sl.Data = (uintptr)(unsafe.Pointer(&nd.key))
 	sl.Len, sl.Cap = int(klen), int(klen)
-	cmp := api.Binarycmp(key, other, partial)
-	return cmp == -1 || cmp == 0
+	return cmp.Partialcmp(key, other, partial) <= 0
 }
 
-func (nd *Llrbnode) gtkey(other []byte, partial bool) bool {
+func (nd *Llrbnode) gtkey(
+	other []byte, partial bool, cmp api.Comparator) bool {
+
 	var key []byte
 	sl := (*reflect.SliceHeader)(unsafe.Pointer(&key))
 	klen := nd.getkeylen()
 	sl.Data = (uintptr)(unsafe.Pointer(&nd.key))
 	sl.Len, sl.Cap = int(klen), int(klen)
-	cmp := api.Binarycmp(key, other, partial)
-	return cmp == 1
+	return cmp.Partialcmp(key, other, partial) > 0
 }
 
-func (nd *Llrbnode) gekey(other []byte, partial bool) bool {
+func (nd *Llrbnode) gekey(
+	other []byte, partial bool, cmp api.Comparator) bool {
+
 	var key []byte
 	sl := (*reflect.SliceHeader)(unsafe.Pointer(&key))
 	klen := nd.getkeylen()
 	sl.Data = (uintptr)(unsafe.Pointer(&nd.key))
 	sl.Len, sl.Cap = int(klen), int(klen)
-	cmp := api.Binarycmp(key, other, partial)
-	return cmp == 0 || cmp == 1
+	return cmp.Partialcmp(key, other, partial) >= 0
 }
@@ -95,20 +95,20 @@ func TestNodeLtkey(t *testing.T) {
 
 	// check with empty key
 	nd.setkey([]byte(""))
-	if nd.ltkey([]byte("a"), false) != true {
+	if nd.ltkey([]byte("a"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.ltkey([]byte(""), false) != false {
+	} else if nd.ltkey([]byte(""), false, bytes.Compare) != false {
 		t.Errorf("expected false")
 	}
 	// check with valid key
 	nd.setkey(key)
-	if nd.ltkey([]byte("a"), false) != false {
+	if nd.ltkey([]byte("a"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.ltkey([]byte(""), false) != false {
+	} else if nd.ltkey([]byte(""), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.ltkey([]byte("b"), false) != true {
+	} else if nd.ltkey([]byte("b"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.ltkey([]byte("abcdef"), false) != false {
+	} else if nd.ltkey([]byte("abcdef"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
 	}
 }
@@ -121,20 +121,20 @@ func TestNodeLekey(t *testing.T) {
 
 	// check with empty key
 	nd.setkey([]byte(""))
-	if nd.lekey([]byte("a"), false) != true {
+	if nd.lekey([]byte("a"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.lekey([]byte(""), false) != true {
+	} else if nd.lekey([]byte(""), false, bytes.Compare) != true {
 		t.Errorf("expected true")
 	}
 	// check with valid key
 	nd.setkey(key)
-	if nd.lekey([]byte("a"), false) != false {
+	if nd.lekey([]byte("a"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.lekey([]byte(""), false) != false {
+	} else if nd.lekey([]byte(""), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.lekey([]byte("b"), false) != true {
+	} else if nd.lekey([]byte("b"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.lekey([]byte("abcdef"), false) != true {
+	} else if nd.lekey([]byte("abcdef"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
 	}
 }
@@ -147,20 +147,20 @@ func TestNodeGtkey(t *testing.T) {
 
 	// check with empty key
 	nd.setkey([]byte(""))
-	if nd.gtkey([]byte("a"), false) != false {
+	if nd.gtkey([]byte("a"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.gtkey([]byte(""), false) != false {
+	} else if nd.gtkey([]byte(""), false, bytes.Compare) != false {
 		t.Errorf("expected false")
 	}
 	// check with valid key
 	nd.setkey(key)
-	if nd.gtkey([]byte("a"), false) != true {
+	if nd.gtkey([]byte("a"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.gtkey([]byte(""), false) != true {
+	} else if nd.gtkey([]byte(""), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.gtkey([]byte("b"), false) != false {
+	} else if nd.gtkey([]byte("b"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.gtkey([]byte("abcdef"), false) != false {
+	} else if nd.gtkey([]byte("abcdef"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
 	}
 }
@@ -173,20 +173,20 @@ func TestNodeGekey(t *testing.T) {
 
 	// check with empty key
 	nd.setkey([]byte(""))
-	if nd.gekey([]byte("a"), false) != false {
+	if nd.gekey([]byte("a"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.gekey([]byte(""), false) != true {
+	} else if nd.gekey([]byte(""), false, bytes.Compare) != true {
 		t.Errorf("expected true")
 	}
 	// check with valid key
 	nd.setkey(key)
-	if nd.gekey([]byte("a"), false) != true {
+	if nd.gekey([]byte("a"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.gekey([]byte(""), false) != true {
+	} else if nd.gekey([]byte(""), false, bytes.Compare) != true {
 		t.Errorf("expected true")
-	} else if nd.gekey([]byte("b"), false) != false {
+	} else if nd.gekey([]byte("b"), false, bytes.Compare) != false {
 		t.Errorf("expected false")
-	} else if nd.gekey([]byte("abcdef"), false) != true {
+	} else if nd.gekey([]byte("abcdef"), false, bytes.Compare) != true {
 		t.Errorf("expected true")
 	}
 }
@@ -313,7 +313,7 @@ func BenchmarkNodeLt(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		nd.ltkey(otherkey, false)
+		nd.ltkey(otherkey, false, bytes.Compare)
 	}
 }
 
@@ -326,7 +326,7 @@ func BenchmarkNodeLe(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		nd.lekey(otherkey, false)
+		nd.lekey(otherkey, false, bytes.Compare)
 	}
 }
 
@@ -339,7 +339,7 @@ func BenchmarkNodeGt(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		nd.gtkey(otherkey, false)
+		nd.gtkey(otherkey, false, bytes.Compare)
 	}
 }
 
@@ -352,6 +352,6 @@ func BenchmarkNodeGe(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		nd.gekey(otherkey, false)
+		nd.gekey(otherkey, false, bytes.Compare)
 	}
 }
@@ -1,26 +1,37 @@
 package llrb
 
+import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/lib"
 
 var scanlimit = 100
 
 type scanbuf struct {
-	keys   [][]byte
-	values [][]byte
-	seqnos []uint64
-	dels   []bool
-	windex int
-	rindex int
+	keys     [][]byte
+	values   [][]byte
+	seqnos   []uint64
+	dels     []bool
+	expiries []uint64
+	merges   []bool
+	windex   int
+	rindex   int
+	// range tombstones as of the start of scan, not applied for
+	// ScanEntries, whose consumers shall persist them separately.
+	tombs   api.Rangetombs
+	cmp     api.Comparator
+	merge   api.Mergeoperator
+	entries bool
 }
 
 func makescanbuf() *scanbuf {
 	return &scanbuf{
-		keys:   make([][]byte, scanlimit),
-		values: make([][]byte, scanlimit),
-		seqnos: make([]uint64, scanlimit),
-		dels:   make([]bool, scanlimit),
-		rindex: 0,
-		windex: 0,
+		keys:     make([][]byte, scanlimit),
+		values:   make([][]byte, scanlimit),
+		seqnos:   make([]uint64, scanlimit),
+		dels:     make([]bool, scanlimit),
+		expiries: make([]uint64, scanlimit),
+		merges:   make([]bool, scanlimit),
+		rindex:   0,
+		windex:   0,
 	}
 }
 
@@ -45,10 +56,41 @@ func (sb *scanbuf) append(key, value []byte, seqno uint64, deleted bool) int {
 
 	sb.seqnos[sb.windex] = seqno
 	sb.dels[sb.windex] = deleted
+	sb.expiries[sb.windex] = 0
+	sb.merges[sb.windex] = false
 	sb.windex++
 	return sb.windex
 }
 
+// appendnode add node's entry into scan buffer, expired entries and
+// entries covered by a range tombstone are added as deleted without
+// expiry. Merge operands are resolved against nil value, except for
+// ScanEntries, where they are added as is.
+func (sb *scanbuf) appendnode(nd *Llrbnode, seqno uint64) int {
+	key, value := nd.getkey(), nd.livevalue()
+	if sb.entries == false {
+		if tombseqno, ok := sb.tombs.Covers(key, seqno, sb.cmp); ok {
+			return sb.append(key, nil, tombseqno, true)
+		}
+		value = nd.mergedvalue(sb.merge)
+	}
+	n := sb.append(key, value, seqno, nd.istombstone())
+	if nd.isexpired() == false {
+		sb.expiries[n-1] = nd.getexpiry()
+	}
+	sb.merges[n-1] = sb.entries && nd.ismerge()
+	return n
+}
+
+// ismerge return whether the last entry returned by pop is a merge
+// operand.
+func (sb *scanbuf) ismerge() bool {
+	if sb.rindex > 0 {
+		return sb.merges[sb.rindex-1]
+	}
+	return false
+}
+
 func (sb *scanbuf) prepareread() {
 	sb.rindex = 0
 }
@@ -62,3 +104,102 @@ func (sb *scanbuf) pop() (key, value []byte, seqno uint64, deleted bool) {
 	}
 	return
 }
+
+// expiry of the last entry returned by pop.
+func (sb *scanbuf) expiry() uint64 {
+	if sb.rindex > 0 {
+		return sb.expiries[sb.rindex-1]
+	}
+	return 0
+}
+
+// scanrange bounds for range scans.
+type scanrange struct {
+	low      []byte
+	high     []byte
+	lowincl  bool
+	highincl bool
+	reverse  bool
+	cmp      api.Comparator
+}
+
+func makescanrange(
+	low, high []byte, incl string, reverse bool,
+	cmp api.Comparator) *scanrange {
+
+	lowincl, highincl, err := api.Rangeincl(incl)
+	if err != nil {
+		panic(err)
+	}
+	r := &scanrange{lowincl: lowincl, highincl: highincl, reverse: reverse}
+	r.cmp = cmp
+	if low != nil {
+		r.low = lib.Fixbuffer(nil, int64(len(low)))
+		copy(r.low, low)
+	}
+	if high != nil {
+		r.high = lib.Fixbuffer(nil, int64(len(high)))
+		copy(r.high, high)
+	}
+	return r
+}
+
+// resume range after key, which is already iterated.
+func (r *scanrange) resume(key []byte) {
+	if r.reverse {
+		r.high = lib.Fixbuffer(r.high, int64(len(key)))
+		copy(r.high, key)
+		r.highincl = false
+		return
+	}
+	r.low = lib.Fixbuffer(r.low, int64(len(key)))
+	copy(r.low, key)
+	r.lowincl = false
+}
+
+func (r *scanrange) belowlow(nd *Llrbnode) bool {
+	if r.low == nil {
+		return false
+	} else if r.lowincl {
+		return nd.ltkey(r.low, false, r.cmp)
+	}
+	return nd.lekey(r.low, false, r.cmp)
+}
+
+func (r *scanrange) abovehigh(nd *Llrbnode) bool {
+	if r.high == nil {
+		return false
+	} else if r.highincl {
+		return nd.gtkey(r.high, false, r.cmp)
+	}
+	return nd.gekey(r.high, false, r.cmp)
+}
+
+// walk sub-tree under nd, in range order, and append entries that fall
+// within the range and are less than or equal to leseqno. Return false
+// if scan buffer is full.
+func (r *scanrange) walk(nd *Llrbnode, sb *scanbuf, leseqno uint64) bool {
+	if nd == nil {
+		return true
+	} else if r.belowlow(nd) {
+		return r.walk(nd.right, sb, leseqno)
+	} else if r.abovehigh(nd) {
+		return r.walk(nd.left, sb, leseqno)
+	}
+
+	first, second := nd.left, nd.right
+	if r.reverse {
+		first, second = nd.right, nd.left
+	}
+	if !r.walk(first, sb, leseqno) {
+		return false
+	}
+	seqno := nd.getseqno()
+	if seqno <= leseqno {
+		n := sb.appendnode(nd, seqno)
+		if n >= scanlimit {
+			return false
+		}
+	}
+	return r.walk(second, sb, leseqno)
+}
@@ -2,11 +2,13 @@ package llrb
 
 import "io"
 import "fmt"
+import "time"
 import "unsafe"
 import "strings"
 import "sync/atomic"
 
 import "github.com/bnclabs/gostore/lib"
+import "github.com/bnclabs/gostore/api"
 
 // mvccsnapshot refers to MVCC snapshot of LLRB tree. Snapshots
 // can be used for concurrent reads.
@@ -21,15 +23,25 @@ type mvccsnapshot struct {
 	mvcc     *MVCC
 	root     unsafe.Pointer // *Llrbnode
 	next     unsafe.Pointer // *mvccsnapshot
+	tombs    unsafe.Pointer // *api.Rangetombs, copy on write.
 	reclaims []*Llrbnode
 	reclaim  []*Llrbnode
+	retain   []*Llrbnode // reclaimed by newer snapshots, but reachable.
+}
+
+// checkpoint is a read snapshot pinned by name, refer MVCC.Checkpoint.
+type checkpoint struct {
+	name     string
+	seqno    uint64
+	born     time.Time
+	snapshot *mvccsnapshot
 }
 
 // Should be under write-lock.
 func (snap *mvccsnapshot) initsnapshot(
 	id int64, mvcc *MVCC, head *mvccsnapshot) *mvccsnapshot {
 
-	snap.mvcc, snap.root = mvcc, nil
+	snap.mvcc, snap.root, snap.tombs = mvcc, nil, nil
 	atomic.StorePointer(&snap.next, unsafe.Pointer(head))
 	// IMPORTANT: don't update refcount and n_count atomically here.
 	// it can catch bugs in purging and re-cycling snapshots.
@@ -37,10 +49,12 @@ func (snap *mvccsnapshot) initsnapshot(
 	snap.n_count = 0
 	if head != nil {
 		snap.root = atomic.LoadPointer(&head.root)
+		snap.tombs = atomic.LoadPointer(&head.tombs)
 		snap.n_count = mvcc.Count()
 		//fmt.Printf("initsnapshot %v %v\n", time.Now(), len(head.reclaims))
 	}
 	snap.reclaims, snap.reclaim = snap.reclaims[:0], snap.reclaim[:0]
+	snap.retain = snap.retain[:0]
 	atomic.StoreInt64(&snap.id, id)
 	return snap
 }
@@ -74,6 +88,28 @@ func (snap *mvccsnapshot) setroot(root *Llrbnode) {
 	atomic.StorePointer(&snap.root, unsafe.Pointer(root))
 }
 
+func (snap *mvccsnapshot) gettombs() api.Rangetombs {
+	if ptr := atomic.LoadPointer(&snap.tombs); ptr != nil {
+		return *(*api.Rangetombs)(ptr)
+	}
+	return nil
+}
+
+func (snap *mvccsnapshot) settombs(tombs api.Rangetombs) {
+	atomic.StorePointer(&snap.tombs, unsafe.Pointer(&tombs))
+}
+
+// getseqno return the seqno of node, or the seqno of the range
+// tombstone covering the node along with true.
+func (snap *mvccsnapshot) getseqno(nd *Llrbnode) (uint64, bool) {
+	seqno := nd.getseqno()
+	tombs, cmp := snap.gettombs(), snap.mvcc.cmp
+	if tombseqno, ok := tombs.Covers(nd.getkey(), seqno, cmp); ok {
+		return tombseqno, true
+	}
+	return seqno, false
+}
+
 //---- Exported Read methods
 
 // Get value for key, if value argument is not nil it will be used to copy the
@@ -82,26 +118,48 @@ func (snap *mvccsnapshot) setroot(root *Llrbnode) {
 func (snap *mvccsnapshot) get(
 	key, value []byte) (v []byte, cas uint64, deleted bool, ok bool) {
 
+	v, cas, deleted, merge, ok := snap.getmerge(key, value)
+	if merge {
+		v = resolveoperand(snap.mvcc.merge, key, v)
+	}
+	return v, cas, deleted, ok
+}
+
+// getmerge is same as get, except that merge operands are returned as
+// is, along with merge as true.
+func (snap *mvccsnapshot) getmerge(
+	key, value []byte) (v []byte, cas uint64, deleted, merge, ok bool) {
+
 	deleted, seqno := false, uint64(0)
 	nd, ok := snap.getkey(snap.getroot(), key)
 	if ok {
+		if snap.mvcc.accesstime {
+			nd.touch(accessclock(true, snap.mvcc.epoch))
+		}
 		if value != nil {
-			val := nd.Value()
+			val := nd.livevalue()
 			value = lib.Fixbuffer(value, int64(len(val)))
 			copy(value, val)
 		}
-		seqno, deleted = nd.getseqno(), nd.isdeleted()
-	} else if value != nil {
+		seqno, deleted, merge = nd.getseqno(), nd.istombstone(), nd.ismerge()
+	}
+	tombs, cmp := snap.gettombs(), snap.mvcc.cmp
+	if tombseqno, covered := tombs.Covers(key, seqno, cmp); covered {
+		seqno, deleted, merge, ok = tombseqno, true, false, true
+		if value != nil {
+			value = lib.Fixbuffer(value, 0)
+		}
+	} else if ok == false && value != nil {
 		value = lib.Fixbuffer(value, 0)
 	}
-	return value, seqno, deleted, ok
+	return value, seqno, deleted, merge, ok
 }
 
 func (snap *mvccsnapshot) getkey(nd *Llrbnode, k []byte) (*Llrbnode, bool) {
 	for nd != nil {
-		if nd.gtkey(k, false) {
+		if nd.gtkey(k, false, snap.mvcc.cmp) {
 			nd = nd.left
-		} else if nd.ltkey(k, false) {
+		} else if nd.ltkey(k, false, snap.mvcc.cmp) {
 			nd = nd.right
 		} else {
 			return nd, true
@@ -112,6 +170,16 @@ func (snap *mvccsnapshot) getkey(nd *Llrbnode, k []byte) (*Llrbnode, bool) {
 
 //---- local methods
 
+func (snap *mvccsnapshot) getnext() *mvccsnapshot {
+	return (*mvccsnapshot)(atomic.LoadPointer(&snap.next))
+}
+
+// getnode return the node for key in this snapshot, if present.
+func (snap *mvccsnapshot) getnode(key []byte) *Llrbnode {
+	nd, _ := snap.getkey(snap.getroot(), key)
+	return nd
+}
+
 func (snap *mvccsnapshot) getref() int64 {
 	return atomic.LoadInt64(&snap.refcount)
 }
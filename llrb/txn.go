@@ -1,5 +1,7 @@
 package llrb
 
+import "fmt"
+import "time"
 import "bytes"
 import "hash/crc32"
 
@@ -14,14 +16,19 @@ type Txn struct {
 	snapshot interface{}
 	tblcrc32 *crc32.Table
 	writes   map[uint32]*record
+	reads    map[uint32]*record // only for serializable txns.
 	cursors  []*Cursor
 	recchan  chan *record
 	curchan  chan *Cursor
+
+	optimistic bool // refer LLRB.BeginOptimistic.
+	rlocked    bool // read lock held on behalf of cursors.
 }
 
 const (
 	cmdSet byte = iota + 1
 	cmdDelete
+	cmdGet
 )
 
 func newtxn(
@@ -38,6 +45,9 @@ func newtxn(
 	if txn.recchan != nil && txn.writes == nil {
 		txn.writes = make(map[uint32]*record)
 	}
+	if txn.recchan != nil && txn.reads == nil {
+		txn.reads = make(map[uint32]*record)
+	}
 	return txn
 }
 
@@ -74,10 +84,19 @@ func (txn *Txn) Abort() {
 
 // OpenCursor open an active cursor inside the index.
 func (txn *Txn) OpenCursor(key []byte) (api.Cursor, error) {
+	txn.readlock()
 	cur := txn.getcursor().opencursor(txn, txn.snapshot, key)
 	return cur, nil
 }
 
+// OpenReverseCursor open an active cursor inside the index, positioned
+// at the last entry less than or equal to key.
+func (txn *Txn) OpenReverseCursor(key []byte) (api.Cursor, error) {
+	txn.readlock()
+	cur := txn.getcursor().openreverse(txn, txn.snapshot, key)
+	return cur, nil
+}
+
 //---- Exported Read methods
 
 // Get value for key from snapshot.
@@ -89,9 +108,10 @@ func (txn *Txn) Get(
 	_, next := head.get(key)
 	if next == nil {
 		v, cas, deleted, ok = txn.getonsnap(key, value)
+		txn.addread(index, key, cas)
 		return
 
-	} else if next.cmd == cmdDelete {
+	} else if next.cmd == cmdDelete || api.Isexpired(next.expiry) {
 		return lib.Fixbuffer(v, 0), next.seqno, true, true
 	}
 	v = lib.Fixbuffer(value, int64(len(next.value)))
@@ -99,19 +119,54 @@ func (txn *Txn) Get(
 	return v, next.seqno, false, true
 }
 
+// Getmerge is same as Get, except that a value stored as merge operand
+// in snapshot is returned as is, along with merge as true.
+func (txn *Txn) Getmerge(
+	key, value []byte) (v []byte, cas uint64, deleted, merge, ok bool) {
+
+	index := crc32.Checksum(key, txn.tblcrc32)
+	head, _ := txn.writes[index]
+	if _, next := head.get(key); next == nil {
+		v, cas, deleted, merge, ok = txn.getmergeonsnap(key, value)
+		txn.addread(index, key, cas)
+		return
+	}
+	v, cas, deleted, ok = txn.Get(key, value)
+	return v, cas, deleted, false, ok
+}
+
 //---- Exported Write methods
 
 // Set an entry of key, value pair. The set operation will be remembered
 // as a log entry and applied on the underlying structure during Commit.
 func (txn *Txn) Set(key, value, oldvalue []byte) []byte {
+	return txn.SetExpiry(key, value, oldvalue, 0)
+}
+
+// SetTTL is same as Set, but the entry shall expire after ttl duration
+// from now. Once expired, the entry is treated as deleted by readers.
+func (txn *Txn) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) []byte {
+
+	return txn.SetExpiry(key, value, oldvalue, api.Ttlexpiry(ttl))
+}
+
+// SetExpiry is same as Set, but the entry shall expire at expiry,
+// specified as unix seconds. Expiry of ZERO never expires. Values
+// larger than Maxvaluesize shall panic.
+func (txn *Txn) SetExpiry(key, value, oldvalue []byte, expiry uint64) []byte {
 	var seqno uint64
 
+	if err := checkvalue(value); err != nil {
+		panic(fmt.Errorf("txn SetExpiry(%q): %v", key, err))
+	}
 	node := txn.getrecord()
 	node.key = lib.Fixbuffer(node.key, int64(len(key)))
 	copy(node.key, key)
 	node.value = lib.Fixbuffer(node.value, int64(len(value)))
 	copy(node.value, value)
 	node.cmd, node.seqno, node.next = cmdSet, 0, nil
+	node.expiry = expiry
 
 	index := crc32.Checksum(key, txn.tblcrc32)
 	head, _ := txn.writes[index]
@@ -140,6 +195,7 @@ func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
 	node.key = lib.Fixbuffer(node.key, int64(len(key)))
 	copy(node.key, key)
 	node.cmd, node.seqno, node.lsm, node.next = cmdDelete, 0, lsm, nil
+	node.expiry = 0
 	node.value = lib.Fixbuffer(node.value, 0)
 
 	index := crc32.Checksum(key, txn.tblcrc32)
@@ -159,27 +215,66 @@ func (txn *Txn) Delete(key, oldvalue []byte, lsm bool) []byte {
 	return oldvalue
 }
 
+// Release the read lock held by cursors opened on an optimistic
+// transaction, cursors shall not be used after this call. Refer
+// LLRB.BeginOptimistic.
+func (txn *Txn) Release() {
+	if llrb, ok := txn.db.(*LLRB); ok && txn.rlocked {
+		txn.rlocked = false
+		llrb.runlock()
+	}
+}
+
 //---- local methods
 
+// readlock hold the read lock on behalf of cursors opened on an
+// optimistic transaction, until it is released.
+func (txn *Txn) readlock() {
+	llrb, ok := txn.db.(*LLRB)
+	if ok && txn.optimistic && txn.rlocked == false {
+		llrb.rlock()
+		txn.rlocked = true
+	}
+}
+
+// addread remember the CAS observed for key, to be validated during
+// commit, ZERO CAS means key was missing in the snapshot.
+func (txn *Txn) addread(index uint32, key []byte, seqno uint64) {
+	if mvcc, ok := txn.db.(*MVCC); ok == false || mvcc.serialize == false {
+		return
+	}
+	head, _ := txn.reads[index]
+	if _, next := head.get(key); next != nil {
+		return // first read on the key is validated.
+	}
+	node := txn.getrecord()
+	node.key = lib.Fixbuffer(node.key, int64(len(key)))
+	copy(node.key, key)
+	node.value = lib.Fixbuffer(node.value, 0)
+	node.cmd, node.seqno, node.expiry, node.next = cmdGet, seqno, 0, nil
+	_, txn.reads[index] = head.prepend(key, node)
+}
+
 func (txn *Txn) getonsnap(key, value []byte) ([]byte, uint64, bool, bool) {
+	v, cas, deleted, merge, ok := txn.getmergeonsnap(key, value)
+	if merge {
+		v = resolveoperand(getmergeoperator(txn.snapshot), key, v)
+	}
+	return v, cas, deleted, ok
+}
+
+func (txn *Txn) getmergeonsnap(
+	key, value []byte) ([]byte, uint64, bool, bool, bool) {
+
 	switch snap := txn.snapshot.(type) {
 	case *LLRB:
-		deleted, seqno := false, uint64(0)
-		nd, ok := snap.getkey(snap.getroot(), key)
-		if ok {
-			if value != nil {
-				val := nd.Value()
-				value = lib.Fixbuffer(value, int64(len(val)))
-				copy(value, val)
-			}
-			seqno, deleted = nd.getseqno(), nd.isdeleted()
-		} else if value != nil {
-			value = lib.Fixbuffer(value, 0)
+		if txn.optimistic && txn.rlocked == false {
+			snap.rlock()
+			defer snap.runlock()
 		}
-		return value, seqno, deleted, ok
-
+		return snap.getmerge(key, value)
 	case *mvccsnapshot:
-		return snap.get(key, value)
+		return snap.getmerge(key, value)
 	}
 	panic("unreachable code")
 }
@@ -219,12 +314,13 @@ func (txn *Txn) putcursor(cur *Cursor) {
 }
 
 type record struct {
-	cmd   byte
-	key   []byte
-	value []byte
-	seqno uint64
-	lsm   bool
-	next  *record
+	cmd    byte
+	key    []byte
+	value  []byte
+	seqno  uint64
+	expiry uint64
+	lsm    bool
+	next   *record
 }
 
 func (head *record) get(key []byte) (*record, *record) {
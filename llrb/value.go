@@ -1,16 +1,22 @@
 // hardlimits:
 //
-// maximum size of value : 2^40 bytes
+// maximum size of value : 2^32-1 bytes, refer Maxvaluesize
+// maximum expiry         : 2^32 unix seconds
 
 package llrb
 
+import "fmt"
 import "unsafe"
 import "reflect"
 
+// Maxvaluesize is the largest value that can be held by an entry,
+// mutations with larger values are rejected.
+const Maxvaluesize = 0xffffffff
+
 const nvaluesize = int(unsafe.Sizeof(nodevalue{})) - 8 // + valuesize
 
 type nodevalue struct {
-	hdr      uint64         // valuesize[39:]
+	hdr      uint64         // expiry[64:32] valuesize[32:0]
 	valstart unsafe.Pointer // just a place-holder
 }
 
@@ -20,14 +26,27 @@ func (nv *nodevalue) sizeof() int {
 
 func (nv *nodevalue) setvalsize(size int64) *nodevalue {
 	if nv != nil {
-		nv.hdr = (nv.hdr & 0xffffff0000000000) | (uint64(size) & 0xffffffffff)
+		nv.hdr = (nv.hdr & 0xffffffff00000000) | (uint64(size) & 0xffffffff)
 	}
 	return nv
 }
 
 func (nv *nodevalue) valsize() int {
-	return int(nv.hdr & 0xffffffffff)
+	return int(nv.hdr & 0xffffffff)
+}
 
+// setexpiry in unix seconds, expiry beyond the hardlimit is treated as
+// the latest expiry that can be held.
+func (nv *nodevalue) setexpiry(expiry uint64) *nodevalue {
+	if expiry > 0xffffffff {
+		expiry = 0xffffffff
+	}
+	nv.hdr = (nv.hdr & 0xffffffff) | (expiry << 32)
+	return nv
+}
+
+func (nv *nodevalue) getexpiry() uint64 {
+	return nv.hdr >> 32
 }
 
 func (nv *nodevalue) setvalue(val []byte) *nodevalue {
@@ -49,3 +68,12 @@ func (nv *nodevalue) value() (val []byte) {
 	sl.Data = (uintptr)(unsafe.Pointer(&nv.valstart))
 	return
 }
+
+// checkvalue return error if value cannot be held by an entry.
+func checkvalue(value []byte) error {
+	if int64(len(value)) > Maxvaluesize {
+		fmsg := "value size %v exceeds %v"
+		return fmt.Errorf(fmsg, len(value), int64(Maxvaluesize))
+	}
+	return nil
+}
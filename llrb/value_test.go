@@ -3,7 +3,10 @@ package llrb
 import "testing"
 import "bytes"
 import "fmt"
+import "unsafe"
+import "reflect"
 
+import "github.com/bnclabs/gostore/api"
 import "github.com/bnclabs/gostore/malloc"
 
 var _ = fmt.Sprintf("dummy")
@@ -23,9 +26,53 @@ func TestNodeValue(t *testing.T) {
 	} else if nv.sizeof() != 16 {
 		t.Errorf("expected %v, got %v", 16, nv.sizeof())
 	}
+	// expiry is held along with value size.
+	if x := nv.setexpiry(0x87654321).getexpiry(); x != 0x87654321 {
+		t.Errorf("expected %x, got %x", 0x87654321, x)
+	} else if x := nv.valsize(); x != len(value) {
+		t.Errorf("expected %v, got %v", len(value), x)
+	} else if x := nv.setexpiry(1 << 40).getexpiry(); x != 0xffffffff {
+		t.Errorf("expected %x, got %x", 0xffffffff, x)
+	}
 	marena.Free(ptr)
 }
 
+func TestMaxvaluesize(t *testing.T) {
+	llrb := NewLLRB("maxvaluesize", Defaultsettings())
+	defer llrb.Destroy()
+
+	// only the length is looked at, large value need not be allocated.
+	value, large := []byte("hello world"), []byte(nil)
+	sl := (*reflect.SliceHeader)(unsafe.Pointer(&large))
+	sl.Data = (uintptr)(unsafe.Pointer(&value[0]))
+	sl.Len, sl.Cap = Maxvaluesize+1, Maxvaluesize+1
+
+	if _, _, err := llrb.SetCAS([]byte("key"), large, nil, 0); err == nil {
+		t.Errorf("expected error")
+	}
+	batch := api.NewBatch(2)
+	batch.Set([]byte("key1"), large)
+	batch.Set([]byte("key2"), value)
+	if err := llrb.Apply(batch); err == nil {
+		t.Errorf("expected error")
+	} else if op := batch.Op(0); op.Err == nil || op.Seqno != 0 {
+		t.Errorf("unexpected %v %v", op.Err, op.Seqno)
+	} else if op := batch.Op(1); op.Err != nil || op.Seqno == 0 {
+		t.Errorf("unexpected %v %v", op.Err, op.Seqno)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected panic")
+			}
+		}()
+		llrb.Set([]byte("key"), large, nil)
+	}()
+	if llrb.Count() != 1 {
+		t.Errorf("expected %v, got %v", 1, llrb.Count())
+	}
+}
+
 func BenchmarkValueSize(b *testing.B) {
 	capacity := int64(1024 * 1024 * 1024)
 	marena := malloc.NewArena(capacity, "flist")
@@ -1,5 +1,7 @@
 package llrb
 
+import "time"
+
 import "github.com/bnclabs/gostore/api"
 
 // View transaction definition. Read only version of Txn.
@@ -28,6 +30,13 @@ func (view *View) OpenCursor(key []byte) (api.Cursor, error) {
 	return cur, nil
 }
 
+// OpenReverseCursor open an active cursor inside the index, positioned
+// at the last entry less than or equal to key.
+func (view *View) OpenReverseCursor(key []byte) (api.Cursor, error) {
+	cur := view.getcursor().openreverse(nil, view.snapshot, key)
+	return cur, nil
+}
+
 // Abort view, must be called once done with the view.
 func (view *View) Abort() {
 	switch snap := view.snapshot.(type) {
@@ -43,6 +52,13 @@ func (view *View) Set(key, value, oldvalue []byte) []byte {
 	panic("Set not allowed on view")
 }
 
+// SetTTL is not allowed.
+func (view *View) SetTTL(
+	key, value, oldvalue []byte, ttl time.Duration) []byte {
+
+	panic("SetTTL not allowed on view")
+}
+
 // Delete is not allowed.
 func (view *View) Delete(key, oldvalue []byte, lsm bool) []byte {
 	panic("Delete not allowed on view")
@@ -63,14 +79,32 @@ func (view *View) Get(
 	return
 }
 
+// Getmerge is same as Get, except that a value stored as merge operand
+// is returned as is, along with merge as true.
+func (view *View) Getmerge(
+	key, value []byte) (v []byte, cas uint64, deleted, merge, ok bool) {
+
+	return view.getmergeonsnap(key, value)
+}
+
 //---- local methods
 
 func (view *View) getonsnap(key, value []byte) ([]byte, uint64, bool, bool) {
+	v, cas, deleted, merge, ok := view.getmergeonsnap(key, value)
+	if merge {
+		v = resolveoperand(getmergeoperator(view.snapshot), key, v)
+	}
+	return v, cas, deleted, ok
+}
+
+func (view *View) getmergeonsnap(
+	key, value []byte) ([]byte, uint64, bool, bool, bool) {
+
 	switch snap := view.snapshot.(type) {
 	case *LLRB:
-		return snap.get(key, value)
+		return snap.getmerge(key, value)
 	case *mvccsnapshot:
-		return snap.get(key, value)
+		return snap.getmerge(key, value)
 	}
 	panic("unreachable code")
 }
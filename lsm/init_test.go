@@ -4,6 +4,7 @@ import "fmt"
 import "net/http"
 
 import "github.com/bnclabs/golog"
+import "github.com/bnclabs/gostore/api"
 import _ "net/http/pprof"
 
 var _ = fmt.Sprintf("dummy")
@@ -14,6 +15,13 @@ func init() {
 		"log.file":  "",
 	}
 	log.SetLogger(nil, setts)
+
+	appendop := func(key, value, operand []byte) []byte {
+		return append(append([]byte{}, value...), operand...)
+	}
+	if err := api.Registermergeoperator("testappend", appendop); err != nil {
+		panic(err)
+	}
 	go func() {
 		log.Infof("%v", http.ListenAndServe("localhost:6060", nil))
 	}()
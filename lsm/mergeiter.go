@@ -0,0 +1,320 @@
+package lsm
+
+import "io"
+import "bytes"
+import "container/heap"
+
+import "github.com/bnclabs/gostore/api"
+
+// Sourcestats count entries read from each source iterator, by
+// iterators returned from MergeIterators and MergeEntryIterators.
+type Sourcestats struct {
+	// Pulled is the number of entries read from the source.
+	Pulled int64
+	// Shadowed is the number of entries dropped from the source, in
+	// favour of a newer version of the same key.
+	Shadowed int64
+}
+
+// MergeIterators is an iterate combinator that takes any number of
+// iterators, typically one for each level, and return a new iterator
+// that handles LSM. Input iterators are merged using a min-heap, when
+// the same key is returned by more than one iterator, the version with
+// the highest seqno wins and the rest are dropped, if seqnos are equal
+// the iterator earlier in the argument list wins. Deleted entries are
+// returned as is. Nil iterators are ignored. An input iterator ends
+// with io.EOF, any other error from an input iterator is returned by
+// the merged iterator, and all the input iterators are closed.
+//
+// Along with the iterator, per-source statistics are returned in the
+// same order as the input iterators, they are updated as and when the
+// returned iterator is consumed.
+func MergeIterators(iters ...api.Iterator) (api.Iterator, []Sourcestats) {
+	return mergeiterators(iters, false /*reverse*/, bytes.Compare)
+}
+
+// MergeIteratorsReverse is same as MergeIterators, except that all
+// input iterators and the returned iterator are in descending order.
+func MergeIteratorsReverse(
+	iters ...api.Iterator) (api.Iterator, []Sourcestats) {
+
+	return mergeiterators(iters, true /*reverse*/, bytes.Compare)
+}
+
+// MergeIteratorscmp is same as MergeIterators, except that input
+// iterators are sorted using cmp.
+func MergeIteratorscmp(
+	cmp api.Comparator, iters ...api.Iterator) (api.Iterator, []Sourcestats) {
+
+	return mergeiterators(iters, false /*reverse*/, cmp)
+}
+
+// MergeIteratorsReversecmp is same as MergeIteratorsReverse, except
+// that input iterators are sorted using cmp.
+func MergeIteratorsReversecmp(
+	cmp api.Comparator, iters ...api.Iterator) (api.Iterator, []Sourcestats) {
+
+	return mergeiterators(iters, true /*reverse*/, cmp)
+}
+
+// MergeEntryIterators is same as MergeIterators, for entry iterators.
+// Returned entry is valid only till the next call to the iterator.
+func MergeEntryIterators(
+	iters ...api.EntryIterator) (api.EntryIterator, []Sourcestats) {
+
+	return MergeEntryIteratorscmp(bytes.Compare, iters...)
+}
+
+// MergeEntryIteratorscmp is same as MergeEntryIterators, except that
+// input iterators are sorted using cmp.
+func MergeEntryIteratorscmp(
+	cmp api.Comparator,
+	iters ...api.EntryIterator) (api.EntryIterator, []Sourcestats) {
+
+	stats := make([]Sourcestats, len(iters))
+	h := &mergeheap{cmp: cmp}
+	for i, iter := range iters {
+		if iter == nil {
+			continue
+		}
+		src := &mergesource{index: i, itere: iter}
+		if h.pulle(src, stats, false /*fin*/) {
+			h.sources = append(h.sources, src)
+		}
+	}
+	heap.Init(h)
+
+	var winner *mergesource
+	eof := neweofentry()
+	return func(fin bool) api.IndexEntry {
+		if winner != nil { // entry from winner is no more referred.
+			if h.pulle(winner, stats, fin) {
+				heap.Push(h, winner)
+			}
+			winner = nil
+		}
+		if fin || h.err != nil {
+			h.close()
+		}
+		if h.err != nil {
+			eof.err = h.err
+			return eof
+		} else if len(h.sources) == 0 {
+			return eof
+		}
+
+		winner = heap.Pop(h).(*mergesource)
+		for len(h.sources) > 0 && h.cmp(h.sources[0].key, winner.key) == 0 {
+			src := h.sources[0]
+			stats[src.index].Shadowed++
+			if h.pulle(src, stats, false /*fin*/) {
+				heap.Fix(h, 0)
+			} else {
+				heap.Pop(h)
+			}
+		}
+		if h.err != nil {
+			h.close()
+			winner, eof.err = nil, h.err
+			return eof
+		}
+		return winner.entry
+	}, stats
+}
+
+func mergeiterators(
+	iters []api.Iterator, reverse bool,
+	cmp api.Comparator) (api.Iterator, []Sourcestats) {
+
+	stats := make([]Sourcestats, len(iters))
+	h := &mergeheap{reverse: reverse, cmp: cmp}
+	for i, iter := range iters {
+		if iter == nil {
+			continue
+		}
+		src := &mergesource{
+			index: i, iter: iter,
+			key: make([]byte, 0, 16), val: make([]byte, 0, 16),
+		}
+		if h.pull(src, stats, false /*fin*/) {
+			h.sources = append(h.sources, src)
+		}
+	}
+	heap.Init(h)
+
+	var err error
+	key, val := make([]byte, 0, 16), make([]byte, 0, 16)
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		if err != nil {
+			return nil, nil, 0, false, err
+
+		} else if fin {
+			h.close()
+			err = io.EOF
+			return nil, nil, 0, false, err
+
+		} else if h.err != nil {
+			h.close()
+			err = h.err
+			return nil, nil, 0, false, err
+
+		} else if len(h.sources) == 0 {
+			err = io.EOF
+			return nil, nil, 0, false, err
+		}
+
+		src := h.sources[0]
+		key, val = cp(key, src.key), cp(val, src.val)
+		seqno, del := src.seqno, src.del
+		h.next(stats)
+		for len(h.sources) > 0 && h.cmp(h.sources[0].key, key) == 0 {
+			stats[h.sources[0].index].Shadowed++
+			h.next(stats)
+		}
+		if h.err != nil { // source might have had a later version.
+			h.close()
+			err = h.err
+			return nil, nil, 0, false, err
+		}
+		return key, val, seqno, del, nil
+	}, stats
+}
+
+// mergesource is the current entry from one of the input iterators.
+type mergesource struct {
+	index int // position of the input iterator.
+	iter  api.Iterator
+	itere api.EntryIterator
+	entry api.IndexEntry
+	key   []byte
+	val   []byte
+	seqno uint64
+	del   bool
+}
+
+// mergeheap implements heap.Interface, top of the heap is the source
+// with the smallest key, or largest key if reverse, and for equal keys,
+// the source with the latest version.
+type mergeheap struct {
+	sources []*mergesource
+	reverse bool
+	cmp     api.Comparator
+	err     error // first error, other than io.EOF, from sources.
+}
+
+func (h *mergeheap) Len() int {
+	return len(h.sources)
+}
+
+func (h *mergeheap) Less(i, j int) bool {
+	a, b := h.sources[i], h.sources[j]
+	if x := keycmp(a.key, b.key, h.reverse, h.cmp); x != 0 {
+		return x < 0
+	} else if a.seqno != b.seqno {
+		return a.seqno > b.seqno
+	}
+	return a.index < b.index
+}
+
+func (h *mergeheap) Swap(i, j int) {
+	h.sources[i], h.sources[j] = h.sources[j], h.sources[i]
+}
+
+func (h *mergeheap) Push(x interface{}) {
+	h.sources = append(h.sources, x.(*mergesource))
+}
+
+func (h *mergeheap) Pop() interface{} {
+	n := len(h.sources)
+	src := h.sources[n-1]
+	h.sources[n-1] = nil
+	h.sources = h.sources[:n-1]
+	return src
+}
+
+// next move the source at the top of the heap to its next entry,
+// source is removed from the heap once it is exhausted.
+func (h *mergeheap) next(stats []Sourcestats) {
+	if h.pull(h.sources[0], stats, false /*fin*/) {
+		heap.Fix(h, 0)
+	} else {
+		heap.Pop(h)
+	}
+}
+
+// close remaining sources in the heap.
+func (h *mergeheap) close() {
+	for _, src := range h.sources {
+		if src.iter != nil {
+			src.iter(true /*fin*/)
+		} else {
+			src.itere(true /*fin*/)
+		}
+	}
+	h.sources = h.sources[:0]
+}
+
+// pull next entry from source, skipping entries with the same key as
+// the current one. Return false if source is exhausted or failed, a
+// failed source is closed and its error is remembered in h.err.
+func (h *mergeheap) pull(
+	src *mergesource, stats []Sourcestats, fin bool) bool {
+
+	first := stats[src.index].Pulled == 0
+	for {
+		key, val, seqno, del, err := src.iter(fin)
+		if err != nil {
+			if h.seterr(err) && fin == false {
+				src.iter(true /*fin*/)
+			}
+			return false
+		}
+		stats[src.index].Pulled++
+		if first == false && h.cmp(key, src.key) == 0 {
+			stats[src.index].Shadowed++
+			continue
+		}
+		src.key, src.val = cp(src.key, key), cp(src.val, val)
+		src.seqno, src.del = seqno, del
+		return true
+	}
+}
+
+// pulle is same as pull, for entry iterators.
+func (h *mergeheap) pulle(
+	src *mergesource, stats []Sourcestats, fin bool) bool {
+
+	first := stats[src.index].Pulled == 0
+	for {
+		entry := src.itere(fin)
+		if entry == nil {
+			return false
+		}
+		key, seqno, del, err := entry.Key()
+		if err != nil {
+			if h.seterr(err) && fin == false {
+				src.itere(true /*fin*/)
+			}
+			return false
+		}
+		stats[src.index].Pulled++
+		if first == false && h.cmp(key, src.key) == 0 {
+			stats[src.index].Shadowed++
+			continue
+		}
+		src.entry, src.key = entry, cp(src.key, key)
+		src.seqno, src.del = seqno, del
+		return true
+	}
+}
+
+// seterr remember the first error, other than io.EOF, from sources.
+// Return true if err is not io.EOF, failed source shall be closed.
+func (h *mergeheap) seterr(err error) bool {
+	if err == io.EOF {
+		return false
+	} else if h.err == nil {
+		h.err = err
+	}
+	return true
+}
@@ -0,0 +1,341 @@
+package lsm
+
+import "io"
+import "fmt"
+import "bytes"
+import "errors"
+import "testing"
+import "math/rand"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/llrb"
+import "github.com/bnclabs/gostore/bubt"
+import s "github.com/bnclabs/gosettings"
+
+func TestMergeIterators(t *testing.T) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+
+	llrb1, keys := makeLLRB("llrb1", 100000, nil, ref, -1, -1)
+	llrb2, keys := makeLLRB("llrb2", 0, keys, ref, 4, 8)
+	llrb3, keys := makeLLRB("llrb3", 0, keys, ref, 4, 8)
+	llrb4, _ := makeLLRB("llrb4", 0, keys, ref, 4, 8)
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+	defer llrb3.Destroy()
+	defer llrb4.Destroy()
+
+	bubt1 := makemergebubt(t, "bubt1", llrb1)
+	defer bubt1.Destroy()
+	defer bubt1.Close()
+
+	refiter := ref.Scan()
+	iter, stats := MergeIterators(
+		llrb4.Scan(), nil, llrb3.Scan(), llrb2.Scan(), bubt1.Scan(),
+	)
+	n := int64(0)
+	key, value, seqno, deleted, err := refiter(false)
+	for err == nil {
+		k, v, s, d, e := iter(false)
+		if bytes.Compare(key, k) != 0 {
+			t.Fatalf("expected %q, got %q", key, k)
+		} else if err != e {
+			t.Errorf("%q expected %v, got %v", key, err, e)
+		} else if d != deleted {
+			t.Errorf("%q expected %v, got %v", key, deleted, d)
+		} else if s != seqno {
+			t.Errorf("%q expected %v, got %v", key, seqno, s)
+		} else if deleted == false && bytes.Compare(value, v) != 0 {
+			t.Errorf("%q expected %q, got %q", key, value, v)
+		}
+		n++
+		key, value, seqno, deleted, err = refiter(false)
+	}
+	if _, _, _, _, e := iter(false); e != err {
+		t.Errorf("unexpected %v", e)
+	}
+	refiter(true /*fin*/)
+	iter(true /*fin*/)
+
+	// every entry from every source is pulled exactly once.
+	counts := []int64{
+		llrb4.Count(), 0, llrb3.Count(), llrb2.Count(), llrb1.Count(),
+	}
+	if len(stats) != len(counts) {
+		t.Fatalf("expected %v, got %v", len(counts), len(stats))
+	}
+	var pulled, shadowed int64
+	for i, stat := range stats {
+		if stat.Pulled != counts[i] {
+			t.Errorf("%v expected %v, got %v", i, counts[i], stat.Pulled)
+		}
+		pulled, shadowed = pulled+stat.Pulled, shadowed+stat.Shadowed
+	}
+	if stats[0].Shadowed != 0 {
+		t.Errorf("unexpected %v", stats[0].Shadowed)
+	} else if x := pulled - shadowed; x != n {
+		t.Errorf("expected %v, got %v", n, x)
+	}
+}
+
+func TestMergeIteratorsRange(t *testing.T) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+
+	llrb1, keys := makeLLRB("llrb1", 100000, nil, ref, -1, -1)
+	llrb2, keys := makeLLRB("llrb2", 0, keys, ref, 4, 8)
+	llrb3, _ := makeLLRB("llrb3", 0, keys, ref, 4, 8)
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+	defer llrb3.Destroy()
+
+	bubt1 := makemergebubt(t, "bubt1", llrb1)
+	defer bubt1.Destroy()
+	defer bubt1.Close()
+
+	for i := 0; i < 100; i++ {
+		low := []byte(fmt.Sprintf("key%d", rand.Intn(100000)))
+		high := []byte(fmt.Sprintf("key%d", rand.Intn(100000)))
+		if bytes.Compare(low, high) > 0 {
+			low, high = high, low
+		}
+		incl := []string{"none", "low", "high", "both"}[rand.Intn(4)]
+		reverse := rand.Intn(2) == 1
+
+		mergeiterators := MergeIterators
+		if reverse {
+			mergeiterators = MergeIteratorsReverse
+		}
+		refiter := ref.Range(low, high, incl, reverse)
+		iter, _ := mergeiterators(
+			llrb3.Range(low, high, incl, reverse),
+			llrb2.Range(low, high, incl, reverse),
+			bubt1.Range(low, high, incl, reverse),
+		)
+		key, value, seqno, deleted, err := refiter(false)
+		for err == nil {
+			k, v, s, d, e := iter(false)
+			if bytes.Compare(key, k) != 0 {
+				t.Fatalf("expected %q, got %q", key, k)
+			} else if err != e {
+				t.Errorf("%q expected %v, got %v", key, err, e)
+			} else if d != deleted {
+				t.Errorf("%q expected %v, got %v", key, deleted, d)
+			} else if s != seqno {
+				t.Errorf("%q expected %v, got %v", key, seqno, s)
+			} else if deleted == false && bytes.Compare(value, v) != 0 {
+				t.Errorf("%q expected %q, got %q", key, value, v)
+			}
+			key, value, seqno, deleted, err = refiter(false)
+		}
+		if _, _, _, _, e := iter(false); e != err {
+			t.Errorf("unexpected %v", e)
+		}
+		refiter(true /*fin*/)
+		iter(true /*fin*/)
+	}
+}
+
+func TestMergeEntryIterators(t *testing.T) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+
+	llrb1, keys := makeLLRB("llrb1", 100000, nil, ref, -1, -1)
+	llrb2, keys := makeLLRB("llrb2", 0, keys, ref, 4, 8)
+	llrb3, keys := makeLLRB("llrb3", 0, keys, ref, 4, 8)
+	llrb4, _ := makeLLRB("llrb4", 0, keys, ref, 4, 8)
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+	defer llrb3.Destroy()
+	defer llrb4.Destroy()
+
+	bubt1 := makemergebubt(t, "bubt1", llrb1)
+	defer bubt1.Destroy()
+	defer bubt1.Close()
+
+	refiter := ref.Scan()
+	iterentries, stats := MergeEntryIterators(
+		llrb4.ScanEntries(), llrb3.ScanEntries(), llrb2.ScanEntries(),
+		bubt1.ScanEntries(),
+	)
+	n := int64(0)
+	key, value, seqno, deleted, err := refiter(false)
+	for err == nil {
+		entry := iterentries(false)
+		k, s, d, e := entry.Key()
+		v := entry.Value()
+		if bytes.Compare(key, k) != 0 {
+			t.Fatalf("expected %q, got %q", key, k)
+		} else if err != e {
+			t.Errorf("%q expected %v, got %v", key, err, e)
+		} else if d != deleted {
+			t.Errorf("%q expected %v, got %v", key, deleted, d)
+		} else if s != seqno {
+			t.Errorf("%q expected %v, got %v", key, seqno, s)
+		} else if deleted == false && bytes.Compare(value, v) != 0 {
+			t.Errorf("%q expected %q, got %q", key, value, v)
+		}
+		n++
+		key, value, seqno, deleted, err = refiter(false)
+	}
+	entry := iterentries(false)
+	if _, _, _, e := entry.Key(); e != err {
+		t.Errorf("unexpected %v", e)
+	}
+	refiter(true /*fin*/)
+	iterentries(true /*fin*/)
+
+	var pulled, shadowed int64
+	for _, stat := range stats {
+		pulled, shadowed = pulled+stat.Pulled, shadowed+stat.Shadowed
+	}
+	count := llrb1.Count() + llrb2.Count() + llrb3.Count() + llrb4.Count()
+	if count != pulled {
+		t.Errorf("expected %v, got %v", count, pulled)
+	} else if x := pulled - shadowed; x != n {
+		t.Errorf("expected %v, got %v", n, x)
+	}
+}
+
+func TestMergeIteratorsEmpty(t *testing.T) {
+	iter, stats := MergeIterators()
+	if _, _, _, _, err := iter(false); err == nil {
+		t.Errorf("expected io.EOF")
+	} else if len(stats) != 0 {
+		t.Errorf("unexpected %v", len(stats))
+	}
+	itere, stats := MergeEntryIterators(nil, nil)
+	if _, _, _, err := itere(false).Key(); err == nil {
+		t.Errorf("expected io.EOF")
+	} else if len(stats) != 2 {
+		t.Errorf("unexpected %v", len(stats))
+	}
+}
+
+func TestMergeIteratorsError(t *testing.T) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+	defer ref.Destroy()
+
+	llrb1, keys := makeLLRB("llrb1", 1000, nil, ref, -1, -1)
+	llrb2, _ := makeLLRB("llrb2", 0, keys, ref, 4, 8)
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+
+	failerr, finned := errors.New("failed"), 0
+	failiter := func(iter api.Iterator, after int) api.Iterator {
+		return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+			if fin {
+				finned++
+				return iter(fin)
+			} else if after--; after < 0 {
+				return nil, nil, 0, false, failerr
+			}
+			return iter(fin)
+		}
+	}
+	iter, _ := MergeIterators(
+		failiter(llrb2.Scan(), 1000000), failiter(llrb1.Scan(), 100),
+	)
+	n, err := 0, error(nil)
+	for _, _, _, _, err = iter(false); err == nil; n++ {
+		_, _, _, _, err = iter(false)
+	}
+	if err != failerr {
+		t.Errorf("expected %v, got %v", failerr, err)
+	} else if n > 100 {
+		t.Errorf("unexpected %v", n)
+	} else if finned != 2 {
+		t.Errorf("expected %v, got %v", 2, finned)
+	}
+	if _, _, _, _, err = iter(true /*fin*/); err != failerr {
+		t.Errorf("expected %v, got %v", failerr, err)
+	}
+
+	// source ending with io.EOF is not an error.
+	iter, _ = MergeIterators(llrb2.Scan(), llrb1.Scan())
+	for _, _, _, _, err = iter(false); err == nil; {
+		_, _, _, _, err = iter(false)
+	}
+	if err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+
+	failentry := &eofentry{err: failerr}
+	failitere := func(itere api.EntryIterator, after int) api.EntryIterator {
+		return func(fin bool) api.IndexEntry {
+			if fin == false {
+				if after--; after < 0 {
+					return failentry
+				}
+			}
+			return itere(fin)
+		}
+	}
+	itere, _ := MergeEntryIterators(
+		llrb2.ScanEntries(), failitere(llrb1.ScanEntries(), 100),
+	)
+	n = 0
+	for _, _, _, err = itere(false).Key(); err == nil; n++ {
+		_, _, _, err = itere(false).Key()
+	}
+	if err != failerr {
+		t.Errorf("expected %v, got %v", failerr, err)
+	} else if n > 100 {
+		t.Errorf("unexpected %v", n)
+	}
+	if _, _, _, err = itere(true /*fin*/).Key(); err != failerr {
+		t.Errorf("expected %v, got %v", failerr, err)
+	}
+}
+
+func BenchmarkMergeIterators(b *testing.B) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+
+	llrbs := []*llrb.LLRB{}
+	index, keys := makeLLRB("llrb0", b.N, nil, ref, -1, -1)
+	llrbs = append(llrbs, index)
+	for i := 1; i < 8; i++ {
+		index, keys = makeLLRB(fmt.Sprintf("llrb%v", i), 0, keys, ref, 2, 4)
+		llrbs = append(llrbs, index)
+	}
+	defer func() {
+		for _, index := range llrbs {
+			index.Destroy()
+		}
+	}()
+
+	b.ResetTimer()
+	iter, _ := MergeIterators(
+		llrbs[7].Scan(), llrbs[6].Scan(), llrbs[5].Scan(), llrbs[4].Scan(),
+		llrbs[3].Scan(), llrbs[2].Scan(), llrbs[1].Scan(), llrbs[0].Scan(),
+	)
+	for _, _, _, _, err := iter(false); err == nil; {
+		_, _, _, _, err = iter(false)
+	}
+}
+
+func makemergebubt(
+	t *testing.T, name string, index *llrb.LLRB) *bubt.Snapshot {
+
+	paths := makepaths()
+	msize, zsize := int64(4096), int64(4096)
+	vsize := []int64{0, zsize, zsize * 2}[rand.Intn(100000)%3]
+	bb, err := bubt.NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itere := index.ScanEntries()
+	if err = bb.Build(itere, []byte("this is metadata")); err != nil {
+		t.Fatal(err)
+	}
+	bb.Close()
+	itere(true /*fin*/)
+
+	snap, err := bubt.OpenSnapshot(name, paths, rand.Intn(2) == 1 /*mmap*/)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap
+}
@@ -47,7 +47,8 @@ import "github.com/bnclabs/gostore/api"
 
 // YGet is a get combinator that takes two get API and return a new Get api
 // that handles LSM. Note that if b argument is supplied, then it is assumed
-// as the latest version.
+// as the latest version. Keys covered by range tombstones in b are
+// expected to be returned as deleted by b, hence shadowing a.
 func YGet(a, b api.Getter) api.Getter {
 	return func(key, value []byte) (val []byte, cas uint64, d, ok bool) {
 		if val, cas, d, ok = b(key, value); ok {
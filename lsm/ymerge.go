@@ -0,0 +1,104 @@
+package lsm
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/lib"
+
+// YGetmerge is same as YGet, except that merge operands returned by b,
+// the latest version, are resolved against the value returned by a.
+// If a is also returning an operand, the operands are combined and
+// returned as operand. If key is missing in a, operand is returned as
+// is, and it is left to the caller to resolve it against nil value.
+func YGetmerge(
+	a, b api.Mergegetter, merge api.Mergeoperator) api.Mergegetter {
+
+	return func(key, value []byte) ([]byte, uint64, bool, bool, bool) {
+		val, cas, deleted, ismerge, ok := b(key, value)
+		if ok == false {
+			return a(key, value)
+		} else if ismerge == false {
+			return val, cas, deleted, ismerge, ok
+		}
+
+		if value == nil { // operand is required to resolve.
+			val, cas, deleted, ismerge, ok = b(key, make([]byte, 0, 16))
+		}
+		older, _, odeleted, omerge, ook := a(key, make([]byte, 0, 16))
+		if ook == false {
+			return val, cas, false, true, true
+		} else if odeleted {
+			older, omerge = nil, false
+		}
+		newval := merge(key, older, val)
+		val = lib.Fixbuffer(val, int64(len(newval)))
+		copy(val, newval)
+		return val, cas, false, omerge, true
+	}
+}
+
+// YMergeEntries is an iterate combinator that takes an iterator,
+// returning merge operands as entries, and return a new iterator that
+// resolves them into full values. Operands are resolved against the
+// value returned by get for the same key, typically a YGet over older
+// levels, or against nil value if get is nil or key is missing or
+// deleted in older levels.
+func YMergeEntries(
+	itere api.EntryIterator, get api.Getter,
+	merge api.Mergeoperator) api.EntryIterator {
+
+	if itere == nil {
+		return nil
+	}
+	me, older := &mergeentry{}, make([]byte, 0, 16)
+	return func(fin bool) api.IndexEntry {
+		entry := itere(fin)
+		if entry == nil || entry.Ismerge() == false {
+			return entry
+		}
+		key, _, _, err := entry.Key()
+		if err != nil {
+			return entry
+		}
+		var base []byte
+		if get != nil {
+			val, _, deleted, ok := get(key, older)
+			if ok && deleted == false {
+				base = val
+			}
+			if val != nil {
+				older = val
+			}
+		}
+		me.entry, me.value = entry, merge(key, base, entry.Value())
+		return me
+	}
+}
+
+// mergeentry is an entry whose merge operand is resolved into value.
+type mergeentry struct {
+	entry api.IndexEntry
+	value []byte
+}
+
+func (me *mergeentry) ID() string {
+	return me.entry.ID()
+}
+
+func (me *mergeentry) Key() (key []byte, seqno uint64, del bool, err error) {
+	return me.entry.Key()
+}
+
+func (me *mergeentry) Value() []byte {
+	return me.value
+}
+
+func (me *mergeentry) Valueref() (valuelen uint64, vlogpos int64) {
+	return uint64(len(me.value)), -1
+}
+
+func (me *mergeentry) Expiry() uint64 {
+	return me.entry.Expiry()
+}
+
+func (me *mergeentry) Ismerge() bool {
+	return false
+}
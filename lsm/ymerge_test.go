@@ -0,0 +1,97 @@
+package lsm
+
+import "testing"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/llrb"
+
+func TestYGetmerge(t *testing.T) {
+	llrb1, llrb2 := makemergellrbs()
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+
+	merge, _ := api.Getmergeoperator("testappend")
+	older := func(key, value []byte) ([]byte, uint64, bool, bool, bool) {
+		value, cas, deleted, ok := llrb1.Get(key, value)
+		return value, cas, deleted, false, ok
+	}
+	get := YGetmerge(older, llrb2.Getmerge, merge)
+
+	refs := []struct {
+		key, value string
+		merge      bool
+	}{
+		{"key001", "ab", false}, {"key002", "x", true},
+		{"key003", "z", false}, {"key004", "d", false},
+	}
+	for _, ref := range refs {
+		value, _, deleted, ismerge, ok := get([]byte(ref.key), []byte{})
+		if ok == false || deleted {
+			t.Errorf("%v unexpected %v %v", ref.key, ok, deleted)
+		} else if ismerge != ref.merge {
+			t.Errorf("%v expected merge %v", ref.key, ref.merge)
+		} else if string(value) != ref.value {
+			t.Errorf("%v expected %q, got %q", ref.key, ref.value, value)
+		}
+	}
+	// operands are resolved even if caller is not interested in value.
+	if _, _, _, ismerge, ok := get([]byte("key001"), nil); !ok || ismerge {
+		t.Errorf("unexpected %v %v", ok, ismerge)
+	}
+	if _, _, _, _, ok := get([]byte("key005"), nil); ok {
+		t.Errorf("expected missing")
+	}
+}
+
+func TestYMergeEntries(t *testing.T) {
+	llrb1, llrb2 := makemergellrbs()
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+
+	merge, _ := api.Getmergeoperator("testappend")
+	refs := map[string]string{
+		"key001": "ab", "key002": "x", "key003": "z", "key004": "d",
+	}
+	for _, get := range []api.Getter{llrb1.Get, nil} {
+		n, itere := 0, YMergeEntries(llrb2.ScanEntries(), get, merge)
+		for entry := itere(false); ; entry = itere(false) {
+			key, _, _, err := entry.Key()
+			if err != nil {
+				break
+			}
+			ref := refs[string(key)]
+			if get == nil && string(key) == "key001" {
+				ref = "b"
+			}
+			if entry.Ismerge() {
+				t.Errorf("%s unexpected operand", key)
+			} else if value := entry.Value(); string(value) != ref {
+				t.Errorf("%s expected %q, got %q", key, ref, value)
+			}
+			n++
+		}
+		if n != len(refs) {
+			t.Errorf("expected %v, got %v", len(refs), n)
+		}
+	}
+}
+
+// older entries in llrb1 and newer entries, including operands, in
+// llrb2.
+func makemergellrbs() (*llrb.LLRB, *llrb.LLRB) {
+	setts := llrb.Defaultsettings()
+	llrb1 := llrb.NewLLRB("llrb1", setts)
+	llrb1.Set([]byte("key001"), []byte("a"), nil)
+	llrb1.Set([]byte("key003"), []byte("c"), nil)
+	llrb1.Delete([]byte("key003"), nil, true /*lsm*/)
+	llrb1.Set([]byte("key004"), []byte("c"), nil)
+
+	setts["mergeoperator"] = "testappend"
+	llrb2 := llrb.NewLLRB("llrb2", setts)
+	llrb2.Setseqno(10)
+	llrb2.Merge([]byte("key001"), []byte("b"))
+	llrb2.Merge([]byte("key002"), []byte("x"))
+	llrb2.Merge([]byte("key003"), []byte("z"))
+	llrb2.Set([]byte("key004"), []byte("d"), nil)
+	return llrb1, llrb2
+}
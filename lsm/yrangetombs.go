@@ -0,0 +1,31 @@
+package lsm
+
+import "github.com/bnclabs/gostore/api"
+
+// YRangetombs is a iterate combinator that takes an iterator, typically
+// returned by YSort over several levels, and return a new iterator
+// that marks entries covered by range tombstones as deleted. Covered
+// entries are returned without value and with the seqno of the covering
+// range tombstone. Iterator can be in ascending or descending order.
+//
+// Note that Get operations need no such combinator, every level shall
+// return keys covered by its range tombstones as deleted, hence YGet
+// stops at the latest level with a matching range tombstone.
+func YRangetombs(
+	iter api.Iterator, tombs api.Rangetombs,
+	cmp api.Comparator) api.Iterator {
+
+	if iter == nil || len(tombs) == 0 {
+		return iter
+	}
+	return func(fin bool) ([]byte, []byte, uint64, bool, error) {
+		key, val, seqno, del, err := iter(fin)
+		if err != nil {
+			return key, val, seqno, del, err
+		}
+		if tombseqno, ok := tombs.Covers(key, seqno, cmp); ok {
+			return key, nil, tombseqno, true, nil
+		}
+		return key, val, seqno, del, nil
+	}
+}
@@ -0,0 +1,63 @@
+package lsm
+
+import "io"
+import "fmt"
+import "testing"
+
+import "github.com/bnclabs/gostore/api"
+import "github.com/bnclabs/gostore/llrb"
+
+func TestYRangetombs(t *testing.T) {
+	cmp, _ := api.Getcomparator(api.Binarycomparator)
+	setts := llrb.Defaultsettings()
+	llrb1 := llrb.NewLLRB("llrb1", setts)
+	defer llrb1.Destroy()
+	llrb2 := llrb.NewLLRB("llrb2", setts)
+	defer llrb2.Destroy()
+
+	for i := 0; i < 100; i++ {
+		key, val := fmt.Sprintf("key%03d", i), fmt.Sprintf("val%03d", i)
+		llrb1.Setseqno(uint64(i + 1))
+		llrb1.Set([]byte(key), []byte(val), nil)
+	}
+	// older entries in llrb1, deleted range and newer entries in llrb2.
+	llrb2.Setseqno(100)
+	seqno := llrb2.DeleteRange([]byte("key010"), []byte("key020"))
+	llrb2.Set([]byte("key015"), []byte("newval"), nil)
+	tombs := llrb2.Rangetombs()
+	if len(tombs) != 1 || tombs[0].Seqno != seqno {
+		t.Fatalf("unexpected %v", tombs)
+	}
+
+	iter := YRangetombs(
+		YSortcmp(llrb2.Scan(), llrb1.Scan(), cmp), tombs, cmp,
+	)
+	count := 0
+	key, value, _, deleted, err := iter(false /*fin*/)
+	for ; err == nil; key, value, _, deleted, err = iter(false /*fin*/) {
+		x, ref := string(key), fmt.Sprintf("val%v", string(key[3:]))
+		if x == "key015" {
+			if deleted || string(value) != "newval" {
+				t.Errorf("%v unexpected %v %s", x, deleted, value)
+			}
+		} else if x >= "key010" && x < "key020" {
+			if deleted == false || value != nil {
+				t.Errorf("%v expected deleted", x)
+			}
+		} else if deleted || string(value) != ref {
+			t.Errorf("%v unexpected %v %s", x, deleted, value)
+		}
+		count++
+	}
+	if err != io.EOF {
+		t.Error(err)
+	} else if count != 100 {
+		t.Errorf("expected %v, got %v", 100, count)
+	}
+	iter(true /*fin*/)
+
+	// without range tombstones iterator is returned as is.
+	if YRangetombs(nil, tombs, cmp) != nil {
+		t.Errorf("expected nil")
+	}
+}
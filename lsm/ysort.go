@@ -30,9 +30,42 @@ func pull(
 	return cp(k, key), cp(v, val), seqno, del, err
 }
 
+func keycmp(bkey, akey []byte, reverse bool, cmp api.Comparator) int {
+	if reverse {
+		return cmp(akey, bkey)
+	}
+	return cmp(bkey, akey)
+}
+
 // YSort is a iterate combinator that takes two iterator and return
-// a new iterator that handles LSM.
+// a new iterator that handles LSM. Range tombstones from all levels
+// can be applied on the merged iterator using YRangetombs. To combine
+// more than two iterators, use MergeIterators.
 func YSort(a, b api.Iterator) api.Iterator {
+	return ysort(a, b, false /*reverse*/, bytes.Compare)
+}
+
+// YSortReverse is same as YSort, except that both input iterators and
+// the returned iterator are in descending sort order.
+func YSortReverse(a, b api.Iterator) api.Iterator {
+	return ysort(a, b, true /*reverse*/, bytes.Compare)
+}
+
+// YSortcmp is same as YSort, except that input iterators are sorted
+// using cmp.
+func YSortcmp(a, b api.Iterator, cmp api.Comparator) api.Iterator {
+	return ysort(a, b, false /*reverse*/, cmp)
+}
+
+// YSortReversecmp is same as YSortReverse, except that input
+// iterators are sorted using cmp.
+func YSortReversecmp(a, b api.Iterator, cmp api.Comparator) api.Iterator {
+	return ysort(a, b, true /*reverse*/, cmp)
+}
+
+func ysort(
+	a, b api.Iterator, reverse bool, cmp api.Comparator) api.Iterator {
+
 	key, val := make([]byte, 0, 16), make([]byte, 0, 16)
 
 	bkey, bval := make([]byte, 0, 16), make([]byte, 0, 16)
@@ -60,12 +93,12 @@ func YSort(a, b api.Iterator) api.Iterator {
 			seqno, del, err = aseqno, adel, aerr
 			akey, aval, aseqno, adel, aerr = pull(a, fin, akey, aval)
 
-		} else if cmp := bytes.Compare(bkey, akey); cmp < 0 {
+		} else if x := keycmp(bkey, akey, reverse, cmp); x < 0 {
 			key, val = cp(key, bkey), cp(val, bval)
 			seqno, del, err = bseqno, bdel, berr
 			bkey, bval, bseqno, bdel, berr = pull(b, fin, bkey, bval)
 
-		} else if cmp > 0 {
+		} else if x > 0 {
 			key, val = cp(key, akey), cp(val, aval)
 			seqno, del, err = aseqno, adel, aerr
 			akey, aval, aseqno, adel, aerr = pull(a, fin, akey, aval)
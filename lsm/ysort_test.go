@@ -1,5 +1,6 @@
 package lsm
 
+import "fmt"
 import "bytes"
 import "testing"
 import "math/rand"
@@ -166,6 +167,84 @@ func TestYSortM2(t *testing.T) {
 	refiter(true /*fin*/)
 }
 
+func TestYSortRange(t *testing.T) {
+	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
+	ref := llrb.NewLLRB("refllrb", setts)
+
+	llrb1, keys := makeLLRB("llrb1", 100000, nil, ref, -1, -1)
+	llrb2, keys := makeLLRB("llrb2", 0, keys, ref, 4, 8)
+	llrb3, _ := makeLLRB("llrb3", 0, keys, ref, 4, 8)
+	defer llrb1.Destroy()
+	defer llrb2.Destroy()
+	defer llrb3.Destroy()
+
+	paths := makepaths()
+
+	name, msize, mmap := "bubt1", int64(4096), false
+	zsize := []int64{0, msize, msize * 2}[rand.Intn(100000)%3]
+	vsize := []int64{0, zsize, zsize * 2}[rand.Intn(100000)%3]
+	bb, err := bubt.NewBubt(name, paths, msize, zsize, vsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itere := llrb1.ScanEntries()
+	err = bb.Build(itere, []byte("this is metadata for llrb1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb.Close()
+	itere(true /*fin*/)
+
+	bubt1, err := bubt.OpenSnapshot(name, paths, mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bubt1.Destroy()
+	defer bubt1.Close()
+
+	for i := 0; i < 100; i++ {
+		low := []byte(fmt.Sprintf("key%d", rand.Intn(100000)))
+		high := []byte(fmt.Sprintf("key%d", rand.Intn(100000)))
+		if bytes.Compare(low, high) > 0 {
+			low, high = high, low
+		}
+		incl := []string{"none", "low", "high", "both"}[rand.Intn(4)]
+		reverse := rand.Intn(2) == 1
+
+		ysort := YSort
+		if reverse {
+			ysort = YSortReverse
+		}
+		refiter := ref.Range(low, high, incl, reverse)
+		iter := ysort(
+			bubt1.Range(low, high, incl, reverse),
+			ysort(
+				llrb2.Range(low, high, incl, reverse),
+				llrb3.Range(low, high, incl, reverse)))
+		key, value, seqno, deleted, err := refiter(false)
+		for err == nil {
+			k, v, s, d, e := iter(false)
+			if bytes.Compare(key, k) != 0 {
+				t.Fatalf("expected %q, got %q", key, k)
+			} else if err != e {
+				t.Errorf("%q expected %v, got %v", key, err, e)
+			} else if d != deleted {
+				t.Errorf("%q expected %v, got %v", key, deleted, d)
+			} else if s != seqno {
+				t.Errorf("%q expected %v, got %v", key, seqno, s)
+			} else if deleted == false && bytes.Compare(value, v) != 0 {
+				t.Errorf("%q expected %q, got %q", key, value, v)
+			}
+			key, value, seqno, deleted, err = refiter(false)
+		}
+		if _, _, _, _, e := iter(false); e != err {
+			t.Errorf("unexpected %v", e)
+		}
+		refiter(true /*fin*/)
+		iter(true /*fin*/)
+	}
+}
+
 func TestYSortV1(t *testing.T) {
 	setts := s.Settings{"memcapacity": 1024 * 1024 * 1024}
 	ref := llrb.NewLLRB("refllrb", setts)
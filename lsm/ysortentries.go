@@ -27,8 +27,17 @@ func pulle(
 }
 
 // YSortEntries is a iterate combinator that takes two iterator and
-// return a new iterator that handles LSM.
+// return a new iterator that handles LSM. To combine more than two
+// iterators, use MergeEntryIterators.
 func YSortEntries(a, b api.EntryIterator) api.EntryIterator {
+	return YSortEntriescmp(a, b, bytes.Compare)
+}
+
+// YSortEntriescmp is same as YSortEntries, except that input
+// iterators are sorted using cmp.
+func YSortEntriescmp(
+	a, b api.EntryIterator, cmp api.Comparator) api.EntryIterator {
+
 	var aentry, bentry api.IndexEntry
 	var key []byte
 	var aseqno, bseqno uint64
@@ -70,10 +79,10 @@ func YSortEntries(a, b api.EntryIterator) api.EntryIterator {
 		} else if berr != nil {
 			entry, anext = aentry, true
 
-		} else if cmp := bytes.Compare(bkey, akey); cmp < 0 {
+		} else if x := cmp(bkey, akey); x < 0 {
 			entry, bnext = bentry, true
 
-		} else if cmp > 0 {
+		} else if x > 0 {
 			entry, anext = aentry, true
 
 		} else {